@@ -10,9 +10,11 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/allcallall/backend/internal/auth"
 	"github.com/allcallall/backend/internal/cache"
+	"github.com/allcallall/backend/internal/callhistory"
 	"github.com/allcallall/backend/internal/config"
 	"github.com/allcallall/backend/internal/contact"
 	"github.com/allcallall/backend/internal/database"
@@ -21,8 +23,11 @@ import (
 	"github.com/allcallall/backend/internal/mail"
 	"github.com/allcallall/backend/internal/models"
 	"github.com/allcallall/backend/internal/presence"
+	"github.com/allcallall/backend/internal/privacy"
+	"github.com/allcallall/backend/internal/pubsub"
 	"github.com/allcallall/backend/internal/server"
 	"github.com/allcallall/backend/internal/signaling"
+	"github.com/allcallall/backend/internal/turn"
 	"github.com/allcallall/backend/internal/user"
 )
 
@@ -60,27 +65,36 @@ func main() {
 	defer sqlDB.Close()
 	appLogger.Info().Msg("mysql connection established")
 
-	if err := db.AutoMigrate(&models.User{}, &models.Contact{}, &models.EmailVerificationCode{}, &models.EmailSendLog{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Contact{}, &models.EmailVerificationCode{}, &models.EmailSendLog{}, &models.CallRecord{}, &models.CallMessage{}, &models.CallQualitySample{}, &models.CallRecording{}, &models.CallRecordingParticipant{}, &models.PrivacySettings{}, &models.Block{}); err != nil {
 		appLogger.Fatal().Err(err).Msg("auto migrate failed")
 	}
 
 	rootCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	redisClient, err := cache.NewRedis(rootCtx, cfg.Redis, appLogger)
-	if err != nil {
-		appLogger.Fatal().Err(err).Msg("failed to connect redis")
-	}
-	defer func() {
-		if err := redisClient.Close(); err != nil {
-			appLogger.Warn().Err(err).Msg("redis client close with error")
+	// 单节点的 memory 后端不需要 Redis：路由、通话与在线状态都在进程内
+	// The single-node memory backend keeps routes, calls and presence in
+	// process, so Redis is only dialled for the other backends.
+	singleNode := cfg.Signaling.PubSub.Backend == pubsub.BackendMemory
+	var redisClient *redis.Client
+	if !singleNode {
+		redisClient, err = cache.NewRedis(rootCtx, cfg.Redis, appLogger)
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("failed to connect redis")
 		}
-	}()
+		defer func() {
+			if err := redisClient.Close(); err != nil {
+				appLogger.Warn().Err(err).Msg("redis client close with error")
+			}
+		}()
+	}
 
 	userRepo := user.NewRepository(db)
 	userSvc := user.NewService(userRepo)
 	contactRepo := contact.NewRepository(db)
 	contactSvc := contact.NewService(contactRepo, userSvc)
+	privacySvc := privacy.NewService(privacy.NewRepository(db), userSvc, contactSvc)
+	privacySvc.WithDefaultVisibility(cfg.Privacy.DefaultVisibility)
 
 	// 初始化邮件服务
 	// Initialize mail service
@@ -111,14 +125,49 @@ func main() {
 
 	authHandler := handlers.NewAuthHandler(appLogger, userSvc, jwtManager)
 	emailHandler := handlers.NewEmailHandler(appLogger, mail.NewVerificationCodeService(db, mailSvc))
-	presenceManager := presence.NewManager(redisClient, appLogger, userSvc)
+	var presenceStore presence.Store = presence.NewMemoryStore()
+	if !singleNode {
+		presenceStore = presence.NewRedisStore(redisClient)
+	}
+	presenceManager := presence.NewManager(presenceStore, appLogger, userSvc)
+	presenceManager.WithAwayAfter(time.Duration(cfg.Signaling.PresenceAwayAfterSec) * time.Second)
+
+	userHandler := handlers.NewUserHandler(appLogger, userSvc, presenceManager, contactSvc, privacySvc)
+	callHistory := callhistory.NewService(callhistory.NewRepository(db))
+	callHandler := handlers.NewCallHandler(appLogger, callHistory)
+	privacyHandler := handlers.NewPrivacyHandler(appLogger, privacySvc)
+	webrtcHandler := handlers.NewWebRTCHandler(appLogger, cfg.WebRTC)
+	signalingBroker, err := pubsub.New(cfg.Signaling.PubSub, redisClient, appLogger)
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("failed to initialize signaling pubsub backend")
+	}
+	appLogger.Info().Str("backend", cfg.Signaling.PubSub.Backend).Msg("signaling pubsub backend selected")
+	var signalingRoutes signaling.RouteRegistry = signaling.NewMemoryRouteRegistry()
+	if !singleNode {
+		signalingRoutes = signaling.NewRedisRouteRegistry(redisClient)
+	}
+	signalingHub := signaling.NewHub(signalingBroker, signalingRoutes, appLogger, presenceManager)
+	if !singleNode {
+		// 通话与会话状态放在 Redis，排空时可由其他节点接管
+		// Keep call and session state in Redis so another node can take over on drain.
+		signalingHub.WithCallStore(signaling.NewRedisCallStore(redisClient))
+		signalingHub.WithSessionStore(signaling.NewRedisSessionStore(redisClient))
+	}
+	signalingHub.WithCallHistory(callHistory)
+	signalingHub.WithPrivacy(privacySvc)
+	signalingHub.WithPresenceUpdates(privacySvc, time.Duration(cfg.Signaling.PresenceDebounceMS)*time.Millisecond)
 
-	userHandler := handlers.NewUserHandler(appLogger, userSvc, presenceManager, contactSvc)
-	signalingHub := signaling.NewHub(redisClient, appLogger, presenceManager)
+	// 信令节点在 HTTP 停止后仍需接收跨节点消息，直到排空完成
+	// The hub keeps receiving cross-node traffic during drain, so it outlives rootCtx.
+	hubCtx, hubCancel := context.WithCancel(context.Background())
+	defer hubCancel()
+	if err := signalingHub.Start(hubCtx); err != nil {
+		appLogger.Fatal().Err(err).Msg("failed to start signaling hub")
+	}
 
 	// 初始化 Pion WebRTC 媒体引擎
 	// Initialize Pion WebRTC media engine
-	mediaEngine, err := signaling.InitPionMediaEngine(appLogger)
+	mediaEngine, err := signaling.InitPionMediaEngine(appLogger, cfg.WebRTC)
 	if err != nil {
 		appLogger.Fatal().Err(err).Msg("failed to initialize pion media engine")
 	}
@@ -130,19 +179,50 @@ func main() {
 		}
 	}()
 
+	// 可选的内置 TURN/STUN 服务，供不部署 coturn 的小型环境使用
+	// Optional embedded TURN/STUN server for small deployments without coturn
+	var turnServer *turn.Server
+	if cfg.TURN.Enabled {
+		turnServer, err = turn.NewServer(appLogger, cfg.TURN)
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("failed to start turn server")
+		}
+		defer func() {
+			if err := turnServer.Close(); err != nil {
+				appLogger.Warn().Err(err).Msg("turn server close with error")
+			}
+		}()
+		appLogger.Info().
+			Str("addr", turnServer.Addr().String()).
+			Str("public_ip", cfg.TURN.PublicIP).
+			Uint16("relay_min_port", cfg.TURN.RelayMinPort).
+			Uint16("relay_max_port", cfg.TURN.RelayMaxPort).
+			Msg("turn server started")
+	}
+
 	// 将媒体引擎关联到信令枢纽
 	// Attach media engine to signaling hub
 	signalingHub.WithMediaEngine(mediaEngine)
+	signalingHub.StartQualitySampling(hubCtx, cfg.WebRTC.StatsInterval())
+	signalingHub.StartPeerReaping(hubCtx, cfg.WebRTC.PeerConnections.ReapInterval())
 
 	signalingHandler := handlers.NewSignalingHandler(appLogger, signalingHub)
 
-	server.RegisterRoutes(engine, server.RouteDependencies{
+	routes := server.RouteDependencies{
 		AuthHandler:      authHandler,
 		EmailHandler:     emailHandler,
 		UserHandler:      userHandler,
+		CallHandler:      callHandler,
+		PrivacyHandler:   privacyHandler,
+		WebRTCHandler:    webrtcHandler,
 		SignalingHandler: signalingHandler,
 		AuthMiddleware:   auth.Middleware(jwtManager),
-	})
+	}
+	if cfg.Server.AdminToken != "" {
+		routes.AdminHandler = handlers.NewAdminHandler(appLogger, mediaEngine, turnServer)
+		routes.AdminMiddleware = auth.AdminMiddleware(cfg.Server.AdminToken)
+	}
+	server.RegisterRoutes(engine, routes)
 
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
@@ -162,6 +242,14 @@ func main() {
 	<-rootCtx.Done()
 	appLogger.Info().Msg("shutdown signal received")
 
+	// 先排空信令连接：Shutdown 不会关闭已劫持的 WebSocket
+	// Drain signaling first: Shutdown does not touch hijacked WebSockets.
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Duration(cfg.Signaling.DrainTimeoutSec)*time.Second)
+	if err := signalingHub.Drain(drainCtx); err != nil {
+		appLogger.Warn().Err(err).Msg("signaling drain did not finish cleanly")
+	}
+	drainCancel()
+
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
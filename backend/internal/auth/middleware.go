@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"strings"
 
@@ -39,6 +40,20 @@ func Middleware(manager *Manager) gin.HandlerFunc {
 	}
 }
 
+// AdminMiddleware 校验管理接口的 Bearer 令牌
+// AdminMiddleware lets through requests whose Authorization header carries the
+// configured admin bearer token.
+func AdminMiddleware(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := extractToken(c.Request.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
 func extractToken(header string) string {
 	if header == "" {
 		return ""
@@ -0,0 +1,32 @@
+package callhistory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/models"
+)
+
+// AddQualitySample 记录服务端与 email 之间连接的一次媒体统计
+// AddQualitySample records media statistics the server measured on its
+// connection with email, folding them into the call's quality score.
+func (s *Service) AddQualitySample(ctx context.Context, email string, stats *media.Stats) error {
+	sample := &models.CallQualitySample{
+		CallID:        stats.CallID,
+		Email:         email,
+		SampledAt:     stats.CollectedAt,
+		RTTMs:         stats.RTTMs,
+		JitterMs:      stats.JitterMs,
+		PacketLossPct: stats.PacketLossPct,
+		InboundKbps:   stats.InboundKbps,
+		OutboundKbps:  stats.OutboundKbps,
+		MOS:           stats.MOS,
+		Codecs:        strings.Join(stats.Codecs, ","),
+	}
+	if pair := stats.CandidatePair; pair != nil {
+		sample.LocalCandidate = pair.Local.Type
+		sample.RemoteCandidate = pair.Remote.Type
+	}
+	return s.repo.AddQualitySample(ctx, sample)
+}
@@ -0,0 +1,195 @@
+package callhistory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/models"
+)
+
+// ErrRecordingInProgress 录制尚未结束，清单与文件还不完整
+// ErrRecordingInProgress means the recording has not stopped, so its manifest
+// and files are not complete yet.
+var ErrRecordingInProgress = errors.New("recording is still in progress")
+
+// CreateRecording 保存录制及其参与者
+// CreateRecording persists a recording together with its participants.
+func (r *Repository) CreateRecording(ctx context.Context, rec *models.CallRecording, participants []models.CallRecordingParticipant) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rec).Error; err != nil {
+			return err
+		}
+		return addParticipants(tx, participants)
+	})
+}
+
+// FinishRecording 记录录制结束，并补充录制期间加入的参与者
+// FinishRecording records the end of a recording and adds the participants
+// who joined while it ran.
+func (r *Repository) FinishRecording(ctx context.Context, recordingID string, endedAt time.Time, participants []models.CallRecordingParticipant) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.CallRecording{}).
+			Where("recording_id = ?", recordingID).
+			Update("ended_at", endedAt).Error
+		if err != nil {
+			return err
+		}
+		return addParticipants(tx, participants)
+	})
+}
+
+func addParticipants(tx *gorm.DB, participants []models.CallRecordingParticipant) error {
+	if len(participants) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&participants).Error
+}
+
+// FindRecording 根据录制 ID 查找录制
+// FindRecording fetches a recording by its ID.
+func (r *Repository) FindRecording(ctx context.Context, recordingID string) (*models.CallRecording, error) {
+	var rec models.CallRecording
+	if err := r.db.WithContext(ctx).Where("recording_id = ?", recordingID).Take(&rec).Error; err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// IsRecordingParticipant 录制是否覆盖用户在该通话中的媒体
+// IsRecordingParticipant reports whether a recording covers email on callID.
+func (r *Repository) IsRecordingParticipant(ctx context.Context, recordingID, callID, email string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.CallRecordingParticipant{}).
+		Where("recording_id = ? AND call_id = ? AND email = ?", recordingID, callID, email).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ListRecordingsByCall 列出覆盖该通话的录制，最早的在前
+// ListRecordingsByCall lists the recordings covering a call, oldest first.
+func (r *Repository) ListRecordingsByCall(ctx context.Context, callID string) ([]models.CallRecording, error) {
+	var recs []models.CallRecording
+	err := r.db.WithContext(ctx).
+		Where("recording_id IN (?)", r.db.Model(&models.CallRecordingParticipant{}).Select("recording_id").Where("call_id = ?", callID)).
+		Order("started_at ASC").
+		Find(&recs).Error
+	return recs, err
+}
+
+// StartRecording 记录开始录制
+// StartRecording records that a recording started.
+func (s *Service) StartRecording(ctx context.Context, manifest *media.RecordingManifest, startedBy string) error {
+	return s.repo.CreateRecording(ctx, &models.CallRecording{
+		RecordingID: manifest.ID,
+		CallID:      manifest.CallID,
+		Room:        manifest.Room,
+		StartedBy:   startedBy,
+		Dir:         manifest.Dir,
+		StartedAt:   manifest.StartedAt,
+	}, recordingParticipants(manifest))
+}
+
+// FinishRecording 记录录制结束
+// FinishRecording records that a recording stopped.
+func (s *Service) FinishRecording(ctx context.Context, manifest *media.RecordingManifest) error {
+	endedAt := time.Now().UTC()
+	if manifest.EndedAt != nil {
+		endedAt = *manifest.EndedAt
+	}
+	return s.repo.FinishRecording(ctx, manifest.ID, endedAt, recordingParticipants(manifest))
+}
+
+// ListRecordings 列出通话的录制，仅通话参与者可见
+// ListRecordings returns the recordings covering a call. Only the call's
+// participants may list them.
+func (s *Service) ListRecordings(ctx context.Context, callID, email string) ([]models.CallRecording, error) {
+	record, err := s.repo.FindByCallID(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.HasParticipant(email) {
+		return nil, ErrForbidden
+	}
+	return s.repo.ListRecordingsByCall(ctx, callID)
+}
+
+// GetRecording 返回录制，仅被录制的参与者通过自己的通话可见
+// GetRecording returns a recording. Only the participants it covers may read
+// it, through the call that connected them to the server.
+func (s *Service) GetRecording(ctx context.Context, callID, recordingID, email string) (*models.CallRecording, error) {
+	rec, err := s.repo.FindRecording(ctx, recordingID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.repo.IsRecordingParticipant(ctx, recordingID, callID, email)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	return rec, nil
+}
+
+// RecordingManifest 读取已结束录制的清单
+// RecordingManifest reads the manifest of a finished recording.
+func (s *Service) RecordingManifest(ctx context.Context, callID, recordingID, email string) (*models.CallRecording, *media.RecordingManifest, error) {
+	rec, err := s.GetRecording(ctx, callID, recordingID, email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec.EndedAt == nil {
+		return nil, nil, ErrRecordingInProgress
+	}
+	data, err := os.ReadFile(filepath.Join(rec.Dir, media.ManifestFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest media.RecordingManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	manifest.Dir = rec.Dir
+	return rec, &manifest, nil
+}
+
+// RecordingFile 返回已结束录制中某个文件的路径；只提供清单本身及其中列出的文件
+// RecordingFile returns the path of one file of a finished recording. Only
+// the manifest and the track files it lists are served.
+func (s *Service) RecordingFile(ctx context.Context, callID, recordingID, email, name string) (string, error) {
+	rec, manifest, err := s.RecordingManifest(ctx, callID, recordingID, email)
+	if err != nil {
+		return "", err
+	}
+	if name == media.ManifestFile {
+		return filepath.Join(rec.Dir, media.ManifestFile), nil
+	}
+	for _, track := range manifest.Tracks {
+		if track.File == name {
+			return filepath.Join(rec.Dir, track.File), nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func recordingParticipants(manifest *media.RecordingManifest) []models.CallRecordingParticipant {
+	participants := make([]models.CallRecordingParticipant, 0, len(manifest.Participants))
+	for _, p := range manifest.Participants {
+		participants = append(participants, models.CallRecordingParticipant{
+			RecordingID: manifest.ID,
+			CallID:      p.CallID,
+			Email:       p.Email,
+		})
+	}
+	return participants
+}
@@ -0,0 +1,123 @@
+package callhistory
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/allcallall/backend/internal/models"
+)
+
+// Repository 通话记录数据访问
+// Repository handles database operations for call records and their messages.
+type Repository struct {
+	db *gorm.DB
+}
+
+// NewRepository 构造函数
+// NewRepository creates a call history repository.
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// ErrNotFound 通话记录不存在
+// ErrNotFound signals that the call record was not located.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// Create 保存通话记录
+// Create persists a new call record.
+func (r *Repository) Create(ctx context.Context, record *models.CallRecord) error {
+	return r.db.WithContext(ctx).Create(record).Error
+}
+
+// FindByCallID 根据通话 ID 查找记录
+// FindByCallID fetches a call record by its signaling call ID.
+func (r *Repository) FindByCallID(ctx context.Context, callID string) (*models.CallRecord, error) {
+	var record models.CallRecord
+	err := r.db.WithContext(ctx).Where("call_id = ?", callID).Take(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Update 更新通话记录的部分字段
+// Update changes the given columns of a call record.
+func (r *Repository) Update(ctx context.Context, callID string, fields map[string]interface{}) error {
+	return r.db.WithContext(ctx).
+		Model(&models.CallRecord{}).
+		Where("call_id = ?", callID).
+		Updates(fields).Error
+}
+
+// ListByParticipant 列出用户参与或转接的通话，最新的在前
+// ListByParticipant lists calls email took part in or referred, newest first.
+func (r *Repository) ListByParticipant(ctx context.Context, email string, before time.Time, limit int) ([]models.CallRecord, error) {
+	var records []models.CallRecord
+	err := r.db.WithContext(ctx).
+		Where("(caller_email = ? OR callee_email = ? OR referred_by_email = ?) AND started_at < ?", email, email, email, before).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
+
+// AddMessage 保存通话内消息
+// AddMessage persists an in-call message.
+func (r *Repository) AddMessage(ctx context.Context, msg *models.CallMessage) error {
+	return r.db.WithContext(ctx).Create(msg).Error
+}
+
+// ListMessages 按时间顺序列出多个通话的消息
+// ListMessages returns the messages of the given calls in chronological order.
+func (r *Repository) ListMessages(ctx context.Context, callIDs []string) ([]models.CallMessage, error) {
+	var messages []models.CallMessage
+	if len(callIDs) == 0 {
+		return messages, nil
+	}
+	err := r.db.WithContext(ctx).
+		Where("call_id IN ?", callIDs).
+		Order("created_at ASC, id ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// AddQualitySample 保存质量采样，并重新计算通话记录上的平均值
+// AddQualitySample persists a quality sample and recomputes the averages kept
+// on the call record.
+func (r *Repository) AddQualitySample(ctx context.Context, sample *models.CallQualitySample) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sample).Error; err != nil {
+			return err
+		}
+		var agg struct {
+			Samples int
+			MOS     float64
+			RTT     float64
+			Jitter  float64
+			Loss    float64
+		}
+		err := tx.Model(&models.CallQualitySample{}).
+			Select("COUNT(*) AS samples, AVG(mos) AS mos, AVG(rtt_ms) AS rtt, AVG(jitter_ms) AS jitter, AVG(packet_loss_pct) AS loss").
+			Where("call_id = ?", sample.CallID).
+			Scan(&agg).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.CallRecord{}).
+			Where("call_id = ?", sample.CallID).
+			Updates(map[string]interface{}{
+				"quality_samples":     agg.Samples,
+				"mos":                 round2(agg.MOS),
+				"avg_rtt_ms":          round2(agg.RTT),
+				"avg_jitter_ms":       round2(agg.Jitter),
+				"avg_packet_loss_pct": round2(agg.Loss),
+			}).Error
+	})
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,192 @@
+package callhistory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/allcallall/backend/internal/models"
+)
+
+// ErrForbidden 用户不是该通话的参与者
+// ErrForbidden means the user did not take part in the call.
+var ErrForbidden = errors.New("not a participant of this call")
+
+// Entry 通话记录及其通话内消息
+// Entry is a call record together with the transcript of its in-call messages.
+type Entry struct {
+	Record   models.CallRecord
+	Messages []models.CallMessage
+}
+
+// Service 通话记录业务逻辑
+// Service records call lifecycle events and serves call history.
+type Service struct {
+	repo *Repository
+}
+
+// NewService 构造函数
+// NewService creates a call history service.
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Start 记录新的通话邀请
+// Start records a new call invitation.
+func (s *Service) Start(ctx context.Context, callID, caller, callee string, at time.Time) error {
+	return s.repo.Create(ctx, &models.CallRecord{
+		CallID:      callID,
+		CallerEmail: caller,
+		CalleeEmail: callee,
+		Status:      models.CallRecordRinging,
+		StartedAt:   at,
+	})
+}
+
+// Answer 记录通话被接听，sender 须为参与者
+// Answer records that the callee accepted. sender must take part in the call.
+func (s *Service) Answer(ctx context.Context, callID, sender string, at time.Time) error {
+	if _, err := s.participantRecord(ctx, callID, sender); err != nil {
+		return err
+	}
+	return s.repo.Update(ctx, callID, map[string]interface{}{
+		"status":      models.CallRecordActive,
+		"answered_at": at,
+	})
+}
+
+// Reject 记录通话被拒绝，sender 须为参与者
+// Reject records that the callee declined. sender must take part in the call.
+func (s *Service) Reject(ctx context.Context, callID, sender, reason string, at time.Time) error {
+	if _, err := s.participantRecord(ctx, callID, sender); err != nil {
+		return err
+	}
+	return s.repo.Update(ctx, callID, map[string]interface{}{
+		"status":     models.CallRecordRejected,
+		"end_reason": reason,
+		"ended_at":   at,
+	})
+}
+
+// End 记录通话结束，sender 须为参与者；未接听的通话记为未接
+// End records that sender hung up. sender must take part in the call. Calls
+// that were never answered count as missed.
+func (s *Service) End(ctx context.Context, callID, sender, reason string, at time.Time) error {
+	record, err := s.participantRecord(ctx, callID, sender)
+	if err != nil {
+		return err
+	}
+	return s.end(ctx, record, reason, at)
+}
+
+// Transfer 记录通话转给了 target 并结束
+// Transfer ends a call that was handed over to target.
+func (s *Service) Transfer(ctx context.Context, callID, target string, at time.Time) error {
+	record, err := s.repo.FindByCallID(ctx, callID)
+	if err != nil {
+		return err
+	}
+	if err := s.end(ctx, record, models.CallEndTransferred, at); err != nil {
+		return err
+	}
+	return s.repo.Update(ctx, callID, map[string]interface{}{"transferred_to": target})
+}
+
+func (s *Service) end(ctx context.Context, record *models.CallRecord, reason string, at time.Time) error {
+	status := models.CallRecordCompleted
+	if record.AnsweredAt == nil {
+		status = models.CallRecordMissed
+	}
+	return s.repo.Update(ctx, record.CallID, map[string]interface{}{
+		"status":     status,
+		"end_reason": reason,
+		"ended_at":   at,
+	})
+}
+
+// participantRecord 返回通话记录，sender 不是参与者时返回 ErrForbidden
+// participantRecord loads a call record, or returns ErrForbidden when sender
+// did not take part in the call.
+func (s *Service) participantRecord(ctx context.Context, callID, sender string) (*models.CallRecord, error) {
+	record, err := s.repo.FindByCallID(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.HasParticipant(sender) {
+		return nil, ErrForbidden
+	}
+	return record, nil
+}
+
+// LinkTransfer 记录通话由 fromCallID 转来，由 referredBy 发起
+// LinkTransfer records that a call continues fromCallID at referredBy's request.
+func (s *Service) LinkTransfer(ctx context.Context, callID, fromCallID, referredBy string) error {
+	return s.repo.Update(ctx, callID, map[string]interface{}{
+		"transferred_from":  fromCallID,
+		"referred_by_email": referredBy,
+	})
+}
+
+// AddMessage 记录通话内消息
+// AddMessage stores a chat line or reaction with the call record.
+func (s *Service) AddMessage(ctx context.Context, callID, sender, kind, body string, at time.Time) error {
+	return s.repo.AddMessage(ctx, &models.CallMessage{
+		CallID:      callID,
+		SenderEmail: sender,
+		Kind:        kind,
+		Body:        body,
+		CreatedAt:   at,
+	})
+}
+
+// ListForUser 列出用户的通话记录（含消息），before 为零值时从最新开始
+// ListForUser returns email's call history with transcripts, newest first.
+// A zero before starts from the most recent call.
+func (s *Service) ListForUser(ctx context.Context, email string, before time.Time, limit int) ([]Entry, error) {
+	if before.IsZero() {
+		before = time.Now().Add(time.Minute)
+	}
+	records, err := s.repo.ListByParticipant(ctx, email, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.withMessages(ctx, records)
+}
+
+// Get 返回单个通话记录，仅参与者可见
+// Get returns one call's entry. Only its participants may read it.
+func (s *Service) Get(ctx context.Context, callID, email string) (*Entry, error) {
+	record, err := s.repo.FindByCallID(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.HasParticipant(email) {
+		return nil, ErrForbidden
+	}
+	entries, err := s.withMessages(ctx, []models.CallRecord{*record})
+	if err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+func (s *Service) withMessages(ctx context.Context, records []models.CallRecord) ([]Entry, error) {
+	callIDs := make([]string, len(records))
+	for i, r := range records {
+		callIDs[i] = r.CallID
+	}
+	messages, err := s.repo.ListMessages(ctx, callIDs)
+	if err != nil {
+		return nil, err
+	}
+	byCall := make(map[string][]models.CallMessage, len(records))
+	for _, m := range messages {
+		byCall[m.CallID] = append(byCall[m.CallID], m)
+	}
+
+	entries := make([]Entry, len(records))
+	for i, r := range records {
+		entries[i] = Entry{Record: r, Messages: byCall[r.CallID]}
+	}
+	return entries, nil
+}
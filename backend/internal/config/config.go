@@ -3,9 +3,12 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -23,13 +26,16 @@ var (
 // Config 应用总配置结构
 // Config aggregates all application settings loaded from YAML/Env.
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Redis    RedisConfig    `yaml:"redis"`
-	Mail     Mail           `yaml:"mail"`
-	JWT      JWTConfig      `yaml:"jwt"`
-	WebRTC   WebRTCConfig   `yaml:"webrtc"`
-	Logging  LoggingConfig  `yaml:"logging"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Mail      Mail            `yaml:"mail"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	WebRTC    WebRTCConfig    `yaml:"webrtc"`
+	TURN      TURNConfig      `yaml:"turn"`
+	Signaling SignalingConfig `yaml:"signaling"`
+	Privacy   PrivacyConfig   `yaml:"privacy"`
+	Logging   LoggingConfig   `yaml:"logging"`
 }
 
 // ServerConfig HTTP 服务相关配置
@@ -40,6 +46,9 @@ type ServerConfig struct {
 	ReadTimeoutSec  int    `yaml:"read_timeout_seconds"`
 	WriteTimeoutSec int    `yaml:"write_timeout_seconds"`
 	IdleTimeoutSec  int    `yaml:"idle_timeout_seconds"`
+	// 管理接口的 Bearer 令牌，为空时不开放管理接口
+	// Bearer token of the admin endpoints; empty leaves them disabled.
+	AdminToken string `yaml:"admin_token"`
 }
 
 // DatabaseConfig MySQL 配置
@@ -70,17 +79,195 @@ type JWTConfig struct {
 }
 
 // WebRTCConfig WebRTC 相关配置
-// WebRTCConfig contains ICE server list.
+// WebRTCConfig contains ICE server list and the server media engine mode.
 type WebRTCConfig struct {
 	ICEServers []ICEServer `yaml:"ice_servers"`
+	// ICE 传输策略: all | relay（只使用 TURN 中继）
+	// ICE transport policy: all | relay (TURN relays only)
+	ICETransportPolicy string `yaml:"ice_transport_policy"`
+	// Bundle 策略: balanced | max-compat | max-bundle
+	// Bundle policy: balanced | max-compat | max-bundle
+	BundlePolicy string `yaml:"bundle_policy"`
+	// 服务端媒体引擎模式: terminate | sfu
+	// Server media engine mode: terminate | sfu
+	MediaMode string `yaml:"media_mode"`
+	// 通话录制文件目录，为空时禁用录制
+	// Directory for call recordings; empty disables recording.
+	RecordingDir string `yaml:"recording_dir"`
+	// 临时 TURN 凭据的有效期
+	// Lifetime of ephemeral TURN credentials.
+	TURNCredentialTTLSec int `yaml:"turn_credential_ttl_seconds"`
+	// 服务端通话的媒体质量采样间隔
+	// How often the media quality of server-terminated calls is sampled.
+	StatsIntervalSec int `yaml:"stats_interval_seconds"`
+	// 服务端媒体引擎的编解码器与 RTP 拦截器
+	// Codecs and RTP interceptors of the server media engine.
+	Codecs       CodecsConfig       `yaml:"codecs"`
+	Interceptors InterceptorsConfig `yaml:"interceptors"`
+	// 服务端媒体引擎的网络设置
+	// Network settings of the server media engine.
+	Network NetworkConfig `yaml:"network"`
+	// 服务端对等连接的数量上限与回收
+	// Limits and reaping of the server's peer connections.
+	PeerConnections PeerConnectionsConfig `yaml:"peer_connections"`
+}
+
+// PeerConnectionsConfig 服务端对等连接的数量上限与回收，上限为 0 表示不限
+// PeerConnectionsConfig caps the server's peer connections and controls how
+// stuck or orphaned ones are reaped. A zero limit means no limit.
+type PeerConnectionsConfig struct {
+	Max        int `yaml:"max"`
+	MaxPerUser int `yaml:"max_per_user"`
+	// 超过该时间仍未连上的连接被回收，其通话随之结束
+	// Connections not connected after this long are reaped and their call ended.
+	ConnectTimeoutSec int `yaml:"connect_timeout_seconds"`
+	// 检查卡住的连接与信令中已结束通话遗留连接的间隔
+	// How often stuck connections, and those left behind by calls that ended in signaling, are looked for.
+	ReapIntervalSec int `yaml:"reap_interval_seconds"`
+}
+
+// ConnectTimeout 返回连接建立超时
+// ConnectTimeout returns how long a peer connection may take to connect.
+func (c PeerConnectionsConfig) ConnectTimeout() time.Duration {
+	return time.Duration(c.ConnectTimeoutSec) * time.Second
+}
+
+// ReapInterval 返回回收检查间隔
+// ReapInterval returns how often peer connections are checked for reaping.
+func (c PeerConnectionsConfig) ReapInterval() time.Duration {
+	return time.Duration(c.ReapIntervalSec) * time.Second
+}
+
+// NetworkConfig 服务端对等连接的网络设置，用于 NAT 之后的部署
+// NetworkConfig holds the network settings of the server's peer connections,
+// for deployments behind NAT.
+type NetworkConfig struct {
+	// ICE 使用的临时 UDP 端口范围，均为 0 时由系统分配
+	// Ephemeral UDP port range used by ICE; both zero lets the OS pick.
+	UDPPortMin uint16 `yaml:"udp_port_min"`
+	UDPPortMax uint16 `yaml:"udp_port_max"`
+	// 1:1 NAT 的公网 IP
+	// Public IPs of a 1:1 NAT.
+	NAT1To1IPs []string `yaml:"nat_1to1_ips"`
+	// host（替换主机候选地址）| srflx（额外添加反射候选）
+	// host (replace host candidate addresses) | srflx (add server reflexive candidates)
+	NAT1To1CandidateType string `yaml:"nat_1to1_candidate_type"`
+	// 只在这些网卡上收集候选，为空表示全部
+	// Only gather candidates on these interfaces; empty means all.
+	Interfaces      []string `yaml:"interfaces"`
+	IncludeLoopback bool     `yaml:"include_loopback"`
+	// 所有连接共用的 ICE UDP 端口与 ICE-TCP 端口，0 表示不启用
+	// Single ICE UDP port shared by every connection, and the ICE-TCP port; 0 disables them.
+	UDPMuxPort int `yaml:"udp_mux_port"`
+	TCPMuxPort int `yaml:"tcp_mux_port"`
+}
+
+// CodecsConfig 服务端协商的编解码器，按偏好排序
+// CodecsConfig lists the codecs the server media engine negotiates, most
+// preferred first.
+type CodecsConfig struct {
+	// 音频: opus | g722 | pcmu | pcma
+	// Audio: opus | g722 | pcmu | pcma
+	Audio []string `yaml:"audio"`
+	// 视频: vp8 | vp9 | h264 | av1，例如只保留 h264 以便 iOS 硬件解码
+	// Video: vp8 | vp9 | h264 | av1, e.g. only h264 for iOS hardware decoding
+	Video []string `yaml:"video"`
+	// 完全禁用视频
+	// Disables video entirely.
+	DisableVideo bool `yaml:"disable_video"`
+}
+
+// InterceptorsConfig RTP/RTCP 拦截器间隔（毫秒），为 0 时使用默认值，为负数时关闭
+// InterceptorsConfig holds RTP/RTCP interceptor intervals in milliseconds. Zero
+// uses the default and a negative value turns the interceptor off.
+type InterceptorsConfig struct {
+	RTCPReportIntervalMs int `yaml:"rtcp_report_interval_ms"`
+	NACKIntervalMs       int `yaml:"nack_interval_ms"`
+	TWCCIntervalMs       int `yaml:"twcc_interval_ms"`
+	// 定期请求关键帧的间隔，为 0 时不请求
+	// Periodic keyframe requests for received video; zero sends none.
+	PLIIntervalMs int `yaml:"pli_interval_ms"`
+}
+
+// TURNCredentialTTL 返回临时 TURN 凭据的有效期
+// TURNCredentialTTL returns how long ephemeral TURN credentials stay valid.
+func (c WebRTCConfig) TURNCredentialTTL() time.Duration {
+	return time.Duration(c.TURNCredentialTTLSec) * time.Second
+}
+
+// StatsInterval 返回媒体质量采样间隔
+// StatsInterval returns how often media quality is sampled.
+func (c WebRTCConfig) StatsInterval() time.Duration {
+	return time.Duration(c.StatsIntervalSec) * time.Second
 }
 
 // ICEServer 单个 ICE 服务配置
-// ICEServer represents a single ICE server entry.
+// ICEServer represents a single ICE server entry. With Secret set, clients
+// get ephemeral TURN REST API credentials instead of Username and Credential.
 type ICEServer struct {
 	URLs       []string `yaml:"urls"`
 	Username   string   `yaml:"username"`
 	Credential string   `yaml:"credential"`
+	// TURN REST API 共享密钥，与 TURN 服务器的 static-auth-secret 一致
+	// Shared secret of the TURN REST API, the TURN server's static-auth-secret.
+	Secret string `yaml:"secret"`
+}
+
+// TURNConfig 内置 TURN/STUN 服务配置
+// TURNConfig controls the embedded TURN/STUN server for deployments that do
+// not run coturn. It accepts the same ephemeral credentials as ICEServer.Secret.
+type TURNConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	ListenAddress string `yaml:"listen_address"`
+	// 对外公布的中继地址，客户端必须能访问
+	// Relay address announced to clients; it must be reachable by them.
+	PublicIP string `yaml:"public_ip"`
+	// 中继套接字绑定的本地地址
+	// Local address relay sockets bind to.
+	RelayAddress string `yaml:"relay_address"`
+	Realm        string `yaml:"realm"`
+	Secret       string `yaml:"secret"`
+	RelayMinPort uint16 `yaml:"relay_min_port"`
+	RelayMaxPort uint16 `yaml:"relay_max_port"`
+	// 每个用户同时持有的中继分配上限
+	// Maximum concurrent relay allocations per user.
+	MaxAllocationsPerUser int `yaml:"max_allocations_per_user"`
+}
+
+// SignalingConfig 信令服务配置
+// SignalingConfig controls the signaling hub.
+type SignalingConfig struct {
+	PubSub          PubSubConfig `yaml:"pubsub"`
+	DrainTimeoutSec int          `yaml:"drain_timeout_seconds"`
+	// 在线状态推送的防抖时间，过滤连接抖动
+	// Debounce for presence pushes, so flapping connections do not spam contacts.
+	PresenceDebounceMS int `yaml:"presence_debounce_ms"`
+	// 无操作多久后自动显示为离开
+	// Inactivity after which an available user shows as away.
+	PresenceAwayAfterSec int `yaml:"presence_away_after_seconds"`
+}
+
+// PubSubConfig 跨节点消息投递配置
+// PubSubConfig selects the cross-node delivery backend: memory | redis | redis_streams.
+type PubSubConfig struct {
+	Backend            string `yaml:"backend"`
+	StreamMaxLen       int64  `yaml:"stream_max_len"`
+	StreamRetentionSec int    `yaml:"stream_retention_seconds"`
+}
+
+// StreamRetention 返回 Redis Streams 主题保留时间
+// StreamRetention returns how long idle topic streams are kept.
+func (c PubSubConfig) StreamRetention() time.Duration {
+	return time.Duration(c.StreamRetentionSec) * time.Second
+}
+
+// PrivacyConfig 隐私设置的服务端默认值
+// PrivacyConfig holds the server-wide privacy defaults.
+type PrivacyConfig struct {
+	// 未选择可见范围的用户，谁能看到其在线状态与最后在线时间: everyone | contacts | nobody
+	// Who sees the online status and last seen time of users who never chose:
+	// everyone, contacts or nobody.
+	DefaultVisibility string `yaml:"default_visibility"`
 }
 
 // LoggingConfig 日志配置
@@ -136,6 +323,110 @@ func (c *Config) postProcess() error {
 		c.Server.IdleTimeoutSec = 60
 	}
 
+	if c.WebRTC.MediaMode == "" {
+		c.WebRTC.MediaMode = "terminate"
+	}
+	if c.WebRTC.TURNCredentialTTLSec == 0 {
+		c.WebRTC.TURNCredentialTTLSec = 86400
+	}
+	if c.WebRTC.StatsIntervalSec <= 0 {
+		c.WebRTC.StatsIntervalSec = 10
+	}
+	if c.WebRTC.ICETransportPolicy == "" {
+		c.WebRTC.ICETransportPolicy = "all"
+	}
+	if c.WebRTC.ICETransportPolicy != "all" && c.WebRTC.ICETransportPolicy != "relay" {
+		return fmt.Errorf("config: webrtc.ice_transport_policy must be all or relay, got %q", c.WebRTC.ICETransportPolicy)
+	}
+	if c.WebRTC.BundlePolicy == "" {
+		c.WebRTC.BundlePolicy = "balanced"
+	}
+	switch c.WebRTC.BundlePolicy {
+	case "balanced", "max-compat", "max-bundle":
+	default:
+		return fmt.Errorf("config: webrtc.bundle_policy must be balanced, max-compat or max-bundle, got %q", c.WebRTC.BundlePolicy)
+	}
+	for i, name := range c.WebRTC.Codecs.Audio {
+		c.WebRTC.Codecs.Audio[i] = strings.ToLower(name)
+		switch c.WebRTC.Codecs.Audio[i] {
+		case "opus", "g722", "pcmu", "pcma":
+		default:
+			return fmt.Errorf("config: webrtc.codecs.audio must list opus, g722, pcmu or pcma, got %q", name)
+		}
+	}
+	for i, name := range c.WebRTC.Codecs.Video {
+		c.WebRTC.Codecs.Video[i] = strings.ToLower(name)
+		switch c.WebRTC.Codecs.Video[i] {
+		case "vp8", "vp9", "h264", "av1":
+		default:
+			return fmt.Errorf("config: webrtc.codecs.video must list vp8, vp9, h264 or av1, got %q", name)
+		}
+	}
+
+	if c.WebRTC.Network.NAT1To1CandidateType == "" {
+		c.WebRTC.Network.NAT1To1CandidateType = "host"
+	}
+	if c.WebRTC.Network.NAT1To1CandidateType != "host" && c.WebRTC.Network.NAT1To1CandidateType != "srflx" {
+		return fmt.Errorf("config: webrtc.network.nat_1to1_candidate_type must be host or srflx, got %q", c.WebRTC.Network.NAT1To1CandidateType)
+	}
+
+	if c.WebRTC.PeerConnections.ConnectTimeoutSec == 0 {
+		c.WebRTC.PeerConnections.ConnectTimeoutSec = 30
+	}
+	if c.WebRTC.PeerConnections.ReapIntervalSec == 0 {
+		c.WebRTC.PeerConnections.ReapIntervalSec = 15
+	}
+	peerConns := c.WebRTC.PeerConnections
+	if peerConns.Max < 0 || peerConns.MaxPerUser < 0 || peerConns.ConnectTimeoutSec < 0 || peerConns.ReapIntervalSec < 0 {
+		return errors.New("config: webrtc.peer_connections settings must not be negative")
+	}
+
+	if c.TURN.ListenAddress == "" {
+		c.TURN.ListenAddress = "0.0.0.0:3478"
+	}
+	if c.TURN.RelayAddress == "" {
+		c.TURN.RelayAddress = "0.0.0.0"
+	}
+	if c.TURN.Realm == "" {
+		c.TURN.Realm = "allcallall"
+	}
+	if c.TURN.RelayMinPort == 0 {
+		c.TURN.RelayMinPort = 49152
+	}
+	if c.TURN.RelayMaxPort == 0 {
+		c.TURN.RelayMaxPort = 65535
+	}
+	if c.TURN.MaxAllocationsPerUser == 0 {
+		c.TURN.MaxAllocationsPerUser = 10
+	}
+
+	if c.Signaling.DrainTimeoutSec == 0 {
+		c.Signaling.DrainTimeoutSec = 20
+	}
+	if c.Signaling.PresenceDebounceMS == 0 {
+		c.Signaling.PresenceDebounceMS = 2000
+	}
+	if c.Signaling.PresenceAwayAfterSec == 0 {
+		c.Signaling.PresenceAwayAfterSec = 300
+	}
+	if c.Signaling.PubSub.Backend == "" {
+		c.Signaling.PubSub.Backend = "redis"
+	}
+	// 支持环境变量覆盖信令投递后端
+	// Support environment variables override signaling pubsub backend
+	if backend := os.Getenv("SIGNALING_PUBSUB_BACKEND"); backend != "" {
+		c.Signaling.PubSub.Backend = backend
+	}
+
+	if c.Privacy.DefaultVisibility == "" {
+		c.Privacy.DefaultVisibility = "contacts"
+	}
+	switch c.Privacy.DefaultVisibility {
+	case "everyone", "contacts", "nobody":
+	default:
+		return fmt.Errorf("config: privacy.default_visibility must be everyone, contacts or nobody, got %q", c.Privacy.DefaultVisibility)
+	}
+
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
@@ -161,6 +452,32 @@ func (c *Config) postProcess() error {
 		c.JWT.Secret = jwtSecret
 	}
 
+	// 支持环境变量覆盖 TURN 共享密钥（配置了密钥的 ICE 服务器与内置 TURN 服务）
+	// Support environment variables override the TURN shared secret of ICE servers that use one and of the embedded TURN server
+	if turnSecret := os.Getenv("TURN_SECRET"); turnSecret != "" {
+		for i := range c.WebRTC.ICEServers {
+			if c.WebRTC.ICEServers[i].Secret != "" {
+				c.WebRTC.ICEServers[i].Secret = turnSecret
+			}
+		}
+		c.TURN.Secret = turnSecret
+	}
+	if turnPublicIP := os.Getenv("TURN_PUBLIC_IP"); turnPublicIP != "" {
+		c.TURN.PublicIP = turnPublicIP
+	}
+
+	// 支持环境变量覆盖媒体引擎的 1:1 NAT 公网 IP（逗号分隔）
+	// Support environment variables override the media engine's 1:1 NAT public IPs (comma separated)
+	if natIPs := os.Getenv("WEBRTC_NAT_1TO1_IPS"); natIPs != "" {
+		c.WebRTC.Network.NAT1To1IPs = strings.Split(natIPs, ",")
+	}
+
+	// 支持环境变量覆盖管理接口令牌
+	// Support environment variables override the admin token
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		c.Server.AdminToken = adminToken
+	}
+
 	// 支持环境变量覆盖邮件密码
 	// Support environment variables override mail password
 	if mailPassword := os.Getenv("MAIL_PASSWORD"); mailPassword != "" {
@@ -171,6 +488,36 @@ func (c *Config) postProcess() error {
 		return errors.New("config: jwt.secret must not be empty")
 	}
 
+	network := c.WebRTC.Network
+	for i, ip := range network.NAT1To1IPs {
+		network.NAT1To1IPs[i] = strings.TrimSpace(ip)
+		if net.ParseIP(network.NAT1To1IPs[i]) == nil {
+			return fmt.Errorf("config: webrtc.network.nat_1to1_ips must be IP addresses, got %q", ip)
+		}
+	}
+	if (network.UDPPortMin == 0) != (network.UDPPortMax == 0) || network.UDPPortMin > network.UDPPortMax {
+		return fmt.Errorf("config: webrtc.network.udp_port_min and udp_port_max must both be set, min not above max, got %d-%d", network.UDPPortMin, network.UDPPortMax)
+	}
+	for name, port := range map[string]int{"udp_mux_port": network.UDPMuxPort, "tcp_mux_port": network.TCPMuxPort} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("config: webrtc.network.%s must be a port number, got %d", name, port)
+		}
+	}
+
+	if c.TURN.Enabled {
+		if c.TURN.Secret == "" {
+			return errors.New("config: turn.secret must not be empty when turn.enabled is set")
+		}
+		if net.ParseIP(c.TURN.PublicIP) == nil {
+			return fmt.Errorf("config: turn.public_ip must be an IP address, got %q", c.TURN.PublicIP)
+		}
+		if c.TURN.RelayMinPort > c.TURN.RelayMaxPort {
+			return fmt.Errorf("config: turn.relay_min_port %d is above turn.relay_max_port %d", c.TURN.RelayMinPort, c.TURN.RelayMaxPort)
+		}
+		if c.TURN.MaxAllocationsPerUser < 0 {
+			return fmt.Errorf("config: turn.max_allocations_per_user must not be negative, got %d", c.TURN.MaxAllocationsPerUser)
+		}
+	}
+
 	return nil
 }
-
@@ -45,6 +45,18 @@ func (r *Repository) ContactExists(ctx context.Context, ownerID, contactID uint6
 	return count > 0, err
 }
 
+// ListOwners 列出把 contactID 加为联系人的用户
+func (r *Repository) ListOwners(ctx context.Context, contactID uint64) ([]models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).
+		Table("contacts").
+		Select("users.*").
+		Joins("JOIN users ON contacts.owner_id = users.id").
+		Where("contacts.contact_id = ?", contactID).
+		Find(&users).Error
+	return users, err
+}
+
 // ListContacts 列出联系人（返回用户信息）
 func (r *Repository) ListContacts(ctx context.Context, ownerID uint64) ([]models.User, error) {
 	var users []models.User
@@ -55,6 +55,16 @@ func (s *Service) Remove(ctx context.Context, ownerID, contactID uint64) error {
 	return s.repo.RemoveContact(ctx, ownerID, contactID)
 }
 
+// IsContact contactID 是否在 ownerID 的联系人中
+func (s *Service) IsContact(ctx context.Context, ownerID, contactID uint64) (bool, error) {
+	return s.repo.ContactExists(ctx, ownerID, contactID)
+}
+
+// ListWatchers 列出把该用户加为联系人的用户，即关注其在线状态的人
+func (s *Service) ListWatchers(ctx context.Context, userID uint64) ([]models.User, error) {
+	return s.repo.ListOwners(ctx, userID)
+}
+
 // List 列出所有联系人
 func (s *Service) List(ctx context.Context, ownerID uint64) ([]models.User, error) {
 	return s.repo.ListContacts(ctx, ownerID)
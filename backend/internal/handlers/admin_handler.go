@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/turn"
+)
+
+// AdminHandler 运维管理接口
+// AdminHandler serves operator endpoints, guarded by auth.AdminMiddleware.
+type AdminHandler struct {
+	logger      zerolog.Logger
+	mediaEngine *media.Engine
+	turnServer  *turn.Server
+}
+
+// NewAdminHandler 构造函数；未启用内置 TURN 时 turnServer 为 nil
+// NewAdminHandler creates an AdminHandler. turnServer is nil when the
+// embedded TURN server is disabled.
+func NewAdminHandler(log zerolog.Logger, mediaEngine *media.Engine, turnServer *turn.Server) *AdminHandler {
+	return &AdminHandler{
+		logger:      log.With().Str("component", "admin_handler").Logger(),
+		mediaEngine: mediaEngine,
+		turnServer:  turnServer,
+	}
+}
+
+// RegisterRoutes 注册管理路由
+// RegisterRoutes attaches admin routes.
+func (h *AdminHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/peer-connections", h.handleListPeerConnections)
+	if h.turnServer != nil {
+		rg.GET("/turn/metrics", h.handleTURNMetrics)
+	}
+}
+
+type peerConnectionDTO struct {
+	CallID         string    `json:"call_id"`
+	Local          string    `json:"local"`
+	Remote         string    `json:"remote"`
+	Room           string    `json:"room,omitempty"`
+	CallState      string    `json:"call_state"`
+	State          string    `json:"state"`
+	ICEState       string    `json:"ice_state"`
+	SignalingState string    `json:"signaling_state"`
+	CreatedAt      time.Time `json:"created_at"`
+	AgeSeconds     float64   `json:"age_seconds"`
+	// 超过连接超时仍未连上，下一轮回收时结束
+	// Not connected within the connect timeout; the next reaping pass ends it.
+	Stalled bool `json:"stalled"`
+}
+
+// handleListPeerConnections 列出服务端对等连接及其存活时间与状态，最早创建的在前
+// handleListPeerConnections lists the server's peer connections with their age
+// and state, oldest first.
+func (h *AdminHandler) handleListPeerConnections(c *gin.Context) {
+	infos := h.mediaEngine.DescribePeerConnections()
+	items := make([]peerConnectionDTO, 0, len(infos))
+	for _, info := range infos {
+		items = append(items, peerConnectionDTO{
+			CallID:         info.CallID,
+			Local:          info.LocalEmail,
+			Remote:         info.RemoteEmail,
+			Room:           info.Room,
+			CallState:      info.CallState.String(),
+			State:          info.State.String(),
+			ICEState:       info.ICEState.String(),
+			SignalingState: info.SignalingState.String(),
+			CreatedAt:      info.CreatedAt,
+			AgeSeconds:     info.Age.Round(time.Second).Seconds(),
+			Stalled:        info.Stalled,
+		})
+	}
+	JSONSuccess(c, http.StatusOK, gin.H{"peer_connections": items})
+}
+
+// handleTURNMetrics 返回内置 TURN 服务的分配统计
+// handleTURNMetrics reports the embedded TURN server's allocations.
+func (h *AdminHandler) handleTURNMetrics(c *gin.Context) {
+	JSONSuccess(c, http.StatusOK, h.turnServer.Metrics())
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/callhistory"
+	"github.com/allcallall/backend/internal/models"
+)
+
+const (
+	defaultCallHistoryLimit = 50
+	maxCallHistoryLimit     = 200
+)
+
+// CallHandler 通话记录接口
+// CallHandler serves call history endpoints.
+type CallHandler struct {
+	logger  zerolog.Logger
+	history *callhistory.Service
+}
+
+// NewCallHandler 构造函数
+// NewCallHandler creates a CallHandler.
+func NewCallHandler(log zerolog.Logger, history *callhistory.Service) *CallHandler {
+	return &CallHandler{
+		logger:  log.With().Str("component", "call_handler").Logger(),
+		history: history,
+	}
+}
+
+// RegisterRoutes 注册通话记录路由
+// RegisterRoutes attaches call history routes.
+func (h *CallHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("", h.handleList)
+	rg.GET("/:call_id", h.handleGet)
+	rg.GET("/:call_id/recordings", h.handleListRecordings)
+	rg.GET("/:call_id/recordings/:recording_id", h.handleGetRecording)
+	rg.GET("/:call_id/recordings/:recording_id/files/:file", h.handleDownloadRecording)
+}
+
+type callMessageDTO struct {
+	Sender    string    `json:"sender"`
+	Kind      string    `json:"kind"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type callDTO struct {
+	CallID     string           `json:"call_id"`
+	Caller     string           `json:"caller"`
+	Callee     string           `json:"callee"`
+	Status     string           `json:"status"`
+	EndReason  string           `json:"end_reason,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	AnsweredAt *time.Time       `json:"answered_at,omitempty"`
+	EndedAt    *time.Time       `json:"ended_at,omitempty"`
+	Quality    *callQualityDTO  `json:"quality,omitempty"`
+	Transcript []callMessageDTO `json:"transcript"`
+}
+
+// callQualityDTO 服务端测得的通话质量平均值
+// callQualityDTO holds the averages of the server's quality samples of a call.
+type callQualityDTO struct {
+	MOS           float64 `json:"mos"`
+	RTTMs         float64 `json:"rtt_ms"`
+	JitterMs      float64 `json:"jitter_ms"`
+	PacketLossPct float64 `json:"packet_loss_pct"`
+	Samples       int     `json:"samples"`
+}
+
+func newCallDTO(entry callhistory.Entry) callDTO {
+	transcript := make([]callMessageDTO, 0, len(entry.Messages))
+	for _, m := range entry.Messages {
+		transcript = append(transcript, callMessageDTO{
+			Sender:    m.SenderEmail,
+			Kind:      m.Kind,
+			Body:      m.Body,
+			CreatedAt: m.CreatedAt,
+		})
+	}
+	r := entry.Record
+	var quality *callQualityDTO
+	if r.QualitySamples > 0 && r.MOS != nil {
+		quality = &callQualityDTO{
+			MOS:           *r.MOS,
+			RTTMs:         valueOf(r.AvgRTTMs),
+			JitterMs:      valueOf(r.AvgJitterMs),
+			PacketLossPct: valueOf(r.AvgPacketLossPct),
+			Samples:       r.QualitySamples,
+		}
+	}
+	return callDTO{
+		CallID:     r.CallID,
+		Caller:     r.CallerEmail,
+		Callee:     r.CalleeEmail,
+		Status:     r.Status,
+		EndReason:  r.EndReason,
+		StartedAt:  r.StartedAt,
+		AnsweredAt: r.AnsweredAt,
+		EndedAt:    r.EndedAt,
+		Quality:    quality,
+		Transcript: transcript,
+	}
+}
+
+func valueOf(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func (h *CallHandler) handleList(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	limit := defaultCallHistoryLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			JSONError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if n > maxCallHistoryLimit {
+			n = maxCallHistoryLimit
+		}
+		limit = n
+	}
+	var before time.Time
+	if raw := c.Query("before"); raw != "" {
+		if before, err = time.Parse(time.RFC3339, raw); err != nil {
+			JSONError(c, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return
+		}
+	}
+
+	entries, err := h.history.ListForUser(c.Request.Context(), claims.Email, before, limit)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("list call history failed")
+		JSONError(c, http.StatusInternalServerError, "failed to list calls")
+		return
+	}
+
+	response := make([]callDTO, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, newCallDTO(entry))
+	}
+	JSONSuccess(c, http.StatusOK, gin.H{"calls": response})
+}
+
+func (h *CallHandler) handleGet(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	entry, err := h.history.Get(c.Request.Context(), c.Param("call_id"), claims.Email)
+	switch {
+	case err == nil:
+		JSONSuccess(c, http.StatusOK, gin.H{"call": newCallDTO(*entry)})
+	case errors.Is(err, callhistory.ErrNotFound):
+		JSONError(c, http.StatusNotFound, "call not found")
+	case errors.Is(err, callhistory.ErrForbidden):
+		JSONError(c, http.StatusForbidden, "not a participant of this call")
+	default:
+		h.logger.Error().Err(err).Str("call_id", c.Param("call_id")).Msg("load call failed")
+		JSONError(c, http.StatusInternalServerError, "failed to load call")
+	}
+}
+
+type recordingDTO struct {
+	RecordingID string     `json:"recording_id"`
+	CallID      string     `json:"call_id"`
+	Room        string     `json:"room,omitempty"`
+	StartedBy   string     `json:"started_by"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+}
+
+func newRecordingDTO(rec models.CallRecording) recordingDTO {
+	return recordingDTO{
+		RecordingID: rec.RecordingID,
+		CallID:      rec.CallID,
+		Room:        rec.Room,
+		StartedBy:   rec.StartedBy,
+		StartedAt:   rec.StartedAt,
+		EndedAt:     rec.EndedAt,
+	}
+}
+
+func (h *CallHandler) handleListRecordings(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	recs, err := h.history.ListRecordings(c.Request.Context(), c.Param("call_id"), claims.Email)
+	if err != nil {
+		h.respondRecordingError(c, err)
+		return
+	}
+	response := make([]recordingDTO, 0, len(recs))
+	for _, rec := range recs {
+		response = append(response, newRecordingDTO(rec))
+	}
+	JSONSuccess(c, http.StatusOK, gin.H{"recordings": response})
+}
+
+func (h *CallHandler) handleGetRecording(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	rec, manifest, err := h.history.RecordingManifest(c.Request.Context(), c.Param("call_id"), c.Param("recording_id"), claims.Email)
+	if err != nil {
+		h.respondRecordingError(c, err)
+		return
+	}
+	JSONSuccess(c, http.StatusOK, gin.H{"recording": newRecordingDTO(*rec), "manifest": manifest})
+}
+
+func (h *CallHandler) handleDownloadRecording(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	name := c.Param("file")
+	path, err := h.history.RecordingFile(c.Request.Context(), c.Param("call_id"), c.Param("recording_id"), claims.Email, name)
+	if err != nil {
+		h.respondRecordingError(c, err)
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+func (h *CallHandler) respondRecordingError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, callhistory.ErrNotFound):
+		JSONError(c, http.StatusNotFound, "recording not found")
+	case errors.Is(err, callhistory.ErrForbidden):
+		JSONError(c, http.StatusForbidden, "not a participant of this recording")
+	case errors.Is(err, callhistory.ErrRecordingInProgress):
+		JSONError(c, http.StatusConflict, "recording is still in progress")
+	default:
+		h.logger.Error().Err(err).Str("call_id", c.Param("call_id")).Msg("load recording failed")
+		JSONError(c, http.StatusInternalServerError, "failed to load recording")
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/models"
+	"github.com/allcallall/backend/internal/privacy"
+	"github.com/allcallall/backend/internal/user"
+)
+
+// PrivacyHandler 隐私设置与屏蔽列表接口
+// PrivacyHandler serves privacy settings and block list endpoints.
+type PrivacyHandler struct {
+	logger  zerolog.Logger
+	privacy *privacy.Service
+}
+
+// NewPrivacyHandler 构造函数
+// NewPrivacyHandler creates a PrivacyHandler.
+func NewPrivacyHandler(log zerolog.Logger, privacy *privacy.Service) *PrivacyHandler {
+	return &PrivacyHandler{
+		logger:  log.With().Str("component", "privacy_handler").Logger(),
+		privacy: privacy,
+	}
+}
+
+// RegisterRoutes 注册隐私路由（挂在 /users 下）
+// RegisterRoutes attaches privacy routes to the users group.
+func (h *PrivacyHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/privacy", h.handleGetSettings)
+	rg.PUT("/privacy", h.handleUpdateSettings)
+
+	blocksGroup := rg.Group("/blocks")
+	blocksGroup.GET("", h.handleListBlocked)
+	blocksGroup.POST("", h.handleBlock)
+	blocksGroup.DELETE("/:id", h.handleUnblock)
+}
+
+type privacySettingsDTO struct {
+	CallPolicy         string `json:"call_policy"`
+	DND                dndDTO `json:"dnd"`
+	OnlineVisibility   string `json:"online_visibility"`
+	LastSeenVisibility string `json:"last_seen_visibility"`
+}
+
+type dndDTO struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+func toPrivacySettingsDTO(s *models.PrivacySettings) privacySettingsDTO {
+	return privacySettingsDTO{
+		CallPolicy: s.CallPolicy,
+		DND: dndDTO{
+			Enabled:  s.DNDEnabled,
+			Start:    s.DNDStart,
+			End:      s.DNDEnd,
+			Timezone: s.DNDTimezone,
+		},
+		OnlineVisibility:   s.OnlineVisibility,
+		LastSeenVisibility: s.LastSeenVisibility,
+	}
+}
+
+func (h *PrivacyHandler) handleGetSettings(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	settings, err := h.privacy.GetSettings(c.Request.Context(), claims.UserID)
+	if err != nil {
+		h.logger.Error().Err(err).Uint64("user_id", claims.UserID).Msg("load privacy settings failed")
+		JSONError(c, http.StatusInternalServerError, "failed to load privacy settings")
+		return
+	}
+
+	JSONSuccess(c, http.StatusOK, gin.H{"privacy": toPrivacySettingsDTO(settings)})
+}
+
+type updatePrivacyRequest struct {
+	CallPolicy *string `json:"call_policy"`
+	DND        *struct {
+		Enabled  *bool   `json:"enabled"`
+		Start    *string `json:"start"`
+		End      *string `json:"end"`
+		Timezone *string `json:"timezone"`
+	} `json:"dnd"`
+	OnlineVisibility   *string `json:"online_visibility"`
+	LastSeenVisibility *string `json:"last_seen_visibility"`
+}
+
+func (h *PrivacyHandler) handleUpdateSettings(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req updatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	in := privacy.UpdateSettingsInput{
+		CallPolicy:         req.CallPolicy,
+		OnlineVisibility:   req.OnlineVisibility,
+		LastSeenVisibility: req.LastSeenVisibility,
+	}
+	if req.DND != nil {
+		in.DNDEnabled = req.DND.Enabled
+		in.DNDStart = req.DND.Start
+		in.DNDEnd = req.DND.End
+		in.DNDTimezone = req.DND.Timezone
+	}
+
+	settings, err := h.privacy.UpdateSettings(c.Request.Context(), claims.UserID, in)
+	if err != nil {
+		if errors.Is(err, privacy.ErrInvalidSettings) {
+			JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Uint64("user_id", claims.UserID).Msg("update privacy settings failed")
+		JSONError(c, http.StatusInternalServerError, "failed to update privacy settings")
+		return
+	}
+
+	JSONSuccess(c, http.StatusOK, gin.H{"privacy": toPrivacySettingsDTO(settings)})
+}
+
+type blockRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func (h *PrivacyHandler) handleBlock(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req blockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.privacy.Block(c.Request.Context(), claims.UserID, strings.TrimSpace(req.Email)); err != nil {
+		switch {
+		case errors.Is(err, privacy.ErrSelfBlock):
+			JSONError(c, http.StatusBadRequest, "cannot block yourself")
+		case errors.Is(err, user.ErrNotFound):
+			JSONError(c, http.StatusNotFound, "user not found")
+		default:
+			h.logger.Error().Err(err).Msg("block user failed")
+			JSONError(c, http.StatusInternalServerError, "failed to block user")
+		}
+		return
+	}
+
+	JSONSuccess(c, http.StatusCreated, gin.H{"success": true})
+}
+
+func (h *PrivacyHandler) handleListBlocked(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	blocked, err := h.privacy.ListBlocked(c.Request.Context(), claims.UserID)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("list blocked users failed")
+		JSONError(c, http.StatusInternalServerError, "failed to list blocked users")
+		return
+	}
+
+	response := make([]userDTO, 0, len(blocked))
+	for _, u := range blocked {
+		response = append(response, userDTO{
+			ID:          u.ID,
+			Email:       u.Email,
+			DisplayName: u.DisplayName,
+		})
+	}
+
+	JSONSuccess(c, http.StatusOK, gin.H{"blocked": response})
+}
+
+func (h *PrivacyHandler) handleUnblock(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	blockedID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		JSONError(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err := h.privacy.Unblock(c.Request.Context(), claims.UserID, blockedID); err != nil {
+		h.logger.Error().Err(err).Uint64("blocked_id", blockedID).Msg("unblock user failed")
+		JSONError(c, http.StatusInternalServerError, "failed to unblock user")
+		return
+	}
+
+	JSONSuccess(c, http.StatusOK, gin.H{"success": true})
+}
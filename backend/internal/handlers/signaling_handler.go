@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -11,8 +13,17 @@ import (
 	"github.com/allcallall/backend/internal/signaling"
 )
 
+// SignalingSessionHeader HTTP 提交时携带的会话 ID 请求头
+// SignalingSessionHeader carries the event stream's session ID on POST /signal.
+const SignalingSessionHeader = "X-Signal-Session"
+
+// maxSignalBodyBytes 单条 HTTP 信令消息的大小上限
+// maxSignalBodyBytes bounds a single signaling message submitted over HTTP.
+const maxSignalBodyBytes = 64 << 10
+
 // SignalingHandler 信令处理器
-// SignalingHandler upgrades HTTP requests to WebSocket for signaling.
+// SignalingHandler upgrades HTTP requests to WebSocket for signaling, and
+// serves the SSE + POST fallback for networks that block WebSockets.
 type SignalingHandler struct {
 	logger   zerolog.Logger
 	hub      *signaling.Hub
@@ -26,7 +37,8 @@ func NewSignalingHandler(log zerolog.Logger, hub *signaling.Hub) *SignalingHandl
 		logger: log.With().Str("component", "signaling_handler").Logger(),
 		hub:    hub,
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
+			CheckOrigin:  func(r *http.Request) bool { return true },
+			Subprotocols: signaling.Subprotocols(),
 		},
 	}
 }
@@ -45,6 +57,14 @@ func (h *SignalingHandler) Handle(c *gin.Context) {
 
 	h.logger.Info().Str("email", claims.Email).Msg("websocket upgrade attempt")
 
+	// 排空中的节点不再接受新连接，让负载均衡器转到其他节点
+	// A draining node refuses new sockets so the load balancer retries elsewhere.
+	if h.hub.Draining() {
+		c.Header("Retry-After", "1")
+		JSONError(c, http.StatusServiceUnavailable, "server draining")
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("failed to upgrade websocket")
@@ -52,5 +72,86 @@ func (h *SignalingHandler) Handle(c *gin.Context) {
 	}
 
 	h.logger.Info().Str("email", claims.Email).Msg("websocket connection established")
-	h.hub.HandleConnection(c.Request.Context(), claims.Email, conn)
+	h.hub.HandleConnection(c.Request.Context(), claims.Email, c.Query("device"), conn)
+}
+
+// HandleSchema 返回信令协议的 JSON Schema
+// HandleSchema serves the machine-readable signaling protocol schema.
+func (h *SignalingHandler) HandleSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", signaling.ProtocolSchema())
+}
+
+// HandleEvents 以 SSE 推送信令消息（WebSocket 不可用时的回退）
+// HandleEvents streams signaling messages as Server-Sent Events for clients
+// that cannot open a WebSocket. The first event is a hello.ack with the
+// session ID to send in the X-Signal-Session header of POST /signal.
+func (h *SignalingHandler) HandleEvents(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if h.hub.Draining() {
+		c.Header("Retry-After", "1")
+		JSONError(c, http.StatusServiceUnavailable, "server draining")
+		return
+	}
+
+	h.logger.Info().Str("email", claims.Email).Msg("event stream established")
+	err = h.hub.HandleStream(c.Request.Context(), claims.Email, c.Query("device"), c.Writer)
+	switch {
+	case errors.Is(err, signaling.ErrStreamingUnsupported):
+		JSONError(c, http.StatusInternalServerError, "streaming unsupported")
+	case err != nil:
+		h.logger.Warn().Err(err).Str("email", claims.Email).Msg("event stream closed with error")
+	}
+}
+
+// HandlePost 接收通过 HTTP 发送的客户端信令消息
+// HandlePost accepts one client signaling message for an event stream session.
+// The Content-Type selects the codec: JSON by default, MessagePack or Protobuf.
+func (h *SignalingHandler) HandlePost(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	sessionID := c.GetHeader(SignalingSessionHeader)
+	if sessionID == "" {
+		JSONError(c, http.StatusBadRequest, SignalingSessionHeader+" header is required")
+		return
+	}
+
+	codec, ok := signaling.CodecForContentType(c.ContentType())
+	if !ok {
+		JSONError(c, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignalBodyBytes))
+	if err != nil {
+		JSONError(c, http.StatusRequestEntityTooLarge, "message too large")
+		return
+	}
+
+	err = h.hub.Submit(c.Request.Context(), claims.Email, sessionID, codec, body)
+	var perr *signaling.ProtocolError
+	switch {
+	case err == nil:
+		JSONSuccess(c, http.StatusAccepted, nil)
+	case errors.As(err, &perr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      perr.Description,
+			"code":       perr.Code,
+			"message_id": perr.MessageID,
+			"success":    false,
+		})
+	case errors.Is(err, signaling.ErrSessionNotFound):
+		JSONError(c, http.StatusNotFound, "signaling session not found")
+	default:
+		h.logger.Error().Err(err).Str("email", claims.Email).Msg("failed to submit signaling message")
+		JSONError(c, http.StatusInternalServerError, "failed to submit message")
+	}
 }
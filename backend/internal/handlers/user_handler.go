@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
@@ -11,9 +14,14 @@ import (
 	"github.com/allcallall/backend/internal/auth"
 	"github.com/allcallall/backend/internal/contact"
 	"github.com/allcallall/backend/internal/presence"
+	"github.com/allcallall/backend/internal/privacy"
 	"github.com/allcallall/backend/internal/user"
 )
 
+// maxPresenceEmails 单次在线状态查询最多的邮箱数
+// maxPresenceEmails caps how many users one presence request may ask about.
+const maxPresenceEmails = 50
+
 // UserHandler 用户相关接口
 // UserHandler serves user-focused endpoints.
 type UserHandler struct {
@@ -21,16 +29,18 @@ type UserHandler struct {
 	users    *user.Service
 	presence *presence.Manager
 	contacts *contact.Service
+	privacy  *privacy.Service
 }
 
 // NewUserHandler 构造函数
 // NewUserHandler creates a UserHandler.
-func NewUserHandler(log zerolog.Logger, users *user.Service, presence *presence.Manager, contacts *contact.Service) *UserHandler {
+func NewUserHandler(log zerolog.Logger, users *user.Service, presence *presence.Manager, contacts *contact.Service, privacy *privacy.Service) *UserHandler {
 	return &UserHandler{
 		logger:   log.With().Str("component", "user_handler").Logger(),
 		users:    users,
 		presence: presence,
 		contacts: contacts,
+		privacy:  privacy,
 	}
 }
 
@@ -40,6 +50,8 @@ func (h *UserHandler) RegisterRoutes(rg *gin.RouterGroup) {
 	rg.GET("/me", h.handleMe)
 	rg.GET("/search", h.handleSearch)
 	rg.GET("/presence", h.handlePresence)
+	rg.GET("/presence/status", h.handleGetStatus)
+	rg.PUT("/presence/status", h.handleSetStatus)
 	rg.POST("/change-password", h.handleChangePassword)
 
 	contactsGroup := rg.Group("/contacts")
@@ -119,9 +131,11 @@ func (h *UserHandler) handlePresence(c *gin.Context) {
 	if raw == "" {
 		emails = []string{claims.Email}
 	} else {
+		seen := make(map[string]struct{})
 		for _, part := range strings.Split(raw, ",") {
 			email := strings.TrimSpace(part)
-			if email != "" {
+			if _, dup := seen[email]; email != "" && !dup {
+				seen[email] = struct{}{}
 				emails = append(emails, email)
 			}
 		}
@@ -129,7 +143,17 @@ func (h *UserHandler) handlePresence(c *gin.Context) {
 			emails = []string{claims.Email}
 		}
 	}
+	if len(emails) > maxPresenceEmails {
+		JSONError(c, http.StatusBadRequest, fmt.Sprintf("at most %d emails per request", maxPresenceEmails))
+		return
+	}
 
+	access, err := h.privacy.PresenceAccess(c.Request.Context(), claims.UserID, emails)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to check presence access")
+		JSONError(c, http.StatusInternalServerError, "failed to fetch presence")
+		return
+	}
 	statuses, err := h.presence.GetStatuses(c.Request.Context(), emails)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("failed to fetch presence")
@@ -140,16 +164,113 @@ func (h *UserHandler) handlePresence(c *gin.Context) {
 	resp := make([]gin.H, 0, len(statuses))
 	for _, email := range emails {
 		status := statuses[email]
-		resp = append(resp, gin.H{
-			"email":     status.Email,
-			"online":    status.Online,
-			"last_seen": status.LastSeen,
-		})
+		if email != claims.Email {
+			// 先按隐身处理，再按对方隐私设置隐藏
+			// Invisible users look offline first, then the user's privacy settings apply.
+			status = status.Visible().Restrict(access[email].Online, access[email].LastSeen)
+		}
+		resp = append(resp, presenceResponse(status))
 	}
 
 	JSONSuccess(c, http.StatusOK, gin.H{"presence": resp})
 }
 
+// presenceResponse 被隐藏的最后在线时间返回 null
+// presenceResponse renders a status; a hidden last seen time is null.
+func presenceResponse(status presence.Status) gin.H {
+	var lastSeen *time.Time
+	if !status.LastSeen.IsZero() {
+		lastSeen = &status.LastSeen
+	}
+	return gin.H{
+		"email":              status.Email,
+		"online":             status.Online,
+		"last_seen":          lastSeen,
+		"devices":            status.Devices,
+		"availability":       status.Availability,
+		"message":            status.Message,
+		"message_expires_at": status.MessageExpiresAt,
+	}
+}
+
+func preferenceResponse(pref presence.Preference) gin.H {
+	return gin.H{
+		"availability":       pref.Availability,
+		"message":            pref.Message,
+		"message_expires_at": pref.MessageExpiresAt,
+	}
+}
+
+// handleGetStatus 返回自己设置的状态及当前对外显示的状态
+// handleGetStatus returns the caller's chosen status and their derived presence.
+func (h *UserHandler) handleGetStatus(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	pref, err := h.presence.GetPreference(c.Request.Context(), claims.Email)
+	if err != nil {
+		h.logger.Error().Err(err).Str("email", claims.Email).Msg("failed to load presence status")
+		JSONError(c, http.StatusInternalServerError, "failed to load status")
+		return
+	}
+	h.respondStatus(c, claims.Email, pref)
+}
+
+type setStatusRequest struct {
+	Availability     *string    `json:"availability"`
+	Message          *string    `json:"message"`
+	MessageExpiresAt *time.Time `json:"message_expires_at"`
+}
+
+// handleSetStatus 设置可用状态与自定义消息；省略的字段保持不变
+// handleSetStatus sets the caller's availability and custom message. Omitted
+// fields keep their value.
+func (h *UserHandler) handleSetStatus(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req setStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pref, err := h.presence.UpdatePreference(c.Request.Context(), claims.Email, presence.PreferenceInput{
+		Availability:     req.Availability,
+		Message:          req.Message,
+		MessageExpiresAt: req.MessageExpiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, presence.ErrInvalidStatus) {
+			JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Str("email", claims.Email).Msg("failed to update presence status")
+		JSONError(c, http.StatusInternalServerError, "failed to update status")
+		return
+	}
+	h.respondStatus(c, claims.Email, pref)
+}
+
+func (h *UserHandler) respondStatus(c *gin.Context, email string, pref presence.Preference) {
+	status, err := h.presence.GetStatus(c.Request.Context(), email)
+	if err != nil {
+		h.logger.Error().Err(err).Str("email", email).Msg("failed to fetch presence")
+		JSONError(c, http.StatusInternalServerError, "failed to fetch presence")
+		return
+	}
+	JSONSuccess(c, http.StatusOK, gin.H{
+		"status":   preferenceResponse(pref),
+		"presence": presenceResponse(status),
+	})
+}
+
 type addContactRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/turn"
+)
+
+// WebRTCHandler 客户端 WebRTC 配置接口
+// WebRTCHandler serves the WebRTC configuration clients should use, so they
+// do not hard-code ICE servers.
+type WebRTCHandler struct {
+	logger zerolog.Logger
+	cfg    config.WebRTCConfig
+}
+
+// NewWebRTCHandler 构造函数
+// NewWebRTCHandler creates a WebRTCHandler.
+func NewWebRTCHandler(log zerolog.Logger, cfg config.WebRTCConfig) *WebRTCHandler {
+	return &WebRTCHandler{
+		logger: log.With().Str("component", "webrtc_handler").Logger(),
+		cfg:    cfg,
+	}
+}
+
+// RegisterRoutes 注册 WebRTC 路由
+// RegisterRoutes attaches WebRTC routes.
+func (h *WebRTCHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/config", h.handleConfig)
+}
+
+// iceServerDTO 与 RTCIceServer 字段一致，可直接传给 RTCPeerConnection
+// iceServerDTO matches RTCIceServer, so clients can pass it to RTCPeerConnection as is.
+type iceServerDTO struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type webrtcConfigDTO struct {
+	ICEServers         []iceServerDTO `json:"ice_servers"`
+	ICETransportPolicy string         `json:"ice_transport_policy"`
+	BundlePolicy       string         `json:"bundle_policy"`
+	// 临时 TURN 凭据的过期时间，客户端应在此之前重新获取
+	// When the ephemeral TURN credentials expire; clients fetch the config again before then.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (h *WebRTCHandler) handleConfig(c *gin.Context) {
+	claims, err := auth.GetClaimsFromContext(c)
+	if err != nil {
+		JSONError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	// TURN 用户名中使用用户 ID 而非邮箱，避免邮箱出现在 TURN 日志中
+	// Credentials name the user by ID rather than email, keeping emails out of TURN logs.
+	iceServers, expiresAt := turn.ICEServers(h.cfg.ICEServers, strconv.FormatUint(claims.UserID, 10), h.cfg.TURNCredentialTTL(), time.Now())
+	servers := make([]iceServerDTO, 0, len(iceServers))
+	for _, s := range iceServers {
+		servers = append(servers, iceServerDTO{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
+	}
+	response := webrtcConfigDTO{
+		ICEServers:         servers,
+		ICETransportPolicy: h.cfg.ICETransportPolicy,
+		BundlePolicy:       h.cfg.BundlePolicy,
+	}
+	if !expiresAt.IsZero() {
+		response.ExpiresAt = &expiresAt
+		c.Header("Cache-Control", "no-store")
+	}
+	JSONSuccess(c, http.StatusOK, response)
+}
@@ -0,0 +1,310 @@
+package media
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// 可配置的编解码器名称
+// Names of the codecs that can be configured.
+const (
+	CodecOpus = "opus"
+	CodecG722 = "g722"
+	CodecPCMU = "pcmu"
+	CodecPCMA = "pcma"
+	CodecVP8  = "vp8"
+	CodecVP9  = "vp9"
+	CodecH264 = "h264"
+	CodecAV1  = "av1"
+)
+
+// 默认的编解码器及偏好顺序，与 Pion 默认支持的一致
+// Default codecs in order of preference, the ones Pion supports by default.
+var (
+	DefaultAudioCodecs = []string{CodecOpus, CodecG722, CodecPCMU, CodecPCMA}
+	DefaultVideoCodecs = []string{CodecVP8, CodecVP9, CodecH264, CodecAV1}
+)
+
+// Codecs 引擎接受的编解码器，按偏好排序
+// Codecs lists the codecs the engine negotiates, most preferred first. Empty
+// lists use the defaults.
+type Codecs struct {
+	Audio []string
+	Video []string
+	// 不协商视频，只建立音频
+	// DisableVideo negotiates audio only; video sections are rejected.
+	DisableVideo bool
+}
+
+// Interceptors RTP/RTCP 拦截器设置。间隔为 0 时使用 Pion 默认值，为负数时关闭该拦截器
+// Interceptors configures the RTP/RTCP interceptors. A zero interval uses
+// Pion's default and a negative one turns the interceptor off.
+type Interceptors struct {
+	// RTCP 发送端与接收端报告
+	// RTCP sender and receiver reports.
+	RTCPReportInterval time.Duration
+	// 丢包重传请求
+	// NACK generation and retransmission.
+	NACKInterval time.Duration
+	// 传输层拥塞控制反馈
+	// Transport-wide congestion control feedback.
+	TWCCInterval time.Duration
+	// 定期向发送方请求关键帧；为 0 时不请求
+	// Periodic keyframe requests for received video. Zero sends none.
+	PLIInterval time.Duration
+}
+
+// newAPI 按配置的编解码器、拦截器与网络设置创建 WebRTC API，另加统计拦截器
+// newAPI builds a WebRTC API with the configured codecs, interceptors and
+// network settings, plus the stats interceptor that records RTP stream
+// statistics. Each peer connection gets its own API; the returned function
+// yields the stats of the peer connection created from it.
+func (e *Engine) newAPI() (*webrtc.API, func() stats.Getter, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	registry := &interceptor.Registry{}
+	nackEnabled := e.interceptors.NACKInterval >= 0
+
+	// 视频编解码器都声明 PLI 与 FIR；NACK 仅在启用时声明
+	// Every video codec offers PLI and FIR; NACK only when it is enabled.
+	feedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack", Parameter: "pli"}}
+	if nackEnabled {
+		feedback = append(feedback, webrtc.RTCPFeedback{Type: "nack"})
+	}
+	catalog := codecCatalog(feedback)
+	for _, name := range e.audioCodecs() {
+		for _, codec := range catalog[name] {
+			if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, name := range e.videoCodecs() {
+		for _, codec := range catalog[name] {
+			if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, nil, err
+	}
+
+	if interval := e.interceptors.RTCPReportInterval; interval >= 0 {
+		var receiverOpts []report.ReceiverOption
+		var senderOpts []report.SenderOption
+		if interval > 0 {
+			receiverOpts = append(receiverOpts, report.ReceiverInterval(interval))
+			senderOpts = append(senderOpts, report.SenderInterval(interval))
+		}
+		receiver, err := report.NewReceiverInterceptor(receiverOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		sender, err := report.NewSenderInterceptor(senderOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		registry.Add(receiver)
+		registry.Add(sender)
+	}
+
+	if nackEnabled {
+		var generatorOpts []nack.GeneratorOption
+		if interval := e.interceptors.NACKInterval; interval > 0 {
+			generatorOpts = append(generatorOpts, nack.GeneratorInterval(interval))
+		}
+		generator, err := nack.NewGeneratorInterceptor(generatorOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		responder, err := nack.NewResponderInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		registry.Add(responder)
+		registry.Add(generator)
+	}
+
+	if interval := e.interceptors.TWCCInterval; interval >= 0 {
+		for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+			mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}, typ)
+			if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.TransportCCURI}, typ); err != nil {
+				return nil, nil, err
+			}
+		}
+		var opts []twcc.Option
+		if interval > 0 {
+			opts = append(opts, twcc.SendInterval(interval))
+		}
+		generator, err := twcc.NewSenderInterceptor(opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		registry.Add(generator)
+	}
+
+	if interval := e.interceptors.PLIInterval; interval > 0 {
+		pli, err := intervalpli.NewReceiverInterceptor(intervalpli.GeneratorInterval(interval))
+		if err != nil {
+			return nil, nil, err
+		}
+		registry.Add(pli)
+	}
+
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, nil, err
+	}
+	var getter stats.Getter
+	statsInterceptor.OnNewPeerConnection(func(_ string, g stats.Getter) {
+		getter = g
+	})
+	registry.Add(statsInterceptor)
+
+	settingEngine, err := e.settingEngine()
+	if err != nil {
+		return nil, nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry), webrtc.WithSettingEngine(settingEngine))
+	return api, func() stats.Getter { return getter }, nil
+}
+
+func (e *Engine) audioCodecs() []string {
+	if len(e.codecs.Audio) == 0 {
+		return DefaultAudioCodecs
+	}
+	return e.codecs.Audio
+}
+
+func (e *Engine) videoCodecs() []string {
+	if e.codecs.DisableVideo {
+		return nil
+	}
+	if len(e.codecs.Video) == 0 {
+		return DefaultVideoCodecs
+	}
+	return e.codecs.Video
+}
+
+// validateCodecs 检查编解码器名称是否受支持
+// validateCodecs checks that every configured codec is one the engine knows,
+// of the right kind.
+func validateCodecs(codecs Codecs) error {
+	catalog := codecCatalog(nil)
+	for kind, names := range map[string][]string{"audio": codecs.Audio, "video": codecs.Video} {
+		for _, name := range names {
+			params, ok := catalog[name]
+			if !ok || !strings.HasPrefix(params[0].MimeType, kind+"/") {
+				return fmt.Errorf("unknown %s codec %q", kind, name)
+			}
+		}
+	}
+	return nil
+}
+
+// PreferCodecs 按配置的偏好重新排列连接各收发器的编解码器
+// PreferCodecs orders the codecs of every transceiver of a peer connection by
+// the configured preference. Answers otherwise list codecs in the order of
+// the remote offer, so call it after applying an offer and before answering,
+// and before creating an offer.
+func (e *Engine) PreferCodecs(peerConn *PeerConnection) error {
+	rank := make(map[string]int)
+	for i, name := range e.audioCodecs() {
+		rank[name] = i
+	}
+	for i, name := range e.videoCodecs() {
+		rank[name] = i
+	}
+	rankOf := func(codec webrtc.RTPCodecParameters) int {
+		_, name, _ := strings.Cut(strings.ToLower(codec.MimeType), "/")
+		if r, ok := rank[name]; ok {
+			return r
+		}
+		// 重传（RTX）等辅助编解码器排在最后
+		// Auxiliary codecs such as RTX go last.
+		return len(rank)
+	}
+
+	for _, transceiver := range peerConn.PC.GetTransceivers() {
+		receiver := transceiver.Receiver()
+		if receiver == nil {
+			continue
+		}
+		// 复制一份：未设置偏好时返回的是媒体引擎内部的切片
+		// Copy them: without preferences set, this is the media engine's own slice.
+		codecs := append([]webrtc.RTPCodecParameters(nil), receiver.GetParameters().Codecs...)
+		if len(codecs) == 0 {
+			continue
+		}
+		sort.SliceStable(codecs, func(i, j int) bool {
+			return rankOf(codecs[i]) < rankOf(codecs[j])
+		})
+		if err := transceiver.SetCodecPreferences(codecs); err != nil {
+			return fmt.Errorf("prefer codecs: %w", err)
+		}
+	}
+	return nil
+}
+
+// codecCatalog 各编解码器的 RTP 参数（含重传），与 Pion 的默认注册一致
+// codecCatalog returns the RTP parameters of each codec, with their RTX
+// retransmission codecs, as Pion registers them by default. Video codecs
+// offer the given RTCP feedback.
+func codecCatalog(feedback []webrtc.RTCPFeedback) map[string][]webrtc.RTPCodecParameters {
+	video := func(mimeType, fmtp string, payloadType, rtxPayloadType webrtc.PayloadType) []webrtc.RTPCodecParameters {
+		return []webrtc.RTPCodecParameters{
+			{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000, SDPFmtpLine: fmtp, RTCPFeedback: feedback},
+				PayloadType:        payloadType,
+			},
+			{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeRTX, ClockRate: 90000, SDPFmtpLine: fmt.Sprintf("apt=%d", payloadType)},
+				PayloadType:        rtxPayloadType,
+			},
+		}
+	}
+	audio := func(mimeType string, clockRate uint32, channels uint16, fmtp string, payloadType webrtc.PayloadType) []webrtc.RTPCodecParameters {
+		return []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: clockRate, Channels: channels, SDPFmtpLine: fmtp},
+			PayloadType:        payloadType,
+		}}
+	}
+
+	var h264 []webrtc.RTPCodecParameters
+	for _, variant := range []struct {
+		fmtp               string
+		payloadType, rtxPT webrtc.PayloadType
+	}{
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", 102, 103},
+		{"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f", 104, 105},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", 106, 107},
+		{"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f", 108, 109},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", 127, 125},
+		{"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=4d001f", 39, 40},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f", 112, 113},
+	} {
+		h264 = append(h264, video(webrtc.MimeTypeH264, variant.fmtp, variant.payloadType, variant.rtxPT)...)
+	}
+
+	return map[string][]webrtc.RTPCodecParameters{
+		CodecOpus: audio(webrtc.MimeTypeOpus, 48000, 2, "minptime=10;useinbandfec=1", 111),
+		CodecG722: audio(webrtc.MimeTypeG722, 8000, 0, "", 9),
+		CodecPCMU: audio(webrtc.MimeTypePCMU, 8000, 0, "", 0),
+		CodecPCMA: audio(webrtc.MimeTypePCMA, 8000, 0, "", 8),
+		CodecVP8:  video(webrtc.MimeTypeVP8, "", 96, 97),
+		CodecVP9:  append(video(webrtc.MimeTypeVP9, "profile-id=0", 98, 99), video(webrtc.MimeTypeVP9, "profile-id=2", 100, 101)...),
+		CodecH264: h264,
+		CodecAV1:  video(webrtc.MimeTypeAV1, "", 45, 46),
+	}
+}
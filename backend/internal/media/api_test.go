@@ -0,0 +1,257 @@
+package media_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// TestCodecPreferences 服务端对同一个客户端 offer 按配置给出的 answer，以及服务端自己的 offer
+// TestCodecPreferences checks the answers the engine gives to the same client
+// offer under different codec settings, and the offers it makes itself.
+func TestCodecPreferences(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		answer := negotiate(t, media.Codecs{})
+		if got := firstCodec(t, answer, "audio"); got != "opus" {
+			t.Errorf("audio answered with %s, want opus", got)
+		}
+		if got := firstCodec(t, answer, "video"); got != "VP8" {
+			t.Errorf("video answered with %s, want the client's first choice VP8", got)
+		}
+	})
+
+	t.Run("prefer H.264", func(t *testing.T) {
+		codecs := media.Codecs{Video: []string{"h264", "vp8"}}
+		answer := negotiate(t, codecs)
+		if got := firstCodec(t, answer, "video"); got != "H264" {
+			t.Errorf("video answered with %s, want H264 ahead of the client's VP8", got)
+		}
+		if !hasCodec(t, answer, "video", "VP8") {
+			t.Error("VP8 not allowed as a fallback")
+		}
+		if got := firstCodec(t, serverOffer(t, &media.Config{Codecs: codecs}), "video"); got != "H264" {
+			t.Errorf("server offer lists %s first, want H264", got)
+		}
+	})
+
+	t.Run("only allowed codecs", func(t *testing.T) {
+		answer := negotiate(t, media.Codecs{Audio: []string{"pcmu"}, Video: []string{"h264"}})
+		if firstCodec(t, answer, "video") != "H264" || hasCodec(t, answer, "video", "VP8") || hasCodec(t, answer, "video", "VP9") {
+			t.Errorf("video answered with %v, want only H264", codecNames(t, answer, "video"))
+		}
+		if firstCodec(t, answer, "audio") != "PCMU" || hasCodec(t, answer, "audio", "opus") {
+			t.Errorf("audio answered with %v, want only PCMU", codecNames(t, answer, "audio"))
+		}
+	})
+
+	t.Run("video disabled", func(t *testing.T) {
+		answer := negotiate(t, media.Codecs{DisableVideo: true})
+		if !rejected(t, answer, "video") {
+			t.Error("video section not rejected")
+		}
+		if rejected(t, answer, "audio") || firstCodec(t, answer, "audio") != "opus" {
+			t.Errorf("audio answered with %v, want opus", codecNames(t, answer, "audio"))
+		}
+	})
+}
+
+// TestInterceptorFeedback 服务端 offer 只声明启用的拦截器对应的 RTCP 反馈
+// TestInterceptorFeedback checks that the server's offers ask for the RTCP
+// feedback of the enabled interceptors only. Answers echo the feedback of the
+// client's offer, so the server's own offer is checked.
+func TestInterceptorFeedback(t *testing.T) {
+	offer := serverOffer(t, &media.Config{})
+	if !hasFeedback(t, offer, "video", "nack") {
+		t.Error("default offer does not ask for NACK")
+	}
+	if !hasFeedback(t, offer, "video", "transport-cc") {
+		t.Error("default offer does not ask for TWCC feedback")
+	}
+
+	offer = serverOffer(t, &media.Config{Interceptors: media.Interceptors{
+		NACKInterval: -time.Millisecond,
+		TWCCInterval: -time.Millisecond,
+		PLIInterval:  500 * time.Millisecond,
+	}})
+	if hasFeedback(t, offer, "video", "nack") {
+		t.Error("NACK asked for when turned off")
+	}
+	if !hasFeedback(t, offer, "video", "nack pli") {
+		t.Error("PLI not asked for")
+	}
+	if hasFeedback(t, offer, "video", "transport-cc") || hasFeedback(t, offer, "audio", "transport-cc") {
+		t.Error("TWCC feedback asked for when turned off")
+	}
+}
+
+// TestCodecValidation 未知的或类型不符的编解码器被拒绝
+// TestCodecValidation checks that unknown codecs, and codecs listed under the
+// wrong kind, are rejected.
+func TestCodecValidation(t *testing.T) {
+	for name, codecs := range map[string]media.Codecs{
+		"unknown video codec":         {Video: []string{"theora"}},
+		"video codec listed as audio": {Audio: []string{"vp8"}},
+	} {
+		if _, err := media.NewEngine(zerolog.Nop(), &media.Config{Codecs: codecs}); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+// newTestEngine 创建媒体引擎，测试结束时关闭
+// newTestEngine creates a media engine that is shut down when the test ends.
+func newTestEngine(t *testing.T, cfg *media.Config) *media.Engine {
+	t.Helper()
+	engine, err := media.NewEngine(zerolog.Nop(), cfg)
+	if err != nil {
+		t.Fatalf("create media engine: %v", err)
+	}
+	t.Cleanup(func() { _ = engine.Shutdown(context.Background()) })
+	return engine
+}
+
+// negotiate 以给定编解码器创建媒体引擎，应用客户端的音视频 offer 并返回服务端 answer
+// negotiate creates a media engine with codecs, applies an audio and video
+// offer from a default Pion client the way the hub does, and returns the answer.
+func negotiate(t *testing.T, codecs media.Codecs) *sdp.SessionDescription {
+	t.Helper()
+	engine := newTestEngine(t, &media.Config{Codecs: codecs})
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("create client peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := client.AddTransceiverFromKind(kind); err != nil {
+			t.Fatalf("add %s transceiver: %v", kind, err)
+		}
+	}
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+
+	peerConn, err := engine.CreatePeerConnection(context.Background(), "codec-test", "alice@example.com", "server", nil)
+	if err != nil {
+		t.Fatalf("create server peer connection: %v", err)
+	}
+	if err := peerConn.PC.SetRemoteDescription(offer); err != nil {
+		t.Fatalf("apply offer: %v", err)
+	}
+	if err := engine.PreferCodecs(peerConn); err != nil {
+		t.Fatalf("prefer codecs: %v", err)
+	}
+	answer, err := peerConn.PC.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("create answer: %v", err)
+	}
+	parsed, err := answer.Unmarshal()
+	if err != nil {
+		t.Fatalf("parse answer: %v", err)
+	}
+	return parsed
+}
+
+// serverOffer 以给定配置创建媒体引擎，返回服务端主动发起的音视频 offer
+// serverOffer creates a media engine with cfg and returns an audio and video
+// offer made by the server itself.
+func serverOffer(t *testing.T, cfg *media.Config) *sdp.SessionDescription {
+	t.Helper()
+	engine := newTestEngine(t, cfg)
+
+	peerConn, err := engine.CreatePeerConnection(context.Background(), "codec-test", "alice@example.com", "server", nil)
+	if err != nil {
+		t.Fatalf("create server peer connection: %v", err)
+	}
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := peerConn.PC.AddTransceiverFromKind(kind); err != nil {
+			t.Fatalf("add %s transceiver: %v", kind, err)
+		}
+	}
+	if err := engine.PreferCodecs(peerConn); err != nil {
+		t.Fatalf("prefer codecs: %v", err)
+	}
+	offer, err := peerConn.PC.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		t.Fatalf("parse offer: %v", err)
+	}
+	return parsed
+}
+
+func section(t *testing.T, desc *sdp.SessionDescription, kind string) *sdp.MediaDescription {
+	t.Helper()
+	for _, m := range desc.MediaDescriptions {
+		if m.MediaName.Media == kind {
+			return m
+		}
+	}
+	t.Fatalf("description has no %s section", kind)
+	return nil
+}
+
+func codecNames(t *testing.T, desc *sdp.SessionDescription, kind string) []string {
+	t.Helper()
+	var names []string
+	for _, format := range section(t, desc, kind).MediaName.Formats {
+		pt, err := strconv.ParseUint(format, 10, 8)
+		if err != nil {
+			continue
+		}
+		if codec, err := desc.GetCodecForPayloadType(uint8(pt)); err == nil {
+			names = append(names, codec.Name)
+		}
+	}
+	return names
+}
+
+func firstCodec(t *testing.T, desc *sdp.SessionDescription, kind string) string {
+	t.Helper()
+	if names := codecNames(t, desc, kind); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+func hasCodec(t *testing.T, desc *sdp.SessionDescription, kind, name string) bool {
+	t.Helper()
+	for _, n := range codecNames(t, desc, kind) {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFeedback 该媒体段是否声明了给定的 RTCP 反馈，如 "nack" 或 "nack pli"
+// hasFeedback reports whether a media section offers an RTCP feedback such as
+// "nack" or "nack pli".
+func hasFeedback(t *testing.T, desc *sdp.SessionDescription, kind, feedback string) bool {
+	t.Helper()
+	for _, attr := range section(t, desc, kind).Attributes {
+		if attr.Key != "rtcp-fb" {
+			continue
+		}
+		if _, value, ok := strings.Cut(attr.Value, " "); ok && strings.TrimSpace(value) == feedback {
+			return true
+		}
+	}
+	return false
+}
+
+func rejected(t *testing.T, desc *sdp.SessionDescription, kind string) bool {
+	t.Helper()
+	return section(t, desc, kind).MediaName.Port.Value == 0
+}
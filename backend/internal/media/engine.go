@@ -2,9 +2,14 @@ package media
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"sync"
+	"time"
 
+	"github.com/pion/ice/v4"
 	"github.com/pion/webrtc/v4"
 	"github.com/rs/zerolog"
 )
@@ -15,6 +20,42 @@ type Engine struct {
 	mu              sync.RWMutex
 	logger          zerolog.Logger
 	peerConnections map[string]*PeerConnection
+	mode            string
+	// 新建对等连接的默认配置（ICE 服务器与策略）
+	// Default configuration of new peer connections: ICE servers and policies.
+	webrtcConfig webrtc.Configuration
+	iceServers   func() []webrtc.ICEServer
+	// 编解码器与拦截器，见 api.go
+	// Codecs and interceptors; see api.go.
+	codecs       Codecs
+	interceptors Interceptors
+	// 网络设置与共用的 ICE 复用端口，见 network.go
+	// Network settings and the shared ICE mux ports; see network.go.
+	network     Network
+	udpMux      ice.UDPMux
+	tcpMux      ice.TCPMux
+	tcpListener net.Listener
+	// 连接数上限与建立超时，见 lifecycle.go
+	// Peer connection limits and connect timeout; see lifecycle.go.
+	limits Limits
+
+	// 事件订阅者，见 events.go
+	// Event subscribers; see events.go.
+	subsMu sync.RWMutex
+	subs   map[*Subscription]struct{}
+
+	// SFU 房间，见 sfu.go
+	// SFU rooms; see sfu.go.
+	roomsMu sync.Mutex
+	rooms   map[string]*room
+
+	// 进行中的录制，按发起录制的连接索引，见 recording.go
+	// Recordings in progress, keyed by the ID of the connection that started
+	// them; see recording.go.
+	recordingDir string
+	recMu        sync.Mutex
+	recordings   map[string]*recording
+	receivers    map[*trackReceiver]struct{}
 }
 
 // Config 包含 Pion 媒体引擎的配置
@@ -23,26 +64,104 @@ type Config struct {
 	// WebRTC 配置
 	// WebRTC configuration
 	WebRTCConfig webrtc.Configuration
+
+	// 工作模式：ModeTerminate（默认）或 ModeSFU
+	// Mode is ModeTerminate (the default) or ModeSFU.
+	Mode string
+
+	// 录制文件根目录，为空时不能录制
+	// RecordingDir is where recordings are written; empty disables recording.
+	RecordingDir string
+
+	// 为每个新连接生成 ICE 服务器（如签发临时 TURN 凭据），为空时使用 WebRTCConfig.ICEServers
+	// ICEServers, when set, supplies the ICE servers of every new peer
+	// connection instead of WebRTCConfig.ICEServers, so expiring TURN
+	// credentials are issued afresh.
+	ICEServers func() []webrtc.ICEServer
+
+	// 允许与偏好的编解码器，为空时使用 Pion 默认
+	// Codecs the engine negotiates, in order of preference; empty uses Pion's defaults.
+	Codecs Codecs
+
+	// RTCP 报告、NACK、TWCC 与 PLI 拦截器
+	// RTCP report, NACK, TWCC and PLI interceptors.
+	Interceptors Interceptors
+
+	// 端口范围、1:1 NAT、网卡过滤与单端口 ICE 复用
+	// Port range, 1:1 NAT, interface filters and single-port ICE muxing.
+	Network Network
+
+	// 连接数上限与建立超时
+	// Peer connection limits and connect timeout.
+	Limits Limits
 }
 
 // NewEngine 创建新的媒体引擎
 // NewEngine creates a new Pion media engine
 func NewEngine(logger zerolog.Logger, cfg *Config) (*Engine, error) {
-	return &Engine{
+	mode := ModeTerminate
+	if cfg != nil && cfg.Mode != "" {
+		mode = cfg.Mode
+	}
+	if mode != ModeTerminate && mode != ModeSFU {
+		return nil, fmt.Errorf("unknown media mode %q", mode)
+	}
+	engine := &Engine{
 		logger:          logger.With().Str("component", "media_engine").Logger(),
 		peerConnections: make(map[string]*PeerConnection),
-	}, nil
+		mode:            mode,
+		rooms:           make(map[string]*room),
+		recordings:      make(map[string]*recording),
+		receivers:       make(map[*trackReceiver]struct{}),
+		subs:            make(map[*Subscription]struct{}),
+	}
+	if cfg != nil {
+		if err := validateCodecs(cfg.Codecs); err != nil {
+			return nil, err
+		}
+		engine.webrtcConfig = cfg.WebRTCConfig
+		engine.iceServers = cfg.ICEServers
+		engine.recordingDir = cfg.RecordingDir
+		engine.codecs = cfg.Codecs
+		engine.interceptors = cfg.Interceptors
+		engine.network = cfg.Network
+		engine.limits = cfg.Limits
+	}
+	if err := engine.openNetwork(); err != nil {
+		return nil, err
+	}
+	return engine, nil
 }
 
-// CreatePeerConnection 创建新的对等连接
-// CreatePeerConnection creates a new peer connection
+// CreatePeerConnection 创建新的对等连接；cfg 为 nil 时使用引擎配置
+// CreatePeerConnection creates a new peer connection. A nil cfg uses the
+// engine's configured ICE servers and policies.
 func (e *Engine) CreatePeerConnection(ctx context.Context, callID, localEmail, remoteEmail string, cfg *webrtc.Configuration) (*PeerConnection, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	id := connectionID(callID, localEmail, remoteEmail)
+	if _, exists := e.peerConnections[id]; exists {
+		return nil, ErrPeerConnectionExists
+	}
+	if err := e.checkLimits(localEmail); err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		defaults := e.webrtcConfig
+		if e.iceServers != nil {
+			defaults.ICEServers = e.iceServers()
+		}
+		cfg = &defaults
+	}
+
 	// 创建 PeerConnection
 	// Create peer connection
-	pc, err := webrtc.NewPeerConnection(*cfg)
+	api, statsGetter, err := e.newAPI()
+	if err != nil {
+		return nil, fmt.Errorf("create webrtc api: %w", err)
+	}
+	pc, err := api.NewPeerConnection(*cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create peer connection: %w", err)
 	}
@@ -52,34 +171,36 @@ func (e *Engine) CreatePeerConnection(ctx context.Context, callID, localEmail, r
 		CallID:      callID,
 		LocalEmail:  localEmail,
 		RemoteEmail: remoteEmail,
-		State:       CallStateOffering,
-		Handlers:    &MediaHandlers{},
+		state:       CallStateOffering,
+		createdAt:   time.Now(),
+		statsGetter: statsGetter(),
 	}
 
 	// 设置事件处理器
 	// Setup event handlers
 	e.setupPeerConnectionHandlers(pc, peerConn)
 
-	connectionID := fmt.Sprintf("%s-%s-%s", callID, localEmail, remoteEmail)
-	e.peerConnections[connectionID] = peerConn
+	e.peerConnections[id] = peerConn
 
 	e.logger.Info().
 		Str("call_id", callID).
 		Str("local", localEmail).
 		Str("remote", remoteEmail).
 		Msg("peer connection created")
+	e.emit(Event{Type: EventCreated, PeerConnection: peerConn})
 
 	return peerConn, nil
 }
 
-// setupPeerConnectionHandlers 设置 PeerConnection 的事件处理器
-// setupPeerConnectionHandlers configures event handlers for a peer connection
+// setupPeerConnectionHandlers 设置 PeerConnection 的事件处理器，转为引擎事件发布
+// setupPeerConnectionHandlers configures event handlers for a peer connection,
+// publishing what happens as engine events.
 func (e *Engine) setupPeerConnectionHandlers(pc *webrtc.PeerConnection, peerConn *PeerConnection) {
 	// ICE candidate 处理
 	// Handle ICE candidates
 	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
-		if candidate != nil && peerConn.Handlers.OnICECandidate != nil {
-			peerConn.Handlers.OnICECandidate(candidate)
+		if candidate != nil {
+			e.emit(Event{Type: EventICECandidate, PeerConnection: peerConn, Candidate: candidate})
 		}
 	})
 
@@ -90,10 +211,6 @@ func (e *Engine) setupPeerConnectionHandlers(pc *webrtc.PeerConnection, peerConn
 			Str("call_id", peerConn.CallID).
 			Str("state", connectionState.String()).
 			Msg("ice connection state changed")
-
-		if peerConn.Handlers.OnICEConnectionStateChange != nil {
-			peerConn.Handlers.OnICEConnectionStateChange(connectionState)
-		}
 	})
 
 	// 信令状态改变
@@ -103,10 +220,6 @@ func (e *Engine) setupPeerConnectionHandlers(pc *webrtc.PeerConnection, peerConn
 			Str("call_id", peerConn.CallID).
 			Str("state", signalingState.String()).
 			Msg("signaling state changed")
-
-		if peerConn.Handlers.OnSignalingStateChange != nil {
-			peerConn.Handlers.OnSignalingStateChange(signalingState)
-		}
 	})
 
 	// 连接状态改变
@@ -117,101 +230,223 @@ func (e *Engine) setupPeerConnectionHandlers(pc *webrtc.PeerConnection, peerConn
 			Str("state", connectionState.String()).
 			Msg("connection state changed")
 
-		if peerConn.Handlers.OnConnectionStateChange != nil {
-			peerConn.Handlers.OnConnectionStateChange(connectionState)
-		}
+		e.emit(Event{Type: EventStateChanged, PeerConnection: peerConn, State: connectionState})
 
 		// 自动清理已关闭的连接
 		// Auto cleanup closed connections
 		if connectionState == webrtc.PeerConnectionStateClosed ||
 			connectionState == webrtc.PeerConnectionStateFailed {
-			_ = e.ClosePeerConnection(peerConn.CallID, peerConn.LocalEmail, peerConn.RemoteEmail)
+			e.closePeerConnection(peerConn)
 		}
 	})
 
-	// 媒体轨道处理
-	// Handle media tracks
+	// 需要重新协商
+	// Handle renegotiation requests
+	pc.OnNegotiationNeeded(func() {
+		e.logger.Debug().
+			Str("call_id", peerConn.CallID).
+			Msg("negotiation needed")
+
+		e.emit(Event{Type: EventNegotiationNeeded, PeerConnection: peerConn})
+	})
+
+	// 媒体轨道处理：由引擎读取（转发、录制与统计），订阅者只收到通知
+	// Handle media tracks: the engine reads them for forwarding, recording and
+	// statistics, and subscribers are only told.
 	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		switch track.Kind() {
-		case webrtc.RTPCodecTypeAudio:
-			e.logger.Debug().
-				Str("call_id", peerConn.CallID).
-				Msg("audio track received")
-			if peerConn.Handlers.OnAudioTrack != nil {
-				peerConn.Handlers.OnAudioTrack(track, receiver)
-			}
+		e.logger.Debug().
+			Str("call_id", peerConn.CallID).
+			Str("kind", track.Kind().String()).
+			Msg("track received")
 
-		case webrtc.RTPCodecTypeVideo:
-			e.logger.Debug().
-				Str("call_id", peerConn.CallID).
-				Msg("video track received")
-			if peerConn.Handlers.OnVideoTrack != nil {
-				peerConn.Handlers.OnVideoTrack(track, receiver)
+		e.emit(Event{Type: EventTrackAdded, PeerConnection: peerConn, Track: track, Receiver: receiver})
+		go e.receive(peerConn, track)
+	})
+}
+
+// receive 读取远端轨道：SFU 模式下转发给房间，录制中时写入文件
+// receive reads a remote track until it ends, forwarding it to the rest of
+// the room in SFU mode and writing it to disk while a recording covers it.
+// Otherwise the packets only feed the connection's statistics.
+func (e *Engine) receive(peerConn *PeerConnection, remote *webrtc.TrackRemote) {
+	var (
+		r  *room
+		ft *forwardedTrack
+	)
+	if e.mode == ModeSFU {
+		r, ft = e.publish(peerConn, remote)
+	}
+	roomID := ""
+	if r != nil {
+		roomID = r.id
+	}
+	tr := e.watchRecordings(peerConn, roomID)
+	defer e.unwatchRecordings(tr)
+
+	jitter, untrack := peerConn.trackJitter(remote)
+	defer untrack()
+
+	buf := make([]byte, rtpBufferSize)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			break
+		}
+		jitter.observe(time.Now(), buf[:n])
+		if ft != nil {
+			if _, err := ft.local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				break
 			}
 		}
-	})
+		if rec := tr.rec.Load(); rec != nil {
+			if err := rec.write(peerConn, remote, buf[:n]); err != nil {
+				e.logger.Debug().Err(err).Str("call_id", peerConn.CallID).Msg("failed to record packet")
+			}
+		}
+	}
+	if ft != nil {
+		e.unpublish(r, ft)
+	}
+	e.finishRecordedTrack(remote)
 }
 
-// ClosePeerConnection 关闭对等连接
-// ClosePeerConnection closes and removes a peer connection
-func (e *Engine) ClosePeerConnection(callID, localEmail, remoteEmail string) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// AddTrack 向对等连接添加本地轨道，随后通过 OnNegotiationNeeded 重新协商
+// AddTrack adds a local track to a peer connection. The connection then asks
+// for renegotiation through OnNegotiationNeeded.
+func (e *Engine) AddTrack(callID, localEmail, remoteEmail string, track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return nil, err
+	}
 
-	connectionID := fmt.Sprintf("%s-%s-%s", callID, localEmail, remoteEmail)
-	peerConn, exists := e.peerConnections[connectionID]
-	if !exists {
-		return fmt.Errorf("peer connection not found: %s", connectionID)
+	sender, err := peerConn.PC.AddTrack(track)
+	if err != nil {
+		return nil, fmt.Errorf("add track: %w", err)
 	}
 
-	peerConn.State = CallStateEnded
+	e.logger.Info().
+		Str("call_id", callID).
+		Str("track_id", track.ID()).
+		Str("kind", track.Kind().String()).
+		Msg("track added")
 
-	if err := peerConn.PC.Close(); err != nil {
-		e.logger.Warn().Err(err).Str("call_id", callID).Msg("error closing peer connection")
+	return sender, nil
+}
+
+// RemoveTrack 移除 AddTrack 添加的轨道，随后重新协商
+// RemoveTrack stops sending a track added with AddTrack and triggers renegotiation
+func (e *Engine) RemoveTrack(callID, localEmail, remoteEmail string, sender *webrtc.RTPSender) error {
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return err
 	}
 
-	delete(e.peerConnections, connectionID)
+	if err := peerConn.PC.RemoveTrack(sender); err != nil {
+		return fmt.Errorf("remove track: %w", err)
+	}
 
 	e.logger.Info().
 		Str("call_id", callID).
-		Str("local", localEmail).
-		Str("remote", remoteEmail).
-		Msg("peer connection closed")
+		Msg("track removed")
 
 	return nil
 }
 
+// ClosePeerConnection 关闭对等连接
+// ClosePeerConnection closes and removes a peer connection
+func (e *Engine) ClosePeerConnection(callID, localEmail, remoteEmail string) error {
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return err
+	}
+	if !e.closePeerConnection(peerConn) {
+		return fmt.Errorf("peer connection not found: %s", connectionID(callID, localEmail, remoteEmail))
+	}
+	return nil
+}
+
+// closePeerConnection 移除并关闭连接；已被移除或被同名新连接取代时返回 false
+// closePeerConnection removes and closes peerConn. It returns false when the
+// connection was already removed, or replaced by a newer one with the same ID.
+func (e *Engine) closePeerConnection(peerConn *PeerConnection) bool {
+	id := peerConn.id()
+	e.mu.Lock()
+	if e.peerConnections[id] != peerConn {
+		e.mu.Unlock()
+		return false
+	}
+	delete(e.peerConnections, id)
+	e.mu.Unlock()
+
+	// 在锁外关闭：Close 会同步触发 OnConnectionStateChange，回调再次进入这里时连接已被移除
+	// Close outside the lock: Close fires OnConnectionStateChange, whose
+	// cleanup re-enters here and finds the connection already removed.
+	peerConn.SetState(CallStateEnded)
+	if err := peerConn.PC.Close(); err != nil {
+		e.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("error closing peer connection")
+	}
+	e.leaveRoom(peerConn)
+	e.emit(Event{Type: EventClosed, PeerConnection: peerConn})
+
+	e.logger.Info().
+		Str("call_id", peerConn.CallID).
+		Str("local", peerConn.LocalEmail).
+		Str("remote", peerConn.RemoteEmail).
+		Msg("peer connection closed")
+	return true
+}
+
 // GetPeerConnection 获取对等连接
 // GetPeerConnection retrieves a peer connection by ID
 func (e *Engine) GetPeerConnection(callID, localEmail, remoteEmail string) (*PeerConnection, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	connectionID := fmt.Sprintf("%s-%s-%s", callID, localEmail, remoteEmail)
-	peerConn, exists := e.peerConnections[connectionID]
+	id := connectionID(callID, localEmail, remoteEmail)
+	peerConn, exists := e.peerConnections[id]
 	if !exists {
-		return nil, fmt.Errorf("peer connection not found: %s", connectionID)
+		return nil, fmt.Errorf("peer connection not found: %s", id)
 	}
 
 	return peerConn, nil
 }
 
+// connectionID 对等连接在引擎中的键
+// connectionID is the key of a peer connection in the engine.
+func connectionID(callID, localEmail, remoteEmail string) string {
+	return fmt.Sprintf("%s-%s-%s", callID, localEmail, remoteEmail)
+}
+
 // Shutdown 关闭媒体引擎并清理所有连接
 // Shutdown closes the media engine and all peer connections
 func (e *Engine) Shutdown(ctx context.Context) error {
+	e.stopAllRecordings()
+
 	e.mu.Lock()
-	defer e.mu.Unlock()
+	peerConns := e.peerConnections
+	e.peerConnections = make(map[string]*PeerConnection)
+	e.mu.Unlock()
 
 	var lastErr error
-	for id, peerConn := range e.peerConnections {
-		peerConn.State = CallStateEnded
+	for id, peerConn := range peerConns {
+		peerConn.SetState(CallStateEnded)
 		if err := peerConn.PC.Close(); err != nil {
 			e.logger.Warn().Err(err).Str("connection_id", id).Msg("error closing peer connection")
 			lastErr = err
 		}
+		e.emit(Event{Type: EventClosed, PeerConnection: peerConn})
 	}
 
-	e.peerConnections = make(map[string]*PeerConnection)
+	e.roomsMu.Lock()
+	e.rooms = make(map[string]*room)
+	e.roomsMu.Unlock()
+	e.mu.Lock()
+	if err := e.closeNetwork(); err != nil {
+		e.logger.Warn().Err(err).Msg("error closing ice mux")
+		lastErr = err
+	}
+	e.mu.Unlock()
+	e.closeSubscriptions()
 
 	if lastErr != nil {
 		return fmt.Errorf("shutdown with errors: %w", lastErr)
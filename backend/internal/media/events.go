@@ -0,0 +1,137 @@
+package media
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// eventBufferSize 每个订阅的事件缓冲
+// eventBufferSize is the number of events buffered for each subscription.
+const eventBufferSize = 256
+
+// EventType 媒体引擎事件类型
+// EventType identifies what happened to a peer connection.
+type EventType string
+
+const (
+	// EventCreated 连接已创建
+	// EventCreated is published when CreatePeerConnection adds a connection.
+	EventCreated EventType = "created"
+	// EventStateChanged 连接状态改变，见 Event.State
+	// EventStateChanged is published when the connection state changes; see Event.State.
+	EventStateChanged EventType = "state_changed"
+	// EventTrackAdded 收到远端轨道，见 Event.Track；轨道由引擎读取，订阅者不得读取
+	// EventTrackAdded is published when a remote track arrives; see
+	// Event.Track. The engine reads the track itself, so subscribers must not.
+	EventTrackAdded EventType = "track_added"
+	// EventICECandidate 收集到本地 ICE 候选，见 Event.Candidate
+	// EventICECandidate is published for each gathered local candidate; see Event.Candidate.
+	EventICECandidate EventType = "ice_candidate"
+	// EventNegotiationNeeded 增删轨道后需要重新协商
+	// EventNegotiationNeeded is published when adding or removing a track
+	// requires SDP renegotiation.
+	EventNegotiationNeeded EventType = "negotiation_needed"
+	// EventClosed 连接已关闭并从引擎移除；Pion 异步回调，最后的状态改变可能晚于它到达
+	// EventClosed is published once the connection is closed and removed from
+	// the engine. Pion reports state asynchronously, so the final
+	// EventStateChanged may arrive after it.
+	EventClosed EventType = "closed"
+)
+
+// Event 对等连接事件
+// Event is something that happened to one of the engine's peer connections.
+type Event struct {
+	Type           EventType
+	PeerConnection *PeerConnection
+	At             time.Time
+
+	// EventStateChanged 的新状态
+	// State is the new connection state of EventStateChanged.
+	State webrtc.PeerConnectionState
+	// EventTrackAdded 的远端轨道
+	// Track and Receiver are the remote track of EventTrackAdded.
+	Track    *webrtc.TrackRemote
+	Receiver *webrtc.RTPReceiver
+	// EventICECandidate 的候选
+	// Candidate is the gathered candidate of EventICECandidate.
+	Candidate *webrtc.ICECandidate
+}
+
+// Subscription 引擎事件订阅
+// Subscription receives the engine's events until it is closed, its context
+// is cancelled or the engine shuts down.
+type Subscription struct {
+	engine *Engine
+	ch     chan Event
+	stop   func() bool
+	once   sync.Once
+}
+
+// Subscribe 订阅所有对等连接的事件
+// Subscribe returns a subscription to the events of every peer connection.
+// Events are delivered in order; a subscriber that falls eventBufferSize
+// events behind loses the newest ones.
+func (e *Engine) Subscribe(ctx context.Context) *Subscription {
+	sub := &Subscription{
+		engine: e,
+		ch:     make(chan Event, eventBufferSize),
+	}
+	e.subsMu.Lock()
+	e.subs[sub] = struct{}{}
+	sub.stop = context.AfterFunc(ctx, func() { sub.Close() })
+	e.subsMu.Unlock()
+	return sub
+}
+
+// Events 返回事件通道，订阅关闭后通道随之关闭
+// Events returns the channel events arrive on. It is closed with the subscription.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close 取消订阅
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.engine.subsMu.Lock()
+		defer s.engine.subsMu.Unlock()
+		s.stop()
+		delete(s.engine.subs, s)
+		close(s.ch)
+	})
+}
+
+// emit 将事件发给所有订阅者，不阻塞
+// emit hands an event to every subscriber without blocking.
+func (e *Engine) emit(event Event) {
+	event.At = time.Now()
+	e.subsMu.RLock()
+	defer e.subsMu.RUnlock()
+	for sub := range e.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			e.logger.Warn().
+				Str("call_id", event.PeerConnection.CallID).
+				Str("event", string(event.Type)).
+				Msg("dropping media event due to slow subscriber")
+		}
+	}
+}
+
+// closeSubscriptions 引擎关闭时结束所有订阅
+// closeSubscriptions ends every subscription when the engine shuts down.
+func (e *Engine) closeSubscriptions() {
+	e.subsMu.RLock()
+	subs := make([]*Subscription, 0, len(e.subs))
+	for sub := range e.subs {
+		subs = append(subs, sub)
+	}
+	e.subsMu.RUnlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
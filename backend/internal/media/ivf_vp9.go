@@ -0,0 +1,105 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+// vp9ClockRate VP9 的 RTP 时钟频率，用作 IVF 的时间基
+// vp9ClockRate is the RTP clock of VP9, used as the IVF time base so RTP
+// timestamps can be written as frame timestamps unchanged.
+const vp9ClockRate = 90000
+
+// vp9Writer 将 VP9 RTP 包写成 IVF 文件；Pion 的 ivfwriter 只支持 VP8 与 AV1
+// vp9Writer writes VP9 RTP packets to an IVF file. Pion's ivfwriter only
+// handles VP8 and AV1.
+type vp9Writer struct {
+	file         *os.File
+	count        uint32
+	seenKeyFrame bool
+	inFrame      bool
+	currentFrame []byte
+}
+
+func newVP9Writer(path string) (*vp9Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)  // Version
+	binary.LittleEndian.PutUint16(header[6:], 32) // Header size
+	copy(header[8:], "VP90")
+	binary.LittleEndian.PutUint16(header[12:], 640) // Width, the decoder reads the real size from the frames
+	binary.LittleEndian.PutUint16(header[14:], 480) // Height
+	binary.LittleEndian.PutUint32(header[16:], vp9ClockRate)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	if _, err := f.Write(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &vp9Writer{file: f}, nil
+}
+
+// WriteRTP 拼装帧，从第一个关键帧开始写入
+// WriteRTP assembles frames from packets and writes them, starting at the
+// first keyframe.
+func (w *vp9Writer) WriteRTP(packet *rtp.Packet) error {
+	if len(packet.Payload) == 0 {
+		return nil
+	}
+	var vp9 codecs.VP9Packet
+	if _, err := vp9.Unmarshal(packet.Payload); err != nil {
+		return err
+	}
+	if vp9.B {
+		w.currentFrame = w.currentFrame[:0]
+		w.inFrame = w.seenKeyFrame || !vp9.P
+		w.seenKeyFrame = w.inFrame
+	}
+	if !w.inFrame {
+		return nil
+	}
+	w.currentFrame = append(w.currentFrame, vp9.Payload...)
+	if !vp9.E && !packet.Marker {
+		return nil
+	}
+
+	frameHeader := make([]byte, 12)
+	binary.LittleEndian.PutUint32(frameHeader[0:], uint32(len(w.currentFrame)))
+	binary.LittleEndian.PutUint64(frameHeader[4:], uint64(packet.Timestamp))
+	if _, err := w.file.Write(frameHeader); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(w.currentFrame); err != nil {
+		return err
+	}
+	w.count++
+	w.inFrame = false
+	return nil
+}
+
+// Close 回填帧数并关闭文件
+// Close fills in the frame count and closes the file.
+func (w *vp9Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	defer func() { w.file = nil }()
+	if _, err := w.file.Seek(24, io.SeekStart); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, w.count)
+	if _, err := w.file.Write(buf); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,119 @@
+package media
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ErrPeerConnectionLimit 连接数已达上限
+// ErrPeerConnectionLimit is returned by CreatePeerConnection when the engine,
+// or the user the connection is for, already has as many peer connections as
+// Limits allow.
+var ErrPeerConnectionLimit = errors.New("peer connection limit reached")
+
+// ErrPeerConnectionExists 同一通话与双方的连接已存在
+// ErrPeerConnectionExists is returned by CreatePeerConnection when a
+// connection for the same call and parties is already open. The existing
+// connection is never replaced, so it can still be closed and reaped.
+var ErrPeerConnectionExists = errors.New("peer connection already exists")
+
+// Limits 对等连接的数量上限与建立超时
+// Limits caps the engine's peer connections and bounds how long one may take
+// to connect.
+type Limits struct {
+	// 连接总数上限，0 表示不限
+	// MaxPeerConnections caps all peer connections; zero means no limit.
+	MaxPeerConnections int
+
+	// 每个用户（LocalEmail）的连接数上限，0 表示不限
+	// MaxPerUser caps the peer connections of one user (LocalEmail); zero
+	// means no limit.
+	MaxPerUser int
+
+	// 停留在 new/connecting 超过该时间的连接视为卡住，0 表示不检查
+	// ConnectTimeout is how long a connection may stay new or connecting
+	// before Stalled reports it; zero never does.
+	ConnectTimeout time.Duration
+}
+
+// PeerConnectionInfo 对等连接的快照，供管理接口列出
+// PeerConnectionInfo is a snapshot of a peer connection for monitoring.
+type PeerConnectionInfo struct {
+	CallID         string
+	LocalEmail     string
+	RemoteEmail    string
+	Room           string
+	CallState      CallState
+	State          webrtc.PeerConnectionState
+	ICEState       webrtc.ICEConnectionState
+	SignalingState webrtc.SignalingState
+	CreatedAt      time.Time
+	Age            time.Duration
+	Stalled        bool
+}
+
+// checkLimits 检查能否再为 localEmail 创建连接，调用方须持有 e.mu
+// checkLimits reports whether another peer connection may be created for
+// localEmail. The caller holds e.mu.
+func (e *Engine) checkLimits(localEmail string) error {
+	if e.limits.MaxPeerConnections > 0 && len(e.peerConnections) >= e.limits.MaxPeerConnections {
+		return ErrPeerConnectionLimit
+	}
+	if e.limits.MaxPerUser > 0 {
+		count := 0
+		for _, peerConn := range e.peerConnections {
+			if peerConn.LocalEmail == localEmail {
+				count++
+			}
+		}
+		if count >= e.limits.MaxPerUser {
+			return ErrPeerConnectionLimit
+		}
+	}
+	return nil
+}
+
+// Stalled 连接是否创建后超过 ConnectTimeout 仍未连上
+// Stalled reports whether a peer connection is still new or connecting
+// ConnectTimeout after it was created.
+func (e *Engine) Stalled(peerConn *PeerConnection, now time.Time) bool {
+	if e.limits.ConnectTimeout <= 0 || now.Sub(peerConn.createdAt) < e.limits.ConnectTimeout {
+		return false
+	}
+	switch peerConn.PC.ConnectionState() {
+	case webrtc.PeerConnectionStateNew, webrtc.PeerConnectionStateConnecting:
+		return true
+	}
+	return false
+}
+
+// DescribePeerConnections 返回所有对等连接的快照，按创建时间排序
+// DescribePeerConnections returns a snapshot of every peer connection, oldest
+// first.
+func (e *Engine) DescribePeerConnections() []PeerConnectionInfo {
+	now := time.Now()
+	peerConns := e.ListPeerConnections()
+	infos := make([]PeerConnectionInfo, 0, len(peerConns))
+	for _, peerConn := range peerConns {
+		infos = append(infos, PeerConnectionInfo{
+			CallID:         peerConn.CallID,
+			LocalEmail:     peerConn.LocalEmail,
+			RemoteEmail:    peerConn.RemoteEmail,
+			Room:           e.roomOf(peerConn),
+			CallState:      peerConn.State(),
+			State:          peerConn.PC.ConnectionState(),
+			ICEState:       peerConn.PC.ICEConnectionState(),
+			SignalingState: peerConn.PC.SignalingState(),
+			CreatedAt:      peerConn.createdAt,
+			Age:            now.Sub(peerConn.createdAt),
+			Stalled:        e.Stalled(peerConn, now),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
+}
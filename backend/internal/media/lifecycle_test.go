@@ -0,0 +1,140 @@
+package media_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// TestPeerConnectionLimits 引擎总数与每用户连接数上限
+// TestPeerConnectionLimits checks the engine-wide and per-user limits on peer
+// connections, and that closing one frees its slot.
+func TestPeerConnectionLimits(t *testing.T) {
+	engine := newTestEngine(t, &media.Config{Limits: media.Limits{MaxPeerConnections: 3, MaxPerUser: 2}})
+	create := func(callID, email string) error {
+		_, err := engine.CreatePeerConnection(context.Background(), callID, email, "server", nil)
+		return err
+	}
+
+	for _, callID := range []string{"limits-1", "limits-2"} {
+		if err := create(callID, "alice@example.com"); err != nil {
+			t.Fatalf("connection %s within the per-user limit: %v", callID, err)
+		}
+	}
+	if err := create("limits-1", "alice@example.com"); !errors.Is(err, media.ErrPeerConnectionExists) {
+		t.Errorf("duplicate connection got %v, want ErrPeerConnectionExists", err)
+	}
+	if err := create("limits-3", "alice@example.com"); !errors.Is(err, media.ErrPeerConnectionLimit) {
+		t.Errorf("third connection for the same user got %v, want ErrPeerConnectionLimit", err)
+	}
+	if err := create("limits-4", "bob@example.com"); err != nil {
+		t.Errorf("another user refused: %v", err)
+	}
+	if err := create("limits-5", "carol@example.com"); !errors.Is(err, media.ErrPeerConnectionLimit) {
+		t.Errorf("connection above the engine limit got %v, want ErrPeerConnectionLimit", err)
+	}
+
+	if err := engine.ClosePeerConnection("limits-1", "alice@example.com", "server"); err != nil {
+		t.Fatalf("close connection: %v", err)
+	}
+	if err := create("limits-5", "carol@example.com"); err != nil {
+		t.Errorf("closing a connection did not free its slot: %v", err)
+	}
+}
+
+// TestPeerConnectionClose 锁外关闭、Pion 关闭的连接被移除，关闭事件只发布一次
+// TestPeerConnectionClose checks that ClosePeerConnection returns while the
+// state change callback cleans up, that a connection closed by Pion leaves the
+// engine, and that each close is published once.
+func TestPeerConnectionClose(t *testing.T) {
+	engine, err := media.NewEngine(zerolog.Nop(), nil)
+	if err != nil {
+		t.Fatalf("create media engine: %v", err)
+	}
+	sub := engine.Subscribe(context.Background())
+	first, err := engine.CreatePeerConnection(context.Background(), "close-1", "alice@example.com", "server", nil)
+	if err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	second, err := engine.CreatePeerConnection(context.Background(), "close-2", "alice@example.com", "server", nil)
+	if err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- engine.ClosePeerConnection("close-1", "alice@example.com", "server") }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("close connection: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ClosePeerConnection deadlocked")
+	}
+	if first.State() != media.CallStateEnded {
+		t.Errorf("closed connection in state %v, want ended", first.State())
+	}
+	if first.PC.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		t.Error("the connection closed is not the original one")
+	}
+
+	_ = second.PC.Close()
+	if !waitFor(func() bool { return len(engine.ListPeerConnections()) == 0 }) {
+		t.Error("connection closed by Pion not removed from the engine")
+	}
+	if err := engine.ClosePeerConnection("close-2", "alice@example.com", "server"); err == nil {
+		t.Error("closing a removed connection again reports no error")
+	}
+
+	// Shutdown 关闭订阅，此后可读完所有事件
+	// Shutdown closes the subscription, so every event can be read after it.
+	_ = engine.Shutdown(context.Background())
+	var seen []string
+	for event := range sub.Events() {
+		if event.PeerConnection.CallID == "close-1" {
+			seen = append(seen, describeEvent(event))
+		}
+	}
+	if len(seen) == 0 || seen[0] != "created" {
+		t.Errorf("events %v, want created published first", seen)
+	}
+	if !slices.Contains(seen, "state_changed:closed") {
+		t.Errorf("events %v, want the state change to closed", seen)
+	}
+	closes := 0
+	for _, event := range seen {
+		if event == "closed" {
+			closes++
+		}
+	}
+	if closes != 1 {
+		t.Errorf("closed published %d times despite ClosePeerConnection and the Pion callback, want once", closes)
+	}
+}
+
+func describeEvent(event media.Event) string {
+	if event.Type == media.EventStateChanged {
+		return string(event.Type) + ":" + event.State.String()
+	}
+	return string(event.Type)
+}
+
+// waitFor 在一秒内轮询条件
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+// Network 对等连接的网络设置，适用于 Docker、云主机等 NAT 之后的部署
+// Network holds the network settings of the engine's peer connections, for
+// deployments behind NAT such as Docker or cloud hosts.
+type Network struct {
+	// 临时 UDP 端口范围，均为 0 时由系统分配
+	// Ephemeral UDP port range for ICE; both zero lets the OS pick.
+	UDPPortMin uint16
+	UDPPortMax uint16
+
+	// 1:1 NAT 的公网 IP，以及用它替换主机候选（host）还是额外添加反射候选（srflx）
+	// Public IPs of a 1:1 NAT, and whether they replace the host candidates
+	// (ICECandidateTypeHost) or are added as server reflexive ones
+	// (ICECandidateTypeSrflx).
+	NAT1To1IPs           []string
+	NAT1To1CandidateType webrtc.ICECandidateType
+
+	// 只在这些网卡上收集候选，为空时使用全部网卡
+	// Interfaces restricts candidate gathering to the named network
+	// interfaces; empty uses all of them.
+	Interfaces []string
+
+	// 同时收集回环地址候选
+	// IncludeLoopback gathers loopback candidates too.
+	IncludeLoopback bool
+
+	// 所有连接共用的 ICE UDP 端口，0 表示每个连接使用各自的端口
+	// UDPMuxPort is a single UDP port shared by every peer connection; zero
+	// gives each connection its own ports.
+	UDPMuxPort int
+
+	// ICE-TCP 监听端口，0 表示不收集 TCP 候选
+	// TCPMuxPort is the port ICE-TCP listens on; zero gathers no TCP candidates.
+	TCPMuxPort int
+}
+
+// openNetwork 打开所有连接共用的 ICE UDP/TCP 复用端口
+// openNetwork opens the ICE UDP and TCP mux ports shared by every peer connection.
+func (e *Engine) openNetwork() error {
+	// 端口范围在创建连接时才交给 Pion，这里提前校验，避免到第一通电话才报错
+	// The port range only reaches Pion when a connection is created, so check
+	// it up front rather than failing the first call.
+	if (e.network.UDPPortMin == 0) != (e.network.UDPPortMax == 0) || e.network.UDPPortMin > e.network.UDPPortMax {
+		return fmt.Errorf("invalid udp port range %d-%d", e.network.UDPPortMin, e.network.UDPPortMax)
+	}
+	if e.network.UDPMuxPort > 0 {
+		opts := []ice.UDPMuxFromPortOption{ice.UDPMuxFromPortWithInterfaceFilter(e.interfaceFilter())}
+		if e.network.IncludeLoopback {
+			opts = append(opts, ice.UDPMuxFromPortWithLoopback())
+		}
+		udpMux, err := ice.NewMultiUDPMuxFromPort(e.network.UDPMuxPort, opts...)
+		if err != nil {
+			return fmt.Errorf("listen ice udp mux on port %d: %w", e.network.UDPMuxPort, err)
+		}
+		e.udpMux = udpMux
+	}
+	if e.network.TCPMuxPort > 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: e.network.TCPMuxPort})
+		if err != nil {
+			_ = e.closeNetwork()
+			return fmt.Errorf("listen ice tcp mux on port %d: %w", e.network.TCPMuxPort, err)
+		}
+		e.tcpListener = listener
+		e.tcpMux = webrtc.NewICETCPMux(nil, listener, 8)
+	}
+	return nil
+}
+
+// closeNetwork 关闭共用的复用端口
+// closeNetwork closes the shared mux ports.
+func (e *Engine) closeNetwork() error {
+	var errs []error
+	if e.udpMux != nil {
+		errs = append(errs, e.udpMux.Close())
+		e.udpMux = nil
+	}
+	if e.tcpMux != nil {
+		errs = append(errs, e.tcpMux.Close())
+		e.tcpMux = nil
+		e.tcpListener = nil
+	}
+	return errors.Join(errs...)
+}
+
+// settingEngine 按网络设置生成 Pion SettingEngine
+// settingEngine builds the Pion SettingEngine of a new peer connection from
+// the network settings.
+func (e *Engine) settingEngine() (webrtc.SettingEngine, error) {
+	var s webrtc.SettingEngine
+	if e.network.UDPPortMin != 0 || e.network.UDPPortMax != 0 {
+		if err := s.SetEphemeralUDPPortRange(e.network.UDPPortMin, e.network.UDPPortMax); err != nil {
+			return s, fmt.Errorf("udp port range: %w", err)
+		}
+	}
+	if len(e.network.NAT1To1IPs) > 0 {
+		candidateType := e.network.NAT1To1CandidateType
+		if candidateType == webrtc.ICECandidateTypeUnknown {
+			candidateType = webrtc.ICECandidateTypeHost
+		}
+		s.SetNAT1To1IPs(e.network.NAT1To1IPs, candidateType)
+	}
+	if len(e.network.Interfaces) > 0 {
+		s.SetInterfaceFilter(e.interfaceFilter())
+	}
+	s.SetIncludeLoopbackCandidate(e.network.IncludeLoopback)
+	if e.udpMux != nil {
+		s.SetICEUDPMux(e.udpMux)
+	}
+	if e.tcpMux != nil {
+		// Pion 默认只收集 UDP 候选
+		// Pion only gathers UDP candidates by default.
+		s.SetICETCPMux(e.tcpMux)
+		s.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+		})
+	}
+	return s, nil
+}
+
+// interfaceFilter 只接受配置的网卡；未配置时接受全部
+// interfaceFilter accepts the configured interfaces, or every interface when
+// none are configured.
+func (e *Engine) interfaceFilter() func(string) bool {
+	allowed := make(map[string]bool, len(e.network.Interfaces))
+	for _, name := range e.network.Interfaces {
+		allowed[name] = true
+	}
+	return func(name string) bool {
+		return len(allowed) == 0 || allowed[name]
+	}
+}
+
+// ListenAddresses 返回共用 ICE 端口实际监听的地址
+// ListenAddresses returns the addresses the shared ICE mux ports listen on.
+func (e *Engine) ListenAddresses() []net.Addr {
+	var addrs []net.Addr
+	if e.udpMux != nil {
+		addrs = append(addrs, e.udpMux.GetListenAddresses()...)
+	}
+	if e.tcpMux != nil {
+		addrs = append(addrs, e.tcpListener.Addr())
+	}
+	return addrs
+}
@@ -0,0 +1,327 @@
+package media_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+const (
+	publicIP = "203.0.113.10"
+
+	// connectTimeout 每个连接建立或收集候选的最长时间
+	// connectTimeout bounds how long each connection or candidate gathering may take.
+	connectTimeout = 15 * time.Second
+)
+
+// 以下测试在回环网卡上端到端校验媒体引擎的网络设置
+// The tests below check the network settings of the media engine end to end
+// over the loopback interface.
+
+// TestUDPMux 多个服务端连接共用一个 UDP 端口
+// TestUDPMux connects two calls through a single shared UDP port and checks
+// that a second engine cannot take the same port.
+func TestUDPMux(t *testing.T) {
+	muxPort := freePort(t, "udp")
+	engine := newTestEngine(t, &media.Config{Network: media.Network{Interfaces: []string{loopbackInterface(t)}, IncludeLoopback: true, UDPMuxPort: muxPort}})
+	if addrs := engine.ListenAddresses(); len(addrs) == 0 {
+		t.Error("engine listens on no address")
+	}
+	for _, callID := range []string{"mux-1", "mux-2"} {
+		peerConn, candidates := connect(t, engine, callID, udpClient())
+		if len(candidates) == 0 || !allPorts(candidates, muxPort, muxPort) {
+			t.Errorf("%s advertises %s, want only port %d", callID, describe(candidates), muxPort)
+		}
+		if pair := engine.CollectStats(peerConn).CandidatePair; pair == nil || pair.Local.Port != int32(muxPort) {
+			t.Errorf("%s not connected through port %d: %+v", callID, muxPort, pair)
+		}
+	}
+	if n := len(engine.ListPeerConnections()); n != 2 {
+		t.Errorf("engine holds %d peer connections, want both calls on the port", n)
+	}
+	if _, err := media.NewEngine(zerolog.Nop(), &media.Config{Network: media.Network{IncludeLoopback: true, UDPMuxPort: muxPort}}); err == nil {
+		t.Error("second engine on the same port accepted")
+	}
+}
+
+// TestTCPMux 只能使用 TCP 的客户端经 ICE-TCP 复用端口连接
+// TestTCPMux connects a TCP-only client through the ICE-TCP mux port.
+func TestTCPMux(t *testing.T) {
+	tcpPort := freePort(t, "tcp")
+	engine := newTestEngine(t, &media.Config{Network: media.Network{Interfaces: []string{loopbackInterface(t)}, IncludeLoopback: true, TCPMuxPort: tcpPort}})
+	peerConn, candidates := connect(t, engine, "tcp", tcpClient())
+	if !hasCandidate(candidates, "tcp", tcpPort) {
+		t.Errorf("no passive TCP candidate on port %d: %s", tcpPort, describe(candidates))
+	}
+	if pair := engine.CollectStats(peerConn).CandidatePair; pair == nil || !strings.EqualFold(pair.Local.Protocol, "tcp") {
+		t.Errorf("TCP-only client not connected over ICE-TCP: %+v", pair)
+	}
+}
+
+// TestUDPPortRange 候选与连接只使用配置的端口范围
+// TestUDPPortRange checks that candidates and the connection stay within the
+// configured port range.
+func TestUDPPortRange(t *testing.T) {
+	const rangeMin, rangeMax = 42000, 42009
+	engine := newTestEngine(t, &media.Config{Network: media.Network{Interfaces: []string{loopbackInterface(t)}, IncludeLoopback: true, UDPPortMin: rangeMin, UDPPortMax: rangeMax}})
+	peerConn, candidates := connect(t, engine, "range", udpClient())
+	if len(candidates) == 0 || !allPorts(candidates, rangeMin, rangeMax) {
+		t.Errorf("candidates %s, want all within %d-%d", describe(candidates), rangeMin, rangeMax)
+	}
+	if pair := engine.CollectStats(peerConn).CandidatePair; pair == nil || pair.Local.Port < rangeMin || pair.Local.Port > rangeMax {
+		t.Errorf("not connected through a port in the range: %+v", pair)
+	}
+}
+
+// TestNAT1To1 1:1 NAT 下对外公布的候选地址
+// TestNAT1To1 inspects the candidates advertised behind a 1:1 NAT.
+func TestNAT1To1(t *testing.T) {
+	network := media.Network{Interfaces: []string{loopbackInterface(t)}, IncludeLoopback: true, NAT1To1IPs: []string{publicIP}}
+
+	network.NAT1To1CandidateType = webrtc.ICECandidateTypeHost
+	candidates := gather(t, newTestEngine(t, &media.Config{Network: network}))
+	if len(candidates) == 0 || !allAddresses(candidates, ice.CandidateTypeHost, publicIP) {
+		t.Errorf("host candidates %s, want all rewritten to %s", describe(candidates), publicIP)
+	}
+
+	network.NAT1To1CandidateType = webrtc.ICECandidateTypeSrflx
+	candidates = gather(t, newTestEngine(t, &media.Config{Network: network}))
+	if !hasAddress(candidates, ice.CandidateTypeServerReflexive, publicIP) {
+		t.Errorf("no server reflexive candidate on %s: %s", publicIP, describe(candidates))
+	}
+	if hasAddress(candidates, ice.CandidateTypeHost, publicIP) {
+		t.Errorf("host candidates rewritten to %s: %s", publicIP, describe(candidates))
+	}
+}
+
+// TestNetworkValidation 不合法的端口范围被拒绝
+// TestNetworkValidation checks that invalid port ranges are rejected.
+func TestNetworkValidation(t *testing.T) {
+	for name, network := range map[string]media.Network{
+		"reversed port range":  {UDPPortMin: 42009, UDPPortMax: 42000},
+		"half-open port range": {UDPPortMin: 42000},
+	} {
+		if _, err := media.NewEngine(zerolog.Nop(), &media.Config{Network: network}); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+// connect 客户端发起音频 offer，服务端应答，等待连接建立；返回服务端连接及其候选
+// connect has a Pion client built on settingEngine offer audio to a new server
+// peer connection, waits for the connection to come up and returns the server
+// peer connection with the candidates it advertised.
+func connect(t *testing.T, engine *media.Engine, callID string, settingEngine webrtc.SettingEngine) (*media.PeerConnection, []ice.Candidate) {
+	t.Helper()
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatalf("register codecs: %v", err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
+	client, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("create client peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if _, err := client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatalf("add audio transceiver: %v", err)
+	}
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(client)
+	if err := client.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set client offer: %v", err)
+	}
+	<-gathered
+
+	peerConn := answer(t, engine, callID, client.LocalDescription())
+	if err := client.SetRemoteDescription(*peerConn.PC.LocalDescription()); err != nil {
+		t.Fatalf("apply answer: %v", err)
+	}
+
+	deadline := time.Now().Add(connectTimeout)
+	for peerConn.PC.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("call %s not connected within %s: %s, server candidates %s", callID, connectTimeout,
+				peerConn.PC.ConnectionState(), describe(candidatesOf(t, peerConn.PC.LocalDescription())))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return peerConn, candidatesOf(t, peerConn.PC.LocalDescription())
+}
+
+// gather 返回服务端为一个音频 offer 收集到的候选，不建立连接
+// gather returns the candidates the server gathers for an audio offer,
+// without connecting.
+func gather(t *testing.T, engine *media.Engine) []ice.Candidate {
+	t.Helper()
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("create client peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if _, err := client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatalf("add audio transceiver: %v", err)
+	}
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	return candidatesOf(t, answer(t, engine, "gather", &offer).PC.LocalDescription())
+}
+
+// answer 服务端应用 offer 并给出收集完候选的 answer
+// answer applies offer to a new server peer connection and sets an answer
+// carrying every gathered candidate.
+func answer(t *testing.T, engine *media.Engine, callID string, offer *webrtc.SessionDescription) *media.PeerConnection {
+	t.Helper()
+	peerConn, err := engine.CreatePeerConnection(context.Background(), callID, "alice@example.com", "server", nil)
+	if err != nil {
+		t.Fatalf("create server peer connection: %v", err)
+	}
+	if err := peerConn.PC.SetRemoteDescription(*offer); err != nil {
+		t.Fatalf("apply offer: %v", err)
+	}
+	answer, err := peerConn.PC.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("create answer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConn.PC)
+	if err := peerConn.PC.SetLocalDescription(answer); err != nil {
+		t.Fatalf("set answer: %v", err)
+	}
+	select {
+	case <-gathered:
+	case <-time.After(connectTimeout):
+		t.Fatalf("server candidates not gathered within %s", connectTimeout)
+	}
+	return peerConn
+}
+
+func udpClient() webrtc.SettingEngine {
+	var s webrtc.SettingEngine
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	return s
+}
+
+// tcpClient 只使用 TCP 的客户端，模拟 UDP 被封锁的网络
+// tcpClient only uses TCP, like a client on a network that blocks UDP.
+func tcpClient() webrtc.SettingEngine {
+	var s webrtc.SettingEngine
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeTCP4})
+	return s
+}
+
+func candidatesOf(t *testing.T, desc *webrtc.SessionDescription) []ice.Candidate {
+	t.Helper()
+	var candidates []ice.Candidate
+	for _, line := range strings.Split(desc.SDP, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "a=candidate:")
+		if !ok {
+			continue
+		}
+		candidate, err := ice.UnmarshalCandidate(value)
+		if err != nil {
+			t.Fatalf("parse candidate %q: %v", value, err)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+func allPorts(candidates []ice.Candidate, min, max int) bool {
+	for _, c := range candidates {
+		if c.Port() < min || c.Port() > max {
+			return false
+		}
+	}
+	return true
+}
+
+func allAddresses(candidates []ice.Candidate, typ ice.CandidateType, address string) bool {
+	for _, c := range candidates {
+		if c.Type() == typ && c.Address() != address {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAddress(candidates []ice.Candidate, typ ice.CandidateType, address string) bool {
+	for _, c := range candidates {
+		if c.Type() == typ && c.Address() == address {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCandidate(candidates []ice.Candidate, network string, port int) bool {
+	for _, c := range candidates {
+		if c.NetworkType().IsTCP() == (network == "tcp") && c.Port() == port {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(candidates []ice.Candidate) string {
+	parts := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		parts = append(parts, fmt.Sprintf("%s %s %s:%d", c.Type(), c.NetworkType(), c.Address(), c.Port()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loopbackInterface 回环网卡名，Linux 上为 lo，macOS 上为 lo0
+// loopbackInterface returns the name of the loopback interface, lo on Linux
+// and lo0 on macOS.
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("list interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+// freePort 向系统借一个空闲端口
+// freePort borrows a free port from the OS.
+func freePort(t *testing.T, network string) int {
+	t.Helper()
+	switch network {
+	case "udp":
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("find free udp port: %v", err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	default:
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("find free tcp port: %v", err)
+		}
+		defer listener.Close()
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+}
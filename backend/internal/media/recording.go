@@ -0,0 +1,505 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// ManifestFile 每个录制目录中的清单文件名
+// ManifestFile is the name of the manifest inside every recording directory.
+const ManifestFile = "manifest.json"
+
+var (
+	// ErrRecordingDisabled 未配置录制目录
+	// ErrRecordingDisabled is returned when the engine has no recording directory.
+	ErrRecordingDisabled = errors.New("recording is not enabled")
+	// ErrAlreadyRecording 通话或其所在房间已在录制
+	// ErrAlreadyRecording is returned when the call, or the room it joined, is
+	// already being recorded.
+	ErrAlreadyRecording = errors.New("call is already being recorded")
+	// ErrNotRecording 通话未在录制
+	// ErrNotRecording is returned when stopping a call that is not being recorded.
+	ErrNotRecording = errors.New("call is not being recorded")
+)
+
+// RecordingParticipant 录制覆盖的参与者，及其与服务端之间的通话
+// RecordingParticipant is someone a recording covers, with their call to the server.
+type RecordingParticipant struct {
+	CallID string `json:"call_id"`
+	Email  string `json:"email"`
+}
+
+// RecordingTrack 录制中的一个轨道文件
+// RecordingTrack describes one track file of a recording. OffsetMS is when
+// the first packet arrived, counted from the start of the recording, so tracks
+// can be lined up on playback.
+type RecordingTrack struct {
+	File        string `json:"file"`
+	CallID      string `json:"call_id"`
+	Participant string `json:"participant"`
+	TrackID     string `json:"track_id"`
+	Kind        string `json:"kind"`
+	Codec       string `json:"codec"`
+	OffsetMS    int64  `json:"offset_ms"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+// RecordingManifest 录制清单，停止录制时写入 manifest.json
+// RecordingManifest describes a recording. It is written to manifest.json
+// when the recording stops.
+type RecordingManifest struct {
+	ID           string                 `json:"id"`
+	CallID       string                 `json:"call_id"`
+	Room         string                 `json:"room,omitempty"`
+	StartedAt    time.Time              `json:"started_at"`
+	EndedAt      *time.Time             `json:"ended_at,omitempty"`
+	Participants []RecordingParticipant `json:"participants"`
+	Tracks       []RecordingTrack       `json:"tracks"`
+
+	// 录制文件所在目录
+	// Directory holding the track files and the manifest.
+	Dir string `json:"-"`
+}
+
+// rtpWriter Pion 媒体写入器的公共接口
+// rtpWriter is what Pion's media writers have in common.
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// recording 进行中的录制；覆盖该通话，若通话在 SFU 房间中则覆盖整个房间
+// recording is a recording in progress. It covers its call or, when the call
+// joined an SFU room, everyone in the room.
+type recording struct {
+	mu       sync.Mutex
+	manifest RecordingManifest
+	tracks   map[*webrtc.TrackRemote]*recordedTrack
+	stopped  bool
+}
+
+// recordedTrack 一个轨道的写入器与首末包时间
+// recordedTrack is the writer of one track, with when its first and last packets arrived.
+type recordedTrack struct {
+	writer      rtpWriter
+	index       int
+	first, last time.Time
+}
+
+// trackReceiver 正在读取的远端轨道及覆盖它的录制；录制开始或停止时更新，读包时无需加锁
+// trackReceiver is a remote track being read, with the recording that covers
+// it. Starting and stopping recordings update rec, so receive can check it for
+// every packet without taking recMu.
+type trackReceiver struct {
+	peerConn *PeerConnection
+	room     string
+	rec      atomic.Pointer[recording]
+}
+
+// covers 录制是否覆盖该轨道
+// covers reports whether rec, started by the connection with ID startedBy,
+// covers the receiver's track.
+func (tr *trackReceiver) covers(startedBy string, rec *recording) bool {
+	return tr.peerConn.id() == startedBy || (tr.room != "" && tr.room == rec.manifest.Room)
+}
+
+// watchRecordings 登记正在读取的轨道，并取得当前覆盖它的录制
+// watchRecordings registers a track receive is about to read and picks up the
+// recording covering it, if one is already running.
+func (e *Engine) watchRecordings(peerConn *PeerConnection, room string) *trackReceiver {
+	tr := &trackReceiver{peerConn: peerConn, room: room}
+	e.recMu.Lock()
+	defer e.recMu.Unlock()
+	e.receivers[tr] = struct{}{}
+	for id, rec := range e.recordings {
+		if tr.covers(id, rec) {
+			tr.rec.Store(rec)
+			break
+		}
+	}
+	return tr
+}
+
+// unwatchRecordings 轨道结束时取消登记
+// unwatchRecordings drops a receiver once its track ended.
+func (e *Engine) unwatchRecordings(tr *trackReceiver) {
+	e.recMu.Lock()
+	defer e.recMu.Unlock()
+	delete(e.receivers, tr)
+}
+
+// StartRecording 开始录制对等连接（或其所在房间）的入站轨道
+// StartRecording starts writing the incoming tracks of a peer connection to
+// disk, or of its whole room if the connection joined one. Opus goes to OGG,
+// VP8 and VP9 to IVF, one file per track.
+func (e *Engine) StartRecording(callID, localEmail, remoteEmail, recordingID string) (*RecordingManifest, error) {
+	if e.recordingDir == "" {
+		return nil, ErrRecordingDisabled
+	}
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	room := e.roomOf(peerConn)
+
+	e.recMu.Lock()
+	defer e.recMu.Unlock()
+	if _, ok := e.recordings[peerConn.id()]; ok {
+		return nil, ErrAlreadyRecording
+	}
+	// 一个房间只有一个录制，否则房间的轨道会被拆到两份清单中
+	// A room has one recording at most; a second one would split the room's
+	// tracks between two manifests.
+	for _, other := range e.recordings {
+		if room != "" && other.manifest.Room == room {
+			return nil, ErrAlreadyRecording
+		}
+	}
+
+	dir := filepath.Join(e.recordingDir, recordingID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create recording directory: %w", err)
+	}
+	rec := &recording{
+		manifest: RecordingManifest{
+			ID:        recordingID,
+			CallID:    callID,
+			Room:      room,
+			StartedAt: time.Now().UTC(),
+			Tracks:    []RecordingTrack{},
+			Dir:       dir,
+		},
+		tracks: make(map[*webrtc.TrackRemote]*recordedTrack),
+	}
+	rec.manifest.Participants = e.participantsOf(peerConn)
+	e.recordings[peerConn.id()] = rec
+	for tr := range e.receivers {
+		if tr.covers(peerConn.id(), rec) {
+			tr.rec.Store(rec)
+		}
+	}
+
+	e.logger.Info().
+		Str("call_id", callID).
+		Str("recording_id", recordingID).
+		Str("room", rec.manifest.Room).
+		Msg("recording started")
+
+	manifest := rec.snapshot()
+	return &manifest, nil
+}
+
+// StopRecording 停止对等连接发起的录制，关闭文件并写入清单；连接已关闭时也可停止
+// StopRecording stops the recording a peer connection started, closes its
+// files and writes the manifest. It works after the connection was closed too.
+func (e *Engine) StopRecording(callID, localEmail, remoteEmail string) (*RecordingManifest, error) {
+	id := connectionID(callID, localEmail, remoteEmail)
+	e.recMu.Lock()
+	rec, ok := e.recordings[id]
+	delete(e.recordings, id)
+	e.detachReceivers(rec)
+	e.recMu.Unlock()
+	if !ok {
+		return nil, ErrNotRecording
+	}
+
+	if peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail); err == nil {
+		rec.addParticipants(e.participantsOf(peerConn))
+	}
+	manifest, err := rec.stop()
+	if err != nil {
+		return nil, err
+	}
+
+	e.logger.Info().
+		Str("call_id", callID).
+		Str("recording_id", manifest.ID).
+		Int("tracks", len(manifest.Tracks)).
+		Msg("recording stopped")
+	return manifest, nil
+}
+
+// ActiveRecording 返回覆盖该对等连接的进行中录制
+// ActiveRecording returns the recording in progress that covers a peer
+// connection, if any, so participants joining a recorded room can be told
+// about it.
+func (e *Engine) ActiveRecording(callID, localEmail, remoteEmail string) (*RecordingManifest, bool) {
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return nil, false
+	}
+	rec := e.recordingFor(peerConn, e.roomOf(peerConn))
+	if rec == nil {
+		return nil, false
+	}
+	manifest := rec.snapshot()
+	return &manifest, true
+}
+
+// detachReceivers 停止录制时不再向其写入；调用方持有 recMu
+// detachReceivers stops feeding rec from the tracks it covered. The caller
+// holds recMu.
+func (e *Engine) detachReceivers(rec *recording) {
+	for tr := range e.receivers {
+		tr.rec.CompareAndSwap(rec, nil)
+	}
+}
+
+// recordingFor 返回覆盖该连接的录制
+// recordingFor returns the recording covering a peer connection in room.
+func (e *Engine) recordingFor(peerConn *PeerConnection, room string) *recording {
+	e.recMu.Lock()
+	defer e.recMu.Unlock()
+	if rec, ok := e.recordings[peerConn.id()]; ok {
+		return rec
+	}
+	if room == "" {
+		return nil
+	}
+	for _, rec := range e.recordings {
+		if rec.manifest.Room == room {
+			return rec
+		}
+	}
+	return nil
+}
+
+// finishRecordedTrack 轨道结束时关闭其文件
+// finishRecordedTrack closes the file of a track that ended, in whichever
+// recording holds it.
+func (e *Engine) finishRecordedTrack(remote *webrtc.TrackRemote) {
+	e.recMu.Lock()
+	recordings := make([]*recording, 0, len(e.recordings))
+	for _, rec := range e.recordings {
+		recordings = append(recordings, rec)
+	}
+	e.recMu.Unlock()
+	for _, rec := range recordings {
+		rec.finishTrack(remote)
+	}
+}
+
+// stopAllRecordings 关闭时结束所有录制，保证文件与清单完整
+// stopAllRecordings finishes every recording on shutdown so files and
+// manifests are complete.
+func (e *Engine) stopAllRecordings() {
+	e.recMu.Lock()
+	recordings := e.recordings
+	e.recordings = make(map[string]*recording)
+	for _, rec := range recordings {
+		e.detachReceivers(rec)
+	}
+	e.recMu.Unlock()
+	for _, rec := range recordings {
+		if _, err := rec.stop(); err != nil {
+			e.logger.Warn().Err(err).Str("call_id", rec.manifest.CallID).Msg("failed to finish recording")
+		}
+	}
+}
+
+// participantsOf 连接所在房间的全部成员，不在房间时只有连接自身
+// participantsOf lists everyone in the connection's room, or just the
+// connection's own user outside a room.
+func (e *Engine) participantsOf(peerConn *PeerConnection) []RecordingParticipant {
+	e.roomsMu.Lock()
+	defer e.roomsMu.Unlock()
+	r, ok := e.rooms[peerConn.Room]
+	if !ok {
+		return []RecordingParticipant{{CallID: peerConn.CallID, Email: peerConn.LocalEmail}}
+	}
+	participants := make([]RecordingParticipant, 0, len(r.members))
+	for member := range r.members {
+		participants = append(participants, RecordingParticipant{CallID: member.CallID, Email: member.LocalEmail})
+	}
+	return participants
+}
+
+// write 写入一个 RTP 包，首次见到轨道时创建其文件
+// write records one RTP packet of remote, creating the track's file the first
+// time the track is seen. Codecs without a writer are skipped.
+func (r *recording) write(peerConn *PeerConnection, remote *webrtc.TrackRemote, packet []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil
+	}
+	track, ok := r.tracks[remote]
+	if !ok {
+		var err error
+		if track, err = r.addTrack(peerConn, remote); err != nil {
+			return err
+		}
+		r.tracks[remote] = track
+	}
+	if track.writer == nil {
+		return nil
+	}
+
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(packet); err != nil {
+		return err
+	}
+	now := time.Now()
+	if track.first.IsZero() {
+		track.first = now
+	}
+	track.last = now
+	return track.writer.WriteRTP(&pkt)
+}
+
+// addTrack 为轨道选择写入器并登记到清单；调用方持有 r.mu
+// addTrack picks a writer for the track's codec and lists the track in the
+// manifest. The caller holds r.mu.
+func (r *recording) addTrack(peerConn *PeerConnection, remote *webrtc.TrackRemote) (*recordedTrack, error) {
+	codec := remote.Codec().MimeType
+	var ext string
+	switch {
+	case strings.EqualFold(codec, webrtc.MimeTypeOpus):
+		ext = "ogg"
+	case strings.EqualFold(codec, webrtc.MimeTypeVP8), strings.EqualFold(codec, webrtc.MimeTypeVP9):
+		ext = "ivf"
+	default:
+		return &recordedTrack{index: -1}, nil
+	}
+
+	name := fmt.Sprintf("track-%d.%s", len(r.manifest.Tracks)+1, ext)
+	path := filepath.Join(r.manifest.Dir, name)
+	var (
+		writer rtpWriter
+		err    error
+	)
+	switch {
+	case strings.EqualFold(codec, webrtc.MimeTypeOpus):
+		writer, err = oggwriter.New(path, remote.Codec().ClockRate, remote.Codec().Channels)
+	case strings.EqualFold(codec, webrtc.MimeTypeVP8):
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(webrtc.MimeTypeVP8))
+	default:
+		writer, err = newVP9Writer(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", name, err)
+	}
+
+	r.manifest.Tracks = append(r.manifest.Tracks, RecordingTrack{
+		File:        name,
+		CallID:      peerConn.CallID,
+		Participant: peerConn.LocalEmail,
+		TrackID:     remote.ID(),
+		Kind:        remote.Kind().String(),
+		Codec:       codec,
+	})
+	return &recordedTrack{writer: writer, index: len(r.manifest.Tracks) - 1}, nil
+}
+
+// finishTrack 关闭已结束轨道的文件并记录其时长
+// finishTrack closes the file of an ended track and records its timing.
+func (r *recording) finishTrack(remote *webrtc.TrackRemote) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	track, ok := r.tracks[remote]
+	if !ok || r.stopped {
+		return
+	}
+	_ = r.closeTrack(track)
+}
+
+// closeTrack 调用方持有 r.mu
+// closeTrack closes a track's writer and fills in its timing. The caller holds r.mu.
+func (r *recording) closeTrack(track *recordedTrack) error {
+	if track.writer == nil {
+		return nil
+	}
+	err := track.writer.Close()
+	track.writer = nil
+	if !track.first.IsZero() {
+		entry := &r.manifest.Tracks[track.index]
+		entry.OffsetMS = track.first.Sub(r.manifest.StartedAt).Milliseconds()
+		entry.DurationMS = track.last.Sub(track.first).Milliseconds()
+	}
+	return err
+}
+
+// addParticipants 合并录制期间加入房间的参与者
+// addParticipants merges in participants who joined the room while recording.
+func (r *recording) addParticipants(participants []RecordingParticipant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range participants {
+		r.addParticipantLocked(p)
+	}
+}
+
+// addParticipantLocked 调用方持有 r.mu
+// addParticipantLocked adds p unless it is already listed. The caller holds r.mu.
+func (r *recording) addParticipantLocked(p RecordingParticipant) {
+	for _, existing := range r.manifest.Participants {
+		if existing == p {
+			return
+		}
+	}
+	r.manifest.Participants = append(r.manifest.Participants, p)
+}
+
+// stop 关闭所有文件并写入清单
+// stop closes every file and writes the manifest.
+func (r *recording) stop() (*RecordingManifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+
+	var firstErr error
+	for _, track := range r.tracks {
+		if err := r.closeTrack(track); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	endedAt := time.Now().UTC()
+	r.manifest.EndedAt = &endedAt
+	// 录制期间加入又离开房间的参与者只出现在轨道中
+	// Participants who joined the room and left again while recording only
+	// show up in the tracks.
+	for _, track := range r.manifest.Tracks {
+		r.addParticipantLocked(RecordingParticipant{CallID: track.CallID, Email: track.Participant})
+	}
+
+	data, err := json.MarshalIndent(r.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.manifest.Dir, ManifestFile), data, 0o640); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+	if firstErr != nil {
+		return nil, fmt.Errorf("close track file: %w", firstErr)
+	}
+	manifest := r.copyManifest()
+	return &manifest, nil
+}
+
+func (r *recording) snapshot() RecordingManifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.copyManifest()
+}
+
+// copyManifest 调用方持有 r.mu
+// copyManifest returns a copy safe to use after r.mu is released. The caller holds r.mu.
+func (r *recording) copyManifest() RecordingManifest {
+	manifest := r.manifest
+	manifest.Participants = append([]RecordingParticipant(nil), r.manifest.Participants...)
+	manifest.Tracks = append([]RecordingTrack{}, r.manifest.Tracks...)
+	return manifest
+}
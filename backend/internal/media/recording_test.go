@@ -0,0 +1,50 @@
+package media_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// TestRecordingIsolation 两个连接使用相同的通话 ID：录制只属于发起它的连接
+// TestRecordingIsolation checks that when two connections share a call ID, a
+// recording belongs to the connection that started it.
+func TestRecordingIsolation(t *testing.T) {
+	const (
+		callID = "shared-call"
+		remote = "server"
+		carol  = "carol@example.com"
+		dave   = "dave@example.com"
+	)
+	engine, err := media.NewEngine(zerolog.Nop(), &media.Config{Mode: media.ModeSFU, RecordingDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("create media engine: %v", err)
+	}
+	t.Cleanup(func() { _ = engine.Shutdown(context.Background()) })
+	for _, email := range []string{carol, dave} {
+		if _, err := engine.CreatePeerConnection(context.Background(), callID, email, remote, nil); err != nil {
+			t.Fatalf("create peer connection for %s: %v", email, err)
+		}
+	}
+
+	manifest, err := engine.StartRecording(callID, carol, remote, "isolation")
+	if err != nil {
+		t.Fatalf("start recording: %v", err)
+	}
+	if len(manifest.Participants) != 1 || manifest.Participants[0].Email != carol {
+		t.Errorf("recording names %+v, want only %s", manifest.Participants, carol)
+	}
+	if _, active := engine.ActiveRecording(callID, dave, remote); active {
+		t.Error("other connection with the same call ID is covered by the recording")
+	}
+	if _, err := engine.StopRecording(callID, dave, remote); !errors.Is(err, media.ErrNotRecording) {
+		t.Errorf("other connection stopping the recording got %v, want ErrNotRecording", err)
+	}
+	if _, err := engine.StopRecording(callID, carol, remote); err != nil {
+		t.Errorf("starting connection stopping the recording: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// 媒体引擎工作模式
+// Media engine modes.
+const (
+	// ModeTerminate 服务端单独终结每个通话，不在连接之间转发媒体
+	// ModeTerminate terminates every call on its own; no media crosses peer connections.
+	ModeTerminate = "terminate"
+	// ModeSFU 同一房间的参与者各自向服务端发布一次，由服务端转发给其他参与者
+	// ModeSFU lets participants of a room publish once to the server, which
+	// forwards every published track to every other participant.
+	ModeSFU = "sfu"
+)
+
+// ErrSFUDisabled 引擎未运行在 SFU 模式
+// ErrSFUDisabled is returned when joining a room on an engine not in ModeSFU.
+var ErrSFUDisabled = errors.New("media engine is not in sfu mode")
+
+// rtpBufferSize 单个 RTP 包的读取缓冲
+// rtpBufferSize fits one RTP packet at the usual MTU.
+const rtpBufferSize = 1500
+
+// room SFU 房间：参与者及其发布的轨道
+// room is an SFU room: its participants and the tracks they publish.
+type room struct {
+	id      string
+	members map[*PeerConnection]struct{}
+	tracks  map[*forwardedTrack]struct{}
+}
+
+// forwardedTrack 参与者发布的轨道，以及在其他参与者处的发送器
+// forwardedTrack is a track one participant publishes, with the senders that
+// forward it to each other participant.
+type forwardedTrack struct {
+	publisher *PeerConnection
+	remote    *webrtc.TrackRemote
+	local     *webrtc.TrackLocalStaticRTP
+	senders   map[*PeerConnection]*webrtc.RTPSender
+}
+
+// Mode 返回引擎的工作模式
+// Mode returns the engine's mode, ModeTerminate or ModeSFU.
+func (e *Engine) Mode() string {
+	return e.mode
+}
+
+// JoinRoom 将对等连接加入 SFU 房间，并订阅房间内已发布的轨道
+// JoinRoom puts a peer connection into an SFU room and subscribes it to every
+// track already published there. Call it before answering the participant's
+// offer; tracks published later are added with renegotiation.
+func (e *Engine) JoinRoom(callID, localEmail, remoteEmail, roomID string) error {
+	if e.mode != ModeSFU {
+		return ErrSFUDisabled
+	}
+	peerConn, err := e.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err != nil {
+		return err
+	}
+
+	e.roomsMu.Lock()
+	defer e.roomsMu.Unlock()
+	if peerConn.Room != "" {
+		return fmt.Errorf("peer connection already joined room %s", peerConn.Room)
+	}
+	r, ok := e.rooms[roomID]
+	if !ok {
+		r = &room{
+			id:      roomID,
+			members: make(map[*PeerConnection]struct{}),
+			tracks:  make(map[*forwardedTrack]struct{}),
+		}
+		e.rooms[roomID] = r
+	}
+	peerConn.Room = roomID
+	r.members[peerConn] = struct{}{}
+	for ft := range r.tracks {
+		e.subscribe(ft, peerConn)
+	}
+
+	e.logger.Info().
+		Str("call_id", callID).
+		Str("room", roomID).
+		Str("participant", localEmail).
+		Int("participants", len(r.members)).
+		Msg("participant joined room")
+	return nil
+}
+
+// publish 将参与者发布的远端轨道登记到房间并转发给其他参与者
+// publish registers a participant's remote track with its room and subscribes
+// everyone else in the room to it. receive then feeds it packets until the
+// track ends. The stream ID of the forwarded track is the publisher's email,
+// so subscribers can tell whose media it is. It returns a nil room when the
+// connection is in none.
+func (e *Engine) publish(peerConn *PeerConnection, remote *webrtc.TrackRemote) (*room, *forwardedTrack) {
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), peerConn.LocalEmail)
+	if err != nil {
+		e.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to create forwarding track")
+		return nil, nil
+	}
+	ft := &forwardedTrack{
+		publisher: peerConn,
+		remote:    remote,
+		local:     local,
+		senders:   make(map[*PeerConnection]*webrtc.RTPSender),
+	}
+
+	e.roomsMu.Lock()
+	r, ok := e.rooms[peerConn.Room]
+	if !ok || peerConn.Room == "" {
+		e.roomsMu.Unlock()
+		return nil, nil
+	}
+	r.tracks[ft] = struct{}{}
+	for member := range r.members {
+		if member != peerConn {
+			e.subscribe(ft, member)
+		}
+	}
+	e.roomsMu.Unlock()
+
+	e.logger.Info().
+		Str("call_id", peerConn.CallID).
+		Str("room", r.id).
+		Str("publisher", peerConn.LocalEmail).
+		Str("kind", remote.Kind().String()).
+		Msg("track published")
+	return r, ft
+}
+
+// roomOf 返回连接所在的房间 ID
+// roomOf returns the room a peer connection joined, or "" if none.
+func (e *Engine) roomOf(peerConn *PeerConnection) string {
+	e.roomsMu.Lock()
+	defer e.roomsMu.Unlock()
+	return peerConn.Room
+}
+
+// subscribe 将转发轨道加到成员的连接上；调用方持有 roomsMu
+// subscribe adds a forwarded track to a member's peer connection, which then
+// renegotiates. The caller holds roomsMu.
+func (e *Engine) subscribe(ft *forwardedTrack, member *PeerConnection) {
+	sender, err := member.PC.AddTrack(ft.local)
+	if err != nil {
+		e.logger.Warn().Err(err).
+			Str("call_id", member.CallID).
+			Str("publisher", ft.publisher.LocalEmail).
+			Msg("failed to subscribe to track")
+		return
+	}
+	ft.senders[member] = sender
+	go e.relayFeedback(ft, sender)
+	// 新订阅者需要关键帧才能开始解码
+	// A new subscriber cannot decode video until the next keyframe.
+	e.requestKeyframe(ft)
+}
+
+// relayFeedback 将订阅者的关键帧请求转给发布者
+// relayFeedback passes a subscriber's keyframe requests on to the publisher.
+func (e *Engine) relayFeedback(ft *forwardedTrack, sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				e.requestKeyframe(ft)
+			}
+		}
+	}
+}
+
+func (e *Engine) requestKeyframe(ft *forwardedTrack) {
+	if ft.remote.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+	_ = ft.publisher.PC.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(ft.remote.SSRC())},
+	})
+}
+
+// unpublish 停止转发轨道，并从仍在房间的订阅者处移除
+// unpublish stops forwarding a track and removes it from the subscribers
+// still in the room.
+func (e *Engine) unpublish(r *room, ft *forwardedTrack) {
+	e.roomsMu.Lock()
+	defer e.roomsMu.Unlock()
+	if _, ok := r.tracks[ft]; !ok {
+		return
+	}
+	delete(r.tracks, ft)
+	for member, sender := range ft.senders {
+		if _, ok := r.members[member]; !ok {
+			continue
+		}
+		if err := member.PC.RemoveTrack(sender); err != nil {
+			e.logger.Debug().Err(err).Str("call_id", member.CallID).Msg("failed to remove forwarded track")
+		}
+	}
+}
+
+// leaveRoom 参与者离开房间：其发布的轨道从其他人处移除，空房间被删除
+// leaveRoom takes a closed peer connection out of its room. Tracks it
+// published are removed from everyone else, and an empty room is dropped.
+func (e *Engine) leaveRoom(peerConn *PeerConnection) {
+	e.roomsMu.Lock()
+	r, ok := e.rooms[peerConn.Room]
+	if !ok {
+		e.roomsMu.Unlock()
+		return
+	}
+	delete(r.members, peerConn)
+	var published []*forwardedTrack
+	for ft := range r.tracks {
+		delete(ft.senders, peerConn)
+		if ft.publisher == peerConn {
+			published = append(published, ft)
+		}
+	}
+	if len(r.members) == 0 {
+		delete(e.rooms, r.id)
+	}
+	e.roomsMu.Unlock()
+
+	for _, ft := range published {
+		e.unpublish(r, ft)
+	}
+
+	e.logger.Info().
+		Str("call_id", peerConn.CallID).
+		Str("room", r.id).
+		Str("participant", peerConn.LocalEmail).
+		Msg("participant left room")
+}
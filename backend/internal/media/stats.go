@@ -0,0 +1,306 @@
+package media
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Stats 对等连接的媒体质量统计
+// Stats summarizes the media quality of a peer connection, computed from
+// Pion's GetStats report. Loss and bitrates cover the time since the previous
+// sample of the same connection, or since it was created.
+type Stats struct {
+	CallID      string    `json:"call_id"`
+	CollectedAt time.Time `json:"collected_at"`
+	// 往返时延（毫秒），取自选中的候选对，缺失时取自 RTCP 接收报告
+	// Round-trip time in milliseconds, from the selected candidate pair or,
+	// failing that, from RTCP receiver reports.
+	RTTMs float64 `json:"rtt_ms"`
+	// 接收流的平均到达抖动（毫秒）
+	// Mean interarrival jitter of the received streams, in milliseconds.
+	JitterMs        float64 `json:"jitter_ms"`
+	PacketsReceived uint64  `json:"packets_received"`
+	PacketsLost     int64   `json:"packets_lost"`
+	PacketLossPct   float64 `json:"packet_loss_pct"`
+	InboundKbps     float64 `json:"inbound_kbps"`
+	OutboundKbps    float64 `json:"outbound_kbps"`
+	// 选中的候选对
+	// The candidate pair carrying the media.
+	CandidatePair *CandidatePair `json:"candidate_pair,omitempty"`
+	Codecs        []string       `json:"codecs,omitempty"`
+	// 按 E 模型估算的 MOS（1–4.5），尚未收到媒体时为 0
+	// MOS-style score estimated with the E-model, from 1 to 4.5. Zero until
+	// media has been received.
+	MOS float64 `json:"mos,omitempty"`
+}
+
+// CandidatePair 选中的 ICE 候选对
+// CandidatePair is the selected ICE candidate pair.
+type CandidatePair struct {
+	Local  Candidate `json:"local"`
+	Remote Candidate `json:"remote"`
+}
+
+// Candidate ICE 候选
+// Candidate is one end of a candidate pair.
+type Candidate struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int32  `json:"port"`
+}
+
+// statsCounters 上一次采样的累计计数
+// statsCounters are the cumulative counters of the previous sample.
+type statsCounters struct {
+	at            time.Time
+	received      uint64
+	lost          int64
+	bytesReceived uint64
+	bytesSent     uint64
+}
+
+// CollectStats 采集对等连接的媒体统计
+// CollectStats collects the media statistics of a peer connection. RTP stream
+// counters come from the stats interceptor, the candidate pair from GetStats.
+func (e *Engine) CollectStats(peerConn *PeerConnection) *Stats {
+	now := time.Now()
+	stats := &Stats{CallID: peerConn.CallID, CollectedAt: now}
+
+	var (
+		counters      = statsCounters{at: now}
+		jitterSum     float64
+		jitterStreams int
+		reportRTT     time.Duration
+		codecs        = make(map[string]bool)
+	)
+	if peerConn.statsGetter != nil {
+		for _, receiver := range peerConn.PC.GetReceivers() {
+			for _, track := range receiver.Tracks() {
+				s := peerConn.statsGetter.Get(uint32(track.SSRC()))
+				if s == nil {
+					continue
+				}
+				counters.received += s.InboundRTPStreamStats.PacketsReceived
+				counters.lost += s.InboundRTPStreamStats.PacketsLost
+				counters.bytesReceived += s.InboundRTPStreamStats.BytesReceived
+				if jitter, ok := peerConn.jitterOf(track.SSRC()); ok {
+					jitterSum += jitter
+					jitterStreams++
+				}
+				codecs[track.Codec().MimeType] = true
+			}
+		}
+		for _, sender := range peerConn.PC.GetSenders() {
+			if sender.Track() == nil {
+				continue
+			}
+			params := sender.GetParameters()
+			for _, encoding := range params.Encodings {
+				s := peerConn.statsGetter.Get(uint32(encoding.SSRC))
+				if s == nil {
+					continue
+				}
+				counters.bytesSent += s.OutboundRTPStreamStats.BytesSent
+				if rtt := s.RemoteInboundRTPStreamStats.RoundTripTime; rtt > reportRTT {
+					reportRTT = rtt
+				}
+			}
+			if len(params.Codecs) > 0 {
+				codecs[params.Codecs[0].MimeType] = true
+			}
+		}
+	}
+	for codec := range codecs {
+		stats.Codecs = append(stats.Codecs, codec)
+	}
+	sort.Strings(stats.Codecs)
+
+	stats.PacketsReceived = counters.received
+	stats.PacketsLost = counters.lost
+	if jitterStreams > 0 {
+		stats.JitterMs = round2(jitterSum / float64(jitterStreams) * 1000)
+	}
+
+	report := peerConn.PC.GetStats()
+	var transport webrtc.TransportStats
+	for _, s := range report {
+		if t, ok := s.(webrtc.TransportStats); ok {
+			transport = t
+			break
+		}
+	}
+	if pair := selectedPair(report, transport); pair != nil {
+		stats.RTTMs = round2(pair.CurrentRoundTripTime * 1000)
+		local, _ := report[pair.LocalCandidateID].(webrtc.ICECandidateStats)
+		remote, _ := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats)
+		stats.CandidatePair = &CandidatePair{Local: candidateOf(local), Remote: candidateOf(remote)}
+	}
+	if stats.RTTMs == 0 {
+		stats.RTTMs = round2(float64(reportRTT) / float64(time.Millisecond))
+	}
+
+	// 与上一次采样比较得到区间丢包率与码率
+	// Interval loss and bitrates come from the difference to the previous sample.
+	peerConn.statsMu.Lock()
+	previous := peerConn.lastStats
+	peerConn.lastStats = &counters
+	peerConn.statsMu.Unlock()
+	if previous == nil {
+		previous = &statsCounters{at: peerConn.createdAt}
+	}
+	received := float64(delta(counters.received, previous.received))
+	lost := float64(counters.lost - previous.lost)
+	if expected := received + lost; expected > 0 && lost > 0 {
+		stats.PacketLossPct = round2(lost / expected * 100)
+	}
+	if elapsed := counters.at.Sub(previous.at).Seconds(); elapsed > 0 {
+		stats.InboundKbps = round2(float64(delta(counters.bytesReceived, previous.bytesReceived)) * 8 / 1000 / elapsed)
+		stats.OutboundKbps = round2(float64(delta(counters.bytesSent, previous.bytesSent)) * 8 / 1000 / elapsed)
+	}
+	if counters.received > 0 {
+		stats.MOS = round2(MOS(stats.RTTMs, stats.JitterMs, stats.PacketLossPct))
+	}
+	return stats
+}
+
+// selectedPair 返回承载媒体的候选对
+// selectedPair returns the candidate pair carrying the media: the transport's
+// selected pair, or else the nominated pair that succeeded.
+func selectedPair(report webrtc.StatsReport, transport webrtc.TransportStats) *webrtc.ICECandidatePairStats {
+	if pair, ok := report[transport.SelectedCandidatePairID].(webrtc.ICECandidatePairStats); ok {
+		return &pair
+	}
+	for _, s := range report {
+		if pair, ok := s.(webrtc.ICECandidatePairStats); ok && pair.Nominated && pair.State == webrtc.StatsICECandidatePairStateSucceeded {
+			return &pair
+		}
+	}
+	return nil
+}
+
+func candidateOf(s webrtc.ICECandidateStats) Candidate {
+	return Candidate{
+		Type:     s.CandidateType.String(),
+		Protocol: s.Protocol,
+		Address:  s.IP,
+		Port:     s.Port,
+	}
+}
+
+// MOS 按简化的 ITU-T G.107 E 模型由时延、抖动与丢包估算 MOS
+// MOS estimates a mean opinion score from round-trip time, jitter and packet
+// loss with the simplified ITU-T G.107 E-model commonly used for VoIP
+// monitoring. The result ranges from 1 (bad) to 4.5 (best achievable).
+func MOS(rttMs, jitterMs, lossPct float64) float64 {
+	// 单向有效时延：半个往返、两倍抖动（抖动缓冲）与 10ms 编解码时延
+	// Effective one-way latency: half the round trip, twice the jitter for the
+	// jitter buffer, and 10ms for the codec.
+	latency := rttMs/2 + 2*jitterMs + 10
+	r := 93.2
+	if latency < 160 {
+		r -= latency / 40
+	} else {
+		r -= (latency - 120) / 10
+	}
+	r -= 2.5 * lossPct
+
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	mos := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+	return math.Min(math.Max(mos, 1), 4.5)
+}
+
+// jitterEstimator 按 RFC 3550 估算一条接收流的到达抖动
+// jitterEstimator estimates the interarrival jitter of a received stream as
+// described in RFC 3550, section 6.4.1. The stats interceptor's own estimate
+// compares arrival intervals with absolute timestamps and cannot be used.
+type jitterEstimator struct {
+	mu            sync.Mutex
+	clockRate     float64
+	started       bool
+	lastArrival   time.Time
+	lastTimestamp uint32
+	// 以秒为单位
+	// In seconds.
+	jitter float64
+}
+
+// observe 记录一个在 arrival 到达的 RTP 包
+// observe records an RTP packet that arrived at arrival.
+func (j *jitterEstimator) observe(arrival time.Time, packet []byte) {
+	if len(packet) < 12 || j.clockRate == 0 {
+		return
+	}
+	timestamp := binary.BigEndian.Uint32(packet[4:8])
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started {
+		// int32 的差值可以跨越时间戳回绕
+		// The int32 difference carries across timestamp wraparound.
+		d := arrival.Sub(j.lastArrival).Seconds() - float64(int32(timestamp-j.lastTimestamp))/j.clockRate
+		j.jitter += (math.Abs(d) - j.jitter) / 16
+	}
+	j.started = true
+	j.lastArrival = arrival
+	j.lastTimestamp = timestamp
+}
+
+// trackJitter 为远端轨道登记抖动估算器，返回的函数在轨道结束时注销它
+// trackJitter registers a jitter estimator for a remote track. The returned
+// function unregisters it once the track ends.
+func (p *PeerConnection) trackJitter(remote *webrtc.TrackRemote) (*jitterEstimator, func()) {
+	estimator := &jitterEstimator{clockRate: float64(remote.Codec().ClockRate)}
+	ssrc := remote.SSRC()
+	p.statsMu.Lock()
+	if p.jitter == nil {
+		p.jitter = make(map[webrtc.SSRC]*jitterEstimator)
+	}
+	p.jitter[ssrc] = estimator
+	p.statsMu.Unlock()
+	return estimator, func() {
+		p.statsMu.Lock()
+		if p.jitter[ssrc] == estimator {
+			delete(p.jitter, ssrc)
+		}
+		p.statsMu.Unlock()
+	}
+}
+
+// jitterOf 返回接收流当前的抖动（秒）
+// jitterOf returns the current jitter of a received stream, in seconds.
+func (p *PeerConnection) jitterOf(ssrc webrtc.SSRC) (float64, bool) {
+	p.statsMu.Lock()
+	estimator, ok := p.jitter[ssrc]
+	p.statsMu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	estimator.mu.Lock()
+	defer estimator.mu.Unlock()
+	return estimator.jitter, estimator.started
+}
+
+// delta 计数器的增量；重新协商移除流后累计值可能变小，此时按 0 计
+// delta is how much a counter grew. Sums shrink when renegotiation removes a
+// stream, which counts as no growth.
+func delta(current, previous uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
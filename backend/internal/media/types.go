@@ -1,6 +1,10 @@
 package media
 
 import (
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -23,6 +27,23 @@ const (
 	CallStateEnded
 )
 
+// String 返回通话状态名称
+// String returns the name of the call state
+func (s CallState) String() string {
+	switch s {
+	case CallStateOffering:
+		return "offering"
+	case CallStateAnswering:
+		return "answering"
+	case CallStateActive:
+		return "active"
+	case CallStateEnded:
+		return "ended"
+	default:
+		return "unknown"
+	}
+}
+
 // PeerConnection 包装 Pion WebRTC PeerConnection
 // PeerConnection wraps Pion WebRTC PeerConnection with extensible management
 type PeerConnection struct {
@@ -42,41 +63,46 @@ type PeerConnection struct {
 	// Remote user email
 	RemoteEmail string
 
-	// 通话状态
-	// Call state
-	State CallState
-
-	// 媒体轨道处理器
-	// Media track handlers
-	Handlers *MediaHandlers
+	// 通话状态，由引擎与信令并发读写，使用 State/SetState 访问
+	// Call state, read and written by the engine and the signaling hub
+	// concurrently; use State and SetState.
+	stateMu sync.RWMutex
+	state   CallState
+
+	// SFU 房间 ID，未加入房间时为空
+	// SFU room the connection joined, empty outside SFU mode
+	Room string
+
+	// 媒体统计：创建时间、RTP 流统计、上一次采样与各接收流的抖动，见 stats.go
+	// Media statistics: creation time, RTP stream stats, the previous sample
+	// and the jitter of each received stream; see stats.go.
+	createdAt   time.Time
+	statsGetter stats.Getter
+	statsMu     sync.Mutex
+	lastStats   *statsCounters
+	jitter      map[webrtc.SSRC]*jitterEstimator
 }
 
-// MediaHandlers 包含所有媒体相关的回调处理
-// MediaHandlers contains all media-related event handlers
-type MediaHandlers struct {
-	// 当音频轨道被添加时调用
-	// Called when audio track is added
-	OnAudioTrack func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
-
-	// 当视频轨道被添加时调用
-	// Called when video track is added
-	OnVideoTrack func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
-
-	// 当 ICE 候选被生成时调用
-	// Called when ICE candidate is generated
-	OnICECandidate func(candidate *webrtc.ICECandidate)
-
-	// 当 ICE 连接状态改变时调用
-	// Called when ICE connection state changes
-	OnICEConnectionStateChange func(connectionState webrtc.ICEConnectionState)
+// id 返回连接在引擎中的键
+// id returns the connection's key in the engine.
+func (p *PeerConnection) id() string {
+	return connectionID(p.CallID, p.LocalEmail, p.RemoteEmail)
+}
 
-	// 当信令状态改变时调用
-	// Called when signaling state changes
-	OnSignalingStateChange func(signalingState webrtc.SignalingState)
+// State 返回通话状态
+// State returns the call state
+func (p *PeerConnection) State() CallState {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	return p.state
+}
 
-	// 当连接状态改变时调用
-	// Called when connection state changes
-	OnConnectionStateChange func(connectionState webrtc.PeerConnectionState)
+// SetState 设置通话状态
+// SetState sets the call state
+func (p *PeerConnection) SetState(state CallState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.state = state
 }
 
 // OfferAnswer 包含 SDP offer 或 answer
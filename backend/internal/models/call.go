@@ -0,0 +1,109 @@
+package models
+
+import "time"
+
+// 通话记录状态
+// Call record statuses.
+const (
+	CallRecordRinging   = "ringing"
+	CallRecordActive    = "active"
+	CallRecordCompleted = "completed"
+	CallRecordMissed    = "missed"
+	CallRecordRejected  = "rejected"
+)
+
+// CallEndTransferred 通话因转接而结束的原因
+// CallEndTransferred is the end reason of a call that was handed over to someone else.
+const CallEndTransferred = "transferred"
+
+// CallRecord 通话记录
+// CallRecord is the persisted history entry of a call.
+type CallRecord struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	CallID      string    `gorm:"size:64;uniqueIndex;not null"`
+	CallerEmail string    `gorm:"size:255;not null;index"`
+	CalleeEmail string    `gorm:"size:255;not null;index"`
+	Status      string    `gorm:"size:20;not null;index"`
+	EndReason   string    `gorm:"size:255"`
+	StartedAt   time.Time `gorm:"not null;index"`
+	AnsweredAt  *time.Time
+	EndedAt     *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+
+	// 转接：本通话转给了谁；或本通话由哪个通话转来、由谁发起
+	// Transfers: who this call was handed to, or which call it continues and who referred it.
+	TransferredTo   string `gorm:"size:255"`
+	TransferredFrom string `gorm:"size:64;index"`
+	ReferredByEmail string `gorm:"size:255;index"`
+
+	// 媒体质量：服务端采样的次数与平均值，仅服务端参与媒体的通话有值
+	// Media quality: how many samples the server took and their averages. Only
+	// calls whose media the server terminated have them.
+	QualitySamples   int `gorm:"not null;default:0"`
+	MOS              *float64
+	AvgRTTMs         *float64
+	AvgJitterMs      *float64
+	AvgPacketLossPct *float64
+}
+
+// HasParticipant 用户是否参与或转接了该通话
+// HasParticipant reports whether email took part in the call or referred it.
+func (r *CallRecord) HasParticipant(email string) bool {
+	return r.CallerEmail == email || r.CalleeEmail == email || r.ReferredByEmail == email
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (CallRecord) TableName() string {
+	return "call_records"
+}
+
+// 通话内消息类型
+// Kinds of in-call messages.
+const (
+	CallMessageChat     = "chat"
+	CallMessageReaction = "reaction"
+)
+
+// CallMessage 通话中发送的文字或表情
+// CallMessage is a chat line or reaction sent during a call.
+type CallMessage struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	CallID      string    `gorm:"size:64;not null;index:idx_call_message_time"`
+	SenderEmail string    `gorm:"size:255;not null"`
+	Kind        string    `gorm:"size:20;not null"`
+	Body        string    `gorm:"type:text;not null"`
+	CreatedAt   time.Time `gorm:"not null;index:idx_call_message_time"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (CallMessage) TableName() string {
+	return "call_messages"
+}
+
+// CallQualitySample 服务端对通话媒体质量的一次采样
+// CallQualitySample is one measurement of a call's media quality, taken by the
+// server on its peer connection with Email.
+type CallQualitySample struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement"`
+	CallID          string    `gorm:"size:64;not null;index:idx_call_quality_time"`
+	Email           string    `gorm:"size:255;not null"`
+	SampledAt       time.Time `gorm:"not null;index:idx_call_quality_time"`
+	RTTMs           float64
+	JitterMs        float64
+	PacketLossPct   float64
+	InboundKbps     float64
+	OutboundKbps    float64
+	MOS             float64
+	LocalCandidate  string `gorm:"size:20"`
+	RemoteCandidate string `gorm:"size:20"`
+	Codecs          string `gorm:"size:255"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (CallQualitySample) TableName() string {
+	return "call_quality_samples"
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// 谁可以呼叫该用户
+// Who may call a user.
+const (
+	CallPolicyEveryone = "everyone"
+	CallPolicyContacts = "contacts"
+	CallPolicyNobody   = "nobody"
+)
+
+// 谁可以看到在线状态或最后在线时间
+// Who may see a user's online status or last seen time.
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
+// PrivacySettings 用户隐私设置；没有记录时使用默认值
+// PrivacySettings holds a user's privacy preferences. Users without a row get
+// the defaults.
+type PrivacySettings struct {
+	UserID     uint64 `gorm:"primaryKey;autoIncrement:false"`
+	CallPolicy string `gorm:"size:20;not null;default:everyone"`
+	// 免打扰时段，本地时间 HH:MM，可跨越午夜
+	// Do-not-disturb window in local HH:MM time; it may wrap past midnight.
+	DNDEnabled  bool   `gorm:"not null;default:false"`
+	DNDStart    string `gorm:"size:5"`
+	DNDEnd      string `gorm:"size:5"`
+	DNDTimezone string `gorm:"size:64"`
+	// 在线状态与最后在线时间的可见范围；为空时使用服务端默认值
+	// Who may see the online status and the last seen time. Empty follows
+	// the server's default visibility.
+	OnlineVisibility   string    `gorm:"size:20;not null;default:''"`
+	LastSeenVisibility string    `gorm:"size:20;not null;default:''"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (PrivacySettings) TableName() string {
+	return "privacy_settings"
+}
+
+// Block 屏蔽关系：OwnerID 屏蔽了 BlockedID
+// Block records that OwnerID blocked BlockedID.
+type Block struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	OwnerID   uint64    `gorm:"not null;index;column:owner_id;uniqueIndex:idx_owner_blocked"`
+	BlockedID uint64    `gorm:"not null;index;column:blocked_id;uniqueIndex:idx_owner_blocked"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (Block) TableName() string {
+	return "blocks"
+}
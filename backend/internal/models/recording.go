@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// CallRecording 服务端通话录制；文件保存在 Dir 下，清单见 manifest.json
+// CallRecording is a server-side recording of a call, or of the SFU room the
+// call joined. Its track files and manifest.json live in Dir.
+type CallRecording struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	RecordingID string    `gorm:"size:64;uniqueIndex;not null"`
+	CallID      string    `gorm:"size:64;not null;index"`
+	Room        string    `gorm:"size:128"`
+	StartedBy   string    `gorm:"size:255;not null"`
+	Dir         string    `gorm:"size:512;not null"`
+	StartedAt   time.Time `gorm:"not null"`
+	EndedAt     *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (CallRecording) TableName() string {
+	return "call_recordings"
+}
+
+// CallRecordingParticipant 被录制的参与者，及其与服务端之间的通话
+// CallRecordingParticipant is someone a recording covers, with the call that
+// connected them to the server. In an SFU room every participant has their own call.
+type CallRecordingParticipant struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	RecordingID string `gorm:"size:64;not null;uniqueIndex:idx_recording_participant"`
+	CallID      string `gorm:"size:64;not null;index"`
+	Email       string `gorm:"size:255;not null;uniqueIndex:idx_recording_participant"`
+}
+
+// TableName 自定义表名
+// TableName specifies the database table name.
+func (CallRecordingParticipant) TableName() string {
+	return "call_recording_participants"
+}
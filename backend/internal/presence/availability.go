@@ -0,0 +1,235 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// inCallTTL 通话标记的兜底过期时间，防止节点崩溃后一直显示通话中
+	// inCallTTL bounds how long a user stays "in a call" if the end of the call is never seen.
+	inCallTTL = 12 * time.Hour
+
+	defaultAwayAfter = 5 * time.Minute
+
+	// maxStatusMessageLength 自定义状态消息的最大字符数
+	// maxStatusMessageLength is the longest custom status message, in characters.
+	maxStatusMessageLength = 140
+)
+
+// 可用状态；前五种可由用户设置，其余由服务端推导
+// Availability values. The first five are set by the user, the rest are derived by the server.
+const (
+	AvailabilityAvailable = "available"
+	AvailabilityAway      = "away"
+	AvailabilityBusy      = "busy"
+	AvailabilityDND       = "dnd"
+	AvailabilityInvisible = "invisible"
+	AvailabilityInCall    = "in_call"
+	AvailabilityOffline   = "offline"
+)
+
+// ErrInvalidStatus 状态设置不合法
+// ErrInvalidStatus wraps validation failures of UpdatePreference.
+var ErrInvalidStatus = errors.New("invalid presence status")
+
+// Preference 用户设置的可用状态与自定义消息
+// Preference is the availability and custom message a user chose.
+type Preference struct {
+	Availability     string     `json:"availability"`
+	Message          string     `json:"message,omitempty"`
+	MessageExpiresAt *time.Time `json:"message_expires_at,omitempty"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// PreferenceInput 部分更新；nil 字段保持不变，空消息清除消息及其过期时间
+// PreferenceInput is a partial update. Nil fields keep their value; an empty
+// message clears the message together with its expiry.
+type PreferenceInput struct {
+	Availability     *string
+	Message          *string
+	MessageExpiresAt *time.Time
+}
+
+// WithAwayAfter 设置无操作多久后自动显示为离开
+// WithAwayAfter sets how long an available user may stay inactive before they
+// show as away.
+func (m *Manager) WithAwayAfter(d time.Duration) {
+	if d > 0 {
+		m.awayAfter = d
+	}
+}
+
+// GetPreference 读取用户设置的状态
+// GetPreference returns the user's chosen status, defaulting to available.
+func (m *Manager) GetPreference(ctx context.Context, email string) (Preference, error) {
+	pref, err := m.store.Preference(ctx, email)
+	if err != nil {
+		return Preference{}, err
+	}
+	return pref.current(time.Now()), nil
+}
+
+// UpdatePreference 更新用户设置的状态并通知监听者
+// UpdatePreference applies a partial update to the user's chosen status and
+// notifies listeners.
+func (m *Manager) UpdatePreference(ctx context.Context, email string, in PreferenceInput) (Preference, error) {
+	now := time.Now()
+	pref, err := m.GetPreference(ctx, email)
+	if err != nil {
+		return Preference{}, err
+	}
+
+	if in.Availability != nil {
+		switch *in.Availability {
+		case AvailabilityAvailable, AvailabilityAway, AvailabilityBusy, AvailabilityDND, AvailabilityInvisible:
+			pref.Availability = *in.Availability
+		default:
+			return Preference{}, fmt.Errorf("%w: unknown availability %q", ErrInvalidStatus, *in.Availability)
+		}
+	}
+	if in.Message != nil {
+		if utf8.RuneCountInString(*in.Message) > maxStatusMessageLength {
+			return Preference{}, fmt.Errorf("%w: message must be at most %d characters", ErrInvalidStatus, maxStatusMessageLength)
+		}
+		pref.Message = *in.Message
+		pref.MessageExpiresAt = nil
+	}
+	if in.MessageExpiresAt != nil {
+		if !in.MessageExpiresAt.After(now) {
+			return Preference{}, fmt.Errorf("%w: message_expires_at must be in the future", ErrInvalidStatus)
+		}
+		expiresAt := in.MessageExpiresAt.UTC()
+		pref.MessageExpiresAt = &expiresAt
+	}
+	if pref.Message == "" {
+		pref.MessageExpiresAt = nil
+	}
+	pref.UpdatedAt = now
+
+	if err := m.store.SavePreference(ctx, email, pref); err != nil {
+		return Preference{}, err
+	}
+	m.notify(Status{Email: email})
+	return pref, nil
+}
+
+// SetInCall 标记或清除用户在某通话中
+// SetInCall marks the user as taking part in callID, or clears the mark. While
+// any mark remains the user shows as in a call.
+func (m *Manager) SetInCall(ctx context.Context, email, callID string, inCall bool) error {
+	if inCall {
+		if err := m.store.AddCall(ctx, email, callID, inCallTTL); err != nil {
+			return err
+		}
+	} else {
+		removed, err := m.store.RemoveCall(ctx, email, callID)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return nil
+		}
+	}
+	m.notify(Status{Email: email})
+	return nil
+}
+
+// parsePreference 解析保存的设置；无法解析时返回零值
+// parsePreference decodes a stored preference, the zero value if it cannot.
+func parsePreference(raw string) Preference {
+	var pref Preference
+	if raw != "" {
+		_ = json.Unmarshal([]byte(raw), &pref)
+	}
+	return pref
+}
+
+// current 补全默认状态并去掉已过期的消息
+// current fills in the default availability and drops an expired message.
+func (p Preference) current(now time.Time) Preference {
+	if p.Availability == "" {
+		p.Availability = AvailabilityAvailable
+	}
+	if p.MessageExpiresAt != nil && !p.MessageExpiresAt.After(now) {
+		p.Message = ""
+		p.MessageExpiresAt = nil
+	}
+	return p
+}
+
+// applyPreference 结合用户设置、通话与活跃时间得出对外的可用状态
+// applyPreference derives the availability others see from the user's choice,
+// their calls and how long they have been inactive.
+func (s *Status) applyPreference(pref Preference, inCall bool, awayAfter time.Duration, now time.Time) {
+	s.Message = pref.Message
+	s.MessageExpiresAt = pref.MessageExpiresAt
+	s.changesAt = time.Time{}
+	if pref.MessageExpiresAt != nil {
+		s.changesAt = *pref.MessageExpiresAt
+	}
+
+	switch {
+	case !s.Online:
+		s.Availability = AvailabilityOffline
+	case pref.Availability == AvailabilityInvisible, pref.Availability == AvailabilityDND:
+		s.Availability = pref.Availability
+	case inCall:
+		s.Availability = AvailabilityInCall
+	case pref.Availability != AvailabilityAvailable:
+		s.Availability = pref.Availability
+	case now.Sub(s.LastSeen) >= awayAfter:
+		s.Availability = AvailabilityAway
+	default:
+		s.Availability = AvailabilityAvailable
+		if awayAt := s.LastSeen.Add(awayAfter); s.changesAt.IsZero() || awayAt.Before(s.changesAt) {
+			s.changesAt = awayAt
+		}
+	}
+	if pref.Availability == AvailabilityInvisible {
+		s.hiddenSince = pref.UpdatedAt
+	}
+}
+
+// Visible 返回其他用户看到的状态：隐身用户显示为离线
+// Visible returns the status as other users see it: an invisible user appears
+// offline, last seen when they went invisible.
+func (s Status) Visible() Status {
+	if s.Availability != AvailabilityInvisible {
+		return s
+	}
+	s.Online = false
+	s.Devices = nil
+	s.Availability = AvailabilityOffline
+	s.Message = ""
+	s.MessageExpiresAt = nil
+	s.changesAt = time.Time{}
+	if !s.hiddenSince.IsZero() && s.hiddenSince.Before(s.LastSeen) {
+		s.LastSeen = s.hiddenSince
+	}
+	return s
+}
+
+// Restrict 按隐私设置隐藏字段：不可见在线状态时只保留邮箱
+// Restrict hides what a viewer may not see under the user's privacy settings.
+// Without online access nothing but the email is left, availability included.
+func (s Status) Restrict(online, lastSeen bool) Status {
+	if !online {
+		s = Status{Email: s.Email, LastSeen: s.LastSeen}
+	}
+	if !lastSeen {
+		s.LastSeen = time.Time{}
+	}
+	return s
+}
+
+// ChangesAt 状态将自行变化的时间（自动离开或消息过期），零值表示不会
+// ChangesAt reports when the status will change without any event, because
+// the user turns away or their message expires. It is zero if it will not.
+func (s Status) ChangesAt() time.Time {
+	return s.changesAt
+}
@@ -0,0 +1,102 @@
+package presence
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 设备类型
+// Device types reported by clients when they connect.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceWeb     = "web"
+	DeviceDesktop = "desktop"
+	DeviceUnknown = "unknown"
+)
+
+// NormalizeDevice 将客户端上报的设备类型归一，未知值记为 unknown
+// NormalizeDevice maps a client-reported device type to a known one.
+func NormalizeDevice(device string) string {
+	switch d := strings.ToLower(strings.TrimSpace(device)); d {
+	case DeviceIOS, DeviceAndroid, DeviceWeb, DeviceDesktop:
+		return d
+	}
+	return DeviceUnknown
+}
+
+// Connection 用户的一条信令连接
+// Connection is one live signaling connection of a user, on any node.
+type Connection struct {
+	Email  string
+	ID     string
+	Device string
+}
+
+// member 有序集合成员："设备/连接ID"
+// member encodes the connection as a sorted set member, "device/id".
+func (c Connection) member() string {
+	return c.Device + "/" + c.ID
+}
+
+// Connect 登记连接；用户的第一条连接使其上线
+// Connect records a live connection for ttl; heartbeats extend it. The user's
+// status is recomputed from every live connection, so only the first one
+// brings them online.
+func (m *Manager) Connect(ctx context.Context, conn Connection, ttl time.Duration) error {
+	if err := m.store.AddConnection(ctx, conn, ttl); err != nil {
+		return err
+	}
+	return m.refresh(ctx, conn.Email)
+}
+
+// Disconnect 移除连接；最后一条连接断开时用户离线
+// Disconnect removes a connection. The user goes offline only when it was
+// their last live connection on any node.
+func (m *Manager) Disconnect(ctx context.Context, conn Connection) error {
+	if err := m.store.RemoveConnection(ctx, conn); err != nil {
+		return err
+	}
+	return m.refresh(ctx, conn.Email)
+}
+
+// Heartbeat 续期一批连接；节点崩溃后其连接在 ttl 后自然过期
+// Heartbeat extends a batch of connections by ttl. Connections of a node that
+// stops heartbeating expire on their own.
+func (m *Manager) Heartbeat(ctx context.Context, conns []Connection, ttl time.Duration) error {
+	return m.store.RefreshConnections(ctx, conns, ttl)
+}
+
+// refresh 按存活连接重新计算在线状态与设备列表
+// refresh recomputes online state and device types from the live connections.
+func (m *Manager) refresh(ctx context.Context, email string) error {
+	devices, err := m.store.LiveDevices(ctx, email)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return m.SetOffline(ctx, email)
+	}
+	return m.setOnline(ctx, email, devices)
+}
+
+func devicesOf(members []string) []string {
+	seen := make(map[string]struct{}, len(members))
+	devices := make([]string, 0, len(members))
+	for _, member := range members {
+		device, _, _ := strings.Cut(member, "/")
+		if _, ok := seen[device]; ok {
+			continue
+		}
+		seen[device] = struct{}{}
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+func expiryScore(ttl time.Duration) float64 {
+	return float64(time.Now().Add(ttl).UnixMilli())
+}
@@ -2,19 +2,14 @@ package presence
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 
 	"github.com/allcallall/backend/internal/user"
 )
 
-const (
-	presenceKeyPrefix = "presence:user:"
-	defaultTTL        = 24 * time.Hour
-)
+const defaultTTL = 24 * time.Hour
 
 // Status 表示用户在线状态
 // Status represents a user's presence information.
@@ -22,37 +17,74 @@ type Status struct {
 	Email    string    `json:"email"`
 	Online   bool      `json:"online"`
 	LastSeen time.Time `json:"last_seen"`
+	// Devices 在线设备类型
+	// Devices lists the device types the user is connected from.
+	Devices []string `json:"devices,omitempty"`
+	// Availability 对外显示的可用状态，见 applyPreference
+	// Availability is the derived availability; see applyPreference.
+	Availability     string     `json:"availability,omitempty"`
+	Message          string     `json:"message,omitempty"`
+	MessageExpiresAt *time.Time `json:"message_expires_at,omitempty"`
+
+	changesAt   time.Time
+	hiddenSince time.Time
 }
 
 // Manager 管理用户在线状态
-// Manager handles presence updates backed by Redis.
+// Manager handles presence updates on top of a Store.
 type Manager struct {
-	redis     *redis.Client
+	store     Store
 	logger    zerolog.Logger
 	userSvc   *user.Service
 	statusTTL time.Duration
+	awayAfter time.Duration
+	listeners []func(Status)
 }
 
 // NewManager 创建 presence 管理器
 // NewManager returns a presence manager.
-func NewManager(rdb *redis.Client, log zerolog.Logger, userSvc *user.Service) *Manager {
+func NewManager(store Store, log zerolog.Logger, userSvc *user.Service) *Manager {
 	return &Manager{
-		redis:     rdb,
+		store:     store,
 		logger:    log.With().Str("component", "presence_manager").Logger(),
 		userSvc:   userSvc,
 		statusTTL: defaultTTL,
+		awayAfter: defaultAwayAfter,
+	}
+}
+
+// OnChange 注册在线状态变化回调；应在启动时调用
+// OnChange registers fn to be called after every presence change made through
+// this manager. Register listeners during startup, before connections arrive.
+func (m *Manager) OnChange(fn func(Status)) {
+	m.listeners = append(m.listeners, fn)
+}
+
+func (m *Manager) notify(status Status) {
+	for _, fn := range m.listeners {
+		fn(status)
 	}
 }
 
 // SetOnline 标记用户在线
-// SetOnline updates Redis presence entry to online.
+// SetOnline saves the user's status as online. Readers only report the
+// user online while they also have a live connection; see Connect.
 func (m *Manager) SetOnline(ctx context.Context, email string) error {
+	return m.setOnline(ctx, email, nil)
+}
+
+func (m *Manager) setOnline(ctx context.Context, email string, devices []string) error {
 	status := Status{
 		Email:    email,
 		Online:   true,
 		LastSeen: time.Now(),
+		Devices:  devices,
 	}
-	return m.saveStatus(ctx, status)
+	if err := m.saveStatus(ctx, status); err != nil {
+		return err
+	}
+	m.notify(status)
+	return nil
 }
 
 // SetOffline 标记用户离线，并同步 last_seen
@@ -68,6 +100,7 @@ func (m *Manager) SetOffline(ctx context.Context, email string) error {
 	if err := m.saveStatus(ctx, status); err != nil {
 		return err
 	}
+	m.notify(status)
 
 	userModel, err := m.userSvc.GetByEmail(ctx, email)
 	if err != nil {
@@ -81,91 +114,77 @@ func (m *Manager) SetOffline(ctx context.Context, email string) error {
 	return nil
 }
 
-// UpdateLastSeen 仅更新 last_seen，不改变在线状态
-// UpdateLastSeen refreshes the timestamp while keeping status.
+// UpdateLastSeen 仅更新 last_seen，不改变在线状态；自动离开的用户恢复可用
+// UpdateLastSeen refreshes the timestamp while keeping status. It counts as
+// activity, so a user shown as away after inactivity becomes available again.
 func (m *Manager) UpdateLastSeen(ctx context.Context, email string) error {
-	status, err := m.GetStatus(ctx, email)
+	status, err := m.storedStatus(ctx, email)
 	if err != nil {
 		return err
 	}
 	if status.Email == "" {
 		status.Email = email
 	}
-	status.LastSeen = time.Now()
-	return m.saveStatus(ctx, status)
+	now := time.Now()
+	wasIdle := status.Online && now.Sub(status.LastSeen) >= m.awayAfter
+	status.LastSeen = now
+	if err := m.saveStatus(ctx, status); err != nil {
+		return err
+	}
+	if wasIdle {
+		m.notify(status)
+	}
+	return nil
 }
 
 // GetStatus 获取单个用户状态
 // GetStatus fetches presence for a single email.
 func (m *Manager) GetStatus(ctx context.Context, email string) (Status, error) {
-	val, err := m.redis.Get(ctx, m.key(email)).Result()
+	statuses, err := m.GetStatuses(ctx, []string{email})
 	if err != nil {
-		if err == redis.Nil {
-			return Status{
-				Email:    email,
-				Online:   false,
-				LastSeen: time.Time{},
-			}, nil
-		}
-		return Status{}, err
-	}
-	var status Status
-	if err := json.Unmarshal([]byte(val), &status); err != nil {
 		return Status{}, err
 	}
-	return status, nil
+	return statuses[email], nil
 }
 
-// GetStatuses 批量获取用户状态
-// GetStatuses fetches presence for multiple emails.
+// GetStatuses 批量获取用户状态；仅有存活连接的用户视为在线
+// GetStatuses fetches presence for multiple emails. A user counts as online
+// only while at least one of their connections is live, so users of a node
+// that died without cleaning up go offline once its heartbeats lapse.
+// Statuses are returned as the users themselves see them; use Status.Visible
+// before showing one to anybody else.
 func (m *Manager) GetStatuses(ctx context.Context, emails []string) (map[string]Status, error) {
 	result := make(map[string]Status, len(emails))
 	if len(emails) == 0 {
 		return result, nil
 	}
 
-	keys := make([]string, 0, len(emails))
-	for _, email := range emails {
-		keys = append(keys, m.key(email))
-	}
-
-	values, err := m.redis.MGet(ctx, keys...).Result()
-	if err != nil && err != redis.Nil {
+	snapshots, err := m.store.Snapshots(ctx, emails)
+	if err != nil {
 		return nil, err
 	}
 
-	for i, raw := range values {
-		email := emails[i]
-		if raw == nil {
-			result[email] = Status{
-				Email:    email,
-				Online:   false,
-				LastSeen: time.Time{},
-			}
-			continue
-		}
-		var status Status
-		if err := json.Unmarshal([]byte(raw.(string)), &status); err != nil {
-			result[email] = Status{
-				Email:    email,
-				Online:   false,
-				LastSeen: time.Time{},
-			}
-			continue
+	now := time.Now()
+	for i, email := range emails {
+		snap := snapshots[i]
+		status := snap.Status
+		status.Devices = snap.Devices
+		status.Online = status.Online && len(status.Devices) > 0
+		if !status.Online {
+			status.Devices = nil
 		}
+		status.applyPreference(snap.Preference.current(now), snap.InCall, m.awayAfter, now)
 		result[email] = status
 	}
 	return result, nil
 }
 
-func (m *Manager) saveStatus(ctx context.Context, status Status) error {
-	data, err := json.Marshal(status)
-	if err != nil {
-		return err
-	}
-	return m.redis.Set(ctx, m.key(status.Email), data, m.statusTTL).Err()
+// storedStatus 读取保存的状态，不考虑存活连接
+// storedStatus reads the saved status without consulting live connections.
+func (m *Manager) storedStatus(ctx context.Context, email string) (Status, error) {
+	return m.store.Status(ctx, email)
 }
 
-func (m *Manager) key(email string) string {
-	return presenceKeyPrefix + email
+func (m *Manager) saveStatus(ctx context.Context, status Status) error {
+	return m.store.SaveStatus(ctx, status, m.statusTTL)
 }
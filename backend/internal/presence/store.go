@@ -0,0 +1,422 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	presenceKeyPrefix    = "presence:user:"
+	connectionsKeyPrefix = "presence:conns:"
+	preferenceKeyPrefix  = "presence:pref:"
+	inCallKeyPrefix      = "presence:calls:"
+)
+
+// Snapshot 一个用户在线状态的全部存储数据
+// Snapshot is everything stored about one user's presence, read at once.
+type Snapshot struct {
+	// Status 保存的状态；没有时只有邮箱
+	// Status is the saved status, or just the email when none is saved.
+	Status Status
+	// Devices 存活连接的设备类型（去重、排序）
+	// Devices are the sorted, distinct device types of the live connections.
+	Devices []string
+	// Preference 用户保存的设置，未做默认值与过期处理
+	// Preference is the stored preference, before defaults and expiry apply.
+	Preference Preference
+	InCall     bool
+}
+
+// Store 在线状态存储
+// Store keeps presence state: saved statuses, live connections, chosen
+// preferences and call marks. Connections expire unless refreshed, so those
+// of a crashed node drop out on their own.
+type Store interface {
+	// Status 读取保存的状态，不考虑存活连接
+	// Status reads the saved status without consulting live connections.
+	Status(ctx context.Context, email string) (Status, error)
+
+	// SaveStatus 保存状态 ttl 时长
+	// SaveStatus saves status for ttl.
+	SaveStatus(ctx context.Context, status Status, ttl time.Duration) error
+
+	// Snapshots 批量读取用户的在线状态数据，顺序与 emails 一致
+	// Snapshots reads the presence of every email, in the order given.
+	Snapshots(ctx context.Context, emails []string) ([]Snapshot, error)
+
+	// AddConnection 登记存活 ttl 时长的连接
+	// AddConnection records a connection live for ttl.
+	AddConnection(ctx context.Context, conn Connection, ttl time.Duration) error
+
+	// RemoveConnection 移除连接
+	// RemoveConnection removes a connection.
+	RemoveConnection(ctx context.Context, conn Connection) error
+
+	// RefreshConnections 将一批连接续期 ttl
+	// RefreshConnections extends a batch of connections by ttl.
+	RefreshConnections(ctx context.Context, conns []Connection, ttl time.Duration) error
+
+	// LiveDevices 清理过期连接并返回存活连接的设备类型（去重、排序）
+	// LiveDevices prunes expired connections and returns the sorted, distinct
+	// device types of the live ones.
+	LiveDevices(ctx context.Context, email string) ([]string, error)
+
+	// Preference 读取保存的设置；没有时返回零值
+	// Preference reads the stored preference, the zero value if there is none.
+	Preference(ctx context.Context, email string) (Preference, error)
+
+	// SavePreference 保存设置
+	// SavePreference stores the user's preference.
+	SavePreference(ctx context.Context, email string, pref Preference) error
+
+	// AddCall 标记用户在 callID 中，ttl 后自动清除
+	// AddCall marks the user as taking part in callID for at most ttl.
+	AddCall(ctx context.Context, email, callID string, ttl time.Duration) error
+
+	// RemoveCall 清除通话标记，返回标记是否存在
+	// RemoveCall clears a call mark and reports whether it was set.
+	RemoveCall(ctx context.Context, email, callID string) (bool, error)
+}
+
+// RedisStore 基于 Redis 的在线状态存储，所有节点共享
+// RedisStore keeps presence in Redis, shared by every node. Connections live
+// in one sorted set per user, scored by expiry time.
+type RedisStore struct {
+	redis *redis.Client
+}
+
+// NewRedisStore 创建 Redis 在线状态存储
+// NewRedisStore returns a Redis backed presence store.
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{redis: rdb}
+}
+
+// Status 实现 Store
+// Status implements Store.
+func (s *RedisStore) Status(ctx context.Context, email string) (Status, error) {
+	val, err := s.redis.Get(ctx, presenceKeyPrefix+email).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return Status{Email: email}, nil
+		}
+		return Status{}, err
+	}
+	var status Status
+	if err := json.Unmarshal([]byte(val), &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// SaveStatus 实现 Store
+// SaveStatus implements Store.
+func (s *RedisStore) SaveStatus(ctx context.Context, status Status, ttl time.Duration) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, presenceKeyPrefix+status.Email, data, ttl).Err()
+}
+
+// Snapshots 实现 Store；一次往返读取全部用户
+// Snapshots implements Store in a single round trip.
+func (s *RedisStore) Snapshots(ctx context.Context, emails []string) ([]Snapshot, error) {
+	nowScore := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := s.redis.Pipeline()
+	stored := make([]*redis.StringCmd, len(emails))
+	live := make([]*redis.StringSliceCmd, len(emails))
+	prefs := make([]*redis.StringCmd, len(emails))
+	calls := make([]*redis.IntCmd, len(emails))
+	for i, email := range emails {
+		stored[i] = pipe.Get(ctx, presenceKeyPrefix+email)
+		live[i] = pipe.ZRangeByScore(ctx, connectionsKeyPrefix+email, &redis.ZRangeBy{Min: nowScore, Max: "+inf"})
+		prefs[i] = pipe.Get(ctx, preferenceKeyPrefix+email)
+		calls[i] = pipe.SCard(ctx, inCallKeyPrefix+email)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, len(emails))
+	for i, email := range emails {
+		status := Status{Email: email}
+		if raw, err := stored[i].Result(); err == nil {
+			if err := json.Unmarshal([]byte(raw), &status); err != nil {
+				status = Status{Email: email}
+			}
+		}
+		snapshots[i] = Snapshot{
+			Status:     status,
+			Devices:    devicesOf(live[i].Val()),
+			Preference: parsePreference(prefs[i].Val()),
+			InCall:     calls[i].Val() > 0,
+		}
+	}
+	return snapshots, nil
+}
+
+// AddConnection 实现 Store
+// AddConnection implements Store.
+func (s *RedisStore) AddConnection(ctx context.Context, conn Connection, ttl time.Duration) error {
+	key := connectionsKeyPrefix + conn.Email
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: expiryScore(ttl), Member: conn.member()})
+	pipe.PExpire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RemoveConnection 实现 Store
+// RemoveConnection implements Store.
+func (s *RedisStore) RemoveConnection(ctx context.Context, conn Connection) error {
+	return s.redis.ZRem(ctx, connectionsKeyPrefix+conn.Email, conn.member()).Err()
+}
+
+// RefreshConnections 实现 Store
+// RefreshConnections implements Store.
+func (s *RedisStore) RefreshConnections(ctx context.Context, conns []Connection, ttl time.Duration) error {
+	if len(conns) == 0 {
+		return nil
+	}
+	score := expiryScore(ttl)
+	pipe := s.redis.Pipeline()
+	for _, conn := range conns {
+		key := connectionsKeyPrefix + conn.Email
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: conn.member()})
+		pipe.PExpire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// LiveDevices 实现 Store
+// LiveDevices implements Store.
+func (s *RedisStore) LiveDevices(ctx context.Context, email string) ([]string, error) {
+	key := connectionsKeyPrefix + email
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := s.redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+now)
+	members := pipe.ZRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return devicesOf(members.Val()), nil
+}
+
+// Preference 实现 Store
+// Preference implements Store.
+func (s *RedisStore) Preference(ctx context.Context, email string) (Preference, error) {
+	raw, err := s.redis.Get(ctx, preferenceKeyPrefix+email).Result()
+	if err != nil && err != redis.Nil {
+		return Preference{}, err
+	}
+	return parsePreference(raw), nil
+}
+
+// SavePreference 实现 Store
+// SavePreference implements Store.
+func (s *RedisStore) SavePreference(ctx context.Context, email string, pref Preference) error {
+	data, err := json.Marshal(pref)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, preferenceKeyPrefix+email, data, 0).Err()
+}
+
+// AddCall 实现 Store
+// AddCall implements Store.
+func (s *RedisStore) AddCall(ctx context.Context, email, callID string, ttl time.Duration) error {
+	key := inCallKeyPrefix + email
+	pipe := s.redis.TxPipeline()
+	pipe.SAdd(ctx, key, callID)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RemoveCall 实现 Store
+// RemoveCall implements Store.
+func (s *RedisStore) RemoveCall(ctx context.Context, email, callID string) (bool, error) {
+	removed, err := s.redis.SRem(ctx, inCallKeyPrefix+email, callID).Result()
+	return removed > 0, err
+}
+
+// MemoryStore 进程内在线状态存储，用于单节点部署和测试
+// MemoryStore is an in-process presence store for single-node deployments and tests.
+type MemoryStore struct {
+	mu          sync.Mutex
+	statuses    map[string]expiringStatus
+	connections map[string]map[string]time.Time
+	preferences map[string]Preference
+	calls       map[string]map[string]time.Time
+}
+
+type expiringStatus struct {
+	status  Status
+	expires time.Time
+}
+
+// NewMemoryStore 创建进程内在线状态存储
+// NewMemoryStore returns an in-process presence store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		statuses:    make(map[string]expiringStatus),
+		connections: make(map[string]map[string]time.Time),
+		preferences: make(map[string]Preference),
+		calls:       make(map[string]map[string]time.Time),
+	}
+}
+
+// Status 实现 Store
+// Status implements Store.
+func (s *MemoryStore) Status(ctx context.Context, email string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusLocked(email, time.Now()), nil
+}
+
+// SaveStatus 实现 Store
+// SaveStatus implements Store.
+func (s *MemoryStore) SaveStatus(ctx context.Context, status Status, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status.Email] = expiringStatus{status: status, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Snapshots 实现 Store
+// Snapshots implements Store.
+func (s *MemoryStore) Snapshots(ctx context.Context, emails []string) ([]Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	snapshots := make([]Snapshot, len(emails))
+	for i, email := range emails {
+		snapshots[i] = Snapshot{
+			Status:     s.statusLocked(email, now),
+			Devices:    devicesOf(live(s.connections[email], now)),
+			Preference: s.preferences[email],
+			InCall:     len(live(s.calls[email], now)) > 0,
+		}
+	}
+	return snapshots, nil
+}
+
+// AddConnection 实现 Store
+// AddConnection implements Store.
+func (s *MemoryStore) AddConnection(ctx context.Context, conn Connection, ttl time.Duration) error {
+	return s.RefreshConnections(ctx, []Connection{conn}, ttl)
+}
+
+// RemoveConnection 实现 Store
+// RemoveConnection implements Store.
+func (s *MemoryStore) RemoveConnection(ctx context.Context, conn Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removeMember(s.connections, conn.Email, conn.member())
+	return nil
+}
+
+// RefreshConnections 实现 Store
+// RefreshConnections implements Store.
+func (s *MemoryStore) RefreshConnections(ctx context.Context, conns []Connection, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	for _, conn := range conns {
+		addMember(s.connections, conn.Email, conn.member(), expires)
+	}
+	return nil
+}
+
+// LiveDevices 实现 Store
+// LiveDevices implements Store.
+func (s *MemoryStore) LiveDevices(ctx context.Context, email string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for member, expires := range s.connections[email] {
+		if expires.Before(now) {
+			removeMember(s.connections, email, member)
+		}
+	}
+	return devicesOf(live(s.connections[email], now)), nil
+}
+
+// Preference 实现 Store
+// Preference implements Store.
+func (s *MemoryStore) Preference(ctx context.Context, email string) (Preference, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.preferences[email], nil
+}
+
+// SavePreference 实现 Store
+// SavePreference implements Store.
+func (s *MemoryStore) SavePreference(ctx context.Context, email string, pref Preference) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preferences[email] = pref
+	return nil
+}
+
+// AddCall 实现 Store
+// AddCall implements Store.
+func (s *MemoryStore) AddCall(ctx context.Context, email, callID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addMember(s.calls, email, callID, time.Now().Add(ttl))
+	return nil
+}
+
+// RemoveCall 实现 Store
+// RemoveCall implements Store.
+func (s *MemoryStore) RemoveCall(ctx context.Context, email, callID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.calls[email][callID]
+	removeMember(s.calls, email, callID)
+	return ok && !expires.Before(time.Now()), nil
+}
+
+func (s *MemoryStore) statusLocked(email string, now time.Time) Status {
+	entry, ok := s.statuses[email]
+	if !ok || entry.expires.Before(now) {
+		delete(s.statuses, email)
+		return Status{Email: email}
+	}
+	return entry.status
+}
+
+// live 返回未过期的成员
+// live returns the members that have not expired by now.
+func live(members map[string]time.Time, now time.Time) []string {
+	result := make([]string, 0, len(members))
+	for member, expires := range members {
+		if !expires.Before(now) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func addMember(sets map[string]map[string]time.Time, key, member string, expires time.Time) {
+	if _, ok := sets[key]; !ok {
+		sets[key] = make(map[string]time.Time)
+	}
+	sets[key][member] = expires
+}
+
+func removeMember(sets map[string]map[string]time.Time, key, member string) {
+	if members, ok := sets[key]; ok {
+		delete(members, member)
+		if len(members) == 0 {
+			delete(sets, key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/allcallall/backend/internal/models"
+)
+
+// Repository 隐私设置与屏蔽列表数据访问
+// Repository handles database operations for privacy settings and block lists.
+type Repository struct {
+	db *gorm.DB
+}
+
+// NewRepository 构造函数
+// NewRepository creates a privacy repository.
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// GetSettings 读取设置，没有记录时返回默认值
+// GetSettings loads a user's settings, falling back to the defaults.
+func (r *Repository) GetSettings(ctx context.Context, userID uint64) (*models.PrivacySettings, error) {
+	var settings models.PrivacySettings
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Take(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultSettings(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveSettings 创建或更新设置
+// SaveSettings upserts a user's settings.
+func (r *Repository) SaveSettings(ctx context.Context, settings *models.PrivacySettings) error {
+	return r.db.WithContext(ctx).Save(settings).Error
+}
+
+// AddBlock 创建屏蔽关系，已存在时忽略
+// AddBlock blocks a user; blocking twice is a no-op.
+func (r *Repository) AddBlock(ctx context.Context, ownerID, blockedID uint64) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Block{OwnerID: ownerID, BlockedID: blockedID}).Error
+}
+
+// RemoveBlock 删除屏蔽关系
+// RemoveBlock unblocks a user.
+func (r *Repository) RemoveBlock(ctx context.Context, ownerID, blockedID uint64) error {
+	return r.db.WithContext(ctx).
+		Where("owner_id = ? AND blocked_id = ?", ownerID, blockedID).
+		Delete(&models.Block{}).Error
+}
+
+// IsBlocked ownerID 是否屏蔽了 blockedID
+// IsBlocked reports whether ownerID blocked blockedID.
+func (r *Repository) IsBlocked(ctx context.Context, ownerID, blockedID uint64) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Block{}).
+		Where("owner_id = ? AND blocked_id = ?", ownerID, blockedID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// BlockedIDs 返回 ownerID 屏蔽的用户 ID 集合
+// BlockedIDs returns the set of user IDs ownerID blocked.
+func (r *Repository) BlockedIDs(ctx context.Context, ownerID uint64) (map[uint64]struct{}, error) {
+	var ids []uint64
+	err := r.db.WithContext(ctx).
+		Model(&models.Block{}).
+		Where("owner_id = ?", ownerID).
+		Pluck("blocked_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set, nil
+}
+
+// ListBlocked 列出被屏蔽的用户
+// ListBlocked returns the users ownerID blocked.
+func (r *Repository) ListBlocked(ctx context.Context, ownerID uint64) ([]models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).
+		Table("blocks").
+		Select("users.*").
+		Joins("JOIN users ON blocks.blocked_id = users.id").
+		Where("blocks.owner_id = ?", ownerID).
+		Order("users.email ASC").
+		Find(&users).Error
+	return users, err
+}
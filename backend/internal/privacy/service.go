@@ -0,0 +1,406 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allcallall/backend/internal/contact"
+	"github.com/allcallall/backend/internal/models"
+	"github.com/allcallall/backend/internal/user"
+)
+
+// dndTimeLayout 免打扰时段的时间格式
+// dndTimeLayout is the HH:MM layout of do-not-disturb bounds.
+const dndTimeLayout = "15:04"
+
+// ErrInvalidSettings 设置值不合法
+// ErrInvalidSettings wraps validation failures of UpdateSettings.
+var ErrInvalidSettings = errors.New("invalid privacy settings")
+
+// ErrSelfBlock 不能屏蔽自己
+// ErrSelfBlock indicates a user tried to block themselves.
+var ErrSelfBlock = errors.New("cannot block yourself")
+
+// DefaultSettings 新用户的默认设置：任何人可呼叫，无免打扰，在线状态可见范围使用服务端默认值
+// DefaultSettings returns the settings of a user who never changed them:
+// everyone may call, no do-not-disturb, and their presence follows the
+// server's default visibility.
+func DefaultSettings(userID uint64) *models.PrivacySettings {
+	return &models.PrivacySettings{
+		UserID:     userID,
+		CallPolicy: models.CallPolicyEveryone,
+	}
+}
+
+// PresenceAccess 查看者能看到的在线信息
+// PresenceAccess tells which parts of a user's presence a viewer may see.
+type PresenceAccess struct {
+	Online   bool
+	LastSeen bool
+}
+
+// PresenceWatcher 在线状态推送的接收者
+// PresenceWatcher is a recipient of a user's presence pushes.
+type PresenceWatcher struct {
+	Email string
+	// LastSeen 是否可以看到最后在线时间
+	// LastSeen reports whether the watcher may see the last seen time.
+	LastSeen bool
+}
+
+// Service 隐私设置业务逻辑
+// Service manages privacy settings and block lists and decides who may reach whom.
+type Service struct {
+	repo     *Repository
+	users    *user.Service
+	contacts *contact.Service
+	// 未设置可见范围的用户使用的默认值
+	// Visibility of users who never chose one.
+	defaultVisibility string
+}
+
+// NewService 构造函数
+// NewService creates a privacy service.
+func NewService(repo *Repository, users *user.Service, contacts *contact.Service) *Service {
+	return &Service{
+		repo:              repo,
+		users:             users,
+		contacts:          contacts,
+		defaultVisibility: models.VisibilityContacts,
+	}
+}
+
+// WithDefaultVisibility 设置未选择可见范围的用户的在线状态与最后在线时间可见范围（默认仅联系人）
+// WithDefaultVisibility sets who sees the online status and last seen time of
+// users who never chose a visibility. It defaults to contacts.
+func (s *Service) WithDefaultVisibility(visibility string) {
+	s.defaultVisibility = visibility
+}
+
+// UpdateSettingsInput 部分更新，nil 字段保持不变
+// UpdateSettingsInput is a partial update; nil fields are left unchanged.
+type UpdateSettingsInput struct {
+	CallPolicy         *string
+	DNDEnabled         *bool
+	DNDStart           *string
+	DNDEnd             *string
+	DNDTimezone        *string
+	OnlineVisibility   *string
+	LastSeenVisibility *string
+}
+
+// GetSettings 读取用户设置
+// GetSettings returns a user's settings.
+func (s *Service) GetSettings(ctx context.Context, userID uint64) (*models.PrivacySettings, error) {
+	return s.settings(ctx, userID)
+}
+
+// settings 读取设置，并将未设置的可见范围替换为默认值
+// settings loads a user's settings with unset visibilities replaced by the
+// server's default.
+func (s *Service) settings(ctx context.Context, userID uint64) (*models.PrivacySettings, error) {
+	settings, err := s.repo.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.applyDefaults(settings)
+	return settings, nil
+}
+
+func (s *Service) applyDefaults(settings *models.PrivacySettings) {
+	if settings.OnlineVisibility == "" {
+		settings.OnlineVisibility = s.defaultVisibility
+	}
+	if settings.LastSeenVisibility == "" {
+		settings.LastSeenVisibility = s.defaultVisibility
+	}
+}
+
+// UpdateSettings 校验并保存设置
+// UpdateSettings validates and stores a partial settings update. A visibility
+// never set, or set to "", follows the server's default.
+func (s *Service) UpdateSettings(ctx context.Context, userID uint64, in UpdateSettingsInput) (*models.PrivacySettings, error) {
+	settings, err := s.repo.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.CallPolicy != nil {
+		settings.CallPolicy = *in.CallPolicy
+	}
+	if in.DNDEnabled != nil {
+		settings.DNDEnabled = *in.DNDEnabled
+	}
+	if in.DNDStart != nil {
+		settings.DNDStart = strings.TrimSpace(*in.DNDStart)
+	}
+	if in.DNDEnd != nil {
+		settings.DNDEnd = strings.TrimSpace(*in.DNDEnd)
+	}
+	if in.DNDTimezone != nil {
+		settings.DNDTimezone = strings.TrimSpace(*in.DNDTimezone)
+	}
+	if in.OnlineVisibility != nil {
+		settings.OnlineVisibility = *in.OnlineVisibility
+	}
+	if in.LastSeenVisibility != nil {
+		settings.LastSeenVisibility = *in.LastSeenVisibility
+	}
+	if err := validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SaveSettings(ctx, settings); err != nil {
+		return nil, err
+	}
+	s.applyDefaults(settings)
+	return settings, nil
+}
+
+func validateSettings(settings *models.PrivacySettings) error {
+	switch settings.CallPolicy {
+	case models.CallPolicyEveryone, models.CallPolicyContacts, models.CallPolicyNobody:
+	default:
+		return fmt.Errorf("%w: call_policy must be everyone, contacts or nobody", ErrInvalidSettings)
+	}
+	if settings.OnlineVisibility != "" && !validVisibility(settings.OnlineVisibility) {
+		return fmt.Errorf("%w: online_visibility must be everyone, contacts or nobody", ErrInvalidSettings)
+	}
+	if settings.LastSeenVisibility != "" && !validVisibility(settings.LastSeenVisibility) {
+		return fmt.Errorf("%w: last_seen_visibility must be everyone, contacts or nobody", ErrInvalidSettings)
+	}
+	if !settings.DNDEnabled {
+		return nil
+	}
+	if _, err := time.Parse(dndTimeLayout, settings.DNDStart); err != nil {
+		return fmt.Errorf("%w: dnd_start must be HH:MM", ErrInvalidSettings)
+	}
+	if _, err := time.Parse(dndTimeLayout, settings.DNDEnd); err != nil {
+		return fmt.Errorf("%w: dnd_end must be HH:MM", ErrInvalidSettings)
+	}
+	if _, err := time.LoadLocation(settings.DNDTimezone); err != nil {
+		return fmt.Errorf("%w: unknown dnd_timezone %q", ErrInvalidSettings, settings.DNDTimezone)
+	}
+	return nil
+}
+
+func validVisibility(v string) bool {
+	switch v {
+	case models.VisibilityEveryone, models.VisibilityContacts, models.VisibilityNobody:
+		return true
+	}
+	return false
+}
+
+// visibleTo 按可见范围判断；isContact 表示查看者在对方联系人中
+// visibleTo applies a visibility setting to a viewer; isContact reports
+// whether the viewer is among the subject's contacts.
+func visibleTo(visibility string, isContact bool) bool {
+	switch visibility {
+	case models.VisibilityEveryone:
+		return true
+	case models.VisibilityContacts:
+		return isContact
+	}
+	return false
+}
+
+// InDND 判断 at 时刻是否处于免打扰时段；起止相同表示全天
+// InDND reports whether at falls inside the user's do-not-disturb window.
+// Equal start and end mean all day.
+func InDND(settings *models.PrivacySettings, at time.Time) bool {
+	if !settings.DNDEnabled {
+		return false
+	}
+	start, err := time.Parse(dndTimeLayout, settings.DNDStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(dndTimeLayout, settings.DNDEnd)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(settings.DNDTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := at.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	switch {
+	case from == to:
+		return true
+	case from < to:
+		return now >= from && now < to
+	default:
+		// 跨越午夜，例如 22:00-07:00
+		// Wraps past midnight, e.g. 22:00-07:00.
+		return now >= from || now < to
+	}
+}
+
+// Block 通过邮箱屏蔽用户
+// Block adds the user with targetEmail to ownerID's block list.
+func (s *Service) Block(ctx context.Context, ownerID uint64, targetEmail string) error {
+	target, err := s.users.GetByEmail(ctx, targetEmail)
+	if err != nil {
+		return err
+	}
+	if target.ID == ownerID {
+		return ErrSelfBlock
+	}
+	return s.repo.AddBlock(ctx, ownerID, target.ID)
+}
+
+// Unblock 解除屏蔽
+// Unblock removes blockedID from ownerID's block list.
+func (s *Service) Unblock(ctx context.Context, ownerID, blockedID uint64) error {
+	return s.repo.RemoveBlock(ctx, ownerID, blockedID)
+}
+
+// ListBlocked 列出被屏蔽的用户
+// ListBlocked returns the users on ownerID's block list.
+func (s *Service) ListBlocked(ctx context.Context, ownerID uint64) ([]models.User, error) {
+	return s.repo.ListBlocked(ctx, ownerID)
+}
+
+// PresenceAudience 返回应收到该用户在线状态推送的人：把他加为联系人、未被他屏蔽且按其设置可见的人
+// PresenceAudience returns who should be told about email's presence changes:
+// users who have email as a contact, whom email has not blocked and who may
+// see email's online status under email's privacy settings.
+func (s *Service) PresenceAudience(ctx context.Context, email string) ([]PresenceWatcher, error) {
+	subject, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := s.settings(ctx, subject.ID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.OnlineVisibility == models.VisibilityNobody {
+		return nil, nil
+	}
+	watchers, err := s.contacts.ListWatchers(ctx, subject.ID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.repo.BlockedIDs(ctx, subject.ID)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make(map[uint64]struct{})
+	if settings.OnlineVisibility == models.VisibilityContacts || settings.LastSeenVisibility == models.VisibilityContacts {
+		list, err := s.contacts.List(ctx, subject.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range list {
+			contacts[c.ID] = struct{}{}
+		}
+	}
+
+	audience := make([]PresenceWatcher, 0, len(watchers))
+	for _, w := range watchers {
+		if _, ok := blocked[w.ID]; ok {
+			continue
+		}
+		_, isContact := contacts[w.ID]
+		if !visibleTo(settings.OnlineVisibility, isContact) {
+			continue
+		}
+		audience = append(audience, PresenceWatcher{
+			Email:    w.Email,
+			LastSeen: visibleTo(settings.LastSeenVisibility, isContact),
+		})
+	}
+	return audience, nil
+}
+
+// PresenceAccess 计算查看者对每个邮箱的在线信息可见范围；未知用户不可见
+// PresenceAccess decides, for each of emails, what viewerID may see of that
+// user's presence. Unknown users and users who blocked the viewer reveal nothing.
+func (s *Service) PresenceAccess(ctx context.Context, viewerID uint64, emails []string) (map[string]PresenceAccess, error) {
+	result := make(map[string]PresenceAccess, len(emails))
+	for _, email := range emails {
+		subject, err := s.users.GetByEmail(ctx, email)
+		if errors.Is(err, user.ErrNotFound) {
+			result[email] = PresenceAccess{}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subject.ID == viewerID {
+			result[email] = PresenceAccess{Online: true, LastSeen: true}
+			continue
+		}
+
+		blocked, err := s.repo.IsBlocked(ctx, subject.ID, viewerID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			result[email] = PresenceAccess{}
+			continue
+		}
+		settings, err := s.settings(ctx, subject.ID)
+		if err != nil {
+			return nil, err
+		}
+		isContact := false
+		if settings.OnlineVisibility == models.VisibilityContacts || settings.LastSeenVisibility == models.VisibilityContacts {
+			if isContact, err = s.contacts.IsContact(ctx, subject.ID, viewerID); err != nil {
+				return nil, err
+			}
+		}
+		result[email] = PresenceAccess{
+			Online:   visibleTo(settings.OnlineVisibility, isContact),
+			LastSeen: visibleTo(settings.LastSeenVisibility, isContact),
+		}
+	}
+	return result, nil
+}
+
+// CanCall 判断主叫能否呼叫被叫：屏蔽、呼叫策略与免打扰依次检查
+// CanCall decides whether callerEmail may ring calleeEmail at the given time,
+// checking the callee's block list, calling policy and do-not-disturb window.
+// Unknown callees are reported as unreachable rather than as an error.
+func (s *Service) CanCall(ctx context.Context, callerEmail, calleeEmail string, at time.Time) (bool, error) {
+	callee, err := s.users.GetByEmail(ctx, calleeEmail)
+	if errors.Is(err, user.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	caller, err := s.users.GetByEmail(ctx, callerEmail)
+	if err != nil {
+		return false, err
+	}
+
+	blocked, err := s.repo.IsBlocked(ctx, callee.ID, caller.ID)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	settings, err := s.settings(ctx, callee.ID)
+	if err != nil {
+		return false, err
+	}
+	switch settings.CallPolicy {
+	case models.CallPolicyNobody:
+		return false, nil
+	case models.CallPolicyContacts:
+		isContact, err := s.contacts.IsContact(ctx, callee.ID, caller.ID)
+		if err != nil || !isContact {
+			return false, err
+		}
+	}
+
+	return !InDND(settings, at), nil
+}
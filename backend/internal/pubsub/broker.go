@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	appcfg "github.com/allcallall/backend/internal/config"
+)
+
+// 可选的跨节点投递后端
+// Supported cross-node delivery backends.
+const (
+	BackendMemory       = "memory"
+	BackendRedis        = "redis"
+	BackendRedisStreams = "redis_streams"
+)
+
+// Broker 跨节点消息投递接口
+// Broker delivers opaque messages between signaling nodes.
+type Broker interface {
+	// Publish 向主题发布消息
+	// Publish sends data to every subscriber of topic.
+	Publish(ctx context.Context, topic string, data []byte) error
+
+	// Subscribe 订阅主题，返回的订阅在 ctx 结束或 Close 后停止
+	// Subscribe starts receiving topic; the subscription stops on Close or when ctx ends.
+	Subscribe(ctx context.Context, topic string) (Subscription, error)
+}
+
+// Subscription 单个主题订阅
+// Subscription is a live subscription to a single topic.
+type Subscription interface {
+	// Messages 返回消息通道，订阅关闭后通道关闭
+	// Messages returns the delivery channel, closed once the subscription ends.
+	Messages() <-chan *Message
+
+	// Close 取消订阅
+	// Close cancels the subscription.
+	Close() error
+}
+
+// Message 投递的消息
+// Message is a single delivery. Consumers must call Ack once it has been handled.
+type Message struct {
+	Topic string
+	Data  []byte
+
+	ack func(ctx context.Context) error
+}
+
+// Ack 确认消息已处理；对不支持确认的后端为空操作
+// Ack confirms the message was handled. It is a no-op for backends without redelivery.
+func (m *Message) Ack(ctx context.Context) error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack(ctx)
+}
+
+// New 根据配置创建 Broker
+// New builds the broker selected in configuration.
+func New(cfg appcfg.PubSubConfig, rdb *redis.Client, log zerolog.Logger) (Broker, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return NewMemory(log), nil
+	case "", BackendRedis:
+		if rdb == nil {
+			return nil, fmt.Errorf("pubsub: backend %q requires redis", BackendRedis)
+		}
+		return NewRedis(rdb, log), nil
+	case BackendRedisStreams:
+		if rdb == nil {
+			return nil, fmt.Errorf("pubsub: backend %q requires redis", BackendRedisStreams)
+		}
+		return NewRedisStreams(rdb, log, StreamsOptions{
+			MaxLen:    cfg.StreamMaxLen,
+			Retention: cfg.StreamRetention(),
+		}), nil
+	default:
+		return nil, fmt.Errorf("pubsub: unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+const memoryBufferSize = 64
+
+// MemoryBroker 进程内 Broker，用于单节点部署和测试
+// MemoryBroker is an in-process broker for single-node deployments and tests.
+type MemoryBroker struct {
+	logger zerolog.Logger
+
+	mu     sync.RWMutex
+	topics map[string]map[*memorySubscription]struct{}
+}
+
+// NewMemory 创建进程内 Broker
+// NewMemory returns an in-process broker.
+func NewMemory(log zerolog.Logger) *MemoryBroker {
+	return &MemoryBroker{
+		logger: log.With().Str("component", "pubsub_memory").Logger(),
+		topics: make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+// Publish 实现 Broker
+// Publish implements Broker.
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, data []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.topics[topic] {
+		msg := &Message{Topic: topic, Data: append([]byte(nil), data...)}
+		select {
+		case sub.ch <- msg:
+		default:
+			b.logger.Warn().Str("topic", topic).Msg("dropping message due to slow subscriber")
+		}
+	}
+	return nil
+}
+
+// Subscribe 实现 Broker
+// Subscribe implements Broker.
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	sub := &memorySubscription{
+		broker: b,
+		topic:  topic,
+		ch:     make(chan *Message, memoryBufferSize),
+	}
+
+	b.mu.Lock()
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make(map[*memorySubscription]struct{})
+	}
+	b.topics[topic][sub] = struct{}{}
+	sub.stop = context.AfterFunc(ctx, func() { _ = sub.Close() })
+	b.mu.Unlock()
+
+	return sub, nil
+}
+
+// Subscriptions 返回当前活跃订阅数量
+// Subscriptions reports how many subscriptions are currently open.
+func (b *MemoryBroker) Subscriptions() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	total := 0
+	for _, subs := range b.topics {
+		total += len(subs)
+	}
+	return total
+}
+
+type memorySubscription struct {
+	broker *MemoryBroker
+	topic  string
+	ch     chan *Message
+	stop   func() bool
+	once   sync.Once
+}
+
+func (s *memorySubscription) Messages() <-chan *Message {
+	return s.ch
+}
+
+func (s *memorySubscription) Close() error {
+	s.once.Do(func() {
+		s.broker.mu.Lock()
+		defer s.broker.mu.Unlock()
+		s.stop()
+		if subs, ok := s.broker.topics[s.topic]; ok {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(s.broker.topics, s.topic)
+			}
+		}
+		close(s.ch)
+	})
+	return nil
+}
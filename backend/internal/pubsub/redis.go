@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// RedisBroker 基于 Redis Pub/Sub 的 Broker（至多一次投递）
+// RedisBroker delivers through Redis Pub/Sub with at-most-once semantics.
+type RedisBroker struct {
+	redis  *redis.Client
+	logger zerolog.Logger
+}
+
+// NewRedis 创建 Redis Pub/Sub Broker
+// NewRedis returns a Redis Pub/Sub backed broker.
+func NewRedis(rdb *redis.Client, log zerolog.Logger) *RedisBroker {
+	return &RedisBroker{
+		redis:  rdb,
+		logger: log.With().Str("component", "pubsub_redis").Logger(),
+	}
+}
+
+// Publish 实现 Broker
+// Publish implements Broker.
+func (b *RedisBroker) Publish(ctx context.Context, topic string, data []byte) error {
+	return b.redis.Publish(ctx, topic, data).Err()
+}
+
+// Subscribe 实现 Broker
+// Subscribe implements Broker. It returns once Redis has confirmed the subscription.
+func (b *RedisBroker) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	ps := b.redis.Subscribe(ctx, topic)
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &redisSubscription{
+		ps:     ps,
+		ch:     make(chan *Message, memoryBufferSize),
+		cancel: cancel,
+	}
+	go sub.forward(ctx)
+	return sub, nil
+}
+
+type redisSubscription struct {
+	ps     *redis.PubSub
+	ch     chan *Message
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (s *redisSubscription) forward(ctx context.Context) {
+	defer close(s.ch)
+	defer s.Close()
+
+	in := s.ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case s.ch <- &Message{Topic: msg.Channel, Data: []byte(msg.Payload)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (s *redisSubscription) Messages() <-chan *Message {
+	return s.ch
+}
+
+func (s *redisSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		err = s.ps.Close()
+	})
+	return err
+}
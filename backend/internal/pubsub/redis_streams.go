@@ -0,0 +1,275 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	streamDataField        = "d"
+	defaultStreamMaxLen    = 1000
+	defaultStreamRetention = time.Hour
+	defaultStreamBlock     = 2 * time.Second
+	defaultStreamRedeliver = 15 * time.Second
+	streamReadCount        = 64
+)
+
+// StreamsOptions Redis Streams 后端参数
+// StreamsOptions tunes the Redis Streams backend.
+type StreamsOptions struct {
+	// 每个主题保留的近似最大条数
+	// Approximate number of entries kept per topic stream.
+	MaxLen int64
+	// 最后一次发布后主题流的保留时间
+	// How long a topic stream survives after its last publish.
+	Retention time.Duration
+	// XREADGROUP 的阻塞时间
+	// How long a single XREADGROUP call blocks.
+	Block time.Duration
+	// 未确认消息重新投递前的空闲时间
+	// Idle time after which unacknowledged entries are redelivered.
+	Redeliver time.Duration
+}
+
+// RedisStreamsBroker 基于 Redis Streams 的 Broker（至少一次投递）
+// RedisStreamsBroker delivers through Redis Streams with at-least-once semantics.
+// Each subscription owns a consumer group, so every subscriber sees every entry;
+// entries stay pending until acknowledged and are redelivered if the consumer
+// stalls or the connection to Redis drops.
+type RedisStreamsBroker struct {
+	redis    *redis.Client
+	logger   zerolog.Logger
+	opts     StreamsOptions
+	consumer string
+	seq      atomic.Uint64
+}
+
+// NewRedisStreams 创建 Redis Streams Broker
+// NewRedisStreams returns a Redis Streams backed broker.
+func NewRedisStreams(rdb *redis.Client, log zerolog.Logger, opts StreamsOptions) *RedisStreamsBroker {
+	if opts.MaxLen <= 0 {
+		opts.MaxLen = defaultStreamMaxLen
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = defaultStreamRetention
+	}
+	if opts.Block <= 0 {
+		opts.Block = defaultStreamBlock
+	}
+	if opts.Redeliver <= 0 {
+		opts.Redeliver = defaultStreamRedeliver
+	}
+	return &RedisStreamsBroker{
+		redis:    rdb,
+		logger:   log.With().Str("component", "pubsub_redis_streams").Logger(),
+		opts:     opts,
+		consumer: uuid.NewString(),
+	}
+}
+
+// Publish 实现 Broker
+// Publish implements Broker.
+func (b *RedisStreamsBroker) Publish(ctx context.Context, topic string, data []byte) error {
+	pipe := b.redis.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: b.opts.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{streamDataField: data},
+	})
+	pipe.PExpire(ctx, topic, b.opts.Retention)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Subscribe 实现 Broker
+// Subscribe implements Broker. Only entries added after the call are delivered.
+func (b *RedisStreamsBroker) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	group := fmt.Sprintf("%s:%d", b.consumer, b.seq.Add(1))
+	if err := b.createGroup(ctx, topic, group); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &streamSubscription{
+		broker: b,
+		topic:  topic,
+		group:  group,
+		ch:     make(chan *Message, memoryBufferSize),
+		cancel: cancel,
+		queued: make(map[string]struct{}),
+	}
+	go sub.run(ctx)
+	return sub, nil
+}
+
+func (b *RedisStreamsBroker) createGroup(ctx context.Context, topic, group string) error {
+	err := b.redis.XGroupCreateMkStream(ctx, topic, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create consumer group: %w", err)
+	}
+	return nil
+}
+
+type streamSubscription struct {
+	broker *RedisStreamsBroker
+	topic  string
+	group  string
+	ch     chan *Message
+	cancel context.CancelFunc
+	once   sync.Once
+
+	// 已交给订阅者但尚未确认的条目；它们可能仍在缓冲中，不应被重新投递
+	// Entries handed to the subscriber and not yet acknowledged. They may
+	// still sit in ch, so redeliver skips them instead of duplicating them.
+	mu     sync.Mutex
+	queued map[string]struct{}
+}
+
+func (s *streamSubscription) run(ctx context.Context) {
+	defer close(s.ch)
+	defer s.destroyGroup()
+
+	lastClaim := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= s.broker.opts.Redeliver {
+			s.redeliver(ctx)
+			lastClaim = time.Now()
+		}
+
+		streams, err := s.broker.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.group,
+			Streams:  []string{s.topic, ">"},
+			Count:    streamReadCount,
+			Block:    s.broker.opts.Block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// 主题流过期后消费组随之消失，重新创建
+				// The stream expired and took the group with it; recreate both.
+				if err := s.broker.createGroup(ctx, s.topic, s.group); err != nil {
+					s.broker.logger.Warn().Err(err).Str("topic", s.topic).Msg("failed to recreate consumer group")
+				}
+				continue
+			}
+			s.broker.logger.Warn().Err(err).Str("topic", s.topic).Msg("stream read failed")
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			s.deliver(ctx, stream.Messages)
+		}
+	}
+}
+
+// redeliver 认领空闲过久的未确认消息并重新投递
+// redeliver claims entries left unacknowledged for too long and delivers them
+// again, except those still waiting to be acknowledged by this subscriber.
+func (s *streamSubscription) redeliver(ctx context.Context) {
+	start := "0-0"
+	for {
+		msgs, next, err := s.broker.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.topic,
+			Group:    s.group,
+			Consumer: s.group,
+			MinIdle:  s.broker.opts.Redeliver,
+			Start:    start,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				s.broker.logger.Debug().Err(err).Str("topic", s.topic).Msg("stream redelivery failed")
+			}
+			return
+		}
+		s.deliver(ctx, s.unqueued(msgs))
+		if next == "0-0" || len(msgs) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+func (s *streamSubscription) deliver(ctx context.Context, entries []redis.XMessage) {
+	for _, entry := range entries {
+		raw, ok := entry.Values[streamDataField].(string)
+		if !ok {
+			// 无法解析的条目直接确认，避免无限重投
+			// Acknowledge entries we cannot decode so they are not redelivered forever.
+			_ = s.broker.redis.XAck(ctx, s.topic, s.group, entry.ID).Err()
+			continue
+		}
+		id := entry.ID
+		msg := &Message{
+			Topic: s.topic,
+			Data:  []byte(raw),
+			ack: func(ctx context.Context) error {
+				// 确认失败时条目仍处于待确认状态，之后会被重新投递
+				// If the ack fails the entry stays pending and is redelivered later.
+				s.mu.Lock()
+				delete(s.queued, id)
+				s.mu.Unlock()
+				return s.broker.redis.XAck(ctx, s.topic, s.group, id).Err()
+			},
+		}
+		s.mu.Lock()
+		s.queued[id] = struct{}{}
+		s.mu.Unlock()
+		select {
+		case s.ch <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unqueued 过滤掉已交给订阅者、尚未确认的条目
+// unqueued drops the entries already handed to the subscriber and not yet
+// acknowledged.
+func (s *streamSubscription) unqueued(entries []redis.XMessage) []redis.XMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := entries[:0]
+	for _, entry := range entries {
+		if _, ok := s.queued[entry.ID]; !ok {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+func (s *streamSubscription) destroyGroup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.broker.redis.XGroupDestroy(ctx, s.topic, s.group).Err(); err != nil && !strings.HasPrefix(err.Error(), "NOGROUP") {
+		s.broker.logger.Debug().Err(err).Str("topic", s.topic).Msg("failed to destroy consumer group")
+	}
+}
+
+func (s *streamSubscription) Messages() <-chan *Message {
+	return s.ch
+}
+
+// Close 取消订阅；消费组在读循环退出时销毁
+// Close cancels the subscription; the consumer group is destroyed once the read loop exits.
+func (s *streamSubscription) Close() error {
+	s.once.Do(s.cancel)
+	return nil
+}
@@ -12,8 +12,15 @@ type RouteDependencies struct {
 	AuthHandler      *handlers.AuthHandler
 	EmailHandler     *handlers.EmailHandler
 	UserHandler      *handlers.UserHandler
+	CallHandler      *handlers.CallHandler
+	PrivacyHandler   *handlers.PrivacyHandler
+	WebRTCHandler    *handlers.WebRTCHandler
 	SignalingHandler *handlers.SignalingHandler
 	AuthMiddleware   gin.HandlerFunc
+	// 管理接口，未配置管理令牌时为 nil
+	// Admin endpoints; nil when no admin token is configured.
+	AdminHandler    *handlers.AdminHandler
+	AdminMiddleware gin.HandlerFunc
 }
 
 // RegisterRoutes 注册所有 HTTP 路由
@@ -27,11 +34,24 @@ func RegisterRoutes(router *gin.Engine, deps RouteDependencies) {
 	emailGroup := api.Group("")
 	deps.EmailHandler.RegisterRoutes(emailGroup)
 
+	api.GET("/signal/schema", deps.SignalingHandler.HandleSchema)
+
 	protected := api.Group("/")
 	protected.Use(deps.AuthMiddleware)
 	{
 		userGroup := protected.Group("/users")
 		deps.UserHandler.RegisterRoutes(userGroup)
+		deps.PrivacyHandler.RegisterRoutes(userGroup)
+		deps.CallHandler.RegisterRoutes(protected.Group("/calls"))
+		deps.WebRTCHandler.RegisterRoutes(protected.Group("/webrtc"))
 		protected.GET("/ws", deps.SignalingHandler.Handle)
+		protected.GET("/signal/events", deps.SignalingHandler.HandleEvents)
+		protected.POST("/signal", deps.SignalingHandler.HandlePost)
+	}
+
+	if deps.AdminHandler != nil {
+		admin := api.Group("/admin")
+		admin.Use(deps.AdminMiddleware)
+		deps.AdminHandler.RegisterRoutes(admin)
 	}
 }
@@ -1,16 +1,23 @@
 package signaling
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/media"
 )
 
 // SignalAdapter 适配现有的信令消息到 Pion 媒体操作
 // SignalAdapter adapts existing signaling messages to Pion media operations
 // 这个适配器确保向后兼容性，允许现有客户端无需改动就能工作
 // This adapter ensures backward compatibility, allowing existing clients to work without changes
+// 发往 ServerPeer 的消息由它交给媒体引擎，对端连接以客户端为 local、ServerPeer 为 remote
+// The hub hands it every message addressed to ServerPeer; the engine's peer
+// connection is keyed with the client as local and ServerPeer as remote.
 type SignalAdapter struct {
 	logger zerolog.Logger
 	hub    *Hub
@@ -29,7 +36,7 @@ func NewSignalAdapter(logger zerolog.Logger, hub *Hub) *SignalAdapter {
 // ProcessSignalMessage processes signaling messages from clients
 // 支持现有的 WebRTC 格式并适配到 Pion 操作
 // Supports existing WebRTC formats and adapts them to Pion operations
-func (a *SignalAdapter) ProcessSignalMessage(message *SignalMessage) error {
+func (a *SignalAdapter) ProcessSignalMessage(ctx context.Context, message *SignalMessage) error {
 	if a.hub.mediaEngine == nil {
 		return fmt.Errorf("media engine not available")
 	}
@@ -45,19 +52,41 @@ func (a *SignalAdapter) ProcessSignalMessage(message *SignalMessage) error {
 	// Adapt based on message type
 	switch message.Type {
 	case TypeCallInvite:
-		return a.handleCallInvite(message)
+		return a.handleCallInvite(ctx, message)
 
 	case TypeCallAccept:
-		return a.handleCallAccept(message)
+		return a.handleCallAccept(ctx, message)
 
 	case TypeCallReject:
-		return a.handleCallReject(message)
+		return a.handleCallReject(ctx, message)
 
 	case TypeCallEnd:
-		return a.handleCallEnd(message)
+		return a.handleCallEnd(ctx, message)
 
 	case TypeIceCandidate:
-		return a.handleIceCandidate(message)
+		return a.handleIceCandidate(ctx, message)
+
+	case TypeCallRenegotiate:
+		return a.handleCallRenegotiate(ctx, message)
+
+	case TypeCallRecordStart:
+		return a.hub.startRecording(ctx, message)
+
+	case TypeCallRecordStop:
+		return a.hub.handleRecordStop(ctx, message)
+
+	case TypeCallStatsGet:
+		return a.hub.handleStatsGet(ctx, message)
+
+	case TypeCallHold, TypeCallResume:
+		// 保持状态已由 Hub 记录；客户端自行暂停发送
+		// The hub already recorded the hold state; the client pauses its own senders.
+		a.logger.Info().
+			Str("call_id", message.CallID).
+			Str("type", message.Type).
+			Str("from", message.From).
+			Msg("call hold state changed")
+		return nil
 
 	default:
 		// 其他消息类型继续使用原有的处理逻辑
@@ -68,9 +97,9 @@ func (a *SignalAdapter) ProcessSignalMessage(message *SignalMessage) error {
 
 // handleCallInvite 处理通话邀请
 // handleCallInvite handles call invitations
-// 为新的通话创建 Pion 对等连接
-// Creates a Pion peer connection for the new call
-func (a *SignalAdapter) handleCallInvite(message *SignalMessage) error {
+// 为新的通话创建 Pion 对等连接，并把 answer 发回主叫
+// Creates a Pion peer connection for the new call and sends the answer back to the caller
+func (a *SignalAdapter) handleCallInvite(ctx context.Context, message *SignalMessage) error {
 	if message.CallID == "" {
 		return fmt.Errorf("missing call_id in invite")
 	}
@@ -81,17 +110,79 @@ func (a *SignalAdapter) handleCallInvite(message *SignalMessage) error {
 		Str("recipient", message.To).
 		Msg("call invite received, preparing media connection")
 
-	// 不在这里创建对等连接
-	// 而是等待 offer 到达时再创建
-	// Don't create peer connection here
-	// Wait for offer to arrive before creating
+	var offer SessionDescriptionPayload
+	if err := message.DecodePayload(&offer); err != nil {
+		return fmt.Errorf("decode offer: %w", err)
+	}
 
+	err := a.answerOffer(ctx, message, offer)
+	if errors.Is(err, media.ErrPeerConnectionLimit) {
+		a.endCall(ctx, message, "server busy")
+		return newProtocolError(ErrorCodeMediaUnavailable, message.ID, "media engine is at capacity")
+	}
+	if err != nil {
+		// 媒体建立失败时结束通话，避免客户端一直等待
+		// End the call on failure so the client is not left ringing.
+		a.endCall(ctx, message, "media setup failed")
+	}
+	return err
+}
+
+func (a *SignalAdapter) answerOffer(ctx context.Context, message *SignalMessage, offer SessionDescriptionPayload) error {
+	peerConn, err := a.hub.peerConnectionFor(ctx, message.CallID, message.From, message.To)
+	if err != nil {
+		return err
+	}
+	if offer.Room != "" {
+		// 先加入房间，answer 即可带上已发布的轨道
+		// Join first so the answer already carries the room's published tracks.
+		if err := a.hub.mediaEngine.JoinRoom(message.CallID, message.From, message.To, offer.Room); err != nil {
+			return fmt.Errorf("join room: %w", err)
+		}
+	}
+	answer, err := a.hub.handleOffer(ctx, peerConn, offer.SDP)
+	if err != nil {
+		return err
+	}
+	if err := a.hub.sendAnswer(ctx, peerConn, answer); err != nil {
+		return err
+	}
+	if manifest, ok := a.hub.mediaEngine.ActiveRecording(message.CallID, message.From, message.To); ok {
+		// 加入正在录制的房间时告知新参与者
+		// Someone joining a room that is being recorded is told so.
+		a.hub.announceRecording(ctx, manifest, RecordingStarted, "", []media.RecordingParticipant{
+			{CallID: message.CallID, Email: message.From},
+		})
+	}
 	return nil
 }
 
+// endCall 以服务端身份结束通话并清理对等连接
+// endCall ends the call on behalf of the server and cleans up its peer connection
+func (a *SignalAdapter) endCall(ctx context.Context, message *SignalMessage, reason string) {
+	a.hub.finishQualitySampling(ctx, message.CallID, message.From, message.To)
+	a.hub.finishRecording(ctx, message.CallID, message.From)
+	_ = a.hub.mediaEngine.ClosePeerConnection(message.CallID, message.From, message.To)
+
+	payload, _ := json.Marshal(CallReasonPayload{Reason: reason})
+	end := &SignalMessage{
+		Type:    TypeCallEnd,
+		CallID:  message.CallID,
+		To:      message.From,
+		From:    message.To,
+		Payload: payload,
+	}
+	a.hub.trackCall(ctx, end)
+	if err := a.hub.SendToUser(ctx, message.From, end); err != nil {
+		a.logger.Warn().Err(err).Str("call_id", message.CallID).Msg("failed to end server call")
+	}
+}
+
 // handleCallAccept 处理通话接受
 // handleCallAccept handles call acceptance
-func (a *SignalAdapter) handleCallAccept(message *SignalMessage) error {
+// 客户端接听服务端发起的通话时带回 answer
+// The client answers an offer the server created with CreateOffer
+func (a *SignalAdapter) handleCallAccept(ctx context.Context, message *SignalMessage) error {
 	if message.CallID == "" {
 		return fmt.Errorf("missing call_id in accept")
 	}
@@ -102,12 +193,20 @@ func (a *SignalAdapter) handleCallAccept(message *SignalMessage) error {
 		Str("initiator", message.To).
 		Msg("call accept received")
 
-	return nil
+	var answer SessionDescriptionPayload
+	if err := message.DecodePayload(&answer); err != nil {
+		return fmt.Errorf("decode answer: %w", err)
+	}
+	peerConn, err := a.hub.mediaEngine.GetPeerConnection(message.CallID, message.From, message.To)
+	if err != nil {
+		return err
+	}
+	return a.hub.handleAnswer(ctx, peerConn, answer.SDP)
 }
 
 // handleCallReject 处理通话拒绝
 // handleCallReject handles call rejection
-func (a *SignalAdapter) handleCallReject(message *SignalMessage) error {
+func (a *SignalAdapter) handleCallReject(ctx context.Context, message *SignalMessage) error {
 	if message.CallID == "" {
 		return fmt.Errorf("missing call_id in reject")
 	}
@@ -120,6 +219,7 @@ func (a *SignalAdapter) handleCallReject(message *SignalMessage) error {
 
 	// 清理对等连接（如果存在）
 	// Clean up peer connection if it exists
+	a.hub.finishRecording(ctx, message.CallID, message.From)
 	_ = a.hub.mediaEngine.ClosePeerConnection(message.CallID, message.From, message.To)
 
 	return nil
@@ -127,7 +227,7 @@ func (a *SignalAdapter) handleCallReject(message *SignalMessage) error {
 
 // handleCallEnd 处理通话结束
 // handleCallEnd handles call termination
-func (a *SignalAdapter) handleCallEnd(message *SignalMessage) error {
+func (a *SignalAdapter) handleCallEnd(ctx context.Context, message *SignalMessage) error {
 	if message.CallID == "" {
 		return fmt.Errorf("missing call_id in end")
 	}
@@ -136,8 +236,10 @@ func (a *SignalAdapter) handleCallEnd(message *SignalMessage) error {
 		Str("call_id", message.CallID).
 		Msg("call end received")
 
-	// 关闭对等连接
-	// Close peer connection
+	// 记录最后一次质量采样，结束录制并关闭对等连接
+	// Take a last quality sample, finish any recording and close the peer connection
+	a.hub.finishQualitySampling(ctx, message.CallID, message.From, message.To)
+	a.hub.finishRecording(ctx, message.CallID, message.From)
 	err := a.hub.mediaEngine.ClosePeerConnection(message.CallID, message.From, message.To)
 	if err != nil {
 		a.logger.Warn().Err(err).Str("call_id", message.CallID).Msg("error closing peer connection")
@@ -152,7 +254,7 @@ func (a *SignalAdapter) handleCallEnd(message *SignalMessage) error {
 // handleIceCandidate handles ICE candidates
 // 从客户端接收的 ICE 候选被适配到 Pion 格式
 // ICE candidates from client are adapted to Pion format
-func (a *SignalAdapter) handleIceCandidate(message *SignalMessage) error {
+func (a *SignalAdapter) handleIceCandidate(ctx context.Context, message *SignalMessage) error {
 	if message.CallID == "" {
 		return fmt.Errorf("missing call_id in ice candidate")
 	}
@@ -166,20 +268,31 @@ func (a *SignalAdapter) handleIceCandidate(message *SignalMessage) error {
 
 	// 添加到对等连接
 	// Add to peer connection
-	return a.hub.HandlePionMessage(
-		nil, // context 将由调用者提供
-		message.CallID,
-		message.From,
-		message.To,
-		"ice_candidate",
-		message.Payload,
-	)
+	peerConn, err := a.hub.mediaEngine.GetPeerConnection(message.CallID, message.From, message.To)
+	if err != nil {
+		return err
+	}
+	return a.hub.handleICECandidate(ctx, peerConn, &candidate)
+}
+
+// handleCallRenegotiate 处理通话中的重新协商
+// handleCallRenegotiate handles mid-call renegotiation, such as adding video to an audio call
+func (a *SignalAdapter) handleCallRenegotiate(ctx context.Context, message *SignalMessage) error {
+	var desc SessionDescriptionPayload
+	if err := message.DecodePayload(&desc); err != nil {
+		return fmt.Errorf("decode session description: %w", err)
+	}
+	peerConn, err := a.hub.mediaEngine.GetPeerConnection(message.CallID, message.From, message.To)
+	if err != nil {
+		return err
+	}
+	return a.hub.handleRenegotiation(ctx, peerConn, desc)
 }
 
 // CreateOfferFromExistingMessage 从现有的通话邀请创建 offer
 // CreateOfferFromExistingMessage creates an offer from an existing call invitation
 // 这用于接收方创建答案前获取必要信息
 // Used for responder to get necessary info before creating answer
-func (a *SignalAdapter) CreateOfferFromExistingMessage(message *SignalMessage) (string, error) {
-	return a.hub.CreateOffer(nil, message.CallID, message.To, message.From)
+func (a *SignalAdapter) CreateOfferFromExistingMessage(ctx context.Context, message *SignalMessage) (string, error) {
+	return a.hub.CreateOffer(ctx, message.CallID, message.To, message.From)
 }
@@ -0,0 +1,317 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	callKeyPrefix      = "signal:call:"
+	userCallsKeyPrefix = "signal:user_calls:"
+	defaultCallTTL     = 6 * time.Hour
+)
+
+// ErrCallNotFound 通话不存在
+// ErrCallNotFound indicates the call is unknown or already finished.
+var ErrCallNotFound = errors.New("call not found")
+
+// ErrCallExists 通话 ID 已被占用
+// ErrCallExists indicates a call with the same ID is already in progress.
+var ErrCallExists = errors.New("call already exists")
+
+// CallStatus 信令层通话状态
+// CallStatus is the signaling-level state of a call.
+type CallStatus string
+
+const (
+	// CallStatusRinging 已邀请，等待接听
+	// CallStatusRinging means the invite was sent and the callee has not answered.
+	CallStatusRinging CallStatus = "ringing"
+	// CallStatusActive 已接听
+	// CallStatusActive means the callee accepted.
+	CallStatusActive CallStatus = "active"
+)
+
+// Call 跨节点共享的通话状态
+// Call is the call state shared by every signaling node.
+type Call struct {
+	ID         string     `json:"call_id"`
+	Caller     string     `json:"caller"`
+	Callee     string     `json:"callee"`
+	Status     CallStatus `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+	// HeldBy 将通话保持的参与者
+	// HeldBy lists the participants that currently have the call on hold.
+	HeldBy []string `json:"held_by,omitempty"`
+	// Transfer 本通话上等待完成的转接
+	// Transfer is a transfer requested on this call that has not completed yet.
+	Transfer *Transfer `json:"transfer,omitempty"`
+	// 由转接产生的通话：来源通话、发起人与被替换的咨询通话
+	// For calls created by a transfer: the call it continues, who referred it,
+	// and the consultation call it replaces (attended transfers only).
+	TransferredFrom string `json:"transferred_from,omitempty"`
+	ReferredBy      string `json:"referred_by,omitempty"`
+	Replaces        string `json:"replaces,omitempty"`
+}
+
+// Transfer 转接请求
+// Transfer is a pending request to hand the call over to Target.
+type Transfer struct {
+	Mode          string `json:"mode"`
+	Target        string `json:"target"`
+	By            string `json:"by"`
+	ConsultCallID string `json:"consult_call_id,omitempty"`
+}
+
+// HasParticipant 判断用户是否为通话参与者
+// HasParticipant reports whether email takes part in the call.
+func (c *Call) HasParticipant(email string) bool {
+	return c.Caller == email || c.Callee == email
+}
+
+// Held 通话是否被任一方保持
+// Held reports whether any participant has the call on hold.
+func (c *Call) Held() bool {
+	return len(c.HeldBy) > 0
+}
+
+// SetHeld 记录 email 保持或恢复通话
+// SetHeld records that email put the call on hold or resumed it.
+func (c *Call) SetHeld(email string, held bool) {
+	for i, e := range c.HeldBy {
+		if e == email {
+			if !held {
+				c.HeldBy = append(c.HeldBy[:i], c.HeldBy[i+1:]...)
+			}
+			return
+		}
+	}
+	if held {
+		c.HeldBy = append(c.HeldBy, email)
+	}
+}
+
+// Peer 返回另一方
+// Peer returns the other party of email in the call.
+func (c *Call) Peer(email string) string {
+	if c.Caller == email {
+		return c.Callee
+	}
+	return c.Caller
+}
+
+// CallStore 通话状态存储
+// CallStore persists call state so any node can pick it up.
+type CallStore interface {
+	// Create 保存新通话，ID 已存在时返回 ErrCallExists
+	// Create stores a new call, or returns ErrCallExists if its ID is taken.
+	Create(ctx context.Context, call *Call) error
+	Save(ctx context.Context, call *Call) error
+	Get(ctx context.Context, callID string) (*Call, error)
+	Delete(ctx context.Context, callID string) error
+	ListByUser(ctx context.Context, email string) ([]*Call, error)
+}
+
+// RedisCallStore 基于 Redis 的通话存储
+// RedisCallStore keeps one JSON document per call plus a per-user index set.
+type RedisCallStore struct {
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+// NewRedisCallStore 创建 Redis 通话存储
+// NewRedisCallStore returns a Redis backed call store.
+func NewRedisCallStore(rdb *redis.Client) *RedisCallStore {
+	return &RedisCallStore{redis: rdb, ttl: defaultCallTTL}
+}
+
+// Create 实现 CallStore，以 SET NX 保证只有一个邀请能占用该 ID
+// Create implements CallStore. SET NX lets only one invite claim the ID.
+func (s *RedisCallStore) Create(ctx context.Context, call *Call) error {
+	data, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	created, err := s.redis.SetNX(ctx, callKeyPrefix+call.ID, data, s.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrCallExists
+	}
+	pipe := s.redis.TxPipeline()
+	s.index(ctx, pipe, call)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Save 实现 CallStore
+// Save implements CallStore.
+func (s *RedisCallStore) Save(ctx context.Context, call *Call) error {
+	data, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, callKeyPrefix+call.ID, data, s.ttl)
+	s.index(ctx, pipe, call)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// index 将通话加入双方的索引
+// index adds the call to both parties' index sets.
+func (s *RedisCallStore) index(ctx context.Context, pipe redis.Pipeliner, call *Call) {
+	for _, email := range []string{call.Caller, call.Callee} {
+		pipe.SAdd(ctx, userCallsKeyPrefix+email, call.ID)
+		pipe.Expire(ctx, userCallsKeyPrefix+email, s.ttl)
+	}
+}
+
+// Get 实现 CallStore
+// Get implements CallStore.
+func (s *RedisCallStore) Get(ctx context.Context, callID string) (*Call, error) {
+	data, err := s.redis.Get(ctx, callKeyPrefix+callID).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrCallNotFound
+		}
+		return nil, err
+	}
+	var call Call
+	if err := json.Unmarshal(data, &call); err != nil {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// Delete 实现 CallStore
+// Delete implements CallStore.
+func (s *RedisCallStore) Delete(ctx context.Context, callID string) error {
+	call, err := s.Get(ctx, callID)
+	if err != nil {
+		if errors.Is(err, ErrCallNotFound) {
+			return nil
+		}
+		return err
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, callKeyPrefix+callID)
+	pipe.SRem(ctx, userCallsKeyPrefix+call.Caller, callID)
+	pipe.SRem(ctx, userCallsKeyPrefix+call.Callee, callID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListByUser 实现 CallStore
+// ListByUser implements CallStore. Index entries whose call expired are pruned.
+func (s *RedisCallStore) ListByUser(ctx context.Context, email string) ([]*Call, error) {
+	ids, err := s.redis.SMembers(ctx, userCallsKeyPrefix+email).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = callKeyPrefix + id
+	}
+	values, err := s.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	calls := make([]*Call, 0, len(values))
+	var stale []interface{}
+	for i, raw := range values {
+		str, ok := raw.(string)
+		if !ok {
+			stale = append(stale, ids[i])
+			continue
+		}
+		var call Call
+		if err := json.Unmarshal([]byte(str), &call); err != nil {
+			stale = append(stale, ids[i])
+			continue
+		}
+		calls = append(calls, &call)
+	}
+	if len(stale) > 0 {
+		_ = s.redis.SRem(ctx, userCallsKeyPrefix+email, stale...).Err()
+	}
+	return calls, nil
+}
+
+// MemoryCallStore 进程内通话存储，用于单节点部署和测试
+// MemoryCallStore is an in-process call store for single-node deployments and tests.
+type MemoryCallStore struct {
+	mu    sync.RWMutex
+	calls map[string]Call
+}
+
+// NewMemoryCallStore 创建进程内通话存储
+// NewMemoryCallStore returns an in-process call store.
+func NewMemoryCallStore() *MemoryCallStore {
+	return &MemoryCallStore{calls: make(map[string]Call)}
+}
+
+// Create 实现 CallStore
+// Create implements CallStore.
+func (s *MemoryCallStore) Create(ctx context.Context, call *Call) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.calls[call.ID]; ok {
+		return ErrCallExists
+	}
+	s.calls[call.ID] = *call
+	return nil
+}
+
+// Save 实现 CallStore
+// Save implements CallStore.
+func (s *MemoryCallStore) Save(ctx context.Context, call *Call) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[call.ID] = *call
+	return nil
+}
+
+// Get 实现 CallStore
+// Get implements CallStore.
+func (s *MemoryCallStore) Get(ctx context.Context, callID string) (*Call, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	call, ok := s.calls[callID]
+	if !ok {
+		return nil, ErrCallNotFound
+	}
+	return &call, nil
+}
+
+// Delete 实现 CallStore
+// Delete implements CallStore.
+func (s *MemoryCallStore) Delete(ctx context.Context, callID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.calls, callID)
+	return nil
+}
+
+// ListByUser 实现 CallStore
+// ListByUser implements CallStore.
+func (s *MemoryCallStore) ListByUser(ctx context.Context, email string) ([]*Call, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var calls []*Call
+	for _, call := range s.calls {
+		if call.HasParticipant(email) {
+			c := call
+			calls = append(calls, &c)
+		}
+	}
+	return calls, nil
+}
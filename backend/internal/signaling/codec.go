@@ -0,0 +1,338 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 支持的编解码器名称
+// Names of the supported wire codecs.
+const (
+	CodecJSON     = "json"
+	CodecMsgpack  = "msgpack"
+	CodecProtobuf = "protobuf"
+)
+
+// Codec 信令消息的线路编码
+// Codec converts between SignalMessage and its wire form. Every codec carries
+// the payload as the same opaque JSON document, so the hub never translates
+// payloads between clients that use different codecs.
+type Codec interface {
+	Name() string
+	// Binary 是否以 WebSocket 二进制帧发送
+	// Binary reports whether frames go out as WebSocket binary messages.
+	Binary() bool
+	Encode(msg *SignalMessage) ([]byte, error)
+	Decode(data []byte) (*SignalMessage, error)
+}
+
+var (
+	jsonCodec     Codec = jsonWireCodec{}
+	msgpackCodec  Codec = newMsgpackWireCodec()
+	protobufCodec Codec = protobufWireCodec{}
+
+	// codecsByPreference 服务端偏好顺序：二进制优先
+	// codecsByPreference lists codecs in server preference order, binary first.
+	codecsByPreference = []Codec{protobufCodec, msgpackCodec, jsonCodec}
+
+	codecContentTypes = map[string]Codec{
+		"application/json":       jsonCodec,
+		"application/msgpack":    msgpackCodec,
+		"application/x-msgpack":  msgpackCodec,
+		"application/protobuf":   protobufCodec,
+		"application/x-protobuf": protobufCodec,
+	}
+)
+
+// CodecByName 按名称查找编解码器
+// CodecByName returns the codec registered under name.
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range codecsByPreference {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// CodecForContentType 按 HTTP Content-Type 选择编解码器，空值视为 JSON
+// CodecForContentType picks the codec for an HTTP Content-Type; empty means JSON.
+func CodecForContentType(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return jsonCodec, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := codecContentTypes[mediaType]
+	return c, ok
+}
+
+// decodeMessage 解码客户端消息，失败时返回协议错误
+// decodeMessage decodes a client message, reporting failures as protocol errors.
+func decodeMessage(c Codec, data []byte) (*SignalMessage, error) {
+	msg, err := c.Decode(data)
+	if err != nil {
+		return nil, newProtocolError(ErrorCodeMalformedMessage, "", "decode %s message: %v", c.Name(), err)
+	}
+	return msg, nil
+}
+
+// checkPayload 二进制编码中的 payload 必须是 JSON 文档
+// checkPayload ensures a payload carried by a binary codec is a JSON document.
+func checkPayload(payload []byte) error {
+	if len(payload) > 0 && !json.Valid(payload) {
+		return fmt.Errorf("payload is not a JSON document")
+	}
+	return nil
+}
+
+// jsonWireCodec 默认文本编码
+// jsonWireCodec is the default text codec.
+type jsonWireCodec struct{}
+
+func (jsonWireCodec) Name() string { return CodecJSON }
+func (jsonWireCodec) Binary() bool { return false }
+
+func (jsonWireCodec) Encode(msg *SignalMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonWireCodec) Decode(data []byte) (*SignalMessage, error) {
+	var msg SignalMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// msgpackMessage MessagePack 线路结构，payload 为二进制 JSON
+// msgpackMessage is the MessagePack map layout; payload is a bin holding the JSON document.
+type msgpackMessage struct {
+	ID      string `codec:"id,omitempty"`
+	Type    string `codec:"type"`
+	CallID  string `codec:"call_id,omitempty"`
+	To      string `codec:"to,omitempty"`
+	From    string `codec:"from,omitempty"`
+	Payload []byte `codec:"payload,omitempty"`
+}
+
+type msgpackWireCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func newMsgpackWireCodec() msgpackWireCodec {
+	h := &codec.MsgpackHandle{}
+	// 使用新规范的 str/bin 区分
+	// Use the current spec's distinct str and bin types.
+	h.WriteExt = true
+	return msgpackWireCodec{handle: h}
+}
+
+func (msgpackWireCodec) Name() string { return CodecMsgpack }
+func (msgpackWireCodec) Binary() bool { return true }
+
+func (c msgpackWireCodec) Encode(msg *SignalMessage) ([]byte, error) {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, c.handle).Encode(&msgpackMessage{
+		ID:      msg.ID,
+		Type:    msg.Type,
+		CallID:  msg.CallID,
+		To:      msg.To,
+		From:    msg.From,
+		Payload: msg.Payload,
+	})
+	return out, err
+}
+
+func (c msgpackWireCodec) Decode(data []byte) (*SignalMessage, error) {
+	var m msgpackMessage
+	if err := codec.NewDecoderBytes(data, c.handle).Decode(&m); err != nil {
+		return nil, err
+	}
+	if err := checkPayload(m.Payload); err != nil {
+		return nil, err
+	}
+	return &SignalMessage{
+		ID:      m.ID,
+		Type:    m.Type,
+		CallID:  m.CallID,
+		To:      m.To,
+		From:    m.From,
+		Payload: m.Payload,
+	}, nil
+}
+
+// protobuf 字段编号，见 schema/signaling.v1.proto
+// Protobuf field numbers, see schema/signaling.v1.proto.
+const (
+	pbMessageID      protowire.Number = 1
+	pbMessageType    protowire.Number = 2
+	pbMessageCallID  protowire.Number = 3
+	pbMessageTo      protowire.Number = 4
+	pbMessageFrom    protowire.Number = 5
+	pbMessagePayload protowire.Number = 6
+)
+
+// protobufWireCodec 按 signaling.v1.proto 手工编解码，无需生成代码
+// protobufWireCodec encodes the SignalMessage of signaling.v1.proto by hand,
+// without generated code. It is also the hub's node-to-node representation.
+type protobufWireCodec struct{}
+
+func (protobufWireCodec) Name() string { return CodecProtobuf }
+func (protobufWireCodec) Binary() bool { return true }
+
+func (protobufWireCodec) Encode(msg *SignalMessage) ([]byte, error) {
+	size := len(msg.ID) + len(msg.Type) + len(msg.CallID) + len(msg.To) + len(msg.From) + len(msg.Payload) + 32
+	b := make([]byte, 0, size)
+	b = appendStringField(b, pbMessageID, msg.ID)
+	b = appendStringField(b, pbMessageType, msg.Type)
+	b = appendStringField(b, pbMessageCallID, msg.CallID)
+	b = appendStringField(b, pbMessageTo, msg.To)
+	b = appendStringField(b, pbMessageFrom, msg.From)
+	if len(msg.Payload) > 0 {
+		b = protowire.AppendTag(b, pbMessagePayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg.Payload)
+	}
+	return b, nil
+}
+
+func (protobufWireCodec) Decode(data []byte) (*SignalMessage, error) {
+	msg := &SignalMessage{}
+	err := consumeFields(data, func(num protowire.Number, value []byte) {
+		switch num {
+		case pbMessageID:
+			msg.ID = string(value)
+		case pbMessageType:
+			msg.Type = string(value)
+		case pbMessageCallID:
+			msg.CallID = string(value)
+		case pbMessageTo:
+			msg.To = string(value)
+		case pbMessageFrom:
+			msg.From = string(value)
+		case pbMessagePayload:
+			msg.Payload = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPayload(msg.Payload); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// consumeFields 遍历长度分隔字段，跳过未知字段
+// consumeFields walks length-delimited fields and skips any other field.
+func consumeFields(data []byte, fn func(num protowire.Number, value []byte)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fn(num, value)
+		data = data[n:]
+	}
+	return nil
+}
+
+// outbound 待投递的消息；每种编解码器最多编码一次
+// outbound is a message on its way to one or more connections. It is encoded
+// at most once per codec, however many connections and nodes it reaches.
+// The message must not be modified once wrapped.
+type outbound struct {
+	msg *SignalMessage
+
+	mu      sync.Mutex
+	encoded map[string][]byte
+}
+
+func newOutbound(msg *SignalMessage) *outbound {
+	return &outbound{msg: msg}
+}
+
+// newOutboundEncoded 包装已按 c 编码的消息，复用原始字节
+// newOutboundEncoded wraps a message that arrived encoded with c, reusing those bytes.
+func newOutboundEncoded(msg *SignalMessage, c Codec, data []byte) *outbound {
+	return &outbound{msg: msg, encoded: map[string][]byte{c.Name(): data}}
+}
+
+func (o *outbound) encode(c Codec) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if data, ok := o.encoded[c.Name()]; ok {
+		return data, nil
+	}
+	data, err := c.Encode(o.msg)
+	if err != nil {
+		return nil, err
+	}
+	if o.encoded == nil {
+		o.encoded = make(map[string][]byte, 1)
+	}
+	o.encoded[c.Name()] = data
+	return data, nil
+}
+
+// subprotocolCodecSeparator 子协议中版本与编码之间的分隔符
+// subprotocolCodecSeparator joins version and codec in a subprotocol token,
+// e.g. allcall.signal.v1+msgpack. A bare version token means JSON.
+const subprotocolCodecSeparator = "+"
+
+// parseSubprotocol 解析子协议中的版本与编解码器
+// parseSubprotocol splits a subprotocol token into protocol version and codec.
+func parseSubprotocol(name string) (int, Codec, bool) {
+	base, codecName, hasCodec := strings.Cut(name, subprotocolCodecSeparator)
+	version, ok := subprotocolVersions[base]
+	if !ok {
+		return 0, nil, false
+	}
+	if !hasCodec {
+		return version, jsonCodec, true
+	}
+	c, ok := CodecByName(codecName)
+	if !ok || c == jsonCodec {
+		return 0, nil, false
+	}
+	return version, c, true
+}
+
+// subprotocolFor 返回版本与编解码器对应的子协议
+// subprotocolFor returns the subprotocol token for a version and codec.
+func subprotocolFor(version int, c Codec) string {
+	base := subprotocolForVersion(version)
+	if base == "" || c == nil || c == jsonCodec {
+		return base
+	}
+	return base + subprotocolCodecSeparator + c.Name()
+}
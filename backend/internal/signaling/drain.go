@@ -0,0 +1,186 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// closeCodeServiceRestart RFC 6455 1012：服务重启
+	// closeCodeServiceRestart is the RFC 6455 "Service Restart" close code.
+	closeCodeServiceRestart = 1012
+
+	defaultDrainTimeout = 20 * time.Second
+	drainPollInterval   = 250 * time.Millisecond
+	reconnectSpread     = 5 * time.Second
+	closeGracePeriod    = 2 * time.Second
+
+	// callSetupWindow 振铃超过该时长的通话视为已放弃，排空时不再等待
+	// callSetupWindow bounds how long a call may ring before drain treats it
+	// as abandoned rather than a setup in progress.
+	callSetupWindow = time.Minute
+)
+
+// WithCallStore 替换通话状态存储（默认进程内）
+// WithCallStore replaces the in-process call store, e.g. with a Redis store shared by all nodes.
+func (h *Hub) WithCallStore(store CallStore) {
+	h.calls = store
+}
+
+// WithSessionStore 替换会话交接存储（默认进程内）
+// WithSessionStore replaces the in-process session store used for handoff.
+func (h *Hub) WithSessionStore(store SessionStore) {
+	h.sessions = store
+}
+
+// Draining 是否处于排空模式
+// Draining reports whether the hub stopped accepting new connections.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Drain 排空本节点的信令连接
+// Drain stops accepting sockets, hands every local session off through the
+// session store, tells clients to reconnect with server.draining, waits for
+// call setups in progress until ctx ends, then closes the remaining sockets.
+func (h *Hub) Drain(ctx context.Context) error {
+	if !h.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultDrainTimeout)
+	}
+
+	clients := h.localClients()
+	h.logger.Info().Int("connections", len(clients)).Time("deadline", deadline).Msg("draining signaling connections")
+
+	// 会话交接时间覆盖排空窗口与客户端重连
+	// Keep handed-off sessions long enough to cover the drain window and the reconnect.
+	sessionTTL := time.Until(deadline) + defaultSessionResumeTTL
+	for _, cl := range clients {
+		sessionID, version := cl.session()
+		if err := h.sessions.Save(ctx, &Session{
+			ID:      sessionID,
+			Email:   cl.email,
+			Version: version,
+			NodeID:  h.nodeID,
+		}, sessionTTL); err != nil {
+			h.logger.Warn().Err(err).Str("email", cl.email).Msg("failed to hand off signaling session")
+		}
+		h.sendDraining(cl, sessionID, deadline)
+	}
+
+	h.waitForCallSetups(ctx)
+	h.waitForSendQueues(closeGracePeriod)
+
+	for _, cl := range h.localClients() {
+		cl.conn.Shutdown(closeCodeServiceRestart, "server draining")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		h.logger.Info().Msg("signaling connections drained")
+	case <-time.After(closeGracePeriod):
+		h.logger.Warn().Msg("signaling connections still open after drain")
+	}
+	return ctx.Err()
+}
+
+// sendDraining 通知客户端重连，随机延迟避免重连风暴
+// sendDraining tells the client to reconnect, spreading reconnects to avoid a thundering herd.
+func (h *Hub) sendDraining(cl *client, sessionID string, deadline time.Time) {
+	payload, err := json.Marshal(DrainingPayload{
+		SessionID:        sessionID,
+		ReconnectAfterMs: rand.Int63n(reconnectSpread.Milliseconds()),
+		Deadline:         deadline,
+	})
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to marshal draining payload")
+		return
+	}
+	h.sendToClient(cl, newOutbound(&SignalMessage{
+		Type:    TypeServerDraining,
+		To:      cl.email,
+		Payload: payload,
+	}))
+}
+
+// waitForCallSetups 等待本节点用户正在建立的通话完成或超时
+// waitForCallSetups waits until no local user has a call that started ringing
+// within callSetupWindow, or ctx ends.
+func (h *Hub) waitForCallSetups(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		pending := 0
+		for _, email := range h.localUsers() {
+			calls, err := h.calls.ListByUser(ctx, email)
+			if err != nil {
+				h.logger.Warn().Err(err).Str("email", email).Msg("failed to check call setups")
+				continue
+			}
+			for _, call := range calls {
+				if call.Status == CallStatusRinging && time.Since(call.CreatedAt) < callSetupWindow {
+					pending++
+				}
+			}
+		}
+		if pending == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			h.logger.Warn().Int("pending_setups", pending).Msg("drain deadline reached with call setups in progress")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForSendQueues 等待已排队的消息（包括 server.draining）写出
+// waitForSendQueues gives write loops a moment to flush queued messages,
+// server.draining included, before the sockets are closed.
+func (h *Hub) waitForSendQueues(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		queued := 0
+		for _, cl := range h.localClients() {
+			queued += int(cl.pending.Load())
+		}
+		if queued == 0 {
+			return
+		}
+		time.Sleep(drainPollInterval / 5)
+	}
+}
+
+func (h *Hub) localClients() []*client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var clients []*client
+	for _, conns := range h.clients {
+		for cl := range conns {
+			clients = append(clients, cl)
+		}
+	}
+	return clients
+}
+
+// closeConn 发送关闭帧后关闭连接；可与写循环并发调用
+// closeConn sends a close frame and closes the socket. Safe to call concurrently with the write loop.
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = conn.Close()
+}
@@ -0,0 +1,66 @@
+package signaling
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// brokerEnvelope 节点间投递的信封
+// brokerEnvelope wraps a message published to another node's channel. Data is
+// the message in the protobuf codec, so the receiving node hands the same bytes
+// to its protobuf clients and never re-encodes the payload. When Session is
+// set, Data is instead a client message submitted over HTTP, encoded with
+// Codec, for the connection with that session ID.
+type brokerEnvelope struct {
+	NodeID  string
+	To      string
+	Session string
+	Codec   string
+	Data    []byte
+}
+
+// 信封字段编号
+// Envelope field numbers.
+const (
+	pbEnvelopeNodeID  protowire.Number = 1
+	pbEnvelopeTo      protowire.Number = 2
+	pbEnvelopeSession protowire.Number = 3
+	pbEnvelopeCodec   protowire.Number = 4
+	pbEnvelopeData    protowire.Number = 5
+)
+
+// marshal 编码信封；Data 原样拷贝，不重新编码
+// marshal encodes the envelope. Data is copied as is, never re-encoded.
+func (e *brokerEnvelope) marshal() []byte {
+	b := make([]byte, 0, len(e.NodeID)+len(e.To)+len(e.Session)+len(e.Codec)+len(e.Data)+24)
+	b = appendStringField(b, pbEnvelopeNodeID, e.NodeID)
+	b = appendStringField(b, pbEnvelopeTo, e.To)
+	b = appendStringField(b, pbEnvelopeSession, e.Session)
+	b = appendStringField(b, pbEnvelopeCodec, e.Codec)
+	b = protowire.AppendTag(b, pbEnvelopeData, protowire.BytesType)
+	return protowire.AppendBytes(b, e.Data)
+}
+
+func unmarshalEnvelope(data []byte) (*brokerEnvelope, error) {
+	env := &brokerEnvelope{}
+	err := consumeFields(data, func(num protowire.Number, value []byte) {
+		switch num {
+		case pbEnvelopeNodeID:
+			env.NodeID = string(value)
+		case pbEnvelopeTo:
+			env.To = string(value)
+		case pbEnvelopeSession:
+			env.Session = string(value)
+		case pbEnvelopeCodec:
+			env.Codec = string(value)
+		case pbEnvelopeData:
+			env.Data = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if env.Session == "" {
+		env.Codec = CodecProtobuf
+	}
+	return env, nil
+}
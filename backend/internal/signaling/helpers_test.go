@@ -0,0 +1,196 @@
+package signaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/handlers"
+	"github.com/allcallall/backend/internal/pubsub"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+// awaitTimeout 等待一条消息的最长时间
+// awaitTimeout bounds how long a client waits for a message.
+const awaitTimeout = 5 * time.Second
+
+// testServer 单节点信令服务，客户端经真实 WebSocket 连接
+// testServer is a single-node signaling stack that clients reach over a real
+// WebSocket.
+type testServer struct {
+	url    string
+	hub    *signaling.Hub
+	calls  *signaling.MemoryCallStore
+	jwt    *auth.Manager
+	nextID uint64
+}
+
+// startServer 启动信令服务；configure 可在启动前为 Hub 挂接依赖
+// startServer starts the signaling stack. configure, if set, attaches
+// dependencies to the hub before it starts.
+func startServer(t *testing.T, configure func(*signaling.Hub)) *testServer {
+	t.Helper()
+	logger := zerolog.Nop()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	calls := signaling.NewMemoryCallStore()
+	hub := signaling.NewHub(pubsub.NewMemory(logger), signaling.NewMemoryRouteRegistry(), logger, nil)
+	hub.WithCallStore(calls)
+	if configure != nil {
+		configure(hub)
+	}
+	if err := hub.Start(ctx); err != nil {
+		t.Fatalf("start hub: %v", err)
+	}
+
+	jwtManager, err := auth.NewManager(auth.Config{
+		Secret:          "signaling-test",
+		Issuer:          "allcall-signaling-test",
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("create jwt manager: %v", err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	protected := router.Group("/api/v1")
+	protected.Use(auth.Middleware(jwtManager))
+	protected.GET("/ws", handlers.NewSignalingHandler(logger, hub).Handle)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	return &testServer{url: srv.URL, hub: hub, calls: calls, jwt: jwtManager}
+}
+
+// awaitHeldBy 等待通话的保持方变为 want
+// awaitHeldBy waits until the stored call is held by exactly want.
+func (s *testServer) awaitHeldBy(t *testing.T, callID string, want ...string) {
+	t.Helper()
+	var held []string
+	for deadline := time.Now().Add(awaitTimeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		call, err := s.calls.Get(context.Background(), callID)
+		if err != nil {
+			t.Fatalf("load call %s: %v", callID, err)
+		}
+		if held = call.HeldBy; slices.Equal(held, want) {
+			return
+		}
+	}
+	t.Errorf("call %s held by %v, want %v", callID, held, want)
+}
+
+// client 一个已连接的信令客户端
+// client is a connected signaling client.
+type client struct {
+	email  string
+	conn   *websocket.Conn
+	frames chan *signaling.SignalMessage
+}
+
+// connect 以 email 的身份建立信令连接并持续读取消息
+// connect opens a signaling socket as email and keeps reading its frames.
+func (s *testServer) connect(t *testing.T, email string) *client {
+	t.Helper()
+	s.nextID++
+	token, err := s.jwt.GenerateAccessToken(s.nextID, email)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	wsURL := strings.Replace(s.url, "http", "ws", 1) + "/api/v1/ws?token=" + token
+	dialer := websocket.Dialer{Subprotocols: []string{signaling.SubprotocolV1}}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial signaling socket: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	c := &client{email: email, conn: conn, frames: make(chan *signaling.SignalMessage, 64)}
+	go func() {
+		defer close(c.frames)
+		for {
+			var msg signaling.SignalMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.frames <- &msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(t *testing.T, msg *signaling.SignalMessage) {
+	t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		t.Fatalf("send %s: %v", msg.Type, err)
+	}
+}
+
+// await 等待指定类型之一的消息，忽略其他消息
+// await waits for a message of one of msgTypes, skipping any other.
+func (c *client) await(t *testing.T, msgTypes ...string) *signaling.SignalMessage {
+	t.Helper()
+	deadline := time.After(awaitTimeout)
+	for {
+		select {
+		case msg, ok := <-c.frames:
+			if !ok {
+				t.Fatalf("%s: signaling socket closed while waiting for %v", c.email, msgTypes)
+			}
+			for _, msgType := range msgTypes {
+				if msg.Type == msgType {
+					return msg
+				}
+			}
+		case <-deadline:
+			t.Fatalf("%s: no %v within %s", c.email, msgTypes, awaitTimeout)
+		}
+	}
+}
+
+// none 在短时间内没有收到该类型的消息
+// none reports whether no message of msgType arrives for a short while.
+func (c *client) none(msgType string) bool {
+	deadline := time.After(300 * time.Millisecond)
+	for {
+		select {
+		case msg := <-c.frames:
+			if msg != nil && msg.Type == msgType {
+				return false
+			}
+		case <-deadline:
+			return true
+		}
+	}
+}
+
+// errorCode 解析错误消息的错误码
+// errorCode decodes the code of an error message.
+func errorCode(t *testing.T, msg *signaling.SignalMessage) signaling.ErrorCode {
+	t.Helper()
+	var payload signaling.ErrorPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		t.Fatalf("decode error payload %s: %v", msg.Payload, err)
+	}
+	return payload.Code
+}
+
+func mustJSON(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
@@ -3,17 +3,21 @@ package signaling
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 
+	"github.com/allcallall/backend/internal/callhistory"
 	"github.com/allcallall/backend/internal/media"
 	"github.com/allcallall/backend/internal/presence"
+	"github.com/allcallall/backend/internal/privacy"
+	"github.com/allcallall/backend/internal/pubsub"
 )
 
 // Hub 管理所有 WebSocket 连接
@@ -21,19 +25,30 @@ import (
 // 现在同时支持 WebSocket 信令和 Pion WebRTC 媒体引擎
 // Now supports both WebSocket signaling and Pion WebRTC media engine
 type Hub struct {
-	redis        *redis.Client
-	logger       zerolog.Logger
-	presence     *presence.Manager
-	mediaEngine  *media.Engine
+	broker      pubsub.Broker
+	routes      RouteRegistry
+	routeTTL    time.Duration
+	logger      zerolog.Logger
+	presence    *presence.Manager
+	mediaEngine *media.Engine
+	adapter     *SignalAdapter
+	calls       CallStore
+	sessions    SessionStore
+	history     *callhistory.Service
+	privacy     *privacy.Service
 
 	mu      sync.RWMutex
 	clients map[string]map[*client]struct{}
 	nodeID  string
+
+	draining atomic.Bool
+	active   sync.WaitGroup
 }
 
 // SignalMessage 信令消息
 // SignalMessage represents the payload exchanged between peers.
 type SignalMessage struct {
+	ID      string          `json:"id,omitempty"`
 	Type    string          `json:"type"`
 	CallID  string          `json:"call_id,omitempty"`
 	To      string          `json:"to"`
@@ -42,129 +57,321 @@ type SignalMessage struct {
 }
 
 const (
-	TypeCallInvite    = "call.invite"
-	TypeCallInviteAck = "call.invite.ack"
-	TypeCallAccept    = "call.accept"
-	TypeCallReject    = "call.reject"
-	TypeCallEnd       = "call.end"
-	TypeIceCandidate  = "ice.candidate"
+	TypeCallInvite      = "call.invite"
+	TypeCallInviteAck   = "call.invite.ack"
+	TypeCallAccept      = "call.accept"
+	TypeCallReject      = "call.reject"
+	TypeCallEnd         = "call.end"
+	TypeIceCandidate    = "ice.candidate"
+	TypeCallChat        = "call.chat"
+	TypeCallReaction    = "call.reaction"
+	TypeCallTyping      = "call.typing"
+	TypeCallHold        = "call.hold"
+	TypeCallResume      = "call.resume"
+	TypeCallRenegotiate = "call.renegotiate"
+	TypeCallTransfer    = "call.transfer"
+	TypeCallUnavailable = "call.unavailable"
+	TypePresenceUpdate  = "presence.update"
+	TypeCallRecordStart = "call.record.start"
+	TypeCallRecordStop  = "call.record.stop"
+	TypeCallRecording   = "call.recording"
+	TypeCallStatsGet    = "call.stats.get"
+	TypeCallStats       = "call.stats"
 )
 
 type client struct {
 	email string
-	conn  *websocket.Conn
-	send  chan []byte
+	// presence 中的连接标识与设备类型
+	// Identity of the connection in presence, with the device type it reported.
+	presence presence.Connection
+	conn     transport
+	codec    Codec
+	send     chan *outbound
+
+	// 握手时由读循环更新，排空和 HTTP 提交时被其他协程读取
+	// Updated on hello, read by other goroutines while draining or matching HTTP submissions.
+	stateMu   sync.Mutex
+	sessionID string
+	version   int
+
+	// 已入队但尚未写出的消息数
+	// Messages queued but not yet written, so drain can wait for them.
+	pending atomic.Int32
+}
+
+// enqueue 非阻塞入队，队列满时返回 false
+// enqueue queues out without blocking and reports false when the queue is full.
+func (cl *client) enqueue(out *outbound) bool {
+	cl.pending.Add(1)
+	select {
+	case cl.send <- out:
+		return true
+	default:
+		cl.pending.Add(-1)
+		return false
+	}
 }
 
-type redisEnvelope struct {
-	NodeID string          `json:"node_id"`
-	Data   json.RawMessage `json:"data"`
+func (cl *client) session() (string, int) {
+	cl.stateMu.Lock()
+	defer cl.stateMu.Unlock()
+	return cl.sessionID, cl.version
+}
+
+func (cl *client) setSession(sessionID string, version int) {
+	cl.stateMu.Lock()
+	defer cl.stateMu.Unlock()
+	cl.sessionID = sessionID
+	cl.version = version
 }
 
 // NewHub 创建 Hub
-// NewHub constructs a signaling hub.
-func NewHub(redis *redis.Client, logger zerolog.Logger, presence *presence.Manager) *Hub {
+// NewHub constructs a signaling hub. Each node subscribes to a single broker
+// topic and routes to users through the route registry.
+func NewHub(broker pubsub.Broker, routes RouteRegistry, logger zerolog.Logger, presence *presence.Manager) *Hub {
 	return &Hub{
-		redis:    redis,
+		broker:   broker,
+		routes:   routes,
+		routeTTL: defaultRouteTTL,
 		logger:   logger.With().Str("component", "signaling_hub").Logger(),
 		presence: presence,
+		calls:    NewMemoryCallStore(),
+		sessions: NewMemorySessionStore(),
 		clients:  make(map[string]map[*client]struct{}),
 		nodeID:   uuid.NewString(),
 	}
 }
 
 // HandleConnection 处理单个连接
-// HandleConnection attaches websocket connection to the hub.
-func (h *Hub) HandleConnection(ctx context.Context, email string, conn *websocket.Conn) {
-	cl := &client{
+// HandleConnection attaches websocket connection to the hub. device is the
+// client's device type as reported to presence.
+func (h *Hub) HandleConnection(ctx context.Context, email, device string, conn *websocket.Conn) {
+	if h.Draining() {
+		closeConn(conn, closeCodeServiceRestart, "server draining")
+		return
+	}
+
+	// 子协议决定编解码器；协商的版本优先于 hello 消息
+	// The subprotocol selects the codec, and its version takes precedence over a later hello.
+	version, c, ok := parseSubprotocol(conn.Subprotocol())
+	if !ok {
+		version, c = DefaultProtocolVersion, jsonCodec
+	}
+	cl := newClient(email, device, &wsTransport{conn: conn, binary: c.Binary()}, c)
+	cl.version = version
+
+	h.serve(ctx, cl, func(ctx context.Context) {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			h.handleClientMessage(ctx, cl, cl.codec, data)
+		}
+	})
+}
+
+func newClient(email, device string, conn transport, c Codec) *client {
+	return &client{
 		email: email,
-		conn:  conn,
-		send:  make(chan []byte, 16),
+		presence: presence.Connection{
+			Email:  email,
+			ID:     uuid.NewString(),
+			Device: presence.NormalizeDevice(device),
+		},
+		sessionID: uuid.NewString(),
+		conn:      conn,
+		codec:     c,
+		send:      make(chan *outbound, 16),
+		version:   DefaultProtocolVersion,
 	}
+}
+
+// serve 登记连接、维护在线状态与路由，并在 run 返回前保持连接
+// serve registers cl with presence and routing, runs its write loop and keeps
+// the connection attached until run returns. Both transports go through here.
+func (h *Hub) serve(ctx context.Context, cl *client, run func(ctx context.Context)) {
+	h.active.Add(1)
+	defer h.active.Done()
 
+	email := cl.email
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	if h.presence != nil {
-		if err := h.presence.SetOnline(ctx, email); err != nil {
+		// 以连接计数：其他设备或节点上仍有连接时不会离线
+		// Presence counts connections, so the user stays online while another
+		// device or node still holds one.
+		if err := h.presence.Connect(ctx, cl.presence, h.routeTTL); err != nil {
 			h.logger.Warn().Err(err).Str("email", email).Msg("failed to mark user online")
 		}
 		defer func() {
 			timeoutCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 			defer cancel()
-			if err := h.presence.SetOffline(timeoutCtx, email); err != nil {
+			if err := h.presence.Disconnect(timeoutCtx, cl.presence); err != nil {
 				h.logger.Warn().Err(err).Str("email", email).Msg("failed to mark user offline")
 			}
 		}()
 	}
 
-	h.addClient(cl)
-	defer h.removeClient(cl)
+	if first := h.addClient(cl); first {
+		if err := h.routes.Register(ctx, email, h.nodeID, h.routeTTL); err != nil {
+			h.logger.Warn().Err(err).Str("email", email).Msg("failed to register signaling route")
+		}
+	}
+	defer func() {
+		if last := h.removeClient(cl); last {
+			timeoutCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := h.routes.Unregister(timeoutCtx, email, h.nodeID); err != nil {
+				h.logger.Warn().Err(err).Str("email", email).Msg("failed to unregister signaling route")
+			}
+		}
+	}()
 
 	go h.writeLoop(ctx, cl)
 
-	// Redis channel for cross-instance delivery.
-	sub := h.redis.Subscribe(ctx, h.channelName(email))
-	defer sub.Close()
-
-	go h.redisForwarder(ctx, sub, cl)
+	run(ctx)
+}
 
-	for {
-		_, data, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
-		if err := h.handleIncoming(ctx, cl, data); err != nil {
-			h.logger.Warn().Err(err).Msg("failed to handle incoming signaling message")
+// handleClientMessage 处理一条客户端消息，协议错误以 error 帧回给该连接
+// handleClientMessage handles one client message and reports protocol errors
+// back to the connection as error frames.
+func (h *Hub) handleClientMessage(ctx context.Context, cl *client, c Codec, data []byte) {
+	msg, err := decodeMessage(c, data)
+	if err == nil {
+		err = h.handleIncoming(ctx, cl, msg)
+	}
+	if err != nil {
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			h.sendError(cl, perr)
 		}
+		h.logger.Warn().Err(err).Msg("failed to handle incoming signaling message")
 	}
 }
 
-func (h *Hub) handleIncoming(ctx context.Context, fromClient *client, data []byte) error {
+func (h *Hub) handleIncoming(ctx context.Context, fromClient *client, msg *SignalMessage) error {
 	if h.presence != nil {
 		if err := h.presence.UpdateLastSeen(ctx, fromClient.email); err != nil {
 			h.logger.Debug().Err(err).Str("email", fromClient.email).Msg("failed to refresh last seen")
 		}
 	}
 
-	var msg SignalMessage
-	if err := json.Unmarshal(data, &msg); err != nil {
-		return fmt.Errorf("decode message: %w", err)
-	}
-	if msg.To == "" {
-		return fmt.Errorf("missing target 'to'")
+	if err := validateClientMessage(msg); err != nil {
+		return err
 	}
 	msg.From = fromClient.email
 
-	ackMsg, err := h.applyProtocolRules(&msg)
+	if msg.Type == TypeHello {
+		return h.handleHello(ctx, fromClient, msg)
+	}
+	if isCallScoped(msg.Type) {
+		if err := h.addressCallMessage(ctx, msg); err != nil {
+			return err
+		}
+	}
+	switch msg.Type {
+	case TypeCallAccept, TypeCallReject, TypeCallEnd:
+		if err := h.authorizeCallControl(ctx, msg); err != nil {
+			return err
+		}
+	}
+	switch msg.Type {
+	case TypeCallTransfer:
+		if err := h.requestTransfer(ctx, msg); err != nil {
+			return err
+		}
+	case TypeCallInvite:
+		if err := h.bindTransfer(ctx, msg); err != nil {
+			return err
+		}
+	case TypeCallRecordStart, TypeCallRecordStop:
+		if msg.To != ServerPeer {
+			return newProtocolError(ErrorCodeRecordingUnavailable, msg.ID, "only server-terminated calls can be recorded")
+		}
+	case TypeCallStatsGet:
+		if msg.To != ServerPeer {
+			return newProtocolError(ErrorCodeMediaUnavailable, msg.ID, "only server-terminated calls report media statistics")
+		}
+	}
+	if msg.To == ServerPeer && h.adapter == nil {
+		return newProtocolError(ErrorCodeMediaUnavailable, msg.ID, "server-terminated calls are not available")
+	}
+
+	ackMsg, err := h.applyProtocolRules(msg)
 	if err != nil {
 		return err
 	}
+	if msg.Type == TypeCallInvite {
+		reachable, err := h.calleeReachable(ctx, msg)
+		if err != nil {
+			return err
+		}
+		if !reachable {
+			h.dispatchLocal(msg.From, newOutbound(unavailableMessage(msg)))
+			return nil
+		}
+	}
 
-	encoded, err := json.Marshal(msg)
-	if err != nil {
+	if err := h.trackCall(ctx, msg); err != nil {
 		return err
 	}
 
-	h.dispatchLocal(msg.To, encoded)
+	if ackMsg != nil {
+		h.dispatchLocal(msg.From, newOutbound(ackMsg))
+	}
 
-	envBytes, err := json.Marshal(redisEnvelope{
-		NodeID: h.nodeID,
-		Data:   encoded,
-	})
+	// 发给服务端的通话由媒体引擎终结
+	// Calls addressed to the server are terminated by the media engine.
+	if msg.To == ServerPeer {
+		return h.adapter.ProcessSignalMessage(ctx, msg)
+	}
+
+	return h.deliver(ctx, msg.To, newOutbound(msg))
+}
+
+// SendToUser 向用户的所有连接发送服务端消息（跨节点）
+// SendToUser delivers a server-originated message to every connection of email, on any node.
+func (h *Hub) SendToUser(ctx context.Context, email string, msg *SignalMessage) error {
+	if msg.To == "" {
+		msg.To = email
+	}
+	return h.deliver(ctx, email, newOutbound(msg))
+}
+
+// deliver 投递到本地连接，并发布到持有该用户连接的其他节点
+// deliver hands data to local connections of target and publishes it to every
+// other node the route registry lists for target.
+func (h *Hub) deliver(ctx context.Context, target string, out *outbound) error {
+	h.dispatchLocal(target, out)
+
+	nodes, err := h.routes.Lookup(ctx, target)
 	if err != nil {
-		return err
+		return fmt.Errorf("lookup route: %w", err)
 	}
 
-	if ackMsg != nil {
-		if ackBytes, err := json.Marshal(ackMsg); err == nil {
-			h.dispatchLocal(msg.From, ackBytes)
-		} else {
-			h.logger.Warn().Err(err).Msg("failed to marshal ack message")
+	var envBytes []byte
+	for _, node := range nodes {
+		if node == h.nodeID {
+			continue
+		}
+		if envBytes == nil {
+			data, err := out.encode(protobufCodec)
+			if err != nil {
+				return err
+			}
+			envBytes = (&brokerEnvelope{
+				NodeID: h.nodeID,
+				To:     target,
+				Data:   data,
+			}).marshal()
+		}
+		if err := h.broker.Publish(ctx, nodeChannel(node), envBytes); err != nil {
+			return fmt.Errorf("publish to node %s: %w", node, err)
 		}
 	}
-
-	return h.redis.Publish(ctx, h.channelName(msg.To), envBytes).Err()
+	return nil
 }
 
 func (h *Hub) applyProtocolRules(msg *SignalMessage) (*SignalMessage, error) {
@@ -174,60 +381,220 @@ func (h *Hub) applyProtocolRules(msg *SignalMessage) (*SignalMessage, error) {
 			msg.CallID = uuid.NewString()
 		}
 		return &SignalMessage{
+			ID:      msg.ID,
 			Type:    TypeCallInviteAck,
 			CallID:  msg.CallID,
 			To:      msg.From,
 			From:    msg.From,
 			Payload: msg.Payload,
 		}, nil
-	case TypeCallAccept, TypeCallReject, TypeCallEnd:
-		if msg.CallID == "" {
-			return nil, fmt.Errorf("call_id required for message type %s", msg.Type)
+	}
+	return nil, nil
+}
+
+// trackCall 根据信令消息更新共享通话状态与通话记录
+// trackCall updates the shared call state from a call message and, once that
+// succeeded, the call history. It refuses an invite whose call_id is already
+// taken.
+func (h *Hub) trackCall(ctx context.Context, msg *SignalMessage) error {
+	var err error
+	switch msg.Type {
+	case TypeCallInvite:
+		call := &Call{
+			ID:        msg.CallID,
+			Caller:    msg.From,
+			Callee:    msg.To,
+			Status:    CallStatusRinging,
+			CreatedAt: time.Now(),
 		}
-	case TypeIceCandidate:
-		if msg.CallID == "" {
-			return nil, fmt.Errorf("call_id required for ice candidate message")
+		if ref := transferRef(msg); ref != nil {
+			call.TransferredFrom = ref.CallID
+			call.ReferredBy = ref.ReferredBy
+			call.Replaces = ref.Replaces
 		}
-		if len(msg.Payload) == 0 {
-			return nil, fmt.Errorf("payload required for ice candidate message")
+		err = h.calls.Create(ctx, call)
+		if errors.Is(err, ErrCallExists) {
+			return newProtocolError(ErrorCodeCallExists, msg.ID, "call %s already exists", msg.CallID)
 		}
-	default:
-		// Legacy types (offer/answer/etc.) are still allowed without additional validation.
+		if err == nil && call.TransferredFrom != "" && call.Replaces == "" {
+			// 盲转：新邀请发出即释放原通话
+			// Blind transfer: the original call is released as soon as the new invite goes out.
+			h.completeTransfer(ctx, call)
+		}
+	case TypeCallAccept:
+		var call *Call
+		if call, err = h.calls.Get(ctx, msg.CallID); err == nil {
+			now := time.Now()
+			call.Status = CallStatusActive
+			call.AnsweredAt = &now
+			if err = h.calls.Save(ctx, call); err == nil {
+				h.markInCall(ctx, call.ID, true, call.Caller, call.Callee)
+			}
+		}
+		if err == nil && call.Replaces != "" {
+			// 咨询转：目标接听后桥接，原通话与咨询通话结束
+			// Attended transfer: once the target answers, the original and consultation calls end.
+			h.completeTransfer(ctx, call)
+		}
+	case TypeCallHold, TypeCallResume:
+		var call *Call
+		if call, err = h.calls.Get(ctx, msg.CallID); err == nil {
+			call.SetHeld(msg.From, msg.Type == TypeCallHold)
+			err = h.calls.Save(ctx, call)
+		}
+	case TypeCallReject, TypeCallEnd:
+		err = h.calls.Delete(ctx, msg.CallID)
+		h.markInCall(ctx, msg.CallID, false, msg.From, msg.To)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrCallNotFound) {
+			h.logger.Warn().Err(err).Str("call_id", msg.CallID).Str("type", msg.Type).Msg("failed to update call state")
+		}
+		return nil
+	}
+	h.recordHistory(ctx, msg)
+	return nil
+}
+
+// handleHello 协商协议版本并回复 hello.ack
+// handleHello negotiates the protocol version, resumes a handed-off session
+// when asked to, and replies with hello.ack.
+func (h *Hub) handleHello(ctx context.Context, cl *client, msg *SignalMessage) error {
+	var hello HelloPayload
+	if err := msg.DecodePayload(&hello); err != nil {
+		return newProtocolError(ErrorCodeInvalidPayload, msg.ID, "decode hello payload: %v", err)
+	}
+
+	version, ok := negotiateVersion(hello.Versions)
+	if !ok {
+		return newProtocolError(ErrorCodeUnsupportedVersion, msg.ID, "none of the offered versions %v are supported", hello.Versions)
+	}
+	if sub := cl.conn.Subprotocol(); sub != "" {
+		if v, _, _ := parseSubprotocol(sub); v != version {
+			return newProtocolError(ErrorCodeUnsupportedVersion, msg.ID, "connection already negotiated %s", sub)
+		}
+	}
+	sessionID, _ := cl.session()
+
+	ackPayload := HelloAckPayload{
+		Version:     version,
+		Subprotocol: subprotocolFor(version, cl.codec),
+		Codec:       cl.codec.Name(),
+	}
+	if hello.Resume != "" {
+		session, err := h.sessions.Take(ctx, hello.Resume)
+		switch {
+		case err == nil && session.Email == cl.email:
+			sessionID = session.ID
+			ackPayload.Resumed = true
+			h.logger.Info().Str("email", cl.email).Str("from_node", session.NodeID).Msg("signaling session resumed")
+		case err == nil, errors.Is(err, ErrSessionNotFound):
+			// 会话不存在或不属于该用户，按新会话处理
+			// Unknown or foreign session: carry on as a fresh one.
+		default:
+			h.logger.Warn().Err(err).Str("email", cl.email).Msg("failed to load handed-off session")
+		}
+	}
+	cl.setSession(sessionID, version)
+	ackPayload.SessionID = sessionID
+	if ackPayload.Resumed {
+		calls, err := h.calls.ListByUser(ctx, cl.email)
+		if err != nil {
+			h.logger.Warn().Err(err).Str("email", cl.email).Msg("failed to load calls for resumed session")
+		}
+		ackPayload.Calls = calls
+	}
+
+	return h.sendHelloAck(cl, msg.ID, &ackPayload)
+}
+
+// sendSessionAck 主动告知连接当前协议版本与会话 ID
+// sendSessionAck announces the connection's current version and session ID unprompted.
+func (h *Hub) sendSessionAck(cl *client) error {
+	sessionID, version := cl.session()
+	return h.sendHelloAck(cl, "", &HelloAckPayload{
+		Version:     version,
+		Subprotocol: subprotocolFor(version, cl.codec),
+		Codec:       cl.codec.Name(),
+		SessionID:   sessionID,
+	})
+}
+
+func (h *Hub) sendHelloAck(cl *client, messageID string, ackPayload *HelloAckPayload) error {
+	payload, err := json.Marshal(ackPayload)
+	if err != nil {
+		return err
+	}
+	h.sendToClient(cl, newOutbound(&SignalMessage{
+		ID:      messageID,
+		Type:    TypeHelloAck,
+		To:      cl.email,
+		Payload: payload,
+	}))
+	return nil
+}
+
+// sendError 向单个连接发送 error 帧
+// sendError reports a rejected message back to the connection that sent it.
+func (h *Hub) sendError(cl *client, perr *ProtocolError) {
+	msg, err := newErrorMessage(cl.email, perr)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to build error frame")
+		return
+	}
+	h.sendToClient(cl, newOutbound(msg))
+}
+
+func (h *Hub) sendToClient(cl *client, out *outbound) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.clients[cl.email][cl]; !ok {
+		return
+	}
+	if !cl.enqueue(out) {
+		h.logger.Warn().Str("email", cl.email).Msg("dropping signaling message due to slow client")
 	}
-	return nil, nil
 }
 
-func (h *Hub) addClient(cl *client) {
+// addClient 注册连接，返回是否为该用户在本节点的第一个连接
+// addClient registers a connection and reports whether it is the user's first on this node.
+func (h *Hub) addClient(cl *client) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	first := false
 	if _, ok := h.clients[cl.email]; !ok {
 		h.clients[cl.email] = make(map[*client]struct{})
+		first = true
 	}
 	h.clients[cl.email][cl] = struct{}{}
 	h.logger.Info().Str("email", cl.email).Msg("client connected")
+	return first
 }
 
-func (h *Hub) removeClient(cl *client) {
+// removeClient 移除连接，返回是否为该用户在本节点的最后一个连接
+// removeClient drops a connection and reports whether it was the user's last on this node.
+func (h *Hub) removeClient(cl *client) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	last := false
 	if conns, ok := h.clients[cl.email]; ok {
 		delete(conns, cl)
 		if len(conns) == 0 {
 			delete(h.clients, cl.email)
+			last = true
 		}
 	}
 	close(cl.send)
 	_ = cl.conn.Close()
 	h.logger.Info().Str("email", cl.email).Msg("client disconnected")
+	return last
 }
 
-func (h *Hub) dispatchLocal(target string, payload []byte) {
+func (h *Hub) dispatchLocal(target string, out *outbound) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	for cl := range h.clients[target] {
-		select {
-		case cl.send <- payload:
-		default:
+		if !cl.enqueue(out) {
 			h.logger.Warn().Str("email", target).Msg("dropping signaling message due to slow client")
 		}
 	}
@@ -238,11 +605,16 @@ func (h *Hub) writeLoop(ctx context.Context, cl *client) {
 		select {
 		case <-ctx.Done():
 			return
-		case msg, ok := <-cl.send:
+		case out, ok := <-cl.send:
 			if !ok {
 				return
 			}
-			if err := cl.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			data, err := out.encode(cl.codec)
+			if err == nil {
+				err = cl.conn.WriteMessage(data)
+			}
+			cl.pending.Add(-1)
+			if err != nil {
 				h.logger.Warn().Err(err).Str("email", cl.email).Msg("write message failed")
 				return
 			}
@@ -250,33 +622,114 @@ func (h *Hub) writeLoop(ctx context.Context, cl *client) {
 	}
 }
 
-func (h *Hub) redisForwarder(ctx context.Context, sub *redis.PubSub, cl *client) {
-	ch := sub.Channel()
+// Start 订阅本节点的投递通道并启动路由续期
+// Start subscribes to this node's channel and keeps its routes alive until ctx ends.
+// It must be called before the hub accepts connections.
+func (h *Hub) Start(ctx context.Context) error {
+	sub, err := h.broker.Subscribe(ctx, nodeChannel(h.nodeID))
+	if err != nil {
+		return fmt.Errorf("subscribe node channel: %w", err)
+	}
+	go h.nodeDispatcher(ctx, sub)
+	go h.refreshRoutes(ctx)
+	h.logger.Info().Str("node_id", h.nodeID).Msg("signaling node started")
+	return nil
+}
+
+// NodeID 返回本节点标识
+// NodeID returns this node's identifier.
+func (h *Hub) NodeID() string {
+	return h.nodeID
+}
+
+// nodeDispatcher 将节点通道上的消息分发给本地连接
+// nodeDispatcher fans messages from the node channel out to local connections.
+func (h *Hub) nodeDispatcher(ctx context.Context, sub pubsub.Subscription) {
+	defer sub.Close()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg, ok := <-ch:
+		case msg, ok := <-sub.Messages():
 			if !ok {
 				return
 			}
-			var env redisEnvelope
-			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
-				h.logger.Warn().Err(err).Msg("failed to decode redis envelope")
-				continue
+			env, err := unmarshalEnvelope(msg.Data)
+			if err != nil {
+				h.logger.Warn().Err(err).Msg("failed to decode broker envelope")
+			} else if env.NodeID != h.nodeID {
+				if env.Session != "" {
+					h.handleForwarded(ctx, env)
+				} else {
+					h.dispatchRemote(env)
+				}
+			}
+			if err := msg.Ack(ctx); err != nil {
+				h.logger.Debug().Err(err).Msg("failed to ack broker message")
 			}
-			if env.NodeID == h.nodeID {
-				continue
+		}
+	}
+}
+
+// dispatchRemote 将其他节点投递来的消息交给本地连接，复用其编码
+// dispatchRemote hands a message from another node to local connections,
+// reusing its encoding for clients on the same codec.
+func (h *Hub) dispatchRemote(env *brokerEnvelope) {
+	c, ok := CodecByName(env.Codec)
+	if !ok {
+		h.logger.Warn().Str("codec", env.Codec).Msg("unknown codec in broker envelope")
+		return
+	}
+	msg, err := c.Decode(env.Data)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to decode relayed message")
+		return
+	}
+	h.dispatchLocal(env.To, newOutboundEncoded(msg, c, env.Data))
+}
+
+// refreshRoutes 定期续期本节点所有用户的路由
+// refreshRoutes periodically extends the routes of every user connected to this node.
+func (h *Hub) refreshRoutes(ctx context.Context) {
+	ticker := time.NewTicker(h.routeTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			emails := h.localUsers()
+			if err := h.routes.Refresh(ctx, h.nodeID, emails, h.routeTTL); err != nil {
+				h.logger.Warn().Err(err).Int("users", len(emails)).Msg("failed to refresh signaling routes")
 			}
-			select {
-			case cl.send <- env.Data:
-			default:
-				h.logger.Warn().Str("email", cl.email).Msg("drop redis message due to slow client")
+			if h.presence != nil {
+				conns := h.localConnections()
+				if err := h.presence.Heartbeat(ctx, conns, h.routeTTL); err != nil {
+					h.logger.Warn().Err(err).Int("connections", len(conns)).Msg("failed to heartbeat presence connections")
+				}
 			}
 		}
 	}
 }
 
-func (h *Hub) channelName(email string) string {
-	return fmt.Sprintf("signal:%s", email)
+func (h *Hub) localConnections() []presence.Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	conns := make([]presence.Connection, 0, len(h.clients))
+	for _, set := range h.clients {
+		for cl := range set {
+			conns = append(conns, cl.presence)
+		}
+	}
+	return conns
+}
+
+func (h *Hub) localUsers() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	emails := make([]string, 0, len(h.clients))
+	for email := range h.clients {
+		emails = append(emails, email)
+	}
+	return emails
 }
@@ -0,0 +1,120 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/allcallall/backend/internal/callhistory"
+	"github.com/allcallall/backend/internal/models"
+)
+
+// WithCallHistory 记录通话生命周期与通话内消息
+// WithCallHistory records call lifecycle events and in-call messages to svc.
+func (h *Hub) WithCallHistory(svc *callhistory.Service) {
+	h.history = svc
+}
+
+// addressCallMessage 校验发送者在进行中的通话里，并投递给对方
+// addressCallMessage checks that the sender takes part in the active call
+// named by msg and addresses the message to the other participant.
+func (h *Hub) addressCallMessage(ctx context.Context, msg *SignalMessage) error {
+	call, err := h.calls.Get(ctx, msg.CallID)
+	if err != nil {
+		if errors.Is(err, ErrCallNotFound) {
+			return newProtocolError(ErrorCodeNotInCall, msg.ID, "call %s is not active", msg.CallID)
+		}
+		return err
+	}
+	if call.Status != CallStatusActive || !call.HasParticipant(msg.From) {
+		return newProtocolError(ErrorCodeNotInCall, msg.ID, "not a participant of active call %s", msg.CallID)
+	}
+	peer := call.Peer(msg.From)
+	if msg.To != "" && msg.To != peer {
+		return newProtocolError(ErrorCodeNotInCall, msg.ID, "%s is not in call %s", msg.To, msg.CallID)
+	}
+	msg.To = peer
+	return nil
+}
+
+// authorizeCallControl 校验发送者可以接听、拒绝或结束该通话，并投递给对方
+// authorizeCallControl checks that the sender may accept, reject or end the
+// call named by msg: only the callee answers or declines, and either
+// participant hangs up. The message is addressed to the other participant.
+func (h *Hub) authorizeCallControl(ctx context.Context, msg *SignalMessage) error {
+	call, err := h.calls.Get(ctx, msg.CallID)
+	if err != nil {
+		if errors.Is(err, ErrCallNotFound) {
+			return newProtocolError(ErrorCodeNotInCall, msg.ID, "call %s does not exist", msg.CallID)
+		}
+		return err
+	}
+	allowed := call.HasParticipant(msg.From)
+	if msg.Type == TypeCallAccept || msg.Type == TypeCallReject {
+		allowed = msg.From == call.Callee
+	}
+	if !allowed {
+		return newProtocolError(ErrorCodeNotInCall, msg.ID, "%s may not send %s for call %s", msg.From, msg.Type, msg.CallID)
+	}
+	msg.To = call.Peer(msg.From)
+	return nil
+}
+
+// recordHistory 将消息写入通话记录；未配置时忽略
+// recordHistory writes msg to the call history, if one is configured.
+func (h *Hub) recordHistory(ctx context.Context, msg *SignalMessage) {
+	if h.history == nil {
+		return
+	}
+	now := time.Now()
+	var err error
+	switch msg.Type {
+	case TypeCallInvite:
+		err = h.history.Start(ctx, msg.CallID, msg.From, msg.To, now)
+		if ref := transferRef(msg); err == nil && ref != nil {
+			err = h.history.LinkTransfer(ctx, msg.CallID, ref.CallID, ref.ReferredBy)
+		}
+	case TypeCallAccept:
+		err = h.history.Answer(ctx, msg.CallID, msg.From, now)
+	case TypeCallReject:
+		err = h.history.Reject(ctx, msg.CallID, msg.From, callReason(msg), now)
+	case TypeCallEnd:
+		err = h.history.End(ctx, msg.CallID, msg.From, callReason(msg), now)
+	case TypeCallChat:
+		var chat ChatPayload
+		if err = msg.DecodePayload(&chat); err == nil {
+			err = h.history.AddMessage(ctx, msg.CallID, msg.From, models.CallMessageChat, chat.Text, now)
+		}
+	case TypeCallReaction:
+		var reaction ReactionPayload
+		if err = msg.DecodePayload(&reaction); err == nil {
+			err = h.history.AddMessage(ctx, msg.CallID, msg.From, models.CallMessageReaction, reaction.Emoji, now)
+		}
+	}
+	if err != nil && !errors.Is(err, callhistory.ErrNotFound) {
+		h.logger.Warn().Err(err).Str("call_id", msg.CallID).Str("type", msg.Type).Msg("failed to record call history")
+	}
+}
+
+// markInCall 通话接通或结束时更新参与方的“通话中”状态
+// markInCall sets or clears the "in a call" presence of the call's parties
+// when it is answered or ends.
+func (h *Hub) markInCall(ctx context.Context, callID string, inCall bool, parties ...string) {
+	if h.presence == nil {
+		return
+	}
+	for _, email := range parties {
+		if email == "" || email == ServerPeer {
+			continue
+		}
+		if err := h.presence.SetInCall(ctx, email, callID, inCall); err != nil {
+			h.logger.Warn().Err(err).Str("call_id", callID).Str("email", email).Msg("failed to update in-call presence")
+		}
+	}
+}
+
+func callReason(msg *SignalMessage) string {
+	var reason CallReasonPayload
+	_ = msg.DecodePayload(&reason)
+	return reason.Reason
+}
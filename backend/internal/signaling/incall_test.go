@@ -0,0 +1,108 @@
+package signaling_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+// TestCallControlAuthorization 通话 ID 不可被抢占，只有被叫能接听或拒绝，只有参与者能挂断
+// TestCallControlAuthorization checks that a call_id in use cannot be taken
+// over, only the callee answers or declines, and only the participants hang up.
+func TestCallControlAuthorization(t *testing.T) {
+	const callID = "callauth"
+	srv := startServer(t, nil)
+	alice := srv.connect(t, "alice@example.com")
+	bob := srv.connect(t, "bob@example.com")
+	mallory := srv.connect(t, "mallory@example.com")
+	offer := mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: "v=0"})
+	answer := mustJSON(t, signaling.SessionDescriptionPayload{Type: "answer", SDP: "v=0"})
+
+	alice.send(t, &signaling.SignalMessage{ID: "1", Type: signaling.TypeCallInvite, CallID: callID, To: bob.email, Payload: offer})
+	if invite := bob.await(t, signaling.TypeCallInvite); invite.CallID != callID || invite.From != alice.email {
+		t.Fatalf("callee got invite for %s from %s", invite.CallID, invite.From)
+	}
+	mallory.send(t, &signaling.SignalMessage{ID: "2", Type: signaling.TypeCallInvite, CallID: callID, To: bob.email, Payload: offer})
+	if refused := mallory.await(t, signaling.TypeError, signaling.TypeCallInviteAck); refused.Type != signaling.TypeError || errorCode(t, refused) != signaling.ErrorCodeCallExists {
+		t.Errorf("invite reusing a call_id in progress got %s %s", refused.Type, refused.Payload)
+	}
+
+	// 通话之外的用户不能控制通话
+	// Someone outside the call cannot control it.
+	for _, msgType := range []string{signaling.TypeCallAccept, signaling.TypeCallReject, signaling.TypeCallEnd} {
+		payload := json.RawMessage(nil)
+		if msgType == signaling.TypeCallAccept {
+			payload = answer
+		}
+		mallory.send(t, &signaling.SignalMessage{ID: msgType, Type: msgType, CallID: callID, To: alice.email, Payload: payload})
+		if code := errorCode(t, mallory.await(t, signaling.TypeError)); code != signaling.ErrorCodeNotInCall {
+			t.Errorf("%s from a stranger refused with %q", msgType, code)
+		}
+	}
+	alice.send(t, &signaling.SignalMessage{ID: "3", Type: signaling.TypeCallAccept, CallID: callID, To: bob.email, Payload: answer})
+	if code := errorCode(t, alice.await(t, signaling.TypeError)); code != signaling.ErrorCodeNotInCall {
+		t.Errorf("caller accepting its own call refused with %q", code)
+	}
+	alice.send(t, &signaling.SignalMessage{ID: "4", Type: signaling.TypeCallReject, CallID: callID, To: bob.email})
+	if code := errorCode(t, alice.await(t, signaling.TypeError)); code != signaling.ErrorCodeNotInCall {
+		t.Errorf("caller rejecting its own call refused with %q", code)
+	}
+
+	// 参与者的控制消息送达对方，收件人以通话记录为准
+	// The participants' control messages reach each other, whatever they address.
+	bob.send(t, &signaling.SignalMessage{ID: "5", Type: signaling.TypeCallAccept, CallID: callID, To: mallory.email, Payload: answer})
+	if accept := alice.await(t, signaling.TypeCallAccept); accept.CallID != callID || accept.From != bob.email {
+		t.Errorf("caller got accept for %s from %s", accept.CallID, accept.From)
+	}
+	if !mallory.none(signaling.TypeCallAccept) {
+		t.Error("accept addressed to a stranger reached them")
+	}
+	mallory.send(t, &signaling.SignalMessage{ID: "6", Type: signaling.TypeCallEnd, CallID: callID, To: bob.email})
+	mallory.await(t, signaling.TypeError)
+	if !bob.none(signaling.TypeCallEnd) {
+		t.Error("a stranger's end reached the callee")
+	}
+	alice.send(t, &signaling.SignalMessage{ID: "7", Type: signaling.TypeCallEnd, CallID: callID, To: bob.email})
+	if end := bob.await(t, signaling.TypeCallEnd); end.CallID != callID || end.From != alice.email {
+		t.Errorf("callee got end for %s from %s", end.CallID, end.From)
+	}
+}
+
+// TestHoldAndResume 保持与恢复送达对方并记录在通话上
+// TestHoldAndResume checks that hold and resume reach the other participant
+// with their sender and are recorded on the stored call.
+func TestHoldAndResume(t *testing.T) {
+	const callID = "hold"
+	srv := startServer(t, nil)
+	alice := srv.connect(t, "alice@example.com")
+	bob := srv.connect(t, "bob@example.com")
+
+	alice.send(t, &signaling.SignalMessage{Type: signaling.TypeCallInvite, CallID: callID, To: bob.email,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: "v=0"})})
+	bob.await(t, signaling.TypeCallInvite)
+	bob.send(t, &signaling.SignalMessage{Type: signaling.TypeCallAccept, CallID: callID, To: alice.email,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "answer", SDP: "v=0"})})
+	alice.await(t, signaling.TypeCallAccept)
+
+	alice.send(t, &signaling.SignalMessage{Type: signaling.TypeCallHold, CallID: callID})
+	if hold := bob.await(t, signaling.TypeCallHold); hold.CallID != callID || hold.From != alice.email {
+		t.Errorf("callee got hold for %s from %s", hold.CallID, hold.From)
+	}
+	srv.awaitHeldBy(t, callID, alice.email)
+
+	bob.send(t, &signaling.SignalMessage{Type: signaling.TypeCallHold, CallID: callID})
+	if hold := alice.await(t, signaling.TypeCallHold); hold.From != bob.email {
+		t.Errorf("caller got hold from %s", hold.From)
+	}
+	srv.awaitHeldBy(t, callID, alice.email, bob.email)
+
+	alice.send(t, &signaling.SignalMessage{Type: signaling.TypeCallResume, CallID: callID})
+	if resume := bob.await(t, signaling.TypeCallResume); resume.From != alice.email {
+		t.Errorf("callee got resume from %s", resume.From)
+	}
+	srv.awaitHeldBy(t, callID, bob.email)
+	bob.send(t, &signaling.SignalMessage{Type: signaling.TypeCallResume, CallID: callID})
+	alice.await(t, signaling.TypeCallResume)
+	srv.awaitHeldBy(t, callID)
+}
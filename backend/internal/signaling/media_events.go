@@ -0,0 +1,74 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// consumeMediaEvents 处理媒体引擎事件，直到订阅结束
+// consumeMediaEvents acts on the media engine's events until the subscription
+// ends. Candidates and renegotiations are handled in order, as they arrive.
+func (h *Hub) consumeMediaEvents(sub *media.Subscription) {
+	for event := range sub.Events() {
+		switch event.Type {
+		case media.EventICECandidate:
+			h.relayICECandidate(event.PeerConnection, event.Candidate)
+		case media.EventNegotiationNeeded:
+			h.relayNegotiation(event.PeerConnection)
+		case media.EventStateChanged:
+			// 连接失败或关闭而未收到挂断时，也结束其录制
+			// Finish the recording when the connection fails or closes
+			// without a hang-up, so the files and manifest are complete.
+			if event.State == webrtc.PeerConnectionStateFailed || event.State == webrtc.PeerConnectionStateClosed {
+				go func(peerConn *media.PeerConnection) {
+					ctx, cancel := context.WithTimeout(context.Background(), serverSendTimeout)
+					defer cancel()
+					h.finishRecording(ctx, peerConn.CallID, peerConn.LocalEmail)
+				}(event.PeerConnection)
+			}
+		}
+	}
+}
+
+// relayICECandidate 将 Pion 收集到的候选推送给客户端
+// relayICECandidate trickles a candidate gathered by Pion to the client
+func (h *Hub) relayICECandidate(peerConn *media.PeerConnection, candidate *webrtc.ICECandidate) {
+	init := candidate.ToJSON()
+	payload, err := json.Marshal(ICECandidatePayload{
+		Candidate:     init.Candidate,
+		SDPMLineIndex: init.SDPMLineIndex,
+		SDPMid:        init.SDPMid,
+	})
+	if err != nil {
+		h.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to marshal ice candidate")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverSendTimeout)
+	defer cancel()
+	err = h.SendToUser(ctx, peerConn.LocalEmail, &SignalMessage{
+		Type:    TypeIceCandidate,
+		CallID:  peerConn.CallID,
+		To:      peerConn.LocalEmail,
+		From:    peerConn.RemoteEmail,
+		Payload: payload,
+	})
+	if err != nil {
+		h.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to relay ice candidate")
+	}
+}
+
+// relayNegotiation 服务端增删轨道后以 call.renegotiate 向客户端发起新的 offer
+// relayNegotiation sends the client a fresh offer as call.renegotiate after
+// the engine added or removed a track on the peer connection.
+func (h *Hub) relayNegotiation(peerConn *media.PeerConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverSendTimeout)
+	defer cancel()
+	if err := h.renegotiate(ctx, peerConn); err != nil {
+		h.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to renegotiate")
+	}
+}
@@ -3,7 +3,9 @@ package signaling
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 
@@ -31,10 +33,10 @@ type PionSignalMessage struct {
 // ICECandidatePayload 包含 ICE 候选的信息
 // ICECandidatePayload contains ICE candidate information
 type ICECandidatePayload struct {
-	Candidate        string `json:"candidate"`
+	Candidate        string  `json:"candidate"`
 	SDPMLineIndex    *uint16 `json:"sdpMLineIndex"`
 	SDPMid           *string `json:"sdpMid"`
-	UsernameFragment string `json:"usernameFragment,omitempty"`
+	UsernameFragment string  `json:"usernameFragment,omitempty"`
 }
 
 // MediaCommandType 定义媒体命令类型
@@ -63,10 +65,21 @@ const (
 	MediaCommandGetStats MediaCommandType = "get_stats"
 )
 
+// ServerPeer 服务端终结通话时使用的对端地址
+// ServerPeer is the address clients call to have the media engine terminate
+// the call. It can never collide with a user, since it is not an email.
+const ServerPeer = "server"
+
+// serverSendTimeout 媒体引擎回调中发送信令的超时
+// serverSendTimeout bounds signaling sends made from media engine callbacks.
+const serverSendTimeout = 5 * time.Second
+
 // WithMediaEngine 附加媒体引擎到 Hub
 // WithMediaEngine attaches a Pion media engine to the signaling hub
 func (h *Hub) WithMediaEngine(engine *media.Engine) {
 	h.mediaEngine = engine
+	h.adapter = NewSignalAdapter(h.logger, h)
+	go h.consumeMediaEvents(engine.Subscribe(context.Background()))
 	h.logger.Info().Msg("media engine attached to signaling hub")
 }
 
@@ -86,27 +99,20 @@ func (h *Hub) HandlePionMessage(ctx context.Context, callID, localEmail, remoteE
 
 	// 获取或创建对等连接
 	// Get or create peer connection
-	peerConn, err := h.mediaEngine.GetPeerConnection(callID, localEmail, remoteEmail)
+	peerConn, err := h.peerConnectionFor(ctx, callID, localEmail, remoteEmail)
 	if err != nil {
-		// 如果连接不存在，尝试创建
-		// If connection doesn't exist, try to create it
-		peerConn, err = h.mediaEngine.CreatePeerConnection(
-			ctx,
-			callID,
-			localEmail,
-			remoteEmail,
-			&webrtc.Configuration{},
-		)
-		if err != nil {
-			return fmt.Errorf("create peer connection: %w", err)
-		}
+		return err
 	}
 
 	// 根据消息类型处理
 	// Handle based on message type
 	switch msgType {
 	case "offer":
-		return h.handleOffer(ctx, peerConn, pionMsg.SDP)
+		answer, err := h.handleOffer(ctx, peerConn, pionMsg.SDP)
+		if err != nil {
+			return err
+		}
+		return h.sendAnswer(ctx, peerConn, answer)
 
 	case "answer":
 		return h.handleAnswer(ctx, peerConn, pionMsg.SDP)
@@ -122,9 +128,36 @@ func (h *Hub) HandlePionMessage(ctx context.Context, callID, localEmail, remoteE
 	}
 }
 
-// handleOffer 处理 WebRTC offer
-// handleOffer processes a WebRTC offer
-func (h *Hub) handleOffer(ctx context.Context, peerConn *media.PeerConnection, sdp string) error {
+// peerConnectionFor 获取或创建对等连接；并发创建时使用先创建的连接
+// peerConnectionFor gets or creates a peer connection. When a concurrent
+// request created it first, that connection is returned.
+func (h *Hub) peerConnectionFor(ctx context.Context, callID, localEmail, remoteEmail string) (*media.PeerConnection, error) {
+	peerConn, err := h.mediaEngine.GetPeerConnection(callID, localEmail, remoteEmail)
+	if err == nil {
+		return peerConn, nil
+	}
+
+	// 如果连接不存在，尝试创建
+	// If connection doesn't exist, try to create it
+	peerConn, err = h.mediaEngine.CreatePeerConnection(
+		ctx,
+		callID,
+		localEmail,
+		remoteEmail,
+		nil,
+	)
+	if errors.Is(err, media.ErrPeerConnectionExists) {
+		return h.mediaEngine.GetPeerConnection(callID, localEmail, remoteEmail)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create peer connection: %w", err)
+	}
+	return peerConn, nil
+}
+
+// handleOffer 处理 WebRTC offer，返回本地 answer
+// handleOffer processes a WebRTC offer and returns the local answer
+func (h *Hub) handleOffer(ctx context.Context, peerConn *media.PeerConnection, sdp string) (*webrtc.SessionDescription, error) {
 	// 设置远程描述为 offer
 	// Set remote description as offer
 	err := peerConn.PC.SetRemoteDescription(webrtc.SessionDescription{
@@ -132,30 +165,118 @@ func (h *Hub) handleOffer(ctx context.Context, peerConn *media.PeerConnection, s
 		SDP:  sdp,
 	})
 	if err != nil {
-		return fmt.Errorf("set remote description: %w", err)
+		return nil, fmt.Errorf("set remote description: %w", err)
 	}
 
-	peerConn.State = media.CallStateAnswering
+	peerConn.SetState(media.CallStateAnswering)
+	if err := h.mediaEngine.PreferCodecs(peerConn); err != nil {
+		return nil, err
+	}
 
 	// 创建答案
 	// Create answer
 	answer, err := peerConn.PC.CreateAnswer(nil)
 	if err != nil {
-		return fmt.Errorf("create answer: %w", err)
+		return nil, fmt.Errorf("create answer: %w", err)
 	}
 
 	// 设置本地描述为答案
 	// Set local description as answer
 	err = peerConn.PC.SetLocalDescription(answer)
 	if err != nil {
-		return fmt.Errorf("set local description: %w", err)
+		return nil, fmt.Errorf("set local description: %w", err)
 	}
 
 	h.logger.Debug().
 		Str("call_id", peerConn.CallID).
 		Msg("offer handled, answer created")
 
-	return nil
+	return &answer, nil
+}
+
+// sendAnswer 以 call.accept 将 answer 发回客户端
+// sendAnswer returns the engine's answer to the client as call.accept
+func (h *Hub) sendAnswer(ctx context.Context, peerConn *media.PeerConnection, answer *webrtc.SessionDescription) error {
+	payload, err := json.Marshal(SessionDescriptionPayload{
+		Type: answer.Type.String(),
+		SDP:  answer.SDP,
+	})
+	if err != nil {
+		return err
+	}
+	msg := &SignalMessage{
+		Type:    TypeCallAccept,
+		CallID:  peerConn.CallID,
+		To:      peerConn.LocalEmail,
+		From:    peerConn.RemoteEmail,
+		Payload: payload,
+	}
+	h.trackCall(ctx, msg)
+	return h.SendToUser(ctx, peerConn.LocalEmail, msg)
+}
+
+// renegotiate 创建并发送重新协商的 offer
+// renegotiate creates a new offer and sends it to the client.
+func (h *Hub) renegotiate(ctx context.Context, peerConn *media.PeerConnection) error {
+	// 初次协商完成前或协商进行中不发起；Pion 回到 stable 后会再次触发
+	// Wait for the first negotiation and any exchange in flight to finish;
+	// Pion fires again once the connection is back in the stable state.
+	if peerConn.PC.RemoteDescription() == nil || peerConn.PC.SignalingState() != webrtc.SignalingStateStable {
+		return nil
+	}
+
+	if err := h.mediaEngine.PreferCodecs(peerConn); err != nil {
+		return err
+	}
+	offer, err := peerConn.PC.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("create offer: %w", err)
+	}
+	if err := peerConn.PC.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	return h.sendRenegotiation(ctx, peerConn, &offer)
+}
+
+// handleRenegotiation 处理客户端的重新协商；双方同时发起 offer 时服务端回滚自己的 offer
+// handleRenegotiation applies a call.renegotiate description from the client.
+// When both sides sent an offer at once, the server rolls its own back and answers.
+func (h *Hub) handleRenegotiation(ctx context.Context, peerConn *media.PeerConnection, desc SessionDescriptionPayload) error {
+	if desc.Type == "answer" {
+		return h.handleAnswer(ctx, peerConn, desc.SDP)
+	}
+
+	if peerConn.PC.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := peerConn.PC.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return fmt.Errorf("roll back local offer: %w", err)
+		}
+	}
+	state := peerConn.State()
+	answer, err := h.handleOffer(ctx, peerConn, desc.SDP)
+	if err != nil {
+		return err
+	}
+	peerConn.SetState(state)
+	return h.sendRenegotiation(ctx, peerConn, answer)
+}
+
+// sendRenegotiation 以 call.renegotiate 发送 offer 或 answer
+// sendRenegotiation sends an offer or answer to the client as call.renegotiate.
+func (h *Hub) sendRenegotiation(ctx context.Context, peerConn *media.PeerConnection, desc *webrtc.SessionDescription) error {
+	payload, err := json.Marshal(SessionDescriptionPayload{
+		Type: desc.Type.String(),
+		SDP:  desc.SDP,
+	})
+	if err != nil {
+		return err
+	}
+	return h.SendToUser(ctx, peerConn.LocalEmail, &SignalMessage{
+		Type:    TypeCallRenegotiate,
+		CallID:  peerConn.CallID,
+		To:      peerConn.LocalEmail,
+		From:    peerConn.RemoteEmail,
+		Payload: payload,
+	})
 }
 
 // handleAnswer 处理 WebRTC answer
@@ -171,7 +292,7 @@ func (h *Hub) handleAnswer(ctx context.Context, peerConn *media.PeerConnection,
 		return fmt.Errorf("set remote description: %w", err)
 	}
 
-	peerConn.State = media.CallStateActive
+	peerConn.SetState(media.CallStateActive)
 
 	h.logger.Debug().
 		Str("call_id", peerConn.CallID).
@@ -235,9 +356,9 @@ func (h *Hub) handleMediaCommand(ctx context.Context, peerConn *media.PeerConnec
 		return nil
 
 	case MediaCommandGetStats:
-		// 获取统计信息 - 将在未来实现
-		// Get statistics - to be implemented in future
-		return nil
+		// 以 call.stats 回复服务端测得的媒体统计
+		// Reply with the media statistics the server measures, as call.stats
+		return h.sendStats(ctx, peerConn)
 
 	default:
 		return fmt.Errorf("unknown media command: %s", command)
@@ -252,6 +373,9 @@ func (h *Hub) CreateOffer(ctx context.Context, callID, localEmail, remoteEmail s
 		return "", fmt.Errorf("get peer connection: %w", err)
 	}
 
+	if err := h.mediaEngine.PreferCodecs(peerConn); err != nil {
+		return "", err
+	}
 	offer, err := peerConn.PC.CreateOffer(nil)
 	if err != nil {
 		return "", fmt.Errorf("create offer: %w", err)
@@ -262,7 +386,7 @@ func (h *Hub) CreateOffer(ctx context.Context, callID, localEmail, remoteEmail s
 		return "", fmt.Errorf("set local description: %w", err)
 	}
 
-	peerConn.State = media.CallStateOffering
+	peerConn.SetState(media.CallStateOffering)
 
 	return offer.SDP, nil
 }
@@ -278,12 +402,13 @@ func (h *Hub) GetConnectionStats(callID, localEmail, remoteEmail string) (*Conne
 	// 基本状态信息
 	// Basic status information
 	stats := &ConnectionStats{
-		CallID:       callID,
-		LocalEmail:   localEmail,
-		RemoteEmail:  remoteEmail,
-		State:        peerConn.State,
-		PCState:      peerConn.PC.ConnectionState().String(),
+		CallID:         callID,
+		LocalEmail:     localEmail,
+		RemoteEmail:    remoteEmail,
+		State:          peerConn.State(),
+		PCState:        peerConn.PC.ConnectionState().String(),
 		SignalingState: peerConn.PC.SignalingState().String(),
+		Media:          h.mediaEngine.CollectStats(peerConn),
 	}
 
 	return stats, nil
@@ -298,4 +423,5 @@ type ConnectionStats struct {
 	State          media.CallState
 	PCState        string
 	SignalingState string
+	Media          *media.Stats
 }
@@ -0,0 +1,357 @@
+package signaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	pionmedia "github.com/pion/webrtc/v4/pkg/media"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+// connectTimeout 客户端对等连接建立的最长时间
+// connectTimeout bounds how long the client peer connection may take to connect.
+const connectTimeout = 20 * time.Second
+
+// TestServerTerminatedCall Pion 客户端经真实 WebSocket 与服务端媒体引擎协商
+// TestServerTerminatedCall runs a Pion client against the server media engine
+// over a real WebSocket: offer, answer, trickled candidates both ways,
+// connected, media statistics, hold, renegotiation from either side, hang up.
+func TestServerTerminatedCall(t *testing.T) {
+	var engine *media.Engine
+	srv := startServer(t, func(hub *signaling.Hub) {
+		var err error
+		if engine, err = signaling.InitPionMediaEngine(zerolog.Nop(), config.WebRTCConfig{}); err != nil {
+			t.Fatalf("init media engine: %v", err)
+		}
+		t.Cleanup(func() { _ = engine.Shutdown(context.Background()) })
+		hub.WithMediaEngine(engine)
+	})
+	caller := srv.connect(t, "alice@example.com")
+	pc, candidates, connected := newClientPeer(t)
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set local description: %v", err)
+	}
+	caller.send(t, &signaling.SignalMessage{
+		ID:      "1",
+		Type:    signaling.TypeCallInvite,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: offer.SDP}),
+	})
+
+	// 协商：候选在收到 call_id 前暂存
+	// Negotiation. Candidates wait for the call_id before they are sent.
+	var (
+		callID           string
+		pending          []webrtc.ICECandidateInit
+		gotAnswer        bool
+		serverCandidates int
+	)
+	deadline := time.After(connectTimeout)
+	for isConnected := false; !isConnected; {
+		select {
+		case msg, ok := <-caller.frames:
+			if !ok {
+				t.Fatal("signaling socket closed during negotiation")
+			}
+			switch msg.Type {
+			case signaling.TypeCallInviteAck:
+				if callID = msg.CallID; callID == "" {
+					t.Fatal("invite acknowledged without a call_id")
+				}
+				for _, c := range pending {
+					sendCandidate(t, caller, callID, c)
+				}
+				pending = nil
+			case signaling.TypeCallAccept:
+				var answer signaling.SessionDescriptionPayload
+				_ = json.Unmarshal(msg.Payload, &answer)
+				if msg.From != signaling.ServerPeer || answer.Type != "answer" {
+					t.Fatalf("got %s from %s instead of the engine's answer", answer.Type, msg.From)
+				}
+				if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+					t.Fatalf("set remote description: %v", err)
+				}
+				gotAnswer = true
+			case signaling.TypeIceCandidate:
+				var c webrtc.ICECandidateInit
+				_ = json.Unmarshal(msg.Payload, &c)
+				if err := pc.AddICECandidate(c); err != nil {
+					t.Fatalf("add server candidate: %v", err)
+				}
+				serverCandidates++
+			case signaling.TypeCallEnd, signaling.TypeError:
+				t.Fatalf("server rejected the call: %s %s", msg.Type, msg.Payload)
+			}
+		case c := <-candidates:
+			if callID == "" {
+				pending = append(pending, c)
+			} else {
+				sendCandidate(t, caller, callID, c)
+			}
+		case <-connected:
+			isConnected = true
+		case <-deadline:
+			t.Fatalf("peer connection did not connect within %s (answer=%v, server candidates=%d)", connectTimeout, gotAnswer, serverCandidates)
+		}
+	}
+	if !gotAnswer {
+		t.Error("connected before the answer was applied")
+	}
+	if serverCandidates == 0 {
+		t.Error("no server candidate trickled to the client")
+	}
+	if n := len(engine.ListPeerConnections()); n != 1 {
+		t.Errorf("server engine holds %d peer connections, want 1", n)
+	}
+
+	// 媒体统计
+	// Media statistics.
+	time.Sleep(time.Second)
+	caller.send(t, &signaling.SignalMessage{ID: "3", Type: signaling.TypeCallStatsGet, CallID: callID, To: signaling.ServerPeer})
+	var stats signaling.CallStatsPayload
+	_ = json.Unmarshal(awaitMedia(t, caller, pc, signaling.TypeCallStats).Payload, &stats)
+	if stats.PacketsReceived == 0 || stats.InboundKbps == 0 {
+		t.Errorf("server received %d packets at %.1f kbps", stats.PacketsReceived, stats.InboundKbps)
+	}
+	if stats.CandidatePair == nil {
+		t.Error("selected candidate pair not reported")
+	}
+	if len(stats.Codecs) == 0 || stats.Codecs[0] != webrtc.MimeTypeOpus {
+		t.Errorf("codecs reported: %v", stats.Codecs)
+	}
+	if stats.MOS < 1 || stats.MOS > 4.5 {
+		t.Errorf("MOS %.2f (rtt %.2fms, jitter %.2fms, loss %.2f%%)", stats.MOS, stats.RTTMs, stats.JitterMs, stats.PacketLossPct)
+	}
+
+	// 保持与恢复记录在通话上；通话之外的通话消息被拒绝
+	// Hold and resume are recorded on the call; a call-scoped message outside
+	// the call is refused.
+	caller.send(t, &signaling.SignalMessage{Type: signaling.TypeCallHold, CallID: callID})
+	srv.awaitHeldBy(t, callID, caller.email)
+	caller.send(t, &signaling.SignalMessage{Type: signaling.TypeCallResume, CallID: callID})
+	srv.awaitHeldBy(t, callID)
+	caller.send(t, &signaling.SignalMessage{
+		ID:      "2",
+		Type:    signaling.TypeCallChat,
+		CallID:  "not-a-call",
+		Payload: mustJSON(t, signaling.ChatPayload{Text: "hello"}),
+	})
+	var protoErr signaling.ErrorPayload
+	_ = json.Unmarshal(awaitMedia(t, caller, pc, signaling.TypeError).Payload, &protoErr)
+	if protoErr.Code != signaling.ErrorCodeNotInCall || protoErr.MessageID != "2" {
+		t.Errorf("call-scoped message outside the call refused with %s for %q", protoErr.Code, protoErr.MessageID)
+	}
+
+	// 服务端增删轨道后发起重新协商，客户端加入视频后由服务端应答
+	// The server renegotiates after adding or removing a track; the client
+	// renegotiates after adding video.
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "signaling-test-server")
+	if err != nil {
+		t.Fatalf("create video track: %v", err)
+	}
+	sender, err := engine.AddTrack(callID, caller.email, signaling.ServerPeer, video)
+	if err != nil {
+		t.Fatalf("add server video track: %v", err)
+	}
+	answerRenegotiation(t, caller, pc, awaitMedia(t, caller, pc, signaling.TypeCallRenegotiate), callID)
+	if !sendsMedia(pc.RemoteDescription().SDP, "video") {
+		t.Error("server offer after AddTrack does not send video")
+	}
+
+	if err := engine.RemoveTrack(callID, caller.email, signaling.ServerPeer, sender); err != nil {
+		t.Fatalf("remove server video track: %v", err)
+	}
+	answerRenegotiation(t, caller, pc, awaitMedia(t, caller, pc, signaling.TypeCallRenegotiate), callID)
+	if sendsMedia(pc.RemoteDescription().SDP, "video") {
+		t.Error("server offer after RemoveTrack still sends video")
+	}
+
+	videoOffer := addClientVideo(t, pc)
+	caller.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeCallRenegotiate,
+		CallID:  callID,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: videoOffer.SDP}),
+	})
+	var answer signaling.SessionDescriptionPayload
+	_ = json.Unmarshal(awaitMedia(t, caller, pc, signaling.TypeCallRenegotiate).Payload, &answer)
+	if answer.Type != "answer" || !strings.Contains(answer.SDP, "m=video") {
+		t.Fatalf("server replied %s without video to the client's video offer", answer.Type)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+		t.Fatalf("apply renegotiation answer: %v", err)
+	}
+	if state := pc.ConnectionState(); state != webrtc.PeerConnectionStateConnected {
+		t.Errorf("connection %s after renegotiation", state)
+	}
+
+	// 挂断后服务端关闭对等连接
+	// Hanging up closes the server peer connection.
+	caller.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeCallEnd,
+		CallID:  callID,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, signaling.CallReasonPayload{Reason: "hangup"}),
+	})
+	closed := false
+	for i := 0; i < 50 && !closed; i++ {
+		time.Sleep(100 * time.Millisecond)
+		closed = len(engine.ListPeerConnections()) == 0
+	}
+	if !closed {
+		t.Error("server peer connection still open after call.end")
+	}
+}
+
+// newClientPeer 创建持续发送静音音频的客户端对等连接
+// newClientPeer creates a client peer connection that keeps sending silent audio.
+func newClientPeer(t *testing.T) (*webrtc.PeerConnection, <-chan webrtc.ICECandidateInit, <-chan struct{}) {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("create client peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "signaling-test")
+	if err != nil {
+		t.Fatalf("create audio track: %v", err)
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatalf("add audio track: %v", err)
+	}
+	// 持续发送 20ms 的静音帧，让服务端有媒体可统计
+	// Keep sending 20ms silent frames so the server has media to measure.
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		silence := []byte{0xf8, 0xff, 0xfe}
+		for range ticker.C {
+			if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				return
+			}
+			_ = track.WriteSample(pionmedia.Sample{Data: silence, Duration: 20 * time.Millisecond})
+		}
+	}()
+
+	candidates := make(chan webrtc.ICECandidateInit, 16)
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			candidates <- c.ToJSON()
+		}
+	})
+	connected := make(chan struct{})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+	return pc, candidates, connected
+}
+
+// awaitMedia 等待指定类型的消息，期间应用服务端候选
+// awaitMedia waits for a message of msgType, applying server candidates meanwhile.
+func awaitMedia(t *testing.T, c *client, pc *webrtc.PeerConnection, msgType string) *signaling.SignalMessage {
+	t.Helper()
+	deadline := time.After(connectTimeout)
+	for {
+		select {
+		case msg, ok := <-c.frames:
+			if !ok {
+				t.Fatalf("signaling socket closed while waiting for %s", msgType)
+			}
+			switch msg.Type {
+			case msgType:
+				return msg
+			case signaling.TypeIceCandidate:
+				var candidate webrtc.ICECandidateInit
+				_ = json.Unmarshal(msg.Payload, &candidate)
+				_ = pc.AddICECandidate(candidate)
+			case signaling.TypeCallEnd, signaling.TypeError:
+				t.Fatalf("unexpected %s while waiting for %s: %s", msg.Type, msgType, msg.Payload)
+			}
+		case <-deadline:
+			t.Fatalf("no %s within %s", msgType, connectTimeout)
+		}
+	}
+}
+
+// answerRenegotiation 应用服务端的重新协商 offer 并回复 answer
+// answerRenegotiation applies a server renegotiation offer and replies with an answer.
+func answerRenegotiation(t *testing.T, c *client, pc *webrtc.PeerConnection, msg *signaling.SignalMessage, callID string) {
+	t.Helper()
+	var offer signaling.SessionDescriptionPayload
+	_ = json.Unmarshal(msg.Payload, &offer)
+	if offer.Type != "offer" || msg.From != signaling.ServerPeer {
+		t.Fatalf("expected a renegotiation offer from the server, got %s from %s", offer.Type, msg.From)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		t.Fatalf("apply renegotiation offer: %v", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("create renegotiation answer: %v", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		t.Fatalf("set renegotiation answer: %v", err)
+	}
+	c.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeCallRenegotiate,
+		CallID:  callID,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "answer", SDP: answer.SDP}),
+	})
+}
+
+// addClientVideo 客户端在通话中加入视频并创建 offer
+// addClientVideo adds a video track on the client mid-call and creates the offer.
+func addClientVideo(t *testing.T, pc *webrtc.PeerConnection) webrtc.SessionDescription {
+	t.Helper()
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "signaling-test")
+	if err != nil {
+		t.Fatalf("create client video track: %v", err)
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatalf("add client video track: %v", err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create video offer: %v", err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set video offer: %v", err)
+	}
+	return offer
+}
+
+// sendsMedia SDP 中该类媒体是否有发送方向的段
+// sendsMedia reports whether the SDP has a sending section of the given kind.
+func sendsMedia(sdp, kind string) bool {
+	for _, section := range strings.Split(sdp, "m=")[1:] {
+		if strings.HasPrefix(section, kind) &&
+			(strings.Contains(section, "a=sendrecv") || strings.Contains(section, "a=sendonly")) {
+			return true
+		}
+	}
+	return false
+}
+
+func sendCandidate(t *testing.T, c *client, callID string, candidate webrtc.ICECandidateInit) {
+	t.Helper()
+	c.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeIceCandidate,
+		CallID:  callID,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, candidate),
+	})
+}
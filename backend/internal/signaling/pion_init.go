@@ -1,22 +1,80 @@
 package signaling
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/pion/webrtc/v4"
 	"github.com/rs/zerolog"
 
+	"github.com/allcallall/backend/internal/config"
 	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/turn"
 )
 
+// turnUser 服务端媒体引擎申请 TURN 凭据时使用的用户名
+// turnUser is the user the media engine's own TURN credentials are issued to.
+const turnUser = ServerPeer
+
 // InitPionMediaEngine 初始化 Pion 媒体引擎
 // InitPionMediaEngine initializes the Pion WebRTC media engine
-func InitPionMediaEngine(logger zerolog.Logger) (*media.Engine, error) {
+func InitPionMediaEngine(logger zerolog.Logger, webrtcCfg config.WebRTCConfig) (*media.Engine, error) {
+	pcConfig, err := peerConnectionConfig(webrtcCfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// 创建媒体引擎
 	// Create media engine
 	cfg := &media.Config{
-		WebRTCConfig: webrtc.Configuration{
-			// 可以在这里配置 ICE 服务器
-			// ICEServers can be configured here if needed
+		WebRTCConfig: pcConfig,
+		Mode:         webrtcCfg.MediaMode,
+		RecordingDir: webrtcCfg.RecordingDir,
+		Codecs: media.Codecs{
+			Audio:        webrtcCfg.Codecs.Audio,
+			Video:        webrtcCfg.Codecs.Video,
+			DisableVideo: webrtcCfg.Codecs.DisableVideo,
+		},
+		Interceptors: media.Interceptors{
+			RTCPReportInterval: milliseconds(webrtcCfg.Interceptors.RTCPReportIntervalMs),
+			NACKInterval:       milliseconds(webrtcCfg.Interceptors.NACKIntervalMs),
+			TWCCInterval:       milliseconds(webrtcCfg.Interceptors.TWCCIntervalMs),
+			PLIInterval:        milliseconds(webrtcCfg.Interceptors.PLIIntervalMs),
 		},
+		Network: media.Network{
+			UDPPortMin:      webrtcCfg.Network.UDPPortMin,
+			UDPPortMax:      webrtcCfg.Network.UDPPortMax,
+			NAT1To1IPs:      webrtcCfg.Network.NAT1To1IPs,
+			Interfaces:      webrtcCfg.Network.Interfaces,
+			IncludeLoopback: webrtcCfg.Network.IncludeLoopback,
+			UDPMuxPort:      webrtcCfg.Network.UDPMuxPort,
+			TCPMuxPort:      webrtcCfg.Network.TCPMuxPort,
+		},
+		Limits: media.Limits{
+			MaxPeerConnections: webrtcCfg.PeerConnections.Max,
+			MaxPerUser:         webrtcCfg.PeerConnections.MaxPerUser,
+			ConnectTimeout:     webrtcCfg.PeerConnections.ConnectTimeout(),
+		},
+	}
+	switch webrtcCfg.Network.NAT1To1CandidateType {
+	case "", "host":
+		cfg.Network.NAT1To1CandidateType = webrtc.ICECandidateTypeHost
+	case "srflx":
+		cfg.Network.NAT1To1CandidateType = webrtc.ICECandidateTypeSrflx
+	default:
+		return nil, fmt.Errorf("unknown nat 1:1 candidate type %q", webrtcCfg.Network.NAT1To1CandidateType)
+	}
+	for _, server := range webrtcCfg.ICEServers {
+		if server.Secret != "" {
+			// 服务端自己的连接同样使用临时 TURN 凭据，每个连接重新签发
+			// The server's own connections use ephemeral TURN credentials too,
+			// issued afresh for every connection.
+			cfg.ICEServers = func() []webrtc.ICEServer {
+				servers, _ := turn.ICEServers(webrtcCfg.ICEServers, turnUser, webrtcCfg.TURNCredentialTTL(), time.Now())
+				return pionICEServers(servers)
+			}
+			break
+		}
 	}
 
 	engine, err := media.NewEngine(logger, cfg)
@@ -24,6 +82,74 @@ func InitPionMediaEngine(logger zerolog.Logger) (*media.Engine, error) {
 		return nil, err
 	}
 
-	logger.Info().Msg("pion media engine initialized")
+	logger.Info().
+		Str("mode", engine.Mode()).
+		Int("ice_servers", len(pcConfig.ICEServers)).
+		Str("ice_transport_policy", pcConfig.ICETransportPolicy.String()).
+		Str("bundle_policy", pcConfig.BundlePolicy.String()).
+		Strs("audio_codecs", webrtcCfg.Codecs.Audio).
+		Strs("video_codecs", webrtcCfg.Codecs.Video).
+		Bool("video_disabled", webrtcCfg.Codecs.DisableVideo).
+		Strs("nat_1to1_ips", webrtcCfg.Network.NAT1To1IPs).
+		Stringers("ice_mux", listenAddresses(engine)).
+		Int("max_peer_connections", webrtcCfg.PeerConnections.Max).
+		Int("max_peer_connections_per_user", webrtcCfg.PeerConnections.MaxPerUser).
+		Msg("pion media engine initialized")
 	return engine, nil
 }
+
+// peerConnectionConfig 将配置文件中的 ICE 服务器与策略转换为 Pion 配置
+// peerConnectionConfig converts the configured ICE servers and policies into
+// the configuration of the server's peer connections.
+func peerConnectionConfig(webrtcCfg config.WebRTCConfig) (webrtc.Configuration, error) {
+	pcConfig := webrtc.Configuration{
+		ICEServers: pionICEServers(webrtcCfg.ICEServers),
+	}
+
+	switch webrtcCfg.ICETransportPolicy {
+	case "", "all":
+		pcConfig.ICETransportPolicy = webrtc.ICETransportPolicyAll
+	case "relay":
+		pcConfig.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	default:
+		return webrtc.Configuration{}, fmt.Errorf("unknown ice transport policy %q", webrtcCfg.ICETransportPolicy)
+	}
+
+	switch webrtcCfg.BundlePolicy {
+	case "", "balanced":
+		pcConfig.BundlePolicy = webrtc.BundlePolicyBalanced
+	case "max-compat":
+		pcConfig.BundlePolicy = webrtc.BundlePolicyMaxCompat
+	case "max-bundle":
+		pcConfig.BundlePolicy = webrtc.BundlePolicyMaxBundle
+	default:
+		return webrtc.Configuration{}, fmt.Errorf("unknown bundle policy %q", webrtcCfg.BundlePolicy)
+	}
+	return pcConfig, nil
+}
+
+// pionICEServers 转换为 Pion 的 ICE 服务器
+// pionICEServers converts configured ICE servers to Pion's type.
+func pionICEServers(servers []config.ICEServer) []webrtc.ICEServer {
+	result := make([]webrtc.ICEServer, 0, len(servers))
+	for _, server := range servers {
+		result = append(result, webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+	return result
+}
+
+func listenAddresses(engine *media.Engine) []fmt.Stringer {
+	var addrs []fmt.Stringer
+	for _, addr := range engine.ListenAddresses() {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func milliseconds(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,20 @@
+package signaling_test
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+// TestUnknownNATCandidateType 未知的 1:1 NAT 候选类型被拒绝
+// TestUnknownNATCandidateType checks that an unknown 1:1 NAT candidate type is
+// rejected.
+func TestUnknownNATCandidateType(t *testing.T) {
+	cfg := config.WebRTCConfig{Network: config.NetworkConfig{NAT1To1CandidateType: "relay"}}
+	if _, err := signaling.InitPionMediaEngine(zerolog.Nop(), cfg); err == nil {
+		t.Error("1:1 NAT candidate type relay accepted")
+	}
+}
@@ -0,0 +1,39 @@
+package signaling
+
+import (
+	"context"
+	"time"
+
+	"github.com/allcallall/backend/internal/privacy"
+)
+
+// WithPrivacy 在投递邀请前执行被叫的呼叫策略、屏蔽列表与免打扰
+// WithPrivacy enforces the callee's calling policy, block list and
+// do-not-disturb schedule before an invite is delivered.
+func (h *Hub) WithPrivacy(svc *privacy.Service) {
+	h.privacy = svc
+}
+
+// calleeReachable 被叫是否接受该主叫的邀请；服务端终结的通话不受限制
+// calleeReachable reports whether the callee accepts msg's invite right now.
+// Server-terminated calls are not subject to user policies.
+func (h *Hub) calleeReachable(ctx context.Context, msg *SignalMessage) (bool, error) {
+	if h.privacy == nil || msg.To == ServerPeer {
+		return true, nil
+	}
+	return h.privacy.CanCall(ctx, msg.From, msg.To, time.Now())
+}
+
+// unavailableMessage 中性的不可达回复，不透露是屏蔽、策略还是免打扰
+// unavailableMessage is the neutral reply to a refused invite. It does not
+// reveal whether the callee blocked the caller, restricts calls or is in
+// do-not-disturb, nor whether the account exists.
+func unavailableMessage(invite *SignalMessage) *SignalMessage {
+	return &SignalMessage{
+		ID:     invite.ID,
+		Type:   TypeCallUnavailable,
+		CallID: invite.CallID,
+		To:     invite.From,
+		From:   invite.To,
+	}
+}
@@ -0,0 +1,197 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/allcallall/backend/internal/presence"
+	"github.com/allcallall/backend/internal/privacy"
+)
+
+// presencePushTimeout 单次推送（读取状态、查询联系人、投递）的超时
+// presencePushTimeout bounds one push: reading the status, resolving the audience and delivering.
+const presencePushTimeout = 10 * time.Second
+
+// presencePusher 将在线状态变化防抖后推送给联系人
+// presencePusher debounces presence changes and pushes them to contacts.
+type presencePusher struct {
+	hub      *Hub
+	audience *privacy.Service
+	debounce time.Duration
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	// rechecks 状态将自行变化（自动离开、消息过期）时的复查计时器
+	// rechecks fire when a status changes on its own: auto-away or an expiring message.
+	rechecks map[string]*time.Timer
+	// announced 本节点最近一次推送的状态；离线且无消息的用户不保存
+	// announced holds what this node last pushed per user. Users last
+	// announced offline without a message are not kept.
+	announced map[string]presenceView
+}
+
+// presenceView 联系人可见、变化时需要推送的字段
+// presenceView is the part of a status whose changes contacts are told about.
+type presenceView struct {
+	online       bool
+	availability string
+	message      string
+}
+
+func viewOf(status presence.Status) presenceView {
+	return presenceView{online: status.Online, availability: status.Availability, message: status.Message}
+}
+
+// offlineView 未推送过的用户视为离线
+// offlineView is assumed for users this node never announced.
+var offlineView = presenceView{availability: presence.AvailabilityOffline}
+
+// WithPresenceUpdates 在线状态变化时向联系人推送 presence.update
+// WithPresenceUpdates pushes presence.update frames to a user's contacts when
+// the user goes online or offline or their availability or status message
+// changes. Changes that settle back within debounce are not announced.
+// audience decides who may see the change.
+func (h *Hub) WithPresenceUpdates(audience *privacy.Service, debounce time.Duration) {
+	if h.presence == nil {
+		return
+	}
+	p := &presencePusher{
+		hub:       h,
+		audience:  audience,
+		debounce:  debounce,
+		timers:    make(map[string]*time.Timer),
+		rechecks:  make(map[string]*time.Timer),
+		announced: make(map[string]presenceView),
+	}
+	h.presence.OnChange(p.schedule)
+}
+
+// schedule 重置该用户的防抖计时器
+// schedule restarts the user's debounce timer.
+func (p *presencePusher) schedule(status presence.Status) {
+	email := status.Email
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.timers[email]; ok {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(p.debounce, func() {
+		p.mu.Lock()
+		if p.timers[email] == t {
+			delete(p.timers, email)
+		}
+		p.mu.Unlock()
+		p.flush(email)
+	})
+	p.timers[email] = t
+}
+
+// flush 读取稳定后的状态，与上次推送不同时才推送
+// flush reads the settled status and pushes it if it differs from the last
+// one this node announced.
+func (p *presencePusher) flush(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), presencePushTimeout)
+	defer cancel()
+
+	logger := p.hub.logger.With().Str("email", email).Logger()
+	status, err := p.hub.presence.GetStatus(ctx, email)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to read presence for push")
+		return
+	}
+	p.recheck(email, status.ChangesAt())
+	status = status.Visible()
+
+	view := viewOf(status)
+	p.mu.Lock()
+	last, ok := p.announced[email]
+	if !ok {
+		last = offlineView
+	}
+	if view == last {
+		p.mu.Unlock()
+		return
+	}
+	if view == offlineView {
+		delete(p.announced, email)
+	} else {
+		p.announced[email] = view
+	}
+	p.mu.Unlock()
+
+	audience, err := p.audience.PresenceAudience(ctx, email)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to resolve presence audience")
+		return
+	}
+	if len(audience) == 0 {
+		return
+	}
+
+	// 同一消息对象编码一次，投递给所有可见最后在线时间（或不可见）的联系人
+	// Each message is encoded once per codec and shared by every contact that
+	// may (or may not) see the last seen time.
+	withLastSeen := presenceUpdate(status, true)
+	withoutLastSeen := presenceUpdate(status, false)
+	for _, watcher := range audience {
+		out := withoutLastSeen
+		if watcher.LastSeen {
+			out = withLastSeen
+		}
+		if out == nil {
+			continue
+		}
+		if err := p.hub.deliver(ctx, watcher.Email, out); err != nil {
+			logger.Warn().Err(err).Str("to", watcher.Email).Msg("failed to push presence update")
+		}
+	}
+}
+
+// presenceUpdate 构造 presence.update 消息，可选择隐藏最后在线时间
+// presenceUpdate builds the presence.update frame for status, leaving out the
+// last seen time unless lastSeen is set.
+func presenceUpdate(status presence.Status, lastSeen bool) *outbound {
+	payload := PresenceUpdatePayload{
+		Email:            status.Email,
+		Online:           status.Online,
+		Availability:     status.Availability,
+		Message:          status.Message,
+		MessageExpiresAt: status.MessageExpiresAt,
+	}
+	if lastSeen {
+		payload.LastSeen = &status.LastSeen
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	return newOutbound(&SignalMessage{Type: TypePresenceUpdate, From: status.Email, Payload: data})
+}
+
+// recheck 在状态自行变化时再次 flush；at 为零值时取消
+// recheck flushes the user again at at, when their status changes without an
+// event. A zero at cancels the pending recheck.
+func (p *presencePusher) recheck(email string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.rechecks[email]; ok {
+		t.Stop()
+		delete(p.rechecks, email)
+	}
+	if at.IsZero() {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(time.Until(at), func() {
+		p.mu.Lock()
+		if p.rechecks[email] == t {
+			delete(p.rechecks, email)
+		}
+		p.mu.Unlock()
+		p.flush(email)
+	})
+	p.rechecks[email] = t
+}
@@ -0,0 +1,739 @@
+package signaling
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/presence"
+)
+
+// 信令协议版本
+// Signaling protocol versions negotiated at connect time.
+const (
+	// ProtocolVersion1 第一版强类型协议
+	// ProtocolVersion1 is the first typed and validated protocol revision.
+	ProtocolVersion1 = 1
+
+	// DefaultProtocolVersion 未协商时使用的版本（兼容旧客户端）
+	// DefaultProtocolVersion applies to clients that never negotiate.
+	DefaultProtocolVersion = ProtocolVersion1
+
+	// SubprotocolV1 WebSocket 子协议名称
+	// SubprotocolV1 is the Sec-WebSocket-Protocol token for version 1.
+	SubprotocolV1 = "allcall.signal.v1"
+)
+
+// 协议控制消息类型
+// Protocol control message types.
+const (
+	TypeHello          = "hello"
+	TypeHelloAck       = "hello.ack"
+	TypeError          = "error"
+	TypeServerDraining = "server.draining"
+)
+
+//go:embed schema/signaling.v1.schema.json
+var protocolSchemaV1 []byte
+
+var subprotocolVersions = map[string]int{
+	SubprotocolV1: ProtocolVersion1,
+}
+
+// Subprotocols 返回服务端支持的 WebSocket 子协议（按优先级）
+// Subprotocols lists supported WebSocket subprotocols, preferred first. Each
+// version is offered with every codec; binary codecs are preferred.
+func Subprotocols() []string {
+	var names []string
+	for _, c := range codecsByPreference {
+		names = append(names, subprotocolFor(ProtocolVersion1, c))
+	}
+	return names
+}
+
+// ProtocolSchema 返回机器可读的 JSON Schema
+// ProtocolSchema returns the JSON Schema describing protocol version 1.
+func ProtocolSchema() []byte {
+	return protocolSchemaV1
+}
+
+func subprotocolForVersion(version int) string {
+	for name, v := range subprotocolVersions {
+		if v == version {
+			return name
+		}
+	}
+	return ""
+}
+
+// ErrorCode 错误帧中的错误码
+// ErrorCode classifies why the hub rejected a message.
+type ErrorCode string
+
+const (
+	// ErrorCodeMalformedMessage 消息无法解析
+	// ErrorCodeMalformedMessage means the frame is not a valid message envelope.
+	ErrorCodeMalformedMessage ErrorCode = "malformed_message"
+	// ErrorCodeUnknownType 未知消息类型
+	// ErrorCodeUnknownType means the type is not part of the negotiated protocol.
+	ErrorCodeUnknownType ErrorCode = "unknown_type"
+	// ErrorCodeForbiddenType 客户端不允许发送该类型
+	// ErrorCodeForbiddenType means the type may only be emitted by the server.
+	ErrorCodeForbiddenType ErrorCode = "forbidden_type"
+	// ErrorCodeMissingField 缺少必填字段
+	// ErrorCodeMissingField means a required envelope field is empty.
+	ErrorCodeMissingField ErrorCode = "missing_field"
+	// ErrorCodeInvalidPayload 负载校验失败
+	// ErrorCodeInvalidPayload means the payload failed validation.
+	ErrorCodeInvalidPayload ErrorCode = "invalid_payload"
+	// ErrorCodeUnsupportedVersion 无可用的协议版本
+	// ErrorCodeUnsupportedVersion means hello offered no version the server speaks.
+	ErrorCodeUnsupportedVersion ErrorCode = "unsupported_version"
+	// ErrorCodeMediaUnavailable 本节点没有媒体引擎
+	// ErrorCodeMediaUnavailable means a call to ServerPeer reached a node without a media engine.
+	ErrorCodeMediaUnavailable ErrorCode = "media_unavailable"
+	// ErrorCodeNotInCall 发送者不在该进行中的通话里，或无权接听、拒绝、结束该通话
+	// ErrorCodeNotInCall means a call-scoped message came from outside an active
+	// call, or the sender may not accept, reject or end the call.
+	ErrorCodeNotInCall ErrorCode = "not_in_call"
+	// ErrorCodeCallExists 邀请的通话 ID 已被进行中的通话占用
+	// ErrorCodeCallExists means an invite named the call_id of a call in progress.
+	ErrorCodeCallExists ErrorCode = "call_exists"
+	// ErrorCodeInvalidTransfer 转接请求或转接邀请不合法
+	// ErrorCodeInvalidTransfer rejects a transfer request, or an invite that claims a transfer that was not requested.
+	ErrorCodeInvalidTransfer ErrorCode = "invalid_transfer"
+	// ErrorCodeRecordingUnavailable 无法开始或停止录制
+	// ErrorCodeRecordingUnavailable means the call cannot be recorded, or a
+	// recording cannot be started or stopped in its current state.
+	ErrorCodeRecordingUnavailable ErrorCode = "recording_unavailable"
+)
+
+// ProtocolError 协议层错误，会以 error 帧返回给客户端
+// ProtocolError is a rejection that is reported back to the sender as an error frame.
+type ProtocolError struct {
+	Code        ErrorCode
+	MessageID   string
+	Description string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.MessageID == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("%s (message %s): %s", e.Code, e.MessageID, e.Description)
+}
+
+func newProtocolError(code ErrorCode, messageID, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{
+		Code:        code,
+		MessageID:   messageID,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// ErrorPayload error 帧负载
+// ErrorPayload is the body of a standard error frame.
+type ErrorPayload struct {
+	Code        ErrorCode `json:"code"`
+	MessageID   string    `json:"message_id,omitempty"`
+	Description string    `json:"description"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *ErrorPayload) Validate() error {
+	if p.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+	return nil
+}
+
+// HelloPayload 客户端握手负载
+// HelloPayload lists the protocol versions a client is able to speak.
+type HelloPayload struct {
+	Versions []int  `json:"versions"`
+	Client   string `json:"client,omitempty"`
+	// 要恢复的会话（来自 server.draining）
+	// Session to resume, as announced by server.draining.
+	Resume string `json:"resume,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *HelloPayload) Validate() error {
+	if len(p.Versions) == 0 {
+		return fmt.Errorf("versions must not be empty")
+	}
+	return nil
+}
+
+// HelloAckPayload 服务端握手应答
+// HelloAckPayload tells the client which version the hub selected and, for a
+// resumed session, which calls are still in progress.
+type HelloAckPayload struct {
+	Version     int     `json:"version"`
+	Subprotocol string  `json:"subprotocol"`
+	Codec       string  `json:"codec,omitempty"`
+	SessionID   string  `json:"session_id"`
+	Resumed     bool    `json:"resumed,omitempty"`
+	Calls       []*Call `json:"calls,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *HelloAckPayload) Validate() error {
+	if p.Version <= 0 {
+		return fmt.Errorf("version must be positive")
+	}
+	if p.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	return nil
+}
+
+// DrainingPayload server.draining 负载
+// DrainingPayload asks the client to reconnect elsewhere and resume its session.
+type DrainingPayload struct {
+	SessionID        string    `json:"session_id"`
+	ReconnectAfterMs int64     `json:"reconnect_after_ms"`
+	Deadline         time.Time `json:"deadline"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *DrainingPayload) Validate() error {
+	if p.SessionID == "" {
+		return fmt.Errorf("session_id is required")
+	}
+	if p.ReconnectAfterMs < 0 {
+		return fmt.Errorf("reconnect_after_ms must not be negative")
+	}
+	return nil
+}
+
+// SessionDescriptionPayload SDP offer/answer 负载
+// SessionDescriptionPayload carries an SDP offer or answer.
+type SessionDescriptionPayload struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+	// Transfer 转接产生的邀请所引用的原通话
+	// Transfer marks an invite placed to complete a transfer.
+	Transfer *TransferRef `json:"transfer,omitempty"`
+	// Room 邀请服务端时加入的 SFU 房间；同一房间的参与者互相收到对方的媒体
+	// Room names the SFU room an invite to the server joins. Everyone in the
+	// room receives everyone else's media. Room names should be hard to guess.
+	Room string `json:"room,omitempty"`
+
+	expect string
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *SessionDescriptionPayload) Validate() error {
+	if p.Type != "offer" && p.Type != "answer" {
+		return fmt.Errorf("type must be \"offer\" or \"answer\"")
+	}
+	if p.expect != "" && p.Type != p.expect {
+		return fmt.Errorf("type must be %q", p.expect)
+	}
+	if p.Transfer != nil && p.Transfer.CallID == "" {
+		return fmt.Errorf("transfer.call_id is required")
+	}
+	if p.Room != "" && p.Type != "offer" {
+		return fmt.Errorf("room is only allowed on offers")
+	}
+	if len(p.Room) > maxRoomLength {
+		return fmt.Errorf("room must be at most %d bytes", maxRoomLength)
+	}
+	if strings.TrimSpace(p.SDP) == "" {
+		return fmt.Errorf("sdp is required")
+	}
+	return nil
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *ICECandidatePayload) Validate() error {
+	if strings.TrimSpace(p.Candidate) == "" {
+		return fmt.Errorf("candidate is required")
+	}
+	if p.SDPMid == nil && p.SDPMLineIndex == nil {
+		return fmt.Errorf("one of sdpMid or sdpMLineIndex is required")
+	}
+	return nil
+}
+
+// maxRoomLength SFU 房间名的最大字节数
+// maxRoomLength is the longest SFU room name, in bytes.
+const maxRoomLength = 128
+
+// TransferRef 转接邀请引用的原通话；referred_by 与 replaces 由服务端填写
+// TransferRef links an invite to the call being transferred. The server fills
+// in referred_by and, for attended transfers, the consultation call it replaces.
+type TransferRef struct {
+	CallID     string `json:"call_id"`
+	ReferredBy string `json:"referred_by,omitempty"`
+	Replaces   string `json:"replaces,omitempty"`
+}
+
+// 转接方式
+// Transfer modes.
+const (
+	// TransferBlind 直接转给目标
+	// TransferBlind hands the call to the target without talking to them first.
+	TransferBlind = "blind"
+	// TransferAttended 先与目标咨询通话，再桥接
+	// TransferAttended hands the call over after a consultation call with the target.
+	TransferAttended = "attended"
+)
+
+// TransferPayload 转接请求
+// TransferPayload asks the other party of the call to be transferred to Target.
+type TransferPayload struct {
+	Mode          string `json:"mode"`
+	Target        string `json:"target"`
+	ConsultCallID string `json:"consult_call_id,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *TransferPayload) Validate() error {
+	if strings.TrimSpace(p.Target) == "" {
+		return fmt.Errorf("target is required")
+	}
+	switch p.Mode {
+	case TransferBlind:
+		if p.ConsultCallID != "" {
+			return fmt.Errorf("consult_call_id is only allowed for attended transfers")
+		}
+	case TransferAttended:
+		if p.ConsultCallID == "" {
+			return fmt.Errorf("consult_call_id is required for attended transfers")
+		}
+	default:
+		return fmt.Errorf("mode must be %q or %q", TransferBlind, TransferAttended)
+	}
+	return nil
+}
+
+// PresenceUpdatePayload 联系人在线状态变化
+// PresenceUpdatePayload announces a contact's presence change.
+type PresenceUpdatePayload struct {
+	Email            string     `json:"email"`
+	Online           bool       `json:"online"`
+	LastSeen         *time.Time `json:"last_seen,omitempty"`
+	Availability     string     `json:"availability,omitempty"`
+	Message          string     `json:"message,omitempty"`
+	MessageExpiresAt *time.Time `json:"message_expires_at,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *PresenceUpdatePayload) Validate() error {
+	if p.Email == "" {
+		return fmt.Errorf("email is required")
+	}
+	switch p.Availability {
+	case "", presence.AvailabilityAvailable, presence.AvailabilityAway, presence.AvailabilityBusy,
+		presence.AvailabilityDND, presence.AvailabilityInCall, presence.AvailabilityOffline:
+		return nil
+	}
+	return fmt.Errorf("unknown availability %q", p.Availability)
+}
+
+// 录制状态
+// Recording states.
+const (
+	RecordingStarted = "started"
+	RecordingStopped = "stopped"
+)
+
+// RecordingPayload 通知参与者录制开始或停止
+// RecordingPayload tells a participant that a recording of their call started
+// or stopped. By is who asked for it. It is empty when the server acted on its
+// own: the recorded call ended, or the participant joined a room already
+// being recorded.
+type RecordingPayload struct {
+	RecordingID string `json:"recording_id"`
+	State       string `json:"state"`
+	By          string `json:"by,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *RecordingPayload) Validate() error {
+	if p.RecordingID == "" {
+		return fmt.Errorf("recording_id is required")
+	}
+	if p.State != RecordingStarted && p.State != RecordingStopped {
+		return fmt.Errorf("state must be %q or %q", RecordingStarted, RecordingStopped)
+	}
+	return nil
+}
+
+// CallStatsPayload 服务端测得的媒体质量，回复 call.stats.get
+// CallStatsPayload is the media quality the server measured on its side of a
+// server-terminated call, sent in reply to call.stats.get.
+type CallStatsPayload struct {
+	media.Stats
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *CallStatsPayload) Validate() error {
+	if p.CollectedAt.IsZero() {
+		return fmt.Errorf("collected_at is required")
+	}
+	if p.RTTMs < 0 || p.JitterMs < 0 || p.PacketLossPct < 0 {
+		return fmt.Errorf("rtt_ms, jitter_ms and packet_loss_pct must not be negative")
+	}
+	if p.MOS != 0 && (p.MOS < 1 || p.MOS > 5) {
+		return fmt.Errorf("mos must be between 1 and 5")
+	}
+	return nil
+}
+
+// CallReasonPayload 拒绝或挂断的可选原因
+// CallReasonPayload optionally explains a reject or hang-up.
+type CallReasonPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *CallReasonPayload) Validate() error {
+	if len(p.Reason) > 256 {
+		return fmt.Errorf("reason must be at most 256 characters")
+	}
+	return nil
+}
+
+const (
+	maxChatRunes     = 2000
+	maxReactionRunes = 32
+)
+
+// ChatPayload 通话内文字消息
+// ChatPayload is a line of text, such as a link, sent during a call.
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *ChatPayload) Validate() error {
+	if strings.TrimSpace(p.Text) == "" {
+		return fmt.Errorf("text is required")
+	}
+	if utf8.RuneCountInString(p.Text) > maxChatRunes {
+		return fmt.Errorf("text must be at most %d characters", maxChatRunes)
+	}
+	return nil
+}
+
+// ReactionPayload 通话内表情回应
+// ReactionPayload is an emoji reaction sent during a call.
+type ReactionPayload struct {
+	Emoji string `json:"emoji"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *ReactionPayload) Validate() error {
+	if strings.TrimSpace(p.Emoji) == "" {
+		return fmt.Errorf("emoji is required")
+	}
+	if utf8.RuneCountInString(p.Emoji) > maxReactionRunes {
+		return fmt.Errorf("emoji must be at most %d characters", maxReactionRunes)
+	}
+	return nil
+}
+
+// TypingPayload 正在输入提示，不会保存
+// TypingPayload signals that the sender started or stopped typing. It is not stored.
+type TypingPayload struct {
+	Active bool `json:"active"`
+}
+
+// Validate 实现 payloadValidator
+// Validate implements payloadValidator.
+func (p *TypingPayload) Validate() error {
+	return nil
+}
+
+// payloadValidator 可校验的强类型负载
+// payloadValidator is implemented by every typed payload.
+type payloadValidator interface {
+	Validate() error
+}
+
+// messageSpec 描述单个消息类型的协议约束
+// messageSpec captures protocol rules for a single message type.
+type messageSpec struct {
+	// 客户端是否可以发送
+	// Whether clients may send this type; otherwise it is server-only.
+	clientSendable bool
+	// 是否需要 to 字段
+	// Whether the envelope must name a recipient.
+	requireTarget bool
+	// 是否需要 call_id 字段
+	// Whether the envelope must carry a call_id.
+	requireCallID bool
+	// 负载是否必填
+	// Whether the payload must be present.
+	requirePayload bool
+	// 仅进行中通话的参与者可发送，由 Hub 投递给另一方
+	// Only participants of the active call may send it; the hub addresses it to the other party.
+	callScoped bool
+	// 构造负载结构体，nil 表示无负载
+	// Builds the typed payload, nil when the type carries none.
+	newPayload func() payloadValidator
+}
+
+var messageSpecs = map[string]messageSpec{
+	TypeHello: {
+		clientSendable: true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &HelloPayload{} },
+	},
+	TypeHelloAck: {
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &HelloAckPayload{} },
+	},
+	TypeError: {
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &ErrorPayload{} },
+	},
+	TypeServerDraining: {
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &DrainingPayload{} },
+	},
+	TypeCallInvite: {
+		clientSendable: true,
+		requireTarget:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &SessionDescriptionPayload{expect: "offer"} },
+	},
+	TypeCallInviteAck: {
+		requireCallID:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &SessionDescriptionPayload{expect: "offer"} },
+	},
+	TypeCallAccept: {
+		clientSendable: true,
+		requireTarget:  true,
+		requireCallID:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &SessionDescriptionPayload{expect: "answer"} },
+	},
+	TypeCallReject: {
+		clientSendable: true,
+		requireTarget:  true,
+		requireCallID:  true,
+		newPayload:     func() payloadValidator { return &CallReasonPayload{} },
+	},
+	TypeCallEnd: {
+		clientSendable: true,
+		requireTarget:  true,
+		requireCallID:  true,
+		newPayload:     func() payloadValidator { return &CallReasonPayload{} },
+	},
+	TypeIceCandidate: {
+		clientSendable: true,
+		requireTarget:  true,
+		requireCallID:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &ICECandidatePayload{} },
+	},
+	TypeCallChat: {
+		clientSendable: true,
+		requireCallID:  true,
+		requirePayload: true,
+		callScoped:     true,
+		newPayload:     func() payloadValidator { return &ChatPayload{} },
+	},
+	TypeCallReaction: {
+		clientSendable: true,
+		requireCallID:  true,
+		requirePayload: true,
+		callScoped:     true,
+		newPayload:     func() payloadValidator { return &ReactionPayload{} },
+	},
+	TypeCallHold: {
+		clientSendable: true,
+		requireCallID:  true,
+		callScoped:     true,
+	},
+	TypeCallResume: {
+		clientSendable: true,
+		requireCallID:  true,
+		callScoped:     true,
+	},
+	TypeCallRenegotiate: {
+		clientSendable: true,
+		requireCallID:  true,
+		requirePayload: true,
+		callScoped:     true,
+		newPayload:     func() payloadValidator { return &SessionDescriptionPayload{} },
+	},
+	TypeCallTransfer: {
+		clientSendable: true,
+		requireCallID:  true,
+		requirePayload: true,
+		callScoped:     true,
+		newPayload:     func() payloadValidator { return &TransferPayload{} },
+	},
+	TypeCallUnavailable: {
+		requireCallID: true,
+	},
+	TypePresenceUpdate: {
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &PresenceUpdatePayload{} },
+	},
+	TypeCallTyping: {
+		clientSendable: true,
+		requireCallID:  true,
+		requirePayload: true,
+		callScoped:     true,
+		newPayload:     func() payloadValidator { return &TypingPayload{} },
+	},
+	TypeCallRecordStart: {
+		clientSendable: true,
+		requireCallID:  true,
+		callScoped:     true,
+	},
+	TypeCallRecordStop: {
+		clientSendable: true,
+		requireCallID:  true,
+		callScoped:     true,
+	},
+	TypeCallRecording: {
+		requireCallID:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &RecordingPayload{} },
+	},
+	TypeCallStatsGet: {
+		clientSendable: true,
+		requireCallID:  true,
+		callScoped:     true,
+	},
+	TypeCallStats: {
+		requireCallID:  true,
+		requirePayload: true,
+		newPayload:     func() payloadValidator { return &CallStatsPayload{} },
+	},
+}
+
+// isCallScoped 消息是否只能在进行中的通话内发送
+// isCallScoped reports whether msgType may only be sent inside an active call.
+func isCallScoped(msgType string) bool {
+	return messageSpecs[msgType].callScoped
+}
+
+// MessageTypes 返回协议定义的全部消息类型
+// MessageTypes returns every message type defined by the protocol, sorted.
+func MessageTypes() []string {
+	types := make([]string, 0, len(messageSpecs))
+	for t := range messageSpecs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ValidateMessage 校验消息信封及其负载
+// ValidateMessage checks the envelope and typed payload of a message.
+func ValidateMessage(msg *SignalMessage) error {
+	spec, ok := messageSpecs[msg.Type]
+	if !ok {
+		if msg.Type == "" {
+			return newProtocolError(ErrorCodeMissingField, msg.ID, "type is required")
+		}
+		return newProtocolError(ErrorCodeUnknownType, msg.ID, "unknown message type %q", msg.Type)
+	}
+	if spec.requireTarget && msg.To == "" {
+		return newProtocolError(ErrorCodeMissingField, msg.ID, "to is required for %s", msg.Type)
+	}
+	if spec.requireCallID && msg.CallID == "" {
+		return newProtocolError(ErrorCodeMissingField, msg.ID, "call_id is required for %s", msg.Type)
+	}
+
+	empty := isEmptyPayload(msg.Payload)
+	if empty {
+		if spec.requirePayload {
+			return newProtocolError(ErrorCodeMissingField, msg.ID, "payload is required for %s", msg.Type)
+		}
+		return nil
+	}
+	if spec.newPayload == nil {
+		return newProtocolError(ErrorCodeInvalidPayload, msg.ID, "%s does not carry a payload", msg.Type)
+	}
+
+	payload := spec.newPayload()
+	if err := json.Unmarshal(msg.Payload, payload); err != nil {
+		return newProtocolError(ErrorCodeInvalidPayload, msg.ID, "decode %s payload: %v", msg.Type, err)
+	}
+	if err := payload.Validate(); err != nil {
+		return newProtocolError(ErrorCodeInvalidPayload, msg.ID, "%s payload: %v", msg.Type, err)
+	}
+	return nil
+}
+
+// validateClientMessage 在 ValidateMessage 基础上拒绝服务端专用类型
+// validateClientMessage additionally rejects server-only types.
+func validateClientMessage(msg *SignalMessage) error {
+	if spec, ok := messageSpecs[msg.Type]; ok && !spec.clientSendable {
+		return newProtocolError(ErrorCodeForbiddenType, msg.ID, "%s may only be sent by the server", msg.Type)
+	}
+	return ValidateMessage(msg)
+}
+
+// DecodePayload 将负载解析到目标结构体
+// DecodePayload unmarshals the raw payload into v.
+func (m *SignalMessage) DecodePayload(v interface{}) error {
+	if isEmptyPayload(m.Payload) {
+		return fmt.Errorf("empty payload")
+	}
+	return json.Unmarshal(m.Payload, v)
+}
+
+func isEmptyPayload(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
+
+// negotiateVersion 从客户端提供的版本中选择最高的共同版本
+// negotiateVersion picks the highest version offered by the client that the hub speaks.
+func negotiateVersion(offered []int) (int, bool) {
+	best := 0
+	for _, v := range offered {
+		if subprotocolForVersion(v) != "" && v > best {
+			best = v
+		}
+	}
+	return best, best > 0
+}
+
+// newErrorMessage 构造发给 recipient 的 error 帧
+// newErrorMessage builds an error frame addressed to recipient.
+func newErrorMessage(recipient string, perr *ProtocolError) (*SignalMessage, error) {
+	payload, err := json.Marshal(ErrorPayload{
+		Code:        perr.Code,
+		MessageID:   perr.MessageID,
+		Description: perr.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SignalMessage{
+		Type:    TypeError,
+		To:      recipient,
+		Payload: payload,
+	}, nil
+}
@@ -0,0 +1,350 @@
+package signaling_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+// TestMessageTypesMatchSchema 消息类型集合与 JSON Schema 一致
+// TestMessageTypesMatchSchema checks that the schema and the Go registry know
+// the same message types, each with a payload rule.
+func TestMessageTypesMatchSchema(t *testing.T) {
+	schema := loadSchema(t)
+	schemaTypes := schemaMessageTypes(schema)
+	goTypes := signaling.MessageTypes()
+	if strings.Join(schemaTypes, ",") != strings.Join(goTypes, ",") {
+		t.Errorf("schema enum %v does not match Go registry %v", schemaTypes, goTypes)
+	}
+	for _, msgType := range goTypes {
+		if !hasTypeRule(schema, msgType) {
+			t.Errorf("schema has no payload rule for %s", msgType)
+		}
+	}
+}
+
+// TestSchemaExamples 示例消息同时通过 schema 与 Go 校验，且覆盖每种消息类型
+// TestSchemaExamples checks that every schema example passes both the schema
+// and ValidateMessage, and that every message type has one.
+func TestSchemaExamples(t *testing.T) {
+	schema := loadSchema(t)
+	v := &validator{root: schema}
+	covered := make(map[string]bool)
+	for _, example := range schemaExamples(schema) {
+		raw, _ := json.Marshal(example)
+		msg := decode(t, raw)
+		covered[msg.Type] = true
+		if err := v.validate(example, schema, "$"); err != nil {
+			t.Errorf("%s example is invalid against the schema: %v", msg.Type, err)
+		}
+		if err := signaling.ValidateMessage(msg); err != nil {
+			t.Errorf("%s example is rejected by ValidateMessage: %v", msg.Type, err)
+		}
+	}
+	for _, msgType := range signaling.MessageTypes() {
+		if !covered[msgType] {
+			t.Errorf("%s has no schema example", msgType)
+		}
+	}
+}
+
+// TestCodecsRoundTrip 每种编解码器都无损往返，payload 原样保留
+// TestCodecsRoundTrip checks that every codec round-trips the schema examples
+// with their payloads intact.
+func TestCodecsRoundTrip(t *testing.T) {
+	examples := schemaExamples(loadSchema(t))
+	for _, name := range []string{signaling.CodecJSON, signaling.CodecMsgpack, signaling.CodecProtobuf} {
+		t.Run(name, func(t *testing.T) {
+			codec, ok := signaling.CodecByName(name)
+			if !ok {
+				t.Fatalf("codec %s is not registered", name)
+			}
+			for _, example := range examples {
+				raw, _ := json.Marshal(example)
+				msg := decode(t, raw)
+				encoded, err := codec.Encode(msg)
+				if err != nil {
+					t.Errorf("encode %s: %v", msg.Type, err)
+					continue
+				}
+				back, err := codec.Decode(encoded)
+				if err != nil || !sameMessage(msg, back) {
+					t.Errorf("%s does not round-trip: %v", msg.Type, err)
+				}
+			}
+		})
+	}
+}
+
+// TestInvalidMessagesRejected 非法消息同时被 schema 与 Go 拒绝
+// TestInvalidMessagesRejected checks that the schema and ValidateMessage both
+// reject every invalid message.
+func TestInvalidMessagesRejected(t *testing.T) {
+	schema := loadSchema(t)
+	v := &validator{root: schema}
+	for _, raw := range invalidMessages {
+		var doc interface{}
+		_ = json.Unmarshal([]byte(raw), &doc)
+		if err := v.validate(doc, schema, "$"); err == nil {
+			t.Errorf("schema accepts %s", raw)
+		}
+		if err := signaling.ValidateMessage(decode(t, []byte(raw))); err == nil {
+			t.Errorf("ValidateMessage accepts %s", raw)
+		}
+	}
+}
+
+var invalidMessages = []string{
+	`{"type":"call.unknown","to":"bob@example.com"}`,
+	`{"to":"bob@example.com"}`,
+	`{"type":"call.invite","payload":{"type":"offer","sdp":"v=0"}}`,
+	`{"type":"call.invite","to":"bob@example.com","payload":{"type":"answer","sdp":"v=0"}}`,
+	`{"type":"call.invite","to":"bob@example.com","payload":{"type":"offer","sdp":""}}`,
+	`{"type":"call.accept","to":"alice@example.com","payload":{"type":"answer","sdp":"v=0"}}`,
+	`{"type":"call.end","to":"bob@example.com"}`,
+	`{"type":"ice.candidate","call_id":"c1","to":"bob@example.com"}`,
+	`{"type":"ice.candidate","call_id":"c1","to":"bob@example.com","payload":{"candidate":"candidate:1"}}`,
+	`{"type":"hello","payload":{"versions":[]}}`,
+	`{"type":"error","payload":{"description":"no code"}}`,
+	`{"type":"hello.ack","payload":{"version":1,"subprotocol":"allcall.signal.v1"}}`,
+	`{"type":"server.draining","payload":{"session_id":"s1","reconnect_after_ms":-1}}`,
+	`{"type":"call.chat","payload":{"text":"hi"}}`,
+	`{"type":"call.chat","call_id":"c1","payload":{"text":""}}`,
+	`{"type":"call.reaction","call_id":"c1","payload":{}}`,
+	`{"type":"call.typing","call_id":"c1"}`,
+	`{"type":"call.hold"}`,
+	`{"type":"call.resume","call_id":"c1","payload":{"reason":"back"}}`,
+	`{"type":"call.renegotiate","call_id":"c1","payload":{"type":"pranswer","sdp":"v=0"}}`,
+	`{"type":"call.transfer","call_id":"c1","payload":{"mode":"attended","target":"carol@example.com"}}`,
+	`{"type":"call.transfer","call_id":"c1","payload":{"mode":"blind","target":"carol@example.com","consult_call_id":"c3"}}`,
+	`{"type":"call.transfer","call_id":"c1","payload":{"mode":"park","target":"carol@example.com"}}`,
+	`{"type":"call.invite","to":"carol@example.com","payload":{"type":"offer","sdp":"v=0","transfer":{}}}`,
+	`{"type":"call.accept","call_id":"c1","to":"alice@example.com","payload":{"type":"answer","sdp":"v=0","room":"standup"}}`,
+	`{"type":"call.record.start"}`,
+	`{"type":"call.record.stop","call_id":"c5","payload":{"reason":"done"}}`,
+	`{"type":"call.recording","call_id":"c5","payload":{"recording_id":"r1","state":"paused"}}`,
+	`{"type":"call.recording","call_id":"c5","payload":{"state":"started"}}`,
+	`{"type":"call.stats.get"}`,
+	`{"type":"call.stats.get","call_id":"c5","payload":{"detail":"full"}}`,
+	`{"type":"call.stats","call_id":"c5"}`,
+	`{"type":"call.stats","call_id":"c5","payload":{"rtt_ms":12}}`,
+	`{"type":"call.stats","call_id":"c5","payload":{"collected_at":"2024-01-01T00:00:10Z","jitter_ms":-1}}`,
+	`{"type":"call.stats","call_id":"c5","payload":{"collected_at":"2024-01-01T00:00:10Z","mos":7}}`,
+	`{"type":"presence.update","payload":{"online":true}}`,
+	`{"type":"presence.update","payload":{"email":"bob@example.com","online":true,"availability":"invisible"}}`,
+}
+
+func loadSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal(signaling.ProtocolSchema(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+func schemaExamples(schema map[string]interface{}) []interface{} {
+	examples, _ := schema["examples"].([]interface{})
+	return examples
+}
+
+func decode(t *testing.T, raw []byte) *signaling.SignalMessage {
+	t.Helper()
+	var msg signaling.SignalMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return &msg
+}
+
+func sameMessage(a, b *signaling.SignalMessage) bool {
+	if a.ID != b.ID || a.Type != b.Type || a.CallID != b.CallID || a.To != b.To || a.From != b.From {
+		return false
+	}
+	var pa, pb interface{}
+	_ = json.Unmarshal(a.Payload, &pa)
+	_ = json.Unmarshal(b.Payload, &pb)
+	return jsonEqual(pa, pb)
+}
+
+func schemaMessageTypes(schema map[string]interface{}) []string {
+	props, _ := schema["properties"].(map[string]interface{})
+	typeProp, _ := props["type"].(map[string]interface{})
+	enum, _ := typeProp["enum"].([]interface{})
+	types := make([]string, 0, len(enum))
+	for _, t := range enum {
+		types = append(types, fmt.Sprint(t))
+	}
+	sort.Strings(types)
+	return types
+}
+
+func hasTypeRule(schema map[string]interface{}, msgType string) bool {
+	rules, _ := schema["allOf"].([]interface{})
+	for _, rule := range rules {
+		r, _ := rule.(map[string]interface{})
+		cond, _ := r["if"].(map[string]interface{})
+		props, _ := cond["properties"].(map[string]interface{})
+		typeProp, _ := props["type"].(map[string]interface{})
+		if typeProp["const"] == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// validator 实现本协议用到的 JSON Schema 子集
+// validator implements the subset of JSON Schema keywords the protocol schema uses.
+type validator struct {
+	root map[string]interface{}
+}
+
+func (v *validator) validate(doc interface{}, schema map[string]interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		return v.validate(doc, v.resolve(ref), path)
+	}
+	if t, ok := schema["type"]; ok && !matchesType(doc, t) {
+		return fmt.Errorf("%s: expected type %v", path, t)
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(doc, c) {
+		return fmt.Errorf("%s: expected %v", path, c)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(doc, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not allowed", path, doc)
+		}
+	}
+	if s, ok := doc.(string); ok {
+		if min, ok := schema["minLength"].(float64); ok && float64(len(s)) < min {
+			return fmt.Errorf("%s: shorter than %v", path, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len(s)) > max {
+			return fmt.Errorf("%s: longer than %v", path, max)
+		}
+	}
+	if n, ok := doc.(float64); ok {
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			return fmt.Errorf("%s: below minimum %v", path, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			return fmt.Errorf("%s: above maximum %v", path, max)
+		}
+	}
+	if arr, ok := doc.([]interface{}); ok {
+		if min, ok := schema["minItems"].(float64); ok && float64(len(arr)) < min {
+			return fmt.Errorf("%s: fewer than %v items", path, min)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if obj, ok := doc.(map[string]interface{}); ok {
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := obj[name.(string)]; !present {
+					return fmt.Errorf("%s: missing %s", path, name)
+				}
+			}
+		}
+		if props, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, sub := range props {
+				value, present := obj[name]
+				if !present {
+					continue
+				}
+				if err := v.validate(value, sub.(map[string]interface{}), path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := v.validate(doc, sub.(map[string]interface{}), path); err != nil {
+				return err
+			}
+		}
+	}
+	if any, ok := schema["anyOf"].([]interface{}); ok {
+		var lastErr error
+		matched := false
+		for _, sub := range any {
+			if lastErr = v.validate(doc, sub.(map[string]interface{}), path); lastErr == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return lastErr
+		}
+	}
+	if cond, ok := schema["if"].(map[string]interface{}); ok && v.validate(doc, cond, path) == nil {
+		if then, ok := schema["then"].(map[string]interface{}); ok {
+			return v.validate(doc, then, path)
+		}
+	}
+	return nil
+}
+
+func (v *validator) resolve(ref string) map[string]interface{} {
+	node := interface{}(v.root)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]interface{})[part]
+	}
+	return node.(map[string]interface{})
+}
+
+func matchesType(doc interface{}, t interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, item := range list {
+			if matchesType(doc, item) {
+				return true
+			}
+		}
+		return false
+	}
+	switch t {
+	case "object":
+		_, ok := doc.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := doc.([]interface{})
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "integer":
+		n, ok := doc.(float64)
+		return ok && n == float64(int64(n))
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "null":
+		return doc == nil
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
@@ -0,0 +1,72 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// StartPeerReaping 定期回收卡住或被遗留的服务端对等连接
+// StartPeerReaping looks for server peer connections to reap each interval,
+// until ctx is cancelled: connections still not connected after the media
+// engine's connect timeout end their call, and connections whose call already
+// ended in signaling are closed.
+func (h *Hub) StartPeerReaping(ctx context.Context, interval time.Duration) {
+	if h.mediaEngine == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.reapPeerConnections(ctx)
+			}
+		}
+	}()
+}
+
+// reapPeerConnections 执行一次回收
+// reapPeerConnections runs one reaping pass.
+func (h *Hub) reapPeerConnections(ctx context.Context) {
+	now := time.Now()
+	for _, peerConn := range h.mediaEngine.ListPeerConnections() {
+		_, err := h.calls.Get(ctx, peerConn.CallID)
+		switch {
+		case errors.Is(err, ErrCallNotFound):
+			// 信令中通话已结束，但结束消息没有到达本节点或关闭失败
+			// The call ended in signaling, but the end never reached this
+			// node or closing the connection failed.
+			h.reapOrphan(ctx, peerConn)
+		case err != nil:
+			h.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to load call for peer connection")
+		case h.mediaEngine.Stalled(peerConn, now):
+			h.logger.Warn().
+				Str("call_id", peerConn.CallID).
+				Str("local", peerConn.LocalEmail).
+				Str("state", peerConn.PC.ConnectionState().String()).
+				Msg("peer connection did not connect in time, ending call")
+			h.adapter.endCall(ctx, &SignalMessage{
+				CallID: peerConn.CallID,
+				From:   peerConn.LocalEmail,
+				To:     peerConn.RemoteEmail,
+			}, "media connection timed out")
+		}
+	}
+}
+
+func (h *Hub) reapOrphan(ctx context.Context, peerConn *media.PeerConnection) {
+	h.finishRecording(ctx, peerConn.CallID, peerConn.LocalEmail)
+	if err := h.mediaEngine.ClosePeerConnection(peerConn.CallID, peerConn.LocalEmail, peerConn.RemoteEmail); err != nil {
+		return
+	}
+	h.logger.Warn().
+		Str("call_id", peerConn.CallID).
+		Str("local", peerConn.LocalEmail).
+		Msg("closed peer connection left behind by an ended call")
+}
@@ -0,0 +1,193 @@
+package signaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/handlers"
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+const (
+	adminToken = "signaling-test-admin"
+
+	// stuckTimeout 服务端连接建立的最长时间；reapInterval 回收的检查间隔
+	// stuckTimeout is how long a server connection may take to connect, and
+	// reapInterval how often the hub looks for connections to reap.
+	stuckTimeout = 2 * time.Second
+	reapInterval = 200 * time.Millisecond
+)
+
+type peerConnectionDTO struct {
+	CallID     string  `json:"call_id"`
+	Local      string  `json:"local"`
+	State      string  `json:"state"`
+	CallState  string  `json:"call_state"`
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// TestPeerReaping 每用户上限经信令拒绝呼叫，卡住与遗留的连接被回收，管理接口列出连接
+// TestPeerReaping checks that the per-user limit refuses calls over signaling,
+// that stuck connections and those left behind by ended calls are reaped, and
+// that the admin endpoint lists connections.
+func TestPeerReaping(t *testing.T) {
+	var engine *media.Engine
+	srv := startServer(t, func(hub *signaling.Hub) {
+		var err error
+		engine, err = signaling.InitPionMediaEngine(zerolog.Nop(), config.WebRTCConfig{
+			PeerConnections: config.PeerConnectionsConfig{MaxPerUser: 1, ConnectTimeoutSec: int(stuckTimeout / time.Second)},
+		})
+		if err != nil {
+			t.Fatalf("init media engine: %v", err)
+		}
+		t.Cleanup(func() { _ = engine.Shutdown(context.Background()) })
+		hub.WithMediaEngine(engine)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		hub.StartPeerReaping(ctx, reapInterval)
+	})
+	adminURL := startAdmin(t, engine)
+	alice := srv.connect(t, "alice@example.com")
+
+	// 管理接口
+	// Admin endpoint.
+	for _, token := range []string{"", "wrong"} {
+		if status, _ := listPeerConnections(t, adminURL, token); status != http.StatusUnauthorized {
+			t.Errorf("token %q answered %d, want %d", token, status, http.StatusUnauthorized)
+		}
+	}
+
+	// 客户端从不应用 answer，服务端连接停留在 new/connecting
+	// The client never applies the answer, so the server connection stays new
+	// or connecting.
+	inviteServer(t, alice, "1")
+	callID := alice.await(t, signaling.TypeCallAccept).CallID
+	status, items := listPeerConnections(t, adminURL, adminToken)
+	if status != http.StatusOK || len(items) != 1 {
+		t.Fatalf("admin answered %d listing %d connection(s), want the call's", status, len(items))
+	}
+	if item := items[0]; item.CallID != callID || item.Local != alice.email || (item.State != "new" && item.State != "connecting") {
+		t.Errorf("admin lists %+v for call %s", item, callID)
+	}
+
+	// 每用户上限经信令生效
+	// The per-user limit applies over signaling.
+	inviteServer(t, alice, "2")
+	var refused, end *signaling.SignalMessage
+	for refused == nil || end == nil {
+		if msg := alice.await(t, signaling.TypeError, signaling.TypeCallEnd); msg.Type == signaling.TypeError {
+			refused = msg
+		} else {
+			end = msg
+		}
+	}
+	if code := errorCode(t, refused); code != signaling.ErrorCodeMediaUnavailable {
+		t.Errorf("second call refused with %q", code)
+	}
+	if end.CallID == callID || !strings.Contains(string(end.Payload), "server busy") {
+		t.Errorf("refused call ended as %s: %s", end.CallID, end.Payload)
+	}
+
+	// 卡住的连接在超时后回收
+	// The stuck connection is reaped after the connect timeout.
+	end = alice.await(t, signaling.TypeCallEnd)
+	if end.CallID != callID || !strings.Contains(string(end.Payload), "media connection timed out") {
+		t.Errorf("call %s ended as %s: %s", callID, end.CallID, end.Payload)
+	}
+	if !awaitNoPeerConnections(engine) {
+		t.Error("stuck connection not reaped")
+	}
+
+	// 信令中不存在该通话，例如结束消息在另一节点上丢失
+	// The call is unknown to signaling, as when its end got lost on another node.
+	if _, err := engine.CreatePeerConnection(context.Background(), "ended-call", alice.email, signaling.ServerPeer, nil); err != nil {
+		t.Fatalf("create orphan peer connection: %v", err)
+	}
+	if !awaitNoPeerConnections(engine) {
+		t.Error("connection of an ended call not reaped")
+	}
+}
+
+// startAdmin 启动列出对等连接的管理接口
+// startAdmin serves the admin endpoint listing the engine's peer connections.
+func startAdmin(t *testing.T, engine *media.Engine) string {
+	t.Helper()
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	admin := router.Group("/api/v1/admin")
+	admin.Use(auth.AdminMiddleware(adminToken))
+	handlers.NewAdminHandler(zerolog.Nop(), engine, nil).RegisterRoutes(admin)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// inviteServer 以一个从不应用 answer 的客户端 offer 呼叫服务端
+// inviteServer calls the server with the offer of a client that never applies
+// the answer.
+func inviteServer(t *testing.T, c *client, id string) {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("create client peer connection: %v", err)
+	}
+	defer pc.Close()
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatalf("add audio transceiver: %v", err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	c.send(t, &signaling.SignalMessage{
+		ID:      id,
+		Type:    signaling.TypeCallInvite,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: offer.SDP}),
+	})
+}
+
+func listPeerConnections(t *testing.T, baseURL, token string) (int, []peerConnectionDTO) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/admin/peer-connections", nil)
+	if err != nil {
+		t.Fatalf("build admin request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("list peer connections: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		PeerConnections []peerConnectionDTO `json:"peer_connections"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.PeerConnections
+}
+
+// awaitNoPeerConnections 在一秒内等待引擎中的连接全部回收
+// awaitNoPeerConnections waits up to a second for the engine to hold no peer
+// connection.
+func awaitNoPeerConnections(engine *media.Engine) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if len(engine.ListPeerConnections()) == 0 {
+			return true
+		}
+	}
+	return len(engine.ListPeerConnections()) == 0
+}
@@ -0,0 +1,91 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/allcallall/backend/internal/media"
+)
+
+// startRecording 开始录制发送者与服务端的通话，并通知所有被录制的参与者
+// startRecording starts recording the sender's server-terminated call, or the
+// SFU room it joined, and tells everyone being recorded.
+func (h *Hub) startRecording(ctx context.Context, msg *SignalMessage) error {
+	manifest, err := h.mediaEngine.StartRecording(msg.CallID, msg.From, ServerPeer, uuid.NewString())
+	switch {
+	case errors.Is(err, media.ErrRecordingDisabled), errors.Is(err, media.ErrAlreadyRecording):
+		return newProtocolError(ErrorCodeRecordingUnavailable, msg.ID, "%v", err)
+	case err != nil:
+		return err
+	}
+
+	if h.history != nil {
+		if err := h.history.StartRecording(ctx, manifest, msg.From); err != nil {
+			h.logger.Warn().Err(err).Str("call_id", msg.CallID).Str("recording_id", manifest.ID).Msg("failed to save recording")
+		}
+	}
+	h.announceRecording(ctx, manifest, RecordingStarted, msg.From, manifest.Participants)
+	return nil
+}
+
+// stopRecording 停止 email 与服务端通话上的录制并通知参与者；by 为空表示通话结束时由服务端停止
+// stopRecording stops the recording started on email's call with the server
+// and tells everyone who was recorded. An empty by means the server stopped it
+// because the call ended.
+func (h *Hub) stopRecording(ctx context.Context, callID, email, by string) error {
+	manifest, err := h.mediaEngine.StopRecording(callID, email, ServerPeer)
+	if err != nil {
+		return err
+	}
+
+	if h.history != nil {
+		if err := h.history.FinishRecording(ctx, manifest); err != nil {
+			h.logger.Warn().Err(err).Str("call_id", callID).Str("recording_id", manifest.ID).Msg("failed to save recording")
+		}
+	}
+	h.announceRecording(ctx, manifest, RecordingStopped, by, manifest.Participants)
+	return nil
+}
+
+// handleRecordStop 处理客户端的停止录制请求
+// handleRecordStop handles a client's request to stop recording.
+func (h *Hub) handleRecordStop(ctx context.Context, msg *SignalMessage) error {
+	err := h.stopRecording(ctx, msg.CallID, msg.From, msg.From)
+	if errors.Is(err, media.ErrNotRecording) {
+		return newProtocolError(ErrorCodeRecordingUnavailable, msg.ID, "%v", err)
+	}
+	return err
+}
+
+// finishRecording 通话结束时停止 email 在其上发起的录制（如有）
+// finishRecording stops the recording started on email's call with the
+// server, if there is one, when the call ends.
+func (h *Hub) finishRecording(ctx context.Context, callID, email string) {
+	if err := h.stopRecording(ctx, callID, email, ""); err != nil && !errors.Is(err, media.ErrNotRecording) {
+		h.logger.Warn().Err(err).Str("call_id", callID).Msg("failed to stop recording")
+	}
+}
+
+// announceRecording 向参与者发送 call.recording，每人使用其与服务端的通话 ID
+// announceRecording sends call.recording to each participant, on their own
+// call with the server.
+func (h *Hub) announceRecording(ctx context.Context, manifest *media.RecordingManifest, state, by string, participants []media.RecordingParticipant) {
+	payload, err := json.Marshal(RecordingPayload{RecordingID: manifest.ID, State: state, By: by})
+	if err != nil {
+		return
+	}
+	for _, p := range participants {
+		notice := &SignalMessage{
+			Type:    TypeCallRecording,
+			CallID:  p.CallID,
+			From:    ServerPeer,
+			Payload: payload,
+		}
+		if err := h.SendToUser(ctx, p.Email, notice); err != nil {
+			h.logger.Warn().Err(err).Str("call_id", p.CallID).Str("to", p.Email).Msg("failed to announce recording")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package signaling
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	routeKeyPrefix    = "signal:route:"
+	nodeChannelPrefix = "signal:node:"
+	defaultRouteTTL   = 60 * time.Second
+)
+
+// RouteRegistry 用户到节点的路由表
+// RouteRegistry maps a user to the signaling nodes holding their connections.
+// Entries expire unless refreshed, so a crashed node drops out on its own.
+type RouteRegistry interface {
+	// Register 记录 email 在 nodeID 上有连接
+	// Register records that nodeID holds at least one connection for email.
+	Register(ctx context.Context, email, nodeID string, ttl time.Duration) error
+
+	// Refresh 批量续期本节点的路由
+	// Refresh extends the routes of every email held by nodeID.
+	Refresh(ctx context.Context, nodeID string, emails []string, ttl time.Duration) error
+
+	// Unregister 删除 email 在 nodeID 上的路由
+	// Unregister removes the route of email on nodeID.
+	Unregister(ctx context.Context, email, nodeID string) error
+
+	// Lookup 返回持有 email 连接的节点
+	// Lookup returns the nodes currently holding a connection for email.
+	Lookup(ctx context.Context, email string) ([]string, error)
+}
+
+// RedisRouteRegistry 基于 Redis 有序集合的路由表，分数为过期时间
+// RedisRouteRegistry stores routes in one sorted set per user, scored by expiry time.
+type RedisRouteRegistry struct {
+	redis *redis.Client
+}
+
+// NewRedisRouteRegistry 创建 Redis 路由表
+// NewRedisRouteRegistry returns a Redis backed route registry.
+func NewRedisRouteRegistry(rdb *redis.Client) *RedisRouteRegistry {
+	return &RedisRouteRegistry{redis: rdb}
+}
+
+// Register 实现 RouteRegistry
+// Register implements RouteRegistry.
+func (r *RedisRouteRegistry) Register(ctx context.Context, email, nodeID string, ttl time.Duration) error {
+	return r.Refresh(ctx, nodeID, []string{email}, ttl)
+}
+
+// Refresh 实现 RouteRegistry
+// Refresh implements RouteRegistry.
+func (r *RedisRouteRegistry) Refresh(ctx context.Context, nodeID string, emails []string, ttl time.Duration) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	now := time.Now()
+	expires := float64(now.Add(ttl).UnixMilli())
+	stale := strconv.FormatInt(now.UnixMilli(), 10)
+
+	pipe := r.redis.Pipeline()
+	for _, email := range emails {
+		key := routeKeyPrefix + email
+		pipe.ZAdd(ctx, key, redis.Z{Score: expires, Member: nodeID})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", stale)
+		pipe.PExpire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Unregister 实现 RouteRegistry
+// Unregister implements RouteRegistry.
+func (r *RedisRouteRegistry) Unregister(ctx context.Context, email, nodeID string) error {
+	return r.redis.ZRem(ctx, routeKeyPrefix+email, nodeID).Err()
+}
+
+// Lookup 实现 RouteRegistry
+// Lookup implements RouteRegistry.
+func (r *RedisRouteRegistry) Lookup(ctx context.Context, email string) ([]string, error) {
+	return r.redis.ZRangeByScore(ctx, routeKeyPrefix+email, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+// MemoryRouteRegistry 进程内路由表，用于单节点部署和测试
+// MemoryRouteRegistry is an in-process route registry for single-node deployments and tests.
+type MemoryRouteRegistry struct {
+	mu     sync.Mutex
+	routes map[string]map[string]time.Time
+}
+
+// NewMemoryRouteRegistry 创建进程内路由表
+// NewMemoryRouteRegistry returns an in-process route registry.
+func NewMemoryRouteRegistry() *MemoryRouteRegistry {
+	return &MemoryRouteRegistry{routes: make(map[string]map[string]time.Time)}
+}
+
+// Register 实现 RouteRegistry
+// Register implements RouteRegistry.
+func (r *MemoryRouteRegistry) Register(ctx context.Context, email, nodeID string, ttl time.Duration) error {
+	return r.Refresh(ctx, nodeID, []string{email}, ttl)
+}
+
+// Refresh 实现 RouteRegistry
+// Refresh implements RouteRegistry.
+func (r *MemoryRouteRegistry) Refresh(ctx context.Context, nodeID string, emails []string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	for _, email := range emails {
+		if _, ok := r.routes[email]; !ok {
+			r.routes[email] = make(map[string]time.Time)
+		}
+		r.routes[email][nodeID] = expires
+	}
+	return nil
+}
+
+// Unregister 实现 RouteRegistry
+// Unregister implements RouteRegistry.
+func (r *MemoryRouteRegistry) Unregister(ctx context.Context, email, nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if nodes, ok := r.routes[email]; ok {
+		delete(nodes, nodeID)
+		if len(nodes) == 0 {
+			delete(r.routes, email)
+		}
+	}
+	return nil
+}
+
+// Lookup 实现 RouteRegistry
+// Lookup implements RouteRegistry.
+func (r *MemoryRouteRegistry) Lookup(ctx context.Context, email string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	nodes := make([]string, 0, len(r.routes[email]))
+	for node, expires := range r.routes[email] {
+		if expires.After(now) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+func nodeChannel(nodeID string) string {
+	return nodeChannelPrefix + nodeID
+}
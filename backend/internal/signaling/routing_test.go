@@ -0,0 +1,203 @@
+package signaling_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/pubsub"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+const benchClientName = "allcall-signal-bench"
+
+var (
+	routingUsers   = flag.Int("routing.users", 5000, "number of connected users BenchmarkRouting simulates")
+	routingBackend = flag.String("routing.backend", pubsub.BackendMemory, "pubsub backend of BenchmarkRouting: memory | redis | redis_streams")
+)
+
+// BenchmarkRouting 比较“每连接订阅”与“每节点订阅”两种路由设计
+// BenchmarkRouting compares the per-socket and per-node subscription designs.
+// Each iteration routes one message; the goroutines, subscriptions and Redis
+// connections a design holds for its users are reported as metrics. Run it
+// against a real Redis at REDIS_ADDR with
+//
+//	go test ./internal/signaling -run '^$' -bench Routing -args -routing.backend=redis
+func BenchmarkRouting(b *testing.B) {
+	rdb := benchRedis(b)
+	b.Run("per-socket", func(b *testing.B) {
+		benchPerSocket(b, newBenchBroker(b, rdb), rdb, *routingUsers)
+	})
+	settle()
+	b.Run("per-node", func(b *testing.B) {
+		benchPerNode(b, newBenchBroker(b, rdb), rdb, *routingUsers)
+	})
+}
+
+// benchPerSocket 复现旧设计：每个连接一个订阅和一个转发协程
+// benchPerSocket reproduces the previous design: one subscription and forwarder goroutine per socket.
+func benchPerSocket(b *testing.B, broker pubsub.Broker, rdb *redis.Client, users int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	baseGoroutines := runtime.NumGoroutine()
+	baseConns := redisConns(b, rdb)
+
+	subs := make([]pubsub.Subscription, 0, users)
+	for i := 0; i < users; i++ {
+		sub, err := broker.Subscribe(ctx, "signal:"+userEmail(i))
+		if err != nil {
+			b.Fatalf("per-socket subscribe: %v", err)
+		}
+		subs = append(subs, sub)
+		go func() {
+			for msg := range sub.Messages() {
+				_ = msg.Ack(ctx)
+			}
+		}()
+	}
+	defer func() {
+		for _, sub := range subs {
+			_ = sub.Close()
+		}
+	}()
+	goroutines, conns := runtime.NumGoroutine()-baseGoroutines, redisConns(b, rdb)-baseConns
+
+	payload := []byte(`{"node_id":"bench","data":{"type":"call.end","call_id":"c","to":"x","from":"y"}}`)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := broker.Publish(ctx, "signal:"+userEmail(i%users), payload); err != nil {
+			b.Fatalf("per-socket publish: %v", err)
+		}
+	}
+	reportFootprint(b, goroutines, len(subs), conns)
+}
+
+// benchPerNode 新设计：每个节点一个订阅，路由表记录用户所在节点
+// benchPerNode measures the per-node design: one subscription per node plus route registry entries.
+func benchPerNode(b *testing.B, broker pubsub.Broker, rdb *redis.Client, users int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+
+	var routes signaling.RouteRegistry = signaling.NewMemoryRouteRegistry()
+	if rdb != nil {
+		routes = signaling.NewRedisRouteRegistry(rdb)
+	}
+
+	// 发送方节点在基线之前启动，只统计接收节点的开销
+	// The sending node starts before the baseline so only the receiving node is measured.
+	sender := signaling.NewHub(broker, routes, logger, nil)
+	if err := sender.Start(ctx); err != nil {
+		b.Fatalf("start sender node: %v", err)
+	}
+
+	baseGoroutines := runtime.NumGoroutine()
+	baseConns := redisConns(b, rdb)
+
+	receiver := signaling.NewHub(broker, routes, logger, nil)
+	if err := receiver.Start(ctx); err != nil {
+		b.Fatalf("start receiver node: %v", err)
+	}
+	emails := make([]string, users)
+	for i := range emails {
+		emails[i] = userEmail(i)
+	}
+	// 与 Hub 续期路由时相同的批量写入
+	// Same batched write the hub performs when refreshing its routes.
+	if err := routes.Refresh(ctx, receiver.NodeID(), emails, time.Minute); err != nil {
+		b.Fatalf("register routes: %v", err)
+	}
+	if rdb != nil {
+		defer func() {
+			for _, email := range emails {
+				_ = routes.Unregister(context.Background(), email, receiver.NodeID())
+			}
+		}()
+	}
+	goroutines, conns := runtime.NumGoroutine()-baseGoroutines, redisConns(b, rdb)-baseConns
+
+	msg := &signaling.SignalMessage{Type: signaling.TypeCallEnd, CallID: "c", From: "bench"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.SendToUser(ctx, userEmail(i%users), msg); err != nil {
+			b.Fatalf("per-node send: %v", err)
+		}
+	}
+	reportFootprint(b, goroutines, 1, conns)
+}
+
+// reportFootprint 在计时循环后报告，ResetTimer 会清除之前的指标
+// reportFootprint runs after the timed loop, as ResetTimer drops metrics
+// reported before it.
+func reportFootprint(b *testing.B, goroutines, subscriptions, conns int) {
+	b.ReportMetric(float64(goroutines), "goroutines")
+	b.ReportMetric(float64(subscriptions), "subscriptions")
+	b.ReportMetric(float64(conns), "redis-conns")
+}
+
+// benchRedis 按 -routing.backend 连接 Redis；memory 后端返回 nil
+// benchRedis connects to Redis for the redis backends and returns nil for memory.
+func benchRedis(b *testing.B) *redis.Client {
+	if *routingBackend == pubsub.BackendMemory {
+		return nil
+	}
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:       addr,
+		ClientName: benchClientName,
+		PoolSize:   *routingUsers + 16,
+	})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		b.Fatalf("connect redis at %s: %v", addr, err)
+	}
+	b.Cleanup(func() { _ = rdb.Close() })
+	return rdb
+}
+
+func newBenchBroker(b *testing.B, rdb *redis.Client) pubsub.Broker {
+	logger := zerolog.Nop()
+	switch *routingBackend {
+	case pubsub.BackendMemory:
+		return pubsub.NewMemory(logger)
+	case pubsub.BackendRedis:
+		return pubsub.NewRedis(rdb, logger)
+	case pubsub.BackendRedisStreams:
+		return pubsub.NewRedisStreams(rdb, logger, pubsub.StreamsOptions{})
+	}
+	b.Fatalf("unknown backend %q", *routingBackend)
+	return nil
+}
+
+// redisConns 统计本进程打开的 Redis 连接数
+// redisConns counts Redis connections opened by this process.
+func redisConns(b *testing.B, rdb *redis.Client) int {
+	if rdb == nil {
+		return 0
+	}
+	list, err := rdb.ClientList(context.Background()).Result()
+	if err != nil {
+		b.Fatalf("CLIENT LIST: %v", err)
+	}
+	return strings.Count(list, "name="+benchClientName+" ")
+}
+
+func settle() {
+	time.Sleep(500 * time.Millisecond)
+	runtime.GC()
+}
+
+func userEmail(i int) string {
+	return fmt.Sprintf("user%d@bench.local", i)
+}
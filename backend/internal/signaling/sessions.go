@@ -0,0 +1,127 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sessionKeyPrefix        = "signal:session:"
+	defaultSessionResumeTTL = 2 * time.Minute
+)
+
+// ErrSessionNotFound 会话不存在或已被恢复
+// ErrSessionNotFound indicates the session expired or was already resumed.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session 可在其他节点恢复的信令会话
+// Session is a signaling session handed off so it can be resumed on another node.
+type Session struct {
+	ID      string `json:"session_id"`
+	Email   string `json:"email"`
+	Version int    `json:"version"`
+	NodeID  string `json:"node_id"`
+}
+
+// SessionStore 会话交接存储
+// SessionStore hands sessions over between nodes.
+type SessionStore interface {
+	// Save 保存会话，ttl 后过期
+	// Save stores the session until ttl elapses.
+	Save(ctx context.Context, session *Session, ttl time.Duration) error
+
+	// Take 取出并删除会话，保证一个会话只能恢复一次
+	// Take fetches and removes the session so it can be resumed only once.
+	Take(ctx context.Context, sessionID string) (*Session, error)
+}
+
+// RedisSessionStore 基于 Redis 的会话存储
+// RedisSessionStore keeps handed-off sessions in Redis.
+type RedisSessionStore struct {
+	redis *redis.Client
+}
+
+// NewRedisSessionStore 创建 Redis 会话存储
+// NewRedisSessionStore returns a Redis backed session store.
+func NewRedisSessionStore(rdb *redis.Client) *RedisSessionStore {
+	return &RedisSessionStore{redis: rdb}
+}
+
+// Save 实现 SessionStore
+// Save implements SessionStore.
+func (s *RedisSessionStore) Save(ctx context.Context, session *Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, sessionKeyPrefix+session.ID, data, ttl).Err()
+}
+
+// Take 实现 SessionStore
+// Take implements SessionStore.
+func (s *RedisSessionStore) Take(ctx context.Context, sessionID string) (*Session, error) {
+	data, err := s.redis.GetDel(ctx, sessionKeyPrefix+sessionID).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// MemorySessionStore 进程内会话存储
+// MemorySessionStore is an in-process session store for single-node deployments and tests.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	session Session
+	expires time.Time
+}
+
+// NewMemorySessionStore 创建进程内会话存储
+// NewMemorySessionStore returns an in-process session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Save 实现 SessionStore
+// Save implements SessionStore.
+func (s *MemorySessionStore) Save(ctx context.Context, session *Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, entry := range s.sessions {
+		if now.After(entry.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = memorySession{session: *session, expires: now.Add(ttl)}
+	return nil
+}
+
+// Take 实现 SessionStore
+// Take implements SessionStore.
+func (s *MemorySessionStore) Take(ctx context.Context, sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[sessionID]
+	if !ok || time.Now().After(entry.expires) {
+		delete(s.sessions, sessionID)
+		return nil, ErrSessionNotFound
+	}
+	delete(s.sessions, sessionID)
+	return &entry.session, nil
+}
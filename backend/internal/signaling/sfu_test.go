@@ -0,0 +1,395 @@
+package signaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/media"
+	"github.com/allcallall/backend/internal/signaling"
+)
+
+const sfuRoom = "sfu-test-room"
+
+// TestSFURoom 两个 Pion 客户端加入同一 SFU 房间，互相收到对方的音频
+// TestSFURoom runs two Pion clients through the server in SFU mode: both join
+// one room, each receives the other's audio, the room is recorded to disk, and
+// when one hangs up the other's subscription is renegotiated away.
+func TestSFURoom(t *testing.T) {
+	recordingDir := t.TempDir()
+	var engine *media.Engine
+	srv := startServer(t, func(hub *signaling.Hub) {
+		var err error
+		engine, err = signaling.InitPionMediaEngine(zerolog.Nop(), config.WebRTCConfig{MediaMode: media.ModeSFU, RecordingDir: recordingDir})
+		if err != nil {
+			t.Fatalf("init media engine: %v", err)
+		}
+		t.Cleanup(func() { _ = engine.Shutdown(context.Background()) })
+		hub.WithMediaEngine(engine)
+	})
+	alice := joinRoom(t, srv, "alice@example.com")
+	bob := joinRoom(t, srv, "bob@example.com")
+
+	// 加入
+	// Join.
+	alice.awaitConnected(t)
+	bob.awaitConnected(t)
+	if n := len(engine.ListPeerConnections()); n != 2 {
+		t.Errorf("server engine holds %d peer connections, want one per participant", n)
+	}
+
+	// 转发：订阅轨道的流 ID 是发布者的邮箱
+	// Forwarding. The stream ID of a subscribed track is its publisher's email.
+	for _, pair := range [][2]*roomMember{{alice, bob}, {bob, alice}} {
+		publisher, subscriber := pair[0], pair[1]
+		track := subscriber.awaitTrack(t)
+		if track.StreamID() != publisher.email {
+			t.Errorf("%s subscribed to stream %q, want %q", subscriber.email, track.StreamID(), publisher.email)
+		}
+		if !receivesRTP(track) {
+			t.Errorf("%s's audio does not reach %s", publisher.email, subscriber.email)
+		}
+	}
+
+	// 录制整个房间；房间内不能再开始第二个录制
+	// Recording covers the whole room, which cannot be recorded twice.
+	alice.toServer(t, signaling.TypeCallRecordStart, nil)
+	started := alice.awaitRecording(t, signaling.RecordingStarted)
+	if started.By != alice.email {
+		t.Errorf("recording started by %q, want %q", started.By, alice.email)
+	}
+	bob.awaitRecording(t, signaling.RecordingStarted)
+	if _, err := engine.StartRecording(bob.call(), bob.email, signaling.ServerPeer, "second"); !errors.Is(err, media.ErrAlreadyRecording) {
+		t.Errorf("second recording of the room got %v, want ErrAlreadyRecording", err)
+	}
+	time.Sleep(time.Second)
+	alice.toServer(t, signaling.TypeCallRecordStop, nil)
+	alice.awaitRecording(t, signaling.RecordingStopped)
+	bob.awaitRecording(t, signaling.RecordingStopped)
+	checkRecording(t, filepath.Join(recordingDir, started.RecordingID), alice.email, bob.email)
+
+	// 离开：对方的订阅经重新协商移除，只关闭离开者的连接
+	// Leave. The other member's subscription is renegotiated away and only the
+	// leaver's peer connection closes.
+	alice.toServer(t, signaling.TypeCallEnd, mustJSON(t, signaling.CallReasonPayload{Reason: "hangup"}))
+	bob.awaitOffer(t, func(sdp string) bool { return !sendsMedia(sdp, "audio") })
+	left := false
+	for deadline := time.Now().Add(awaitTimeout); !left && time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		left = len(engine.ListPeerConnections()) == 1
+	}
+	if !left {
+		t.Errorf("server engine holds %d peer connections after a hang up, want 1", len(engine.ListPeerConnections()))
+	}
+}
+
+// roomMember 一个房间参与者：信令连接、对等连接与收到的事件
+// roomMember is one room member: its signaling socket, peer connection and
+// the events the test waits for. A goroutine answers the server's
+// renegotiation offers while the test waits, so writes to the socket go
+// through send.
+type roomMember struct {
+	*client
+	pc        *webrtc.PeerConnection
+	connected <-chan struct{}
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	callID  string
+
+	tracks     chan *webrtc.TrackRemote
+	offers     chan string
+	recordings chan signaling.RecordingPayload
+	stop       chan struct{}
+}
+
+// joinRoom 连接信令并以 room 邀请服务端
+// joinRoom connects a member and invites the server into the test room.
+func joinRoom(t *testing.T, srv *testServer, email string) *roomMember {
+	t.Helper()
+	c := srv.connect(t, email)
+	pc, candidates, connected := newClientPeer(t)
+	m := &roomMember{
+		client:     c,
+		pc:         pc,
+		connected:  connected,
+		tracks:     make(chan *webrtc.TrackRemote, 4),
+		offers:     make(chan string, 16),
+		recordings: make(chan signaling.RecordingPayload, 4),
+		stop:       make(chan struct{}),
+	}
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		select {
+		case m.tracks <- track:
+		case <-m.stop:
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set local description: %v", err)
+	}
+	m.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeCallInvite,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "offer", SDP: offer.SDP, Room: sfuRoom}),
+	})
+
+	// 最后注册的清理最先执行：先停下读循环，再关闭连接
+	// Cleanups run last-in first-out, so the read loop stops before the
+	// connections close under it.
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		m.readLoop(t, candidates)
+	}()
+	t.Cleanup(func() {
+		close(m.stop)
+		<-finished
+	})
+	return m
+}
+
+// readLoop 处理服务端消息：应答、候选、重新协商与录制通知
+// readLoop applies the server's answer, candidates and renegotiation offers,
+// trickles the client's candidates once the call_id is known and queues
+// recording notices.
+func (m *roomMember) readLoop(t *testing.T, candidates <-chan webrtc.ICECandidateInit) {
+	var pending []webrtc.ICECandidateInit
+	for {
+		select {
+		case <-m.stop:
+			return
+		case c := <-candidates:
+			if callID := m.call(); callID != "" {
+				m.sendCandidate(t, callID, c)
+			} else {
+				pending = append(pending, c)
+			}
+		case msg, ok := <-m.frames:
+			if !ok {
+				return
+			}
+			switch msg.Type {
+			case signaling.TypeCallInviteAck:
+				m.mu.Lock()
+				m.callID = msg.CallID
+				m.mu.Unlock()
+				for _, c := range pending {
+					m.sendCandidate(t, msg.CallID, c)
+				}
+				pending = nil
+			case signaling.TypeCallAccept:
+				var answer signaling.SessionDescriptionPayload
+				_ = json.Unmarshal(msg.Payload, &answer)
+				if err := m.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+					t.Errorf("%s: set remote answer: %v", m.email, err)
+				}
+			case signaling.TypeIceCandidate:
+				var c webrtc.ICECandidateInit
+				_ = json.Unmarshal(msg.Payload, &c)
+				_ = m.pc.AddICECandidate(c)
+			case signaling.TypeCallRenegotiate:
+				m.answerOffer(t, msg)
+			case signaling.TypeCallRecording:
+				var notice signaling.RecordingPayload
+				_ = json.Unmarshal(msg.Payload, &notice)
+				select {
+				case m.recordings <- notice:
+				case <-m.stop:
+					return
+				}
+			case signaling.TypeError:
+				t.Errorf("%s: server error: %s", m.email, msg.Payload)
+			}
+		}
+	}
+}
+
+// answerOffer 应用服务端的重新协商 offer 并回复 answer
+// answerOffer applies a server renegotiation offer, replies with an answer
+// and queues the offer for awaitOffer.
+func (m *roomMember) answerOffer(t *testing.T, msg *signaling.SignalMessage) {
+	var offer signaling.SessionDescriptionPayload
+	_ = json.Unmarshal(msg.Payload, &offer)
+	if offer.Type != "offer" {
+		return
+	}
+	if err := m.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		t.Errorf("%s: apply renegotiation offer: %v", m.email, err)
+		return
+	}
+	answer, err := m.pc.CreateAnswer(nil)
+	if err == nil {
+		err = m.pc.SetLocalDescription(answer)
+	}
+	if err != nil {
+		t.Errorf("%s: answer renegotiation: %v", m.email, err)
+		return
+	}
+	m.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeCallRenegotiate,
+		CallID:  msg.CallID,
+		Payload: mustJSON(t, signaling.SessionDescriptionPayload{Type: "answer", SDP: answer.SDP}),
+	})
+	select {
+	case m.offers <- offer.SDP:
+	case <-m.stop:
+	}
+}
+
+// send 与读循环共用连接，因此加锁；失败只记录错误，可在读循环中调用
+// send serializes writes with the read loop. It reports failures with
+// t.Errorf so the read loop may call it too.
+func (m *roomMember) send(t *testing.T, msg *signaling.SignalMessage) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	if err := m.conn.WriteJSON(msg); err != nil {
+		t.Errorf("%s: send %s: %v", m.email, msg.Type, err)
+	}
+}
+
+func (m *roomMember) sendCandidate(t *testing.T, callID string, c webrtc.ICECandidateInit) {
+	m.send(t, &signaling.SignalMessage{
+		Type:    signaling.TypeIceCandidate,
+		CallID:  callID,
+		To:      signaling.ServerPeer,
+		Payload: mustJSON(t, c),
+	})
+}
+
+// toServer 在成员的通话上向服务端发送控制消息
+// toServer sends a control message about the member's call to the server.
+func (m *roomMember) toServer(t *testing.T, msgType string, payload json.RawMessage) {
+	t.Helper()
+	m.send(t, &signaling.SignalMessage{Type: msgType, CallID: m.call(), To: signaling.ServerPeer, Payload: payload})
+}
+
+func (m *roomMember) call() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.callID
+}
+
+func (m *roomMember) awaitConnected(t *testing.T) {
+	t.Helper()
+	select {
+	case <-m.connected:
+	case <-time.After(connectTimeout):
+		t.Fatalf("%s: not connected within %s", m.email, connectTimeout)
+	}
+}
+
+func (m *roomMember) awaitTrack(t *testing.T) *webrtc.TrackRemote {
+	t.Helper()
+	select {
+	case track := <-m.tracks:
+		return track
+	case <-time.After(connectTimeout):
+		t.Fatalf("%s: no track subscribed within %s", m.email, connectTimeout)
+		return nil
+	}
+}
+
+// awaitOffer 等待满足条件的重新协商 offer
+// awaitOffer waits for a renegotiation offer whose SDP satisfies match.
+func (m *roomMember) awaitOffer(t *testing.T, match func(sdp string) bool) {
+	t.Helper()
+	deadline := time.After(connectTimeout)
+	for {
+		select {
+		case sdp := <-m.offers:
+			if match(sdp) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("%s: no matching renegotiation offer within %s", m.email, connectTimeout)
+		}
+	}
+}
+
+// awaitRecording 等待指定状态的 call.recording
+// awaitRecording waits for a call.recording notice in the given state.
+func (m *roomMember) awaitRecording(t *testing.T, state string) signaling.RecordingPayload {
+	t.Helper()
+	deadline := time.After(awaitTimeout)
+	for {
+		select {
+		case notice := <-m.recordings:
+			if notice.State == state {
+				return notice
+			}
+		case <-deadline:
+			t.Fatalf("%s: no recording %s notice within %s", m.email, state, awaitTimeout)
+		}
+	}
+}
+
+// receivesRTP 轨道上是否收到 RTP 包
+// receivesRTP reports whether an RTP packet arrives on track in time.
+func receivesRTP(track *webrtc.TrackRemote) bool {
+	got := make(chan struct{})
+	go func() {
+		if _, _, err := track.ReadRTP(); err == nil {
+			close(got)
+		}
+	}()
+	select {
+	case <-got:
+		return true
+	case <-time.After(awaitTimeout):
+		return false
+	}
+}
+
+// checkRecording 校验清单与轨道文件：每位参与者一个非空 OGG 文件
+// checkRecording checks the manifest and track files: one non-empty OGG file
+// per participant, each listed with its timing.
+func checkRecording(t *testing.T, dir string, emails ...string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, media.ManifestFile))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var manifest media.RecordingManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if manifest.EndedAt == nil {
+		t.Error("manifest has no end time")
+	}
+	if len(manifest.Participants) != len(emails) {
+		t.Errorf("manifest lists %d participants, want %d", len(manifest.Participants), len(emails))
+	}
+
+	for _, email := range emails {
+		var track *media.RecordingTrack
+		for i := range manifest.Tracks {
+			if manifest.Tracks[i].Participant == email {
+				track = &manifest.Tracks[i]
+			}
+		}
+		if track == nil {
+			t.Errorf("%s's track not recorded", email)
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, track.File)); err != nil || !strings.HasSuffix(track.File, ".ogg") || info.Size() <= 1024 {
+			t.Errorf("%s's audio not written to %s: %v", email, track.File, err)
+		}
+		if track.OffsetMS < 0 || track.DurationMS <= 500 {
+			t.Errorf("%s's track timed at offset %dms, %dms long", email, track.OffsetMS, track.DurationMS)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// sseKeepAliveInterval 心跳间隔，避免代理关闭空闲连接
+	// sseKeepAliveInterval keeps proxies from closing an idle event stream.
+	sseKeepAliveInterval = 25 * time.Second
+	// sseRetry 断线后浏览器 EventSource 的重连间隔
+	// sseRetry is the reconnect delay advertised to EventSource clients.
+	sseRetry = 3 * time.Second
+)
+
+// ErrStreamingUnsupported 响应写入器不支持逐条刷新
+// ErrStreamingUnsupported is returned when the response writer cannot flush events.
+var ErrStreamingUnsupported = errors.New("signaling: response does not support streaming")
+
+// sseTransport Server-Sent Events 传输，仅负责下行；上行走 HTTP POST
+// sseTransport carries server-to-client messages as Server-Sent Events.
+// Client-to-server messages arrive separately through Hub.Submit.
+type sseTransport struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	// 处理函数返回后写入器会被复用，关闭后不得再写
+	// The writer is reused once the handler returns, so nothing may be written after close.
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newSSETransport(w http.ResponseWriter) (*sseTransport, error) {
+	if _, ok := w.(http.Flusher); !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	return &sseTransport{
+		w:    w,
+		rc:   http.NewResponseController(w),
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (t *sseTransport) Subprotocol() string {
+	return ""
+}
+
+func (t *sseTransport) WriteMessage(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return net.ErrClosed
+	}
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return t.write(buf.Bytes())
+}
+
+// keepAlive 写入注释行作为心跳
+// keepAlive writes a comment line as a heartbeat.
+func (t *sseTransport) keepAlive() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return net.ErrClosed
+	}
+	return t.write([]byte(": keep-alive\n\n"))
+}
+
+func (t *sseTransport) write(p []byte) error {
+	if _, err := t.w.Write(p); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// Shutdown 结束事件流；EventSource 会按 retry 自动重连
+// Shutdown ends the event stream. EventSource clients reconnect on their own after the retry delay.
+func (t *sseTransport) Shutdown(code int, reason string) {
+	t.once.Do(func() { close(t.done) })
+}
+
+func (t *sseTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+// HandleStream 以 SSE 连接用户，直到请求结束或连接被关闭
+// HandleStream attaches an SSE event stream for email to the hub and blocks
+// until the request ends or the hub closes the stream. The first event is a
+// hello.ack carrying the session ID the client must send with Submit.
+func (h *Hub) HandleStream(ctx context.Context, email, device string, w http.ResponseWriter) error {
+	t, err := newSSETransport(w)
+	if err != nil {
+		return err
+	}
+	// 事件流是长连接，不受服务器写超时限制
+	// The stream is long-lived, so lift the server's write timeout.
+	_ = t.rc.SetWriteDeadline(time.Time{})
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := t.write([]byte(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds()))); err != nil {
+		return err
+	}
+
+	// 事件流是文本，始终使用 JSON
+	// Event streams are text, so they always carry JSON.
+	cl := newClient(email, device, t, jsonCodec)
+	h.serve(ctx, cl, func(ctx context.Context) {
+		if err := h.sendSessionAck(cl); err != nil {
+			h.logger.Warn().Err(err).Str("email", email).Msg("failed to announce event stream session")
+		}
+
+		ticker := time.NewTicker(sseKeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.done:
+				return
+			case <-ticker.C:
+				if err := t.keepAlive(); err != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Submit 处理通过 HTTP 提交的客户端消息
+// Submit handles a client message sent over HTTP, encoded with c, on behalf of
+// the connection with sessionID. When that connection lives on this node, the
+// message is handled inline and protocol errors are returned to the caller.
+// Otherwise it is forwarded as is to the user's other nodes, and any error
+// frame is sent down the connection's stream. ErrSessionNotFound means no
+// node holds the session.
+func (h *Hub) Submit(ctx context.Context, email, sessionID string, c Codec, data []byte) error {
+	msg, err := decodeMessage(c, data)
+	if err != nil {
+		return err
+	}
+	if cl := h.sessionClient(email, sessionID); cl != nil {
+		return h.handleIncoming(ctx, cl, msg)
+	}
+
+	nodes, err := h.routes.Lookup(ctx, email)
+	if err != nil {
+		return fmt.Errorf("lookup route: %w", err)
+	}
+	envBytes := (&brokerEnvelope{
+		NodeID:  h.nodeID,
+		To:      email,
+		Session: sessionID,
+		Codec:   c.Name(),
+		Data:    data,
+	}).marshal()
+	forwarded := false
+	for _, node := range nodes {
+		if node == h.nodeID {
+			continue
+		}
+		if err := h.broker.Publish(ctx, nodeChannel(node), envBytes); err != nil {
+			return fmt.Errorf("publish to node %s: %w", node, err)
+		}
+		forwarded = true
+	}
+	if !forwarded {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// handleForwarded 处理其他节点转发来的 HTTP 提交
+// handleForwarded handles a submission another node forwarded to this one.
+func (h *Hub) handleForwarded(ctx context.Context, env *brokerEnvelope) {
+	cl := h.sessionClient(env.To, env.Session)
+	if cl == nil {
+		return
+	}
+	c, ok := CodecByName(env.Codec)
+	if !ok {
+		h.logger.Warn().Str("codec", env.Codec).Msg("unknown codec in forwarded submission")
+		return
+	}
+	h.handleClientMessage(ctx, cl, c, env.Data)
+}
+
+// sessionClient 查找本节点上属于 email 且会话匹配的连接
+// sessionClient finds the local connection of email with the given session ID.
+func (h *Hub) sessionClient(email, sessionID string) *client {
+	if sessionID == "" {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for cl := range h.clients[email] {
+		if id, _ := cl.session(); id == sessionID {
+			return cl
+		}
+	}
+	return nil
+}
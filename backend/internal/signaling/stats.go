@@ -0,0 +1,91 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/allcallall/backend/internal/callhistory"
+	"github.com/allcallall/backend/internal/media"
+)
+
+// handleStatsGet 回复客户端的 call.stats.get，发送服务端测得的媒体统计
+// handleStatsGet answers a client's call.stats.get with the media statistics
+// the server measures on their call.
+func (h *Hub) handleStatsGet(ctx context.Context, msg *SignalMessage) error {
+	peerConn, err := h.mediaEngine.GetPeerConnection(msg.CallID, msg.From, msg.To)
+	if err != nil {
+		return newProtocolError(ErrorCodeMediaUnavailable, msg.ID, "no media connection for this call")
+	}
+	return h.sendStats(ctx, peerConn)
+}
+
+// sendStats 采集对等连接的统计并以 call.stats 发给其客户端
+// sendStats collects the statistics of a peer connection and sends them to
+// its client as call.stats.
+func (h *Hub) sendStats(ctx context.Context, peerConn *media.PeerConnection) error {
+	payload, err := json.Marshal(CallStatsPayload{Stats: *h.mediaEngine.CollectStats(peerConn)})
+	if err != nil {
+		return err
+	}
+	return h.SendToUser(ctx, peerConn.LocalEmail, &SignalMessage{
+		Type:    TypeCallStats,
+		CallID:  peerConn.CallID,
+		From:    peerConn.RemoteEmail,
+		Payload: payload,
+	})
+}
+
+// StartQualitySampling 定期采样所有已连接的服务端通话，计入通话记录
+// StartQualitySampling samples the media quality of every connected
+// server-terminated call each interval and records it in the call history,
+// until ctx is cancelled.
+func (h *Hub) StartQualitySampling(ctx context.Context, interval time.Duration) {
+	if h.mediaEngine == nil || h.history == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, peerConn := range h.mediaEngine.ListPeerConnections() {
+					if peerConn.PC.ConnectionState() == webrtc.PeerConnectionStateConnected {
+						h.sampleQuality(ctx, peerConn)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// finishQualitySampling 通话结束、关闭连接前再采样一次，短于采样间隔的通话也有评分
+// finishQualitySampling takes a last sample before the call's peer connection
+// closes, so calls shorter than the interval still get a score.
+func (h *Hub) finishQualitySampling(ctx context.Context, callID, localEmail, remoteEmail string) {
+	if h.history == nil {
+		return
+	}
+	if peerConn, err := h.mediaEngine.GetPeerConnection(callID, localEmail, remoteEmail); err == nil {
+		h.sampleQuality(ctx, peerConn)
+	}
+}
+
+// sampleQuality 记录一次采样；尚未收到媒体的连接没有评分，跳过
+// sampleQuality records one sample. Connections that have not received media
+// yet have no score and are skipped.
+func (h *Hub) sampleQuality(ctx context.Context, peerConn *media.PeerConnection) {
+	stats := h.mediaEngine.CollectStats(peerConn)
+	if stats.MOS == 0 {
+		return
+	}
+	if err := h.history.AddQualitySample(ctx, peerConn.LocalEmail, stats); err != nil && !errors.Is(err, callhistory.ErrNotFound) {
+		h.logger.Warn().Err(err).Str("call_id", peerConn.CallID).Msg("failed to record call quality")
+	}
+}
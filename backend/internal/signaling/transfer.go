@@ -0,0 +1,154 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/allcallall/backend/internal/models"
+)
+
+// 转接流程：转接方 A 在与 B 的通话上发送 call.transfer，Hub 记录请求并转发给 B；
+// B 带 payload.transfer.call_id 向目标 C 发起新邀请。盲转在邀请发出时结束原通话，
+// 咨询转在 C 接听后结束原通话与 A、C 之间的咨询通话。
+//
+// Transfers: the transferor A sends call.transfer on its call with B. The hub
+// records the request and forwards it to B, whose client then invites the
+// target C with payload.transfer.call_id naming the original call. A blind
+// transfer releases the original call as soon as that invite goes out; an
+// attended transfer ends both the original call and A's consultation call
+// with C once C answers, bridging B and C.
+
+// requestTransfer 校验并记录转接请求
+// requestTransfer validates a call.transfer and records it on the call.
+// addressCallMessage has already addressed msg to the transferee.
+func (h *Hub) requestTransfer(ctx context.Context, msg *SignalMessage) error {
+	var req TransferPayload
+	if err := msg.DecodePayload(&req); err != nil {
+		return newProtocolError(ErrorCodeInvalidPayload, msg.ID, "decode transfer: %v", err)
+	}
+	if msg.To == ServerPeer {
+		return newProtocolError(ErrorCodeInvalidTransfer, msg.ID, "server-terminated calls cannot be transferred")
+	}
+	if req.Target == msg.From || req.Target == msg.To || req.Target == ServerPeer {
+		return newProtocolError(ErrorCodeInvalidTransfer, msg.ID, "cannot transfer to %s", req.Target)
+	}
+
+	call, err := h.calls.Get(ctx, msg.CallID)
+	if err != nil {
+		return err
+	}
+	if req.Mode == TransferAttended {
+		consult, err := h.calls.Get(ctx, req.ConsultCallID)
+		if err != nil && !errors.Is(err, ErrCallNotFound) {
+			return err
+		}
+		if consult == nil || consult.ID == call.ID || consult.Status != CallStatusActive ||
+			!consult.HasParticipant(msg.From) || consult.Peer(msg.From) != req.Target {
+			return newProtocolError(ErrorCodeInvalidTransfer, msg.ID, "call %s is not an active call with %s", req.ConsultCallID, req.Target)
+		}
+	}
+
+	call.Transfer = &Transfer{
+		Mode:          req.Mode,
+		Target:        req.Target,
+		By:            msg.From,
+		ConsultCallID: req.ConsultCallID,
+	}
+	return h.calls.Save(ctx, call)
+}
+
+// bindTransfer 校验转接邀请，并在负载中填入发起人与被替换的咨询通话
+// bindTransfer checks an invite that claims to complete a transfer and fills
+// in who referred it and which consultation call it replaces.
+func (h *Hub) bindTransfer(ctx context.Context, msg *SignalMessage) error {
+	var offer SessionDescriptionPayload
+	if err := msg.DecodePayload(&offer); err != nil || offer.Transfer == nil {
+		return nil
+	}
+
+	original, err := h.calls.Get(ctx, offer.Transfer.CallID)
+	if err != nil && !errors.Is(err, ErrCallNotFound) {
+		return err
+	}
+	if original == nil || original.Transfer == nil ||
+		msg.From != original.Peer(original.Transfer.By) || msg.To != original.Transfer.Target {
+		return newProtocolError(ErrorCodeInvalidTransfer, msg.ID, "no transfer of call %s to %s was requested", offer.Transfer.CallID, msg.To)
+	}
+
+	offer.Transfer.ReferredBy = original.Transfer.By
+	offer.Transfer.Replaces = original.Transfer.ConsultCallID
+	payload, err := json.Marshal(&offer)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+// transferRef 返回邀请引用的转接，没有时返回 nil
+// transferRef returns the transfer an invite completes, if any.
+func transferRef(msg *SignalMessage) *TransferRef {
+	var offer SessionDescriptionPayload
+	if err := msg.DecodePayload(&offer); err != nil {
+		return nil
+	}
+	return offer.Transfer
+}
+
+// completeTransfer 结束被转接的原通话（及咨询通话），通知所有参与方
+// completeTransfer ends the calls a transferred call takes over from and tells
+// every party of those calls.
+func (h *Hub) completeTransfer(ctx context.Context, call *Call) {
+	for _, id := range []string{call.TransferredFrom, call.Replaces} {
+		if id == "" {
+			continue
+		}
+		original, err := h.calls.Get(ctx, id)
+		if err != nil {
+			if !errors.Is(err, ErrCallNotFound) {
+				h.logger.Warn().Err(err).Str("call_id", id).Msg("failed to load transferred call")
+			}
+			continue
+		}
+		h.endTransferred(ctx, original, call)
+	}
+}
+
+// endTransferred 以 transferred 原因结束 original，并在通话记录中记下转给了谁
+// endTransferred ends original with reason "transferred" and records in its
+// history entry who took it over.
+func (h *Hub) endTransferred(ctx context.Context, original, successor *Call) {
+	payload, _ := json.Marshal(CallReasonPayload{Reason: models.CallEndTransferred})
+	for _, party := range []string{original.Caller, original.Callee} {
+		err := h.SendToUser(ctx, party, &SignalMessage{
+			Type:    TypeCallEnd,
+			CallID:  original.ID,
+			To:      party,
+			From:    original.Peer(party),
+			Payload: payload,
+		})
+		if err != nil {
+			h.logger.Warn().Err(err).Str("call_id", original.ID).Str("to", party).Msg("failed to end transferred call")
+		}
+	}
+
+	if err := h.calls.Delete(ctx, original.ID); err != nil && !errors.Is(err, ErrCallNotFound) {
+		h.logger.Warn().Err(err).Str("call_id", original.ID).Msg("failed to delete transferred call")
+	}
+	h.markInCall(ctx, original.ID, false, original.Caller, original.Callee)
+
+	if h.history == nil {
+		return
+	}
+	// 转给了新通话中不在原通话里的一方
+	// The call went to the party of the new call that was not in the original one.
+	transferredTo := successor.Callee
+	if original.HasParticipant(transferredTo) {
+		transferredTo = successor.Caller
+	}
+	if err := h.history.Transfer(ctx, original.ID, transferredTo, time.Now()); err != nil {
+		h.logger.Warn().Err(err).Str("call_id", original.ID).Msg("failed to record call transfer")
+	}
+}
@@ -0,0 +1,51 @@
+package signaling
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// transport 连接的下行通道（WebSocket 或 SSE）
+// transport is the server-to-client side of a signaling connection. The hub
+// treats every transport alike; only how frames are written and closed differs.
+type transport interface {
+	// Subprotocol 返回握手时协商的子协议，没有则为空
+	// Subprotocol returns the subprotocol negotiated at connect time, if any.
+	Subprotocol() string
+	// WriteMessage 写出一条已编码的信令消息，仅由写循环调用
+	// WriteMessage writes one encoded message. Only the write loop calls it.
+	WriteMessage(data []byte) error
+	// Shutdown 通知对端后关闭连接；可与写循环并发调用
+	// Shutdown tells the peer why and closes. Safe to call concurrently with the write loop.
+	Shutdown(code int, reason string)
+	// Close 立即关闭连接
+	// Close closes the connection immediately.
+	Close() error
+}
+
+// wsTransport WebSocket 传输
+// wsTransport carries signaling over a WebSocket.
+type wsTransport struct {
+	conn *websocket.Conn
+	// 二进制编解码器使用二进制帧
+	// Binary codecs are written as binary frames.
+	binary bool
+}
+
+func (t *wsTransport) Subprotocol() string {
+	return t.conn.Subprotocol()
+}
+
+func (t *wsTransport) WriteMessage(data []byte) error {
+	if t.binary {
+		return t.conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	return t.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *wsTransport) Shutdown(code int, reason string) {
+	closeConn(t.conn, code, reason)
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,68 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/allcallall/backend/internal/config"
+)
+
+// Credentials TURN REST API 临时凭据
+// Credentials are ephemeral TURN REST API credentials. The username is
+// "<expiry unix time>:<user>" and the password is the base64 HMAC-SHA1 of the
+// username keyed with the shared secret, so the TURN server can check them
+// without calling back.
+type Credentials struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// Issue 为 user 签发到 now+ttl 过期的凭据
+// Issue issues credentials for user that expire at now+ttl.
+func Issue(secret, user string, ttl time.Duration, now time.Time) Credentials {
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	username := strconv.FormatInt(expiresAt.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+	return Credentials{
+		Username:  username,
+		Password:  Password(secret, username),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Password 按 TURN REST API 计算用户名对应的密码
+// Password computes the TURN REST API password of username.
+func Password(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ICEServers 返回交给 user 的 ICE 服务器：配置了密钥的服务器换成临时凭据
+// ICEServers returns the ICE servers to hand to user. Servers configured with
+// a shared secret get freshly issued credentials; the others are returned as
+// configured. The secret itself is never part of the result. The earliest
+// expiry of the issued credentials is returned as well, zero if none were issued.
+func ICEServers(servers []config.ICEServer, user string, ttl time.Duration, now time.Time) ([]config.ICEServer, time.Time) {
+	result := make([]config.ICEServer, 0, len(servers))
+	var expiresAt time.Time
+	for _, server := range servers {
+		if server.Secret != "" {
+			creds := Issue(server.Secret, user, ttl, now)
+			server.Username = creds.Username
+			server.Credential = creds.Password
+			server.Secret = ""
+			if expiresAt.IsZero() || creds.ExpiresAt.Before(expiresAt) {
+				expiresAt = creds.ExpiresAt
+			}
+		}
+		result = append(result, server)
+	}
+	return result, expiresAt
+}
@@ -0,0 +1,213 @@
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pionturn "github.com/pion/turn/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/config"
+)
+
+// ErrQuotaExceeded 用户的中继分配已达上限
+// ErrQuotaExceeded is returned when a user already holds as many relay
+// allocations as the quota allows.
+var ErrQuotaExceeded = errors.New("turn: allocation quota exceeded")
+
+// Metrics 内置 TURN 服务的分配统计
+// Metrics reports relay allocations of the embedded TURN server.
+type Metrics struct {
+	ActiveAllocations int    `json:"active_allocations"`
+	ActiveUsers       int    `json:"active_users"`
+	AllocationsTotal  uint64 `json:"allocations_total"`
+	QuotaRejections   uint64 `json:"quota_rejections"`
+	AuthFailures      uint64 `json:"auth_failures"`
+}
+
+// Server 基于 pion/turn 的内置 TURN/STUN 服务
+// Server is the embedded TURN/STUN server built on pion/turn. It accepts the
+// ephemeral credentials Issue hands out and caps the allocations each user
+// may hold at once.
+type Server struct {
+	logger zerolog.Logger
+	secret string
+	realm  string
+	quota  int
+	now    func() time.Time
+	server *pionturn.Server
+	conn   net.PacketConn
+
+	mu sync.Mutex
+	// 正在处理的请求所认证的用户。pion/turn 在同一个读循环中先认证再分配，
+	// 因此分配时读取到的就是该请求的用户
+	// User authenticated by the request being handled. pion/turn authenticates
+	// an Allocate request and then allocates its relay on the same read loop,
+	// so the allocation sees the user of its own request.
+	current     string
+	allocations map[string]int
+	metrics     Metrics
+}
+
+// NewServer 监听配置的 UDP 地址并启动 TURN 服务
+// NewServer listens on the configured UDP address and starts serving TURN.
+func NewServer(logger zerolog.Logger, cfg config.TURNConfig) (*Server, error) {
+	publicIP := net.ParseIP(cfg.PublicIP)
+	if publicIP == nil {
+		return nil, fmt.Errorf("turn: invalid public ip %q", cfg.PublicIP)
+	}
+	conn, err := net.ListenPacket("udp4", cfg.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("turn: listen %s: %w", cfg.ListenAddress, err)
+	}
+
+	s := &Server{
+		logger:      logger.With().Str("component", "turn_server").Logger(),
+		secret:      cfg.Secret,
+		realm:       cfg.Realm,
+		quota:       cfg.MaxAllocationsPerUser,
+		now:         time.Now,
+		conn:        conn,
+		allocations: make(map[string]int),
+	}
+	// 只使用一个 UDP 监听：认证与分配在同一读循环中依次进行，配额才能归属到用户
+	// A single UDP listener keeps authentication and allocation on one read
+	// loop, which is what lets quotas be charged to the right user.
+	server, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: s.authenticate,
+		PacketConnConfigs: []pionturn.PacketConnConfig{
+			{
+				PacketConn: conn,
+				RelayAddressGenerator: &quotaRelayGenerator{
+					server: s,
+					RelayAddressGeneratorPortRange: &pionturn.RelayAddressGeneratorPortRange{
+						RelayAddress: publicIP,
+						Address:      cfg.RelayAddress,
+						MinPort:      cfg.RelayMinPort,
+						MaxPort:      cfg.RelayMaxPort,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("turn: start server: %w", err)
+	}
+	s.server = server
+	return s, nil
+}
+
+// Addr 返回 TURN 服务实际监听的地址
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Metrics 返回当前的分配统计
+// Metrics returns a snapshot of the allocation metrics.
+func (s *Server) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metrics := s.metrics
+	metrics.ActiveUsers = len(s.allocations)
+	return metrics
+}
+
+// Close 停止服务并释放所有中继分配
+// Close stops the server and releases every relay allocation.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// authenticate 校验 TURN REST API 用户名 "<过期时间>:<用户>" 并返回长期凭据密钥
+// authenticate checks a TURN REST API username "<expiry>:<user>" and returns
+// the long-term credential key of its password.
+func (s *Server) authenticate(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	expiry, user, _ := strings.Cut(username, ":")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || s.now().Unix() > expiresAt {
+		s.mu.Lock()
+		s.metrics.AuthFailures++
+		s.mu.Unlock()
+		s.logger.Debug().Str("username", username).Str("src", srcAddr.String()).Msg("turn credentials rejected")
+		return nil, false
+	}
+
+	s.mu.Lock()
+	s.current = user
+	s.mu.Unlock()
+	return pionturn.GenerateAuthKey(username, realm, Password(s.secret, username)), true
+}
+
+// reserve 为当前请求的用户占用一个分配名额
+// reserve charges an allocation to the user of the request being handled.
+func (s *Server) reserve() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.current
+	if s.quota > 0 && s.allocations[user] >= s.quota {
+		s.metrics.QuotaRejections++
+		return user, ErrQuotaExceeded
+	}
+	s.allocations[user]++
+	s.metrics.ActiveAllocations++
+	s.metrics.AllocationsTotal++
+	return user, nil
+}
+
+// release 归还用户的分配名额
+// release gives an allocation back to user.
+func (s *Server) release(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.ActiveAllocations--
+	if s.allocations[user] <= 1 {
+		delete(s.allocations, user)
+		return
+	}
+	s.allocations[user]--
+}
+
+// quotaRelayGenerator 在端口范围内分配中继，并按用户计数
+// quotaRelayGenerator allocates relays within the port range and counts them
+// against the quota of the requesting user.
+type quotaRelayGenerator struct {
+	*pionturn.RelayAddressGeneratorPortRange
+	server *Server
+}
+
+func (g *quotaRelayGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	user, err := g.server.reserve()
+	if err != nil {
+		g.server.logger.Warn().Str("user", user).Int("quota", g.server.quota).Msg("turn allocation quota exceeded")
+		return nil, nil, err
+	}
+	conn, addr, err := g.RelayAddressGeneratorPortRange.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		g.server.release(user)
+		return nil, nil, err
+	}
+	g.server.logger.Debug().Str("user", user).Str("relay", addr.String()).Msg("turn allocation created")
+	return &relayConn{PacketConn: conn, release: func() { g.server.release(user) }}, addr, nil
+}
+
+// relayConn 关闭时归还分配名额；分配过期或删除时 pion/turn 会关闭它
+// relayConn gives its allocation back when closed, which pion/turn does when
+// the allocation expires or is deleted.
+type relayConn struct {
+	net.PacketConn
+	once    sync.Once
+	release func()
+}
+
+func (c *relayConn) Close() error {
+	c.once.Do(c.release)
+	return c.PacketConn.Close()
+}
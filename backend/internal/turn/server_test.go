@@ -0,0 +1,384 @@
+package turn_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pionturn "github.com/pion/turn/v4"
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog"
+
+	"github.com/allcallall/backend/internal/auth"
+	"github.com/allcallall/backend/internal/config"
+	"github.com/allcallall/backend/internal/handlers"
+	"github.com/allcallall/backend/internal/turn"
+)
+
+const (
+	turnSecret = "turn-test"
+	adminToken = "turn-test-admin"
+
+	// connectTimeout 对等连接经中继建立的最长时间
+	// connectTimeout bounds how long the peers may take to connect through the relay.
+	connectTimeout = 20 * time.Second
+)
+
+// TestRelayedConnection 两个只允许中继的 Pion 客户端经内置 TURN 服务建立连接
+// TestRelayedConnection starts the embedded TURN server and connects two Pion
+// peers that are only allowed to use relay candidates, with credentials
+// fetched from the WebRTC config endpoint. It then checks quotas, expired and
+// forged credentials, and that allocations are released on close.
+func TestRelayedConnection(t *testing.T) {
+	logger := zerolog.Nop()
+	turnServer, err := turn.NewServer(logger, config.TURNConfig{
+		ListenAddress:         "127.0.0.1:0",
+		PublicIP:              "127.0.0.1",
+		RelayAddress:          "127.0.0.1",
+		Realm:                 "allcallall",
+		Secret:                turnSecret,
+		RelayMinPort:          40000,
+		RelayMaxPort:          40999,
+		MaxAllocationsPerUser: 1,
+	})
+	if err != nil {
+		t.Fatalf("start turn server: %v", err)
+	}
+	t.Cleanup(func() { _ = turnServer.Close() })
+	turnAddr := turnServer.Addr().String()
+
+	srv, jwtManager := startServer(t, logger, config.WebRTCConfig{
+		ICEServers: []config.ICEServer{
+			{URLs: []string{"turn:" + turnAddr + "?transport=udp"}, Secret: turnSecret},
+		},
+		ICETransportPolicy:   "relay",
+		BundlePolicy:         "balanced",
+		TURNCredentialTTLSec: 3600,
+	}, turnServer)
+
+	// 凭据
+	// Credentials.
+	aliceCfg := fetchConfig(t, srv.URL, jwtManager, 1, "alice@example.com")
+	bobCfg := fetchConfig(t, srv.URL, jwtManager, 2, "bob@example.com")
+	if len(aliceCfg.ICEServers) != 1 || aliceCfg.ICEServers[0].Username == "" {
+		t.Fatalf("client got ICE servers %+v, want one with ephemeral TURN credentials", aliceCfg.ICEServers)
+	}
+	if aliceCfg.ICETransportPolicy != "relay" {
+		t.Errorf("clients told ICE transport policy %q, want relay", aliceCfg.ICETransportPolicy)
+	}
+
+	// 经中继建立连接
+	// Relayed connection.
+	alice, aliceConnected := newPeer(t, aliceCfg)
+	bob, bobConnected := newPeer(t, bobCfg)
+	messages := make(chan string, 1)
+	bob.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			messages <- string(msg.Data)
+		})
+	})
+	channel, err := alice.CreateDataChannel("turn-test", nil)
+	if err != nil {
+		t.Fatalf("create data channel: %v", err)
+	}
+	opened := make(chan struct{})
+	channel.OnOpen(func() { close(opened) })
+	connect(t, alice, bob)
+
+	await(t, aliceConnected, "alice connected")
+	await(t, bobConnected, "bob connected")
+	if !relayed(alice) || !relayed(bob) {
+		t.Error("peers connected without relay candidates on both ends")
+	}
+	await(t, opened, "data channel opened")
+	_ = channel.SendText("hello through the relay")
+	select {
+	case msg := <-messages:
+		if msg != "hello through the relay" {
+			t.Errorf("bob received %q", msg)
+		}
+	case <-time.After(connectTimeout):
+		t.Error("alice's message did not reach bob")
+	}
+
+	// 统计与管理接口
+	// Metrics and the admin endpoint.
+	if metrics := turnServer.Metrics(); metrics.ActiveAllocations != 2 || metrics.ActiveUsers != 2 {
+		t.Errorf("metrics %+v, want two users holding one allocation each", metrics)
+	}
+	if status, _ := fetchMetrics(t, srv.URL, ""); status != http.StatusUnauthorized {
+		t.Errorf("metrics without the admin token answered %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, served := fetchMetrics(t, srv.URL, adminToken); status != http.StatusOK || served.ActiveAllocations != 2 {
+		t.Errorf("admin endpoint answered %d with %+v", status, served)
+	}
+
+	// 配额与凭据
+	// Quotas and credentials.
+	if !refused(turnAddr, turn.Issue(turnSecret, "1", time.Hour, time.Now())) {
+		t.Error("alice allocated past her quota of one allocation")
+	}
+	if n := turnServer.Metrics().QuotaRejections; n != 1 {
+		t.Errorf("%d quota rejections counted, want 1", n)
+	}
+	if relay, err := allocate(turnAddr, turn.Issue(turnSecret, "3", time.Hour, time.Now())); err != nil {
+		t.Errorf("another user cannot allocate: %v", err)
+	} else {
+		relay.close()
+	}
+	if !refused(turnAddr, turn.Issue(turnSecret, "3", -time.Minute, time.Now())) {
+		t.Error("expired credentials accepted")
+	}
+	if turnServer.Metrics().AuthFailures == 0 {
+		t.Error("the rejected credentials are not counted")
+	}
+	if !refused(turnAddr, turn.Issue("wrong-secret", "3", time.Hour, time.Now())) {
+		t.Error("credentials signed with another secret accepted")
+	}
+
+	// 关闭后释放分配
+	// Allocations are released when the peers close.
+	_ = alice.Close()
+	_ = bob.Close()
+	deadline := time.Now().Add(connectTimeout)
+	for turnServer.Metrics().ActiveAllocations > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	metrics := turnServer.Metrics()
+	if metrics.ActiveAllocations != 0 || metrics.ActiveUsers != 0 {
+		t.Errorf("metrics %+v after the peers closed, want no allocation left", metrics)
+	}
+	if metrics.AllocationsTotal != 3 {
+		t.Errorf("%d allocations made in total, want 3", metrics.AllocationsTotal)
+	}
+}
+
+// startServer 启动提供 WebRTC 配置接口与管理接口的 HTTP 服务
+// startServer serves the WebRTC config endpoint clients fetch credentials
+// from, and the admin endpoints reporting TURN metrics.
+func startServer(t *testing.T, logger zerolog.Logger, webrtcCfg config.WebRTCConfig, turnServer *turn.Server) (*httptest.Server, *auth.Manager) {
+	t.Helper()
+	jwtManager, err := auth.NewManager(auth.Config{
+		Secret:          "turn-test",
+		Issuer:          "allcall-turn-test",
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("create jwt manager: %v", err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	protected := router.Group("/api/v1")
+	protected.Use(auth.Middleware(jwtManager))
+	handlers.NewWebRTCHandler(logger, webrtcCfg).RegisterRoutes(protected.Group("/webrtc"))
+	admin := router.Group("/api/v1/admin")
+	admin.Use(auth.AdminMiddleware(adminToken))
+	handlers.NewAdminHandler(logger, nil, turnServer).RegisterRoutes(admin)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, jwtManager
+}
+
+type clientConfig struct {
+	ICEServers []struct {
+		URLs       []string `json:"urls"`
+		Username   string   `json:"username"`
+		Credential string   `json:"credential"`
+	} `json:"ice_servers"`
+	ICETransportPolicy string `json:"ice_transport_policy"`
+}
+
+// fetchMetrics 通过管理接口读取 TURN 统计
+// fetchMetrics reads the TURN metrics through the admin endpoint.
+func fetchMetrics(t *testing.T, baseURL, token string) (int, turn.Metrics) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/admin/turn/metrics", nil)
+	if err != nil {
+		t.Fatalf("build metrics request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("fetch metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	var metrics turn.Metrics
+	_ = json.NewDecoder(resp.Body).Decode(&metrics)
+	return resp.StatusCode, metrics
+}
+
+// fetchConfig 以 userID 身份获取 WebRTC 配置
+// fetchConfig fetches the WebRTC config as userID.
+func fetchConfig(t *testing.T, baseURL string, jwtManager *auth.Manager, userID uint64, email string) clientConfig {
+	t.Helper()
+	token, err := jwtManager.GenerateAccessToken(userID, email)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/webrtc/config", nil)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("fetch webrtc config: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("fetch webrtc config: status %d", resp.StatusCode)
+	}
+	var cfg clientConfig
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		t.Fatalf("decode webrtc config: %v", err)
+	}
+	return cfg
+}
+
+// newPeer 按客户端拿到的配置创建只允许中继的对等连接
+// newPeer creates a peer connection from the config a client received.
+func newPeer(t *testing.T, cfg clientConfig) (*webrtc.PeerConnection, <-chan struct{}) {
+	t.Helper()
+	pcConfig := webrtc.Configuration{ICETransportPolicy: webrtc.NewICETransportPolicy(cfg.ICETransportPolicy)}
+	for _, s := range cfg.ICEServers {
+		pcConfig.ICEServers = append(pcConfig.ICEServers, webrtc.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
+	}
+	pc, err := webrtc.NewPeerConnection(pcConfig)
+	if err != nil {
+		t.Fatalf("create peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	connected := make(chan struct{})
+	var once sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			once.Do(func() { close(connected) })
+		}
+	})
+	return pc, connected
+}
+
+// connect 交换完整收集候选后的 offer/answer
+// connect exchanges an offer and answer carrying all gathered candidates.
+func connect(t *testing.T, offerer, answerer *webrtc.PeerConnection) {
+	t.Helper()
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set offer: %v", err)
+	}
+	<-gathered
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		t.Fatalf("apply offer: %v", err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatalf("set answer: %v", err)
+	}
+	<-gathered
+	if err := offerer.SetRemoteDescription(*answerer.LocalDescription()); err != nil {
+		t.Fatalf("apply answer: %v", err)
+	}
+}
+
+// relayed 判断选中的候选对是否走中继
+// relayed reports whether the selected candidate pair uses a relay.
+func relayed(pc *webrtc.PeerConnection) bool {
+	sctp := pc.SCTP()
+	if sctp == nil {
+		return false
+	}
+	pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return false
+	}
+	return pair.Local.Typ == webrtc.ICECandidateTypeRelay
+}
+
+type allocation struct {
+	client *pionturn.Client
+	conn   net.PacketConn
+	relay  net.PacketConn
+}
+
+func (a *allocation) close() {
+	_ = a.relay.Close()
+	a.client.Close()
+	_ = a.conn.Close()
+}
+
+// allocate 使用给定凭据直接向 TURN 服务申请中继
+// allocate requests a relay from the TURN server with creds.
+func allocate(turnAddr string, creds turn.Credentials) (*allocation, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	client, err := pionturn.NewClient(&pionturn.ClientConfig{
+		STUNServerAddr: turnAddr,
+		TURNServerAddr: turnAddr,
+		Conn:           conn,
+		Username:       creds.Username,
+		Password:       creds.Password,
+		Realm:          "allcallall",
+		RTO:            200 * time.Millisecond,
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := client.Listen(); err != nil {
+		client.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+	relay, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+	return &allocation{client: client, conn: conn, relay: relay}, nil
+}
+
+// refused 申请中继是否被拒绝；意外获得的中继立即释放
+// refused reports whether the TURN server turns down an allocation with
+// creds, releasing the relay if it does not.
+func refused(turnAddr string, creds turn.Credentials) bool {
+	relay, err := allocate(turnAddr, creds)
+	if err != nil {
+		return true
+	}
+	relay.close()
+	return false
+}
+
+func await(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(connectTimeout):
+		t.Fatalf("%s: not within %s", what, connectTimeout)
+	}
+}